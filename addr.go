@@ -3,6 +3,7 @@ package nxproxy
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 )
@@ -20,18 +21,25 @@ func GetAddrPort(addr net.Addr) (net.IP, int) {
 	return nil, 0
 }
 
+// Checks whether an address, which may be a host name, an IP literal or either of them with a port,
+// points to the node itself or its local networks. IPv6 literals may be bracketed with or without a port
 func IsLocalAddress(addr string) bool {
 
 	if host, _, err := net.SplitHostPort(addr); err == nil {
 		addr = host
 	}
 
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
 	ipAddr, _ := net.ResolveIPAddr("ip", addr)
 	if ipAddr == nil {
 		return false
 	}
 
-	return ipAddr.IP.IsLoopback() || ipAddr.IP.IsPrivate() || ipAddr.IP.IsUnspecified()
+	ip := ipAddr.IP
+
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
 }
 
 func SplitAddrNet(addr string) (string, string, bool) {
@@ -65,7 +73,7 @@ func ServiceBindAddr(addr string, service ProxyProto) (string, error) {
 
 	var networkSuffix string
 	switch service {
-	case ProxyProtoHttp, ProxyProtoSocks:
+	case ProxyProtoHttp, ProxyProtoHttps, ProxyProtoSocks, ProxyProtoReverse:
 		networkSuffix = "/tcp"
 		//	udp support can be added here in the future
 	}
@@ -73,6 +81,50 @@ func ServiceBindAddr(addr string, service ProxyProto) (string, error) {
 	return net.JoinHostPort(prefix, strconv.Itoa(port)) + networkSuffix, nil
 }
 
+// Checks whether two service bind addresses, as returned by ServiceBindAddr, can't be bound at the same time.
+// That's the case for the same port on the same network when the hosts match or either of them is a wildcard address.
+// The IPv6 wildcard covers IPv4 addresses as well, since listeners are dual-stack by default
+func BindAddrsOverlap(addr1 string, addr2 string) bool {
+
+	if addr1 == addr2 {
+		return true
+	}
+
+	hostPort1, net1, _ := SplitAddrNet(addr1)
+	hostPort2, net2, _ := SplitAddrNet(addr2)
+
+	if net1 != net2 {
+		return false
+	}
+
+	host1, port1, err := net.SplitHostPort(hostPort1)
+	if err != nil {
+		return false
+	}
+
+	host2, port2, err := net.SplitHostPort(hostPort2)
+	if err != nil || port1 != port2 {
+		return false
+	}
+
+	ip1, ip2 := net.ParseIP(host1), net.ParseIP(host2)
+	if ip1 == nil || ip2 == nil {
+		return false
+	}
+
+	var covers = func(wildcard net.IP, ip net.IP) bool {
+
+		if !wildcard.IsUnspecified() {
+			return false
+		}
+
+		//	only the IPv4 wildcard is limited to its own family
+		return wildcard.To4() == nil || ip.To4() != nil
+	}
+
+	return ip1.Equal(ip2) || covers(ip1, ip2) || covers(ip2, ip1)
+}
+
 func ParseFramedIP(addr string) (net.IP, error) {
 
 	if addr == "" {
@@ -121,3 +173,39 @@ func AddrAssigned(addr net.IP) (bool, error) {
 
 	return false, nil
 }
+
+// Parses a list of CIDRs; plain addresses are treated as single-host prefixes
+func ParsePrefixList(entries []string) ([]netip.Prefix, error) {
+
+	var prefixes []netip.Prefix
+
+	for _, entry := range entries {
+
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix: %s", entry)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func PrefixListContains(prefixes []netip.Prefix, addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
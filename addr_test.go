@@ -0,0 +1,97 @@
+package nxproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestIsLocalAddress(t *testing.T) {
+
+	cases := map[string]bool{
+		"127.0.0.1:80":              true,
+		"10.1.2.3":                  true,
+		"169.254.169.254:80":        true,
+		"0.0.0.0:443":               true,
+		"[::1]:443":                 true,
+		"[::1]":                     true,
+		"::1":                       true,
+		"[::]:80":                   true,
+		"[::ffff:127.0.0.1]:80":     true,
+		"[fd00::1]:443":             true,
+		"[fe80::1%lo]:22":           true,
+		"fe80::1":                   true,
+		"[2001:db8::1]:443":         false,
+		"[2606:4700:4700::1111]:53": false,
+		"2606:4700:4700::1111":      false,
+		"1.1.1.1:53":                false,
+		"[::ffff:1.1.1.1]:53":       false,
+	}
+
+	for addr, expected := range cases {
+		if val := nxproxy.IsLocalAddress(addr); val != expected {
+			t.Errorf("%s: expected %v, got %v", addr, expected, val)
+		}
+	}
+}
+
+func TestBindAddrsOverlap(t *testing.T) {
+
+	cases := []struct {
+		addr1   string
+		addr2   string
+		overlap bool
+	}{
+		{"0.0.0.0:1080/tcp", "0.0.0.0:1080/tcp", true},
+		{"0.0.0.0:1080/tcp", "127.0.0.1:1080/tcp", true},
+		{"10.0.0.1:1080/tcp", "0.0.0.0:1080/tcp", true},
+		{"[::]:1080/tcp", "127.0.0.1:1080/tcp", true},
+		{"[::]:1080/tcp", "[::1]:1080/tcp", true},
+		{"0.0.0.0:1080/tcp", "[::1]:1080/tcp", false},
+		{"127.0.0.1:1080/tcp", "10.0.0.1:1080/tcp", false},
+		{"0.0.0.0:1080/tcp", "0.0.0.0:8080/tcp", false},
+		{"0.0.0.0:1080/tcp", "0.0.0.0:1080/udp", false},
+	}
+
+	for _, entry := range cases {
+		if val := nxproxy.BindAddrsOverlap(entry.addr1, entry.addr2); val != entry.overlap {
+			t.Errorf("%s vs %s: expected %v, got %v", entry.addr1, entry.addr2, entry.overlap, val)
+		}
+	}
+}
+
+func TestPeer_DialMappedIPv4(t *testing.T) {
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	//	ipv4-mapped destinations belong to the ipv4 family, so an ipv4 framed ip can reach them
+	peer := &nxproxy.Peer{
+		Dialer: net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}},
+	}
+
+	conn, err := peer.DialContext(context.Background(), "tcp", net.JoinHostPort("::ffff:127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	conn.Close()
+}
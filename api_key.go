@@ -0,0 +1,58 @@
+package nxproxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Access level of an admin API key
+type APIScope string
+
+const (
+
+	//	any request, including the ones that change state
+	APIScopeFull = APIScope("full")
+
+	//	read-only requests; meant for monitoring systems
+	APIScopeStatus = APIScope("status")
+)
+
+// Checks whether a request method is permitted within the scope
+func (scope APIScope) Allows(method string) bool {
+	switch scope {
+	case APIScopeFull:
+		return true
+	case APIScopeStatus:
+		return method == http.MethodGet || method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+// Bearer token of an admin API along with its scope
+type APIKey struct {
+	Token string
+	Scope APIScope
+}
+
+// Matches the request bearer token against the keys and returns the scope of the matching one.
+// Every key is compared in constant time, so that timings don't leak which of them got close
+func AuthorizeAPIRequest(req *http.Request, keys []APIKey) (APIScope, bool) {
+
+	schema, bearer, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if strings.ToLower(schema) != "bearer" || bearer == "" {
+		return "", false
+	}
+
+	var scope APIScope
+	var matched bool
+
+	for _, key := range keys {
+		if key.Token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(key.Token)) == 1 && !matched {
+			scope, matched = key.Scope, true
+		}
+	}
+
+	return scope, matched
+}
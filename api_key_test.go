@@ -0,0 +1,54 @@
+package nxproxy_test
+
+import (
+	"net/http"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestAuthorizeAPIRequest(t *testing.T) {
+
+	keys := []nxproxy.APIKey{
+		{Token: "full-token", Scope: nxproxy.APIScopeFull},
+		{Token: "status-token", Scope: nxproxy.APIScopeStatus},
+		{Token: "", Scope: nxproxy.APIScopeFull},
+	}
+
+	var authorize = func(header string) (nxproxy.APIScope, bool) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost/admin/v1/status", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return nxproxy.AuthorizeAPIRequest(req, keys)
+	}
+
+	if scope, ok := authorize("Bearer full-token"); !ok || scope != nxproxy.APIScopeFull {
+		t.Errorf("full token: %v %v", scope, ok)
+	}
+
+	if scope, ok := authorize("bearer status-token"); !ok || scope != nxproxy.APIScopeStatus {
+		t.Errorf("status token: %v %v", scope, ok)
+	}
+
+	for _, header := range []string{"", "Bearer ", "Bearer wrong", "Basic full-token"} {
+		if _, ok := authorize(header); ok {
+			t.Errorf("authorized with '%s'", header)
+		}
+	}
+}
+
+func TestAPIScope_Allows(t *testing.T) {
+
+	if !nxproxy.APIScopeStatus.Allows(http.MethodGet) || nxproxy.APIScopeStatus.Allows(http.MethodPost) || nxproxy.APIScopeStatus.Allows(http.MethodDelete) {
+		t.Errorf("status scope isn't read-only")
+	}
+
+	if !nxproxy.APIScopeFull.Allows(http.MethodPut) {
+		t.Errorf("full scope rejects writes")
+	}
+
+	if nxproxy.APIScope("").Allows(http.MethodGet) {
+		t.Errorf("empty scope allows reads")
+	}
+}
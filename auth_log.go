@@ -0,0 +1,68 @@
+package nxproxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type AuthFailReason string
+
+const (
+	AuthFailCredentials = AuthFailReason("credentials")
+	AuthFailRateLimit   = AuthFailReason("rate_limited")
+	AuthFailMethod      = AuthFailReason("method")
+	AuthFailMalformed   = AuthFailReason("malformed")
+)
+
+// Writes auth failures as single lines that fail2ban and similar tools can match on:
+//
+//	2025-01-02T15:04:05Z nx-proxy auth failure from 198.51.100.20 slot=socks@0.0.0.0:1080 reason=credentials user="maddsua"
+//
+// The client address always follows "from", and the user name is only present when the client sent a known one
+type AuthFailureLog struct {
+	Writer io.Writer
+
+	//	set when the sink timestamps entries on its own, like syslog does
+	OmitTime bool
+
+	mtx sync.Mutex
+}
+
+func (log *AuthFailureLog) Record(clientIP string, slot string, user string, reason AuthFailReason) {
+
+	if log == nil {
+		return
+	}
+
+	var line string
+	if !log.OmitTime {
+		line = time.Now().UTC().Format(time.RFC3339) + " "
+	}
+
+	line += fmt.Sprintf("nx-proxy auth failure from %s slot=%s reason=%s", clientIP, slot, reason)
+
+	if user != "" {
+		line += " user=" + strconv.Quote(user)
+	}
+
+	log.mtx.Lock()
+	defer log.mtx.Unlock()
+
+	if _, err := io.WriteString(log.Writer, line+"\n"); err != nil {
+		slog.Debug("Auth failure log: Write failed",
+			slog.String("err", err.Error()))
+	}
+}
+
+// Counts a failed client authentication and writes it to the auth failure log
+func (slot *Slot) AuthFailed(clientIP string, user string, reason AuthFailReason) {
+
+	slot.Counters.AuthFailed.Add(1)
+
+	opts := slot.Options()
+	slot.AuthLog.Record(clientIP, opts.Handle(), user, reason)
+}
@@ -0,0 +1,45 @@
+package nxproxy_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_AuthFailed(t *testing.T) {
+
+	var buff strings.Builder
+
+	slot := nxproxy.Slot{AuthLog: &nxproxy.AuthFailureLog{Writer: &buff}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "0.0.0.0:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	slot.AuthFailed("198.51.100.20", "maddsua\" reason=forged", nxproxy.AuthFailCredentials)
+	slot.AuthFailed("2001:db8::1", "", nxproxy.AuthFailRateLimit)
+
+	if val := slot.TakeStats().AuthFailed; val != 2 {
+		t.Errorf("expected 2 failed auths counted, got %d", val)
+	}
+
+	//	same pattern as the packaged fail2ban filter
+	pattern := regexp.MustCompile(`^\S+ nx-proxy auth failure from (\S+) slot=\S+ reason=(credentials|rate_limited|method|malformed)`)
+
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", lines)
+	}
+
+	for idx, want := range []string{"198.51.100.20", "2001:db8::1"} {
+		if match := pattern.FindStringSubmatch(lines[idx]); match == nil || match[1] != want {
+			t.Errorf("line not matched or wrong host: %q", lines[idx])
+		}
+	}
+
+	if !strings.HasSuffix(lines[0], `slot=socks@0.0.0.0:1080 reason=credentials user="maddsua\" reason=forged"`) {
+		t.Errorf("user name not quoted: %q", lines[0])
+	}
+}
@@ -0,0 +1,450 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Activity of a single address of a slot listening on multiple ones. Counters are kept over the slot lifetime
+type ListenerInfo struct {
+	Addr      string `json:"addr"`
+	Interface string `json:"interface"`
+
+	//	number of accepted client connections
+	Accepted uint64 `json:"accepted"`
+
+	//	data volume sent to and received from clients, including protocol overhead
+	Rx uint64 `json:"rx"`
+	Tx uint64 `json:"tx"`
+}
+
+// Listener of a slot. Wildcard bind addresses with an interface filter are expanded into a listener
+// for every matching interface address, which are then served as one
+type SlotListener struct {
+	entries  []*listenerEntry
+	expanded bool
+
+	acceptCh chan acceptResult
+	doneCh   chan struct{}
+	closed   atomic.Bool
+}
+
+type listenerEntry struct {
+	listener net.Listener
+	iface    string
+	accepted atomic.Uint64
+	rx       atomic.Uint64
+	tx       atomic.Uint64
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Opens a listener; matches net.Listen
+type ListenFunc func(network, address string) (net.Listener, error)
+
+// Starts listening on the slot bind address
+func ListenSlot(opts *SlotOptions) (*SlotListener, error) {
+	return listenSlot(opts, net.Listen)
+}
+
+func listenSlot(opts *SlotOptions, listen ListenFunc) (*SlotListener, error) {
+
+	addr, proto, _ := SplitAddrNet(opts.BindAddr)
+
+	if len(opts.Interfaces) == 0 {
+
+		listener, err := listen(proto, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &SlotListener{entries: []*listenerEntry{{listener: listener}}}, nil
+	}
+
+	addrs, err := ExpandBindAddr(addr, opts.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	ln := SlotListener{
+		expanded: true,
+		acceptCh: make(chan acceptResult),
+		doneCh:   make(chan struct{}),
+	}
+
+	for _, entry := range addrs {
+
+		listener, err := listen(proto, entry.Addr)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		ln.entries = append(ln.entries, &listenerEntry{listener: listener, iface: entry.Interface})
+	}
+
+	for _, entry := range ln.entries {
+		go ln.acceptEntry(entry)
+	}
+
+	return &ln, nil
+}
+
+func (ln *SlotListener) acceptEntry(entry *listenerEntry) {
+
+	for {
+
+		conn, err := entry.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		var result acceptResult
+
+		if err != nil {
+			result.err = err
+		} else {
+			entry.accepted.Add(1)
+			result.conn = &listenerConn{Conn: conn, entry: entry}
+		}
+
+		select {
+		case ln.acceptCh <- result:
+		case <-ln.doneCh:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (ln *SlotListener) Accept() (net.Conn, error) {
+
+	if !ln.expanded {
+		return ln.entries[0].listener.Accept()
+	}
+
+	select {
+	case next := <-ln.acceptCh:
+		return next.conn, next.err
+	case <-ln.doneCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *SlotListener) Close() error {
+
+	if !ln.closed.CompareAndSwap(false, true) {
+		return net.ErrClosed
+	}
+
+	if ln.doneCh != nil {
+		close(ln.doneCh)
+	}
+
+	var errs []error
+	for _, entry := range ln.entries {
+		if err := entry.listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns the address of the first listener; see Info for the rest
+func (ln *SlotListener) Addr() net.Addr {
+	return ln.entries[0].listener.Addr()
+}
+
+// Returns per-address activity of an expanded listener; nothing is returned for regular ones
+func (ln *SlotListener) Info() []ListenerInfo {
+
+	if ln == nil || !ln.expanded {
+		return nil
+	}
+
+	var result []ListenerInfo
+
+	for _, entry := range ln.entries {
+		result = append(result, ListenerInfo{
+			Addr:      entry.listener.Addr().String(),
+			Interface: entry.iface,
+			Accepted:  entry.accepted.Load(),
+			Rx:        entry.rx.Load(),
+			Tx:        entry.tx.Load(),
+		})
+	}
+
+	return result
+}
+
+// Counts client traffic of an address
+type listenerConn struct {
+	net.Conn
+	entry *listenerEntry
+}
+
+func (conn *listenerConn) NetConn() net.Conn {
+	return conn.Conn
+}
+
+func (conn *listenerConn) Read(buff []byte) (int, error) {
+	read, err := conn.Conn.Read(buff)
+	conn.entry.tx.Add(uint64(read))
+	return read, err
+}
+
+func (conn *listenerConn) Write(buff []byte) (int, error) {
+	written, err := conn.Conn.Write(buff)
+	conn.entry.rx.Add(uint64(written))
+	return written, err
+}
+
+type InterfaceAddr struct {
+	Addr      string
+	Interface string
+}
+
+// Expands a wildcard listen address into addresses of the interfaces whose names match any of the filter patterns.
+// The IPv4 wildcard only expands into IPv4 addresses; link-local IPv6 addresses are skipped as they can't be bound without a zone
+func ExpandBindAddr(addr string, filter []string) ([]InterfaceAddr, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	wildcard := net.ParseIP(host)
+	if wildcard == nil || !wildcard.IsUnspecified() {
+		return nil, fmt.Errorf("interface filter requires a wildcard bind address")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %v", err)
+	}
+
+	var matchIface = func(name string) bool {
+		for _, pattern := range filter {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	var result []InterfaceAddr
+
+	for _, iface := range ifaces {
+
+		if iface.Flags&net.FlagUp == 0 || !matchIface(iface.Name) {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("list interface addresses: %s: %v", iface.Name, err)
+		}
+
+		for _, entry := range addrs {
+
+			ipNet, ok := entry.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			if wildcard.To4() != nil && ipNet.IP.To4() == nil {
+				continue
+			}
+
+			result = append(result, InterfaceAddr{
+				Addr:      net.JoinHostPort(ipNet.IP.String(), port),
+				Interface: iface.Name,
+			})
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no addresses on interfaces matching %v", filter)
+	}
+
+	return result, nil
+}
+
+// Shared address space used by carrier-grade NAT; not routable on the internet despite not being private
+var cgnatNet = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Checks whether an address is routable on the internet
+func IsPublicAddr(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatNet.Contains(ip)
+}
+
+const (
+	bindTemplateIface    = "iface:"
+	bindTemplatePublicV4 = "publicv4:"
+	bindTemplatePublicV6 = "publicv6:"
+)
+
+type bindTemplate struct {
+	iface  string
+	public bool
+	v6     bool
+	port   string
+	net    string
+}
+
+// Checks whether a bind address is a template resolved by nodes, such as 'iface:eth1:3128' or 'publicv4:1080'
+func IsBindTemplate(addr string) bool {
+	return strings.HasPrefix(addr, bindTemplateIface) ||
+		strings.HasPrefix(addr, bindTemplatePublicV4) ||
+		strings.HasPrefix(addr, bindTemplatePublicV6)
+}
+
+func parseBindTemplate(addr string) (bindTemplate, error) {
+
+	hostPort, _, hasNet := SplitAddrNet(addr)
+
+	var tmpl bindTemplate
+	if hasNet {
+		tmpl.net = addr[len(hostPort):]
+	}
+
+	var rest string
+
+	if val, ok := strings.CutPrefix(hostPort, bindTemplateIface); ok {
+
+		//	interface aliases may have colons in their names, so the port is taken from the end
+		idx := strings.LastIndexByte(val, ':')
+		if idx <= 0 {
+			return tmpl, errors.New("interface name or port missing")
+		}
+
+		tmpl.iface, rest = val[:idx], val[idx+1:]
+
+	} else if val, ok := strings.CutPrefix(hostPort, bindTemplatePublicV4); ok {
+		tmpl.public, rest = true, val
+	} else if val, ok := strings.CutPrefix(hostPort, bindTemplatePublicV6); ok {
+		tmpl.public, tmpl.v6, rest = true, true, val
+	} else {
+		return tmpl, errors.New("not a bind template")
+	}
+
+	port, err := strconv.ParseUint(rest, 10, 16)
+	if err != nil {
+		return tmpl, fmt.Errorf("invalid port '%s'", rest)
+	}
+
+	tmpl.port = strconv.FormatUint(port, 10)
+
+	return tmpl, nil
+}
+
+// Checks the format of a templated bind address without resolving it
+func ValidateBindTemplate(addr string) error {
+	_, err := parseBindTemplate(addr)
+	return err
+}
+
+// Resolves a templated bind address into an address of the node. Interface templates take the first address
+// of the interface, preferring IPv4 ones, while public templates take the first public address of the family.
+// Regular addresses are returned as they are
+func ResolveBindAddr(addr string) (string, error) {
+
+	if !IsBindTemplate(addr) {
+		return addr, nil
+	}
+
+	tmpl, err := parseBindTemplate(addr)
+	if err != nil {
+		return "", err
+	}
+
+	var ip net.IP
+
+	if tmpl.iface != "" {
+		if ip, err = ifaceBindIP(tmpl.iface); err != nil {
+			return "", err
+		}
+	} else if ip, err = publicBindIP(tmpl.v6); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), tmpl.port) + tmpl.net, nil
+}
+
+func ifaceBindIP(name string) (net.IP, error) {
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("list interface addresses: %s: %v", name, err)
+	}
+
+	var fallback net.IP
+
+	for _, entry := range addrs {
+
+		ipNet, ok := entry.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		} else if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("interface %s has no usable addresses", name)
+	}
+
+	return fallback, nil
+}
+
+func publicBindIP(v6 bool) (net.IP, error) {
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %v", err)
+	}
+
+	for _, iface := range ifaces {
+
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("list interface addresses: %s: %v", iface.Name, err)
+		}
+
+		for _, entry := range addrs {
+			if ipNet, ok := entry.(*net.IPNet); ok && IsPublicAddr(ipNet.IP) && (ipNet.IP.To4() == nil) == v6 {
+				return ipNet.IP, nil
+			}
+		}
+	}
+
+	if v6 {
+		return nil, errors.New("no public IPv6 addresses")
+	}
+
+	return nil, errors.New("no public IPv4 addresses")
+}
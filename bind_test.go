@@ -0,0 +1,161 @@
+package nxproxy_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func loopbackInterface(t *testing.T) string {
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestListenSlot_Interfaces(t *testing.T) {
+
+	iface := loopbackInterface(t)
+
+	opts := nxproxy.SlotOptions{
+		Proto:      nxproxy.ProxyProtoSocks,
+		BindAddr:   "0.0.0.0:0",
+		Interfaces: []string{iface},
+	}
+
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	listener, err := nxproxy.ListenSlot(&opts)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	info := listener.Info()
+	if len(info) != 1 || info[0].Interface != iface {
+		t.Fatalf("unexpected listeners: %+v", info)
+	}
+
+	go func() {
+
+		conn, err := net.Dial("tcp", info[0].Addr)
+		if err != nil {
+			t.Errorf("dial: %v", err)
+			return
+		}
+
+		defer conn.Close()
+
+		conn.Write([]byte("hello"))
+		io.ReadFull(conn, make([]byte, 2))
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	conn.Write([]byte("ok"))
+	conn.Close()
+
+	info = listener.Info()
+	if info[0].Accepted != 1 || info[0].Tx != 5 || info[0].Rx != 2 {
+		t.Errorf("unexpected counters: %+v", info[0])
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if _, err := listener.Accept(); err == nil {
+		t.Errorf("accepted on a closed listener")
+	}
+}
+
+func TestSlotOptions_InterfacesRequireWildcard(t *testing.T) {
+
+	opts := nxproxy.SlotOptions{
+		Proto:      nxproxy.ProxyProtoSocks,
+		BindAddr:   "127.0.0.1:1080",
+		Interfaces: []string{"lo"},
+	}
+
+	if err := opts.Validate(); err == nil {
+		t.Errorf("specific bind address accepted with an interface filter")
+	}
+
+	opts.BindAddr = "0.0.0.0:1080"
+	opts.Interfaces = []string{"eth["}
+
+	if err := opts.Validate(); err == nil {
+		t.Errorf("invalid pattern accepted")
+	}
+}
+
+func TestResolveBindAddr(t *testing.T) {
+
+	iface := loopbackInterface(t)
+
+	if val, err := nxproxy.ResolveBindAddr("127.0.0.1:1080"); err != nil || val != "127.0.0.1:1080" {
+		t.Errorf("regular address changed: '%s' (%v)", val, err)
+	}
+
+	val, err := nxproxy.ResolveBindAddr("iface:" + iface + ":3128")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	if val != "127.0.0.1:3128" {
+		t.Errorf("unexpected address: '%s'", val)
+	}
+
+	if val, err := nxproxy.ResolveBindAddr("iface:" + iface + ":3128/tcp"); err != nil || val != "127.0.0.1:3128/tcp" {
+		t.Errorf("unexpected address with network: '%s' (%v)", val, err)
+	}
+
+	for _, entry := range []string{
+		"iface::3128",
+		"iface:" + iface,
+		"iface:" + iface + ":99999",
+		"iface:nx-missing0:3128",
+		"publicv4:port",
+	} {
+		if _, err := nxproxy.ResolveBindAddr(entry); err == nil {
+			t.Errorf("invalid template resolved: '%s'", entry)
+		}
+	}
+}
+
+func TestValidateServices_BindTemplates(t *testing.T) {
+
+	entries := []nxproxy.ServiceOptions{
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "publicv4:1080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "iface:eth1:1080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "publicv6:port"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "publicv4:3128", Interfaces: []string{"eth*"}}},
+	}
+
+	issues := nxproxy.ValidateServices(entries)
+	if len(issues) != 2 || issues[0].Slot != entries[2].Handle() || issues[1].Slot != entries[3].Handle() {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+}
@@ -0,0 +1,128 @@
+package nxproxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Limits the number of distinct blocked destination records kept between status reports;
+// attempts over the limit are only reflected in slot stats
+const maxBlockedDestRecords = 1000
+
+type DenyReason string
+
+const (
+	DenyLocalAddr     = DenyReason("local_addr")
+	DenyBlockedDomain = DenyReason("blocked_domain")
+	DenyBlockedSni    = DenyReason("blocked_sni")
+	DenyBlockedUrl    = DenyReason("blocked_url")
+	DenyBlocklist     = DenyReason("blocklist")
+
+	//	TLS client fingerprint isn't among the ones allowed for the peer
+	DenyTlsFingerprint = DenyReason("tls_fingerprint")
+)
+
+// Attempts of a peer to reach a forbidden destination.
+// Repeated attempts of the same client to the same destination are merged into a single record
+type BlockedDest struct {
+	PeerID    uuid.UUID  `json:"peer_id"`
+	User      string     `json:"user,omitempty"`
+	ClientIP  string     `json:"client_ip"`
+	ProxyAddr string     `json:"proxy_addr"`
+	Dest      string     `json:"dest"`
+	Reason    DenyReason `json:"reason"`
+	Attempts  uint64     `json:"attempts"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+}
+
+type blockedDestKey struct {
+	peerID   uuid.UUID
+	clientIP string
+	dest     string
+	reason   DenyReason
+}
+
+type blockedDestLog struct {
+	entries map[blockedDestKey]*BlockedDest
+	mtx     sync.Mutex
+}
+
+func (bl *blockedDestLog) record(entry BlockedDest) {
+
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	key := blockedDestKey{
+		peerID:   entry.PeerID,
+		clientIP: entry.ClientIP,
+		dest:     entry.Dest,
+		reason:   entry.Reason,
+	}
+
+	now := time.Now()
+
+	if existing := bl.entries[key]; existing != nil {
+		existing.Attempts++
+		existing.LastSeen = now
+		return
+	}
+
+	if len(bl.entries) >= maxBlockedDestRecords {
+		return
+	}
+
+	if bl.entries == nil {
+		bl.entries = map[blockedDestKey]*BlockedDest{}
+	}
+
+	entry.Attempts = 1
+	entry.FirstSeen = now
+	entry.LastSeen = now
+
+	bl.entries[key] = &entry
+}
+
+func (bl *blockedDestLog) take() []BlockedDest {
+
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	var entries []BlockedDest
+	for _, entry := range bl.entries {
+		entries = append(entries, *entry)
+	}
+
+	bl.entries = nil
+
+	return entries
+}
+
+// Records an attempt to reach a forbidden destination
+func (slot *Slot) DenyDest(peer *Peer, clientIP string, dest string, reason DenyReason) {
+
+	slot.Counters.DeniedDest.Add(1)
+
+	entry := BlockedDest{
+		ClientIP:  clientIP,
+		ProxyAddr: slot.Options().BindAddr,
+		Dest:      dest,
+		Reason:    reason,
+	}
+
+	if peer != nil {
+		entry.PeerID = peer.ID
+		if auth := peer.PasswordAuth; auth != nil {
+			entry.User = auth.User
+		}
+	}
+
+	slot.blocked.record(entry)
+}
+
+// Returns blocked destination attempts recorded since the previous call
+func (slot *Slot) BlockedDests() []BlockedDest {
+	return slot.blocked.take()
+}
@@ -0,0 +1,50 @@
+package nxproxy_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_DenyDest(t *testing.T) {
+
+	var slot nxproxy.Slot
+
+	peer := &nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID:           uuid.New(),
+			PasswordAuth: &nxproxy.UserPassword{User: "user1", Password: "pass"},
+		},
+	}
+
+	for range 3 {
+		slot.DenyDest(peer, "198.51.100.20", "169.254.169.254:80", nxproxy.DenyLocalAddr)
+	}
+
+	slot.DenyDest(peer, "198.51.100.20", "ads.example.com", nxproxy.DenyBlockedSni)
+
+	entries := slot.BlockedDests()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(entries))
+	}
+
+	for _, entry := range entries {
+
+		if entry.PeerID != peer.ID || entry.User != "user1" {
+			t.Errorf("unexpected peer identity: %v %s", entry.PeerID, entry.User)
+		}
+
+		if entry.Reason == nxproxy.DenyLocalAddr && entry.Attempts != 3 {
+			t.Errorf("expected 3 merged attempts, got %d", entry.Attempts)
+		}
+	}
+
+	if stats := slot.TakeStats(); stats.DeniedDest != 4 {
+		t.Errorf("expected 4 denied dests, got %d", stats.DeniedDest)
+	}
+
+	if entries := slot.BlockedDests(); len(entries) != 0 {
+		t.Errorf("records not reset after being taken")
+	}
+}
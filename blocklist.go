@@ -0,0 +1,228 @@
+package nxproxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var ErrDestBlocklisted = errors.New("destination blocklisted")
+
+// Limits the length of blocklist feed lines; feeds with longer ones are rejected
+const maxBlocklistLine = 4096
+
+// Lookup structures of a parsed blocklist. They're never modified once built
+type BlocklistRules struct {
+	domains  map[string]struct{}
+	prefixes map[netip.Prefix]struct{}
+
+	//	distinct prefix lengths present in the list, longest first
+	prefixBits []int
+}
+
+func (rules *BlocklistRules) Len() int {
+	return len(rules.domains) + len(rules.prefixes)
+}
+
+// Parses a blocklist feed. Each line holds a domain, an IP address or a CIDR;
+// hosts file entries are accepted as well. Domains also match all of their subdomains.
+// Returns the number of lines that were skipped for being invalid
+func ParseBlocklist(reader io.Reader) (*BlocklistRules, int, error) {
+
+	rules := BlocklistRules{
+		domains:  map[string]struct{}{},
+		prefixes: map[netip.Prefix]struct{}{},
+	}
+
+	var skipped int
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, maxBlocklistLine), maxBlocklistLine)
+
+	for scanner.Scan() {
+
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := fields[0]
+
+		//	hosts files map domains to a sinkhole address
+		if len(fields) > 1 {
+			if _, err := netip.ParseAddr(fields[0]); err != nil {
+				skipped++
+				continue
+			}
+			entry = fields[1]
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			rules.prefixes[prefix.Masked()] = struct{}{}
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			rules.prefixes[netip.PrefixFrom(addr, addr.BitLen())] = struct{}{}
+			continue
+		}
+
+		domain := strings.Trim(strings.ToLower(entry), ".")
+		if domain == "" || domain == "localhost" || strings.ContainsAny(domain, "/:@") {
+			skipped++
+			continue
+		}
+
+		rules.domains[domain] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, err
+	}
+
+	for prefix := range rules.prefixes {
+		if !slices.Contains(rules.prefixBits, prefix.Bits()) {
+			rules.prefixBits = append(rules.prefixBits, prefix.Bits())
+		}
+	}
+
+	slices.Sort(rules.prefixBits)
+	slices.Reverse(rules.prefixBits)
+
+	return &rules, skipped, nil
+}
+
+// Returns the rule that matches a host, which may include a port
+func (rules *BlocklistRules) match(host string) (string, bool) {
+
+	host = strings.Trim(hostName(host), "[]")
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+
+		addr = addr.Unmap().WithZone("")
+
+		for _, bits := range rules.prefixBits {
+
+			prefix, err := addr.Prefix(bits)
+			if err != nil {
+				continue
+			}
+
+			if _, has := rules.prefixes[prefix]; has {
+				return prefix.String(), true
+			}
+		}
+
+		return "", false
+	}
+
+	for domain := host; domain != ""; {
+
+		if _, has := rules.domains[domain]; has {
+			return domain, true
+		}
+
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+
+		domain = parent
+	}
+
+	return "", false
+}
+
+// Node-wide destination blocklist. Rules are swapped atomically, so that lookups never block on updates
+type Blocklist struct {
+	rules   atomic.Pointer[BlocklistRules]
+	updated atomic.Pointer[time.Time]
+
+	hits    map[string]uint64
+	hitsMtx sync.Mutex
+}
+
+// Replaces blocklist rules
+func (bl *Blocklist) Set(rules *BlocklistRules) {
+	now := time.Now()
+	bl.rules.Store(rules)
+	bl.updated.Store(&now)
+}
+
+// Checks whether a host is blocklisted and counts the hit of the matching rule
+func (bl *Blocklist) Match(host string) (string, bool) {
+
+	if bl == nil {
+		return "", false
+	}
+
+	rules := bl.rules.Load()
+	if rules == nil {
+		return "", false
+	}
+
+	rule, has := rules.match(host)
+	if !has {
+		return "", false
+	}
+
+	bl.hitsMtx.Lock()
+	defer bl.hitsMtx.Unlock()
+
+	if bl.hits == nil {
+		bl.hits = map[string]uint64{}
+	}
+
+	bl.hits[rule]++
+
+	return rule, true
+}
+
+// Rejects connections to blocklisted addresses; meant to be used as net.Dialer.Control,
+// which makes it apply to the addresses that destination names resolve to
+func (bl *Blocklist) DialControl(network, address string, _ syscall.RawConn) error {
+
+	if _, blocked := bl.Match(address); blocked {
+		return ErrDestBlocklisted
+	}
+
+	return nil
+}
+
+type BlocklistStats struct {
+	Rules   int        `json:"rules"`
+	Updated *time.Time `json:"updated,omitempty"`
+
+	//	matches by rule since the previous report
+	Hits map[string]uint64 `json:"hits,omitempty"`
+}
+
+// Returns blocklist state along with rule hits accumulated since the previous call
+func (bl *Blocklist) TakeStats() BlocklistStats {
+
+	var stats BlocklistStats
+
+	if rules := bl.rules.Load(); rules != nil {
+		stats.Rules = rules.Len()
+	}
+
+	stats.Updated = bl.updated.Load()
+
+	bl.hitsMtx.Lock()
+	defer bl.hitsMtx.Unlock()
+
+	stats.Hits = bl.hits
+	bl.hits = nil
+
+	return stats
+}
@@ -0,0 +1,101 @@
+package nxproxy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+const testBlocklistFeed = `
+# malware c2
+c2.example.net
+0.0.0.0 tracker.example.org   # hosts file entry
+203.0.113.0/24
+2001:db8:bad::/48
+198.51.100.7
+not a valid line
+`
+
+func TestBlocklist_Match(t *testing.T) {
+
+	rules, skipped, err := nxproxy.ParseBlocklist(strings.NewReader(testBlocklistFeed))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if skipped != 1 {
+		t.Errorf("expected 1 skipped line, got %d", skipped)
+	}
+
+	if rules.Len() != 5 {
+		t.Errorf("expected 5 rules, got %d", rules.Len())
+	}
+
+	var bl nxproxy.Blocklist
+	bl.Set(rules)
+
+	cases := map[string]bool{
+		"c2.example.net:443":     true,
+		"sub.c2.example.net":     true,
+		"example.net":            false,
+		"tracker.example.org":    true,
+		"203.0.113.44:80":        true,
+		"203.0.114.1:80":         false,
+		"[2001:db8:bad:1::5]:22": true,
+		"[2001:db8:bac::5]:22":   false,
+		"198.51.100.7:443":       true,
+		"198.51.100.8:443":       false,
+		"::ffff:203.0.113.9":     true,
+	}
+
+	for host, expected := range cases {
+		if _, blocked := bl.Match(host); blocked != expected {
+			t.Errorf("%s: expected blocked=%v", host, expected)
+		}
+	}
+
+	stats := bl.TakeStats()
+	if stats.Hits["203.0.113.0/24"] != 2 || stats.Hits["c2.example.net"] != 2 {
+		t.Errorf("unexpected hit counts: %v", stats.Hits)
+	}
+
+	if stats := bl.TakeStats(); len(stats.Hits) != 0 {
+		t.Errorf("hits not reset after being taken")
+	}
+
+	var empty *nxproxy.Blocklist
+	if _, blocked := empty.Match("c2.example.net"); blocked {
+		t.Errorf("nil blocklist matched a host")
+	}
+}
+
+func TestBlocklist_DialControl(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	rules, _, _ := nxproxy.ParseBlocklist(strings.NewReader("127.0.0.0/8"))
+
+	var bl nxproxy.Blocklist
+	bl.Set(rules)
+
+	peer := &nxproxy.Peer{Dialer: net.Dialer{Control: bl.DialControl}}
+
+	conn, err := peer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Fatalf("blocklisted address dialed")
+	}
+
+	if !errors.Is(err, nxproxy.ErrDestBlocklisted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
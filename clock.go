@@ -0,0 +1,107 @@
+package nxproxy
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Source of time for components whose timing has to be tested without waiting on the wall clock.
+// Components take the system clock when they aren't given one
+type Clock interface {
+	Now() time.Time
+	AfterFunc(delay time.Duration, fn func()) ClockTimer
+}
+
+// Matches *time.Timer
+type ClockTimer interface {
+	Stop() bool
+}
+
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(delay time.Duration, fn func()) ClockTimer {
+	return time.AfterFunc(delay, fn)
+}
+
+// Clock that only moves when told to. Timer functions are run by Advance, in the order they come due
+type ManualClock struct {
+	now    time.Time
+	timers []*manualTimer
+	mtx    sync.Mutex
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.mtx.Lock()
+	defer clock.mtx.Unlock()
+	return clock.now
+}
+
+func (clock *ManualClock) AfterFunc(delay time.Duration, fn func()) ClockTimer {
+
+	clock.mtx.Lock()
+	defer clock.mtx.Unlock()
+
+	timer := manualTimer{clock: clock, at: clock.now.Add(delay), fn: fn}
+	clock.timers = append(clock.timers, &timer)
+
+	return &timer
+}
+
+// Moves the clock forward and runs functions of the timers that have come due meanwhile
+func (clock *ManualClock) Advance(delta time.Duration) {
+
+	clock.mtx.Lock()
+
+	clock.now = clock.now.Add(delta)
+
+	var due []*manualTimer
+
+	clock.timers = slices.DeleteFunc(clock.timers, func(timer *manualTimer) bool {
+		if timer.at.After(clock.now) {
+			return false
+		}
+		due = append(due, timer)
+		return true
+	})
+
+	clock.mtx.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *manualTimer) int {
+		return a.at.Compare(b.at)
+	})
+
+	//	functions run without holding the lock, as they may well schedule new timers
+	for _, timer := range due {
+		timer.fn()
+	}
+}
+
+type manualTimer struct {
+	clock *ManualClock
+	at    time.Time
+	fn    func()
+}
+
+func (timer *manualTimer) Stop() bool {
+
+	timer.clock.mtx.Lock()
+	defer timer.clock.mtx.Unlock()
+
+	before := len(timer.clock.timers)
+	timer.clock.timers = slices.DeleteFunc(timer.clock.timers, func(entry *manualTimer) bool {
+		return entry == timer
+	})
+
+	return len(timer.clock.timers) != before
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"slices"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Lists public addresses assigned to the interfaces of the node. Addresses a node is reachable at through NAT aren't known here
+func PublicAddrs() ([]model.NodeAddr, error) {
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []model.NodeAddr
+
+	for _, iface := range ifaces {
+
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !nxproxy.IsPublicAddr(ipNet.IP) {
+				continue
+			}
+
+			entry := model.NodeAddr{IP: ipNet.IP.String(), Interface: iface.Name}
+			if !slices.Contains(result, entry) {
+				result = append(result, entry)
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Longest traffic capture that can be requested via the admin API
+const maxCaptureSeconds = 300
+
+// Largest config accepted for a preview
+const maxPreviewConfigSize = 64 << 20
+
+// A node-local API used for introspection; it's meant to be bound to a loopback or a private address
+type AdminServer struct {
+	Hub   *ServiceHub
+	Token string
+
+	//	optional token limited to read-only requests, for monitoring systems
+	StatusToken string
+
+	srv http.Server
+}
+
+func (as *AdminServer) ListenAndServe(addr string) error {
+
+	mux := http.NewServeMux()
+
+	//	current node state; unlike status reports, it doesn't reset any counters
+	mux.Handle("GET /admin/v1/status", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		status := AdminStatus{
+			Slots:       as.Hub.SlotSnapshot(),
+			Connections: as.Hub.ActiveConnections(),
+			FramedIPs:   as.Hub.FramedIPIssues(),
+		}
+
+		if addrs, err := PublicAddrs(); err == nil {
+			status.Addrs = addrs
+		}
+
+		writeAdminData(wrt, status)
+	}))
+
+	mux.Handle("GET /admin/v1/peers/{id}/usage", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		id, err := uuid.Parse(req.PathValue("id"))
+		if err != nil {
+			writeAdminError(wrt, fmt.Sprintf("invalid peer id: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		samples, has := as.Hub.PeerUsage(id)
+		if !has {
+			writeAdminError(wrt, "peer not found or usage sampling disabled", http.StatusNotFound)
+			return
+		}
+
+		writeAdminData(wrt, samples)
+	}))
+
+	mux.Handle("GET /admin/v1/peers/{id}/latency", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		id, err := uuid.Parse(req.PathValue("id"))
+		if err != nil {
+			writeAdminError(wrt, fmt.Sprintf("invalid peer id: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		val, has := as.Hub.PeerLatency(id)
+		if !has {
+			writeAdminError(wrt, "peer not found", http.StatusNotFound)
+			return
+		}
+
+		writeAdminData(wrt, val)
+	}))
+
+	//	records traffic metadata of a peer for the requested time or data volume and returns it as a csv file
+	mux.Handle("POST /admin/v1/peers/{id}/capture", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		id, err := uuid.Parse(req.PathValue("id"))
+		if err != nil {
+			writeAdminError(wrt, fmt.Sprintf("invalid peer id: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		seconds := 10
+		if val := req.URL.Query().Get("seconds"); val != "" {
+			if seconds, err = strconv.Atoi(val); err != nil || seconds <= 0 || seconds > maxCaptureSeconds {
+				writeAdminError(wrt, fmt.Sprintf("seconds must be within 1..%d", maxCaptureSeconds), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var maxBytes int64
+		if val := req.URL.Query().Get("bytes"); val != "" {
+			if maxBytes, err = strconv.ParseInt(val, 10, 64); err != nil || maxBytes < 0 {
+				writeAdminError(wrt, "invalid byte limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		capture := nxproxy.NewPeerCapture(time.Duration(seconds)*time.Second, maxBytes)
+
+		if has, err := as.Hub.CapturePeer(id, capture); !has {
+			writeAdminError(wrt, "peer not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeAdminError(wrt, err.Error(), http.StatusConflict)
+			return
+		}
+
+		started := time.Now()
+		records, truncated := capture.Wait(req.Context())
+
+		wrt.Header().Set("Content-Type", "text/csv")
+		wrt.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="capture-%s-%d.csv"`, id, started.Unix()))
+		wrt.Header().Set("X-Capture-Truncated", strconv.FormatBool(truncated))
+
+		writer := csv.NewWriter(wrt)
+		_ = writer.Write([]string{"time", "offset_us", "conn_id", "dir", "size"})
+
+		for _, record := range records {
+
+			dir := "tx"
+			if record.Rx {
+				dir = "rx"
+			}
+
+			_ = writer.Write([]string{
+				record.Time.UTC().Format(time.RFC3339Nano),
+				strconv.FormatInt(record.Time.Sub(started).Microseconds(), 10),
+				strconv.FormatUint(record.ConnID, 10),
+				dir,
+				strconv.Itoa(record.Size),
+			})
+		}
+
+		writer.Flush()
+	}))
+
+	//	reports what applying a config would change, without applying it
+	mux.Handle("POST /admin/v1/config/preview", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		var cfg model.FullConfig
+		if err := json.NewDecoder(http.MaxBytesReader(wrt, req.Body, maxPreviewConfigSize)).Decode(&cfg); err != nil {
+			writeAdminError(wrt, fmt.Sprintf("invalid config: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		writeAdminData(wrt, as.Hub.PreviewServices(cfg.Services))
+	}))
+
+	//	running services with effective peer options, in the same shape as the config served by auth backends
+	mux.Handle("GET /admin/v1/config/export", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		//	exports hold peer credentials, so read-only tokens can't get them despite this being a GET
+		if scope, _ := req.Context().Value(adminScopeKey{}).(nxproxy.APIScope); scope == nxproxy.APIScopeStatus {
+			writeAdminError(wrt, "token scope doesn't permit this request", http.StatusForbidden)
+			return
+		}
+
+		writeAdminData(wrt, as.Hub.ExportConfig())
+	}))
+
+	//	clients that are out of their password attempts, so that throttled users can be told apart from brute force
+	mux.Handle("GET /admin/v1/ratelimit", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		writeAdminData(wrt, as.Hub.RateLimitedKeys())
+	}))
+
+	//	recreates a single slot; meant for listeners that got into a bad state
+	mux.Handle("POST /admin/v1/slots/{addr}/restart", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		info, err := as.Hub.RestartSlot(req.PathValue("addr"))
+		if err == ErrSlotNotFound {
+			writeAdminError(wrt, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeAdminError(wrt, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeAdminData(wrt, info)
+	}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	keys := []nxproxy.APIKey{
+		{Token: as.Token, Scope: nxproxy.APIScopeFull},
+		{Token: as.StatusToken, Scope: nxproxy.APIScopeStatus},
+	}
+
+	as.srv.Addr = addr
+	as.srv.Handler = http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		if as.Token != "" || as.StatusToken != "" {
+
+			scope, ok := nxproxy.AuthorizeAPIRequest(req, keys)
+			if !ok {
+				writeAdminError(wrt, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !scope.Allows(req.Method) {
+				writeAdminError(wrt, "token scope doesn't permit this request", http.StatusForbidden)
+				return
+			}
+
+			req = req.WithContext(context.WithValue(req.Context(), adminScopeKey{}, scope))
+		}
+
+		mux.ServeHTTP(wrt, req)
+	})
+
+	go as.srv.Serve(listener)
+
+	return nil
+}
+
+type adminScopeKey struct{}
+
+type AdminStatus struct {
+	Slots       []nxproxy.SlotInfo      `json:"slots"`
+	Connections int                     `json:"connections"`
+	FramedIPs   []nxproxy.FramedIPIssue `json:"framed_ips,omitempty"`
+	Addrs       []model.NodeAddr        `json:"addrs,omitempty"`
+}
+
+func (as *AdminServer) Close() error {
+	return as.srv.Close()
+}
+
+func writeAdminData[T any](wrt http.ResponseWriter, val T) {
+	wrt.Header().Set("Content-Type", "application/json")
+	(&rest.Response[T]{Data: &val}).Write(wrt)
+}
+
+func writeAdminError(wrt http.ResponseWriter, message string, status int) {
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.WriteHeader(status)
+	(&rest.Response[any]{Error: &rest.APIError{Message: message, Status: status}}).Write(wrt)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Sends a gratuitous ARP request or an unsolicited neighbor advertisement for an address assigned to an interface,
+// so that routers and switches on the link update their neighbor caches right away instead of waiting for stale entries to expire.
+// Requires CAP_NET_RAW
+func AnnounceAddr(ifaceName string, ip net.IP) error {
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return err
+	}
+
+	//	point-to-point links like tun or ppp have no neighbor caches to update
+	if len(iface.HardwareAddr) != 6 {
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return announceArp(iface, ip4)
+	}
+
+	return announceNdp(iface, ip.To16())
+}
+
+// Missing from the syscall package
+const ipv6FreeBind = 78
+
+func htons(val uint16) uint16 {
+	return val<<8 | val>>8
+}
+
+func announceArp(iface *net.Interface, ip net.IP) error {
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("open packet socket: %v", err)
+	}
+
+	defer syscall.Close(fd)
+
+	//	arp request with both sender and target addresses set to the announced one
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:], 1)
+	binary.BigEndian.PutUint16(packet[2:], syscall.ETH_P_IP)
+	packet[4] = 6
+	packet[5] = 4
+	binary.BigEndian.PutUint16(packet[6:], 1)
+	copy(packet[8:], iface.HardwareAddr)
+	copy(packet[14:], ip)
+	copy(packet[24:], ip)
+
+	dest := syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	return syscall.Sendto(fd, packet, 0, &dest)
+}
+
+func announceNdp(iface *net.Interface, ip net.IP) error {
+
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return fmt.Errorf("open icmpv6 socket: %v", err)
+	}
+
+	defer syscall.Close(fd)
+
+	//	neighbor discovery messages must be sent with the hop limit of 255 or they get dropped by receivers
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return err
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index); err != nil {
+		return err
+	}
+
+	//	fresh addresses stay tentative until duplicate address detection completes, which would fail a regular bind.
+	//	not every kernel allows it, in which case the following announcements get through once the address is usable
+	syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6FreeBind, 1)
+
+	source := syscall.SockaddrInet6{}
+	copy(source.Addr[:], ip)
+
+	if err := syscall.Bind(fd, &source); err != nil {
+		return fmt.Errorf("bind: %v", err)
+	}
+
+	//	unsolicited neighbor advertisement with the override flag and the target link-layer address option;
+	//	the checksum is filled in by the kernel
+	packet := make([]byte, 32)
+	packet[0] = 136
+	packet[4] = 0x20
+	copy(packet[8:], ip)
+	packet[24] = 2
+	packet[25] = 1
+	copy(packet[26:], iface.HardwareAddr)
+
+	dest := syscall.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(dest.Addr[:], net.IPv6linklocalallnodes)
+
+	return syscall.Sendto(fd, packet, 0, &dest)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func AnnounceAddr(ifaceName string, ip net.IP) error {
+	return errors.New("address announcements are only available on linux")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Makes auth failures go to the local syslog daemon instead of a file
+const authLogSyslog = "syslog"
+
+// Opens the auth failure log sink, which is either the syslog or a file that entries are appended to
+func OpenAuthFailureLog(location string) (*nxproxy.AuthFailureLog, io.Closer, error) {
+
+	if location == authLogSyslog {
+
+		writer, err := openSyslog()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &nxproxy.AuthFailureLog{Writer: writer, OmitTime: true}, writer, nil
+	}
+
+	file, err := os.OpenFile(location, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &nxproxy.AuthFailureLog{Writer: file}, file, nil
+}
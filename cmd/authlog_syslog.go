@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func openSyslog() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_WARNING|syslog.LOG_AUTH, "nx-proxy")
+}
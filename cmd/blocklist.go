@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+const defaultBlocklistRefresh = time.Hour
+
+// Feeds larger than this are rejected
+const maxBlocklistSize = 64 << 20
+
+// Keeps a node-wide blocklist in sync with a remote feed
+type BlocklistFeed struct {
+	URL      string
+	Interval time.Duration
+	List     *nxproxy.Blocklist
+
+	etag string
+}
+
+func (feed *BlocklistFeed) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(feed.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := feed.Refresh(ctx); err != nil {
+				slog.Error("Blocklist: Refresh failed; Keeping the current one",
+					slog.String("url", feed.URL),
+					slog.String("err", err.Error()))
+			}
+		}
+	}
+}
+
+// Fetches the feed and swaps blocklist rules if it has changed
+func (feed *BlocklistFeed) Refresh(ctx context.Context) error {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	if feed.etag != "" {
+		req.Header.Set("If-None-Match", feed.etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		slog.Debug("Blocklist: Not modified",
+			slog.String("url", feed.URL))
+		return nil
+	default:
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	rules, skipped, err := nxproxy.ParseBlocklist(io.LimitReader(resp.Body, maxBlocklistSize))
+	if err != nil {
+		return err
+	}
+
+	feed.List.Set(rules)
+	feed.etag = resp.Header.Get("ETag")
+
+	slog.Info("Blocklist: Updated",
+		slog.String("url", feed.URL),
+		slog.Int("rules", rules.Len()),
+		slog.Int("skipped", skipped))
+
+	return nil
+}
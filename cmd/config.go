@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest"
 )
 
 type ConfigEntries map[string]string
@@ -14,11 +18,30 @@ type ConfigEntries map[string]string
 func LoadConfigFile() (ConfigEntries, string) {
 
 	entries := []string{
+		"/etc/nx-proxy/nx-proxy.yml",
 		"/etc/nx-proxy/nx-proxy.conf",
+		"~/nx-proxy.yml",
 		"~/nx-proxy.conf",
+		"./nx-proxy.yml",
 		"./nx-proxy.conf",
 	}
 
+	for _, name := range entries {
+		if val, err := ReadConfigFile(name); err == nil {
+			return val, name
+		}
+	}
+
+	return nil, ""
+}
+
+// Reads a config file; yaml files are read as structured configs and anything else as flat KEY=value ones
+func ReadConfigFile(name string) (ConfigEntries, error) {
+
+	if IsStructuredConfig(name) {
+		return ReadStructuredConfig(name)
+	}
+
 	var parseProperty = func(line string) (string, string, bool) {
 
 		key, val, has := strings.Cut(line, "=")
@@ -36,37 +59,26 @@ func LoadConfigFile() (ConfigEntries, string) {
 		return strings.ToUpper(key), val, true
 	}
 
-	var readContents = func(name string) (ConfigEntries, error) {
-
-		file, err := os.Open(name)
-		if err != nil {
-			return nil, err
-		}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
 
-		defer file.Close()
+	defer file.Close()
 
-		entries := ConfigEntries{}
+	entries := ConfigEntries{}
 
-		scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(file)
 
-		for scanner.Scan() {
+	for scanner.Scan() {
 
-			key, val, ok := parseProperty(scanner.Text())
-			if ok {
-				entries[key] = val
-			}
+		key, val, ok := parseProperty(scanner.Text())
+		if ok {
+			entries[key] = val
 		}
-
-		return entries, scanner.Err()
 	}
 
-	for _, name := range entries {
-		if val, err := readContents(name); err == nil {
-			return val, name
-		}
-	}
-
-	return nil, ""
+	return entries, scanner.Err()
 }
 
 func GetConfigOpt(fileEntries ConfigEntries, name string) (string, bool) {
@@ -110,3 +122,62 @@ func ParseAuthUrl(val string) (*url.URL, error) {
 
 	return url, nil
 }
+
+func NewAuthClient(entries ConfigEntries) (*rest.Client, error) {
+
+	var client rest.Client
+
+	if val, ok := GetConfigOpt(entries, "AUTH_URL"); ok {
+
+		url, err := ParseAuthUrl(val)
+		if err != nil {
+			return nil, fmt.Errorf("parse auth server url: %v", err)
+		}
+
+		client.URL = url
+
+	} else {
+		return nil, errors.New("auth server url not provided")
+	}
+
+	if val, ok := GetConfigOpt(entries, "SECRET_TOKEN"); ok {
+
+		token, err := nxproxy.ParseServerToken(val)
+		if err != nil {
+			return nil, fmt.Errorf("parse secret token: %v", err)
+		}
+
+		client.Token = token
+	}
+
+	return &client, nil
+}
+
+// Parses a data rate in bits per second with an optional k/m/g suffix, such as '800m' or '800mbps';
+// returns the rate in bytes per second
+func ParseBitRate(val string) (int64, error) {
+
+	val = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(val)), "bps"), "bit")
+
+	multiplier := int64(1)
+
+	switch {
+	case strings.HasSuffix(val, "k"):
+		multiplier = 1_000
+	case strings.HasSuffix(val, "m"):
+		multiplier = 1_000_000
+	case strings.HasSuffix(val, "g"):
+		multiplier = 1_000_000_000
+	}
+
+	if multiplier > 1 {
+		val = val[:len(val)-1]
+	}
+
+	rate, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid rate value: '%s'", val)
+	}
+
+	return rate * multiplier / 8, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Structured daemon config. Every option maps onto a flat config key,
+// so that NXPROXY_<KEY> environment variables override it the same way they do with flat configs
+type StructuredConfig struct {
+	Auth       AuthSection       `yaml:"auth"`
+	Standalone StandaloneSection `yaml:"standalone"`
+	Admin      AdminSection      `yaml:"admin"`
+	Health     HealthSection     `yaml:"health"`
+	Logging    LoggingSection    `yaml:"logging"`
+	Metrics    MetricsSection    `yaml:"metrics"`
+	Limits     LimitsSection     `yaml:"limits"`
+	Blocklist  BlocklistSection  `yaml:"blocklist"`
+	GeoIP      GeoIPSection      `yaml:"geoip"`
+	FramedIP   FramedIPSection   `yaml:"framed_ip"`
+}
+
+type AuthSection struct {
+	URL             string `yaml:"url"`
+	SecretToken     string `yaml:"secret_token"`
+	SkipStartupPing bool   `yaml:"skip_startup_ping"`
+}
+
+type StandaloneSection struct {
+
+	//	local config file served in place of an auth backend
+	Config string `yaml:"config"`
+}
+
+type AdminSection struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+
+	//	token that only permits read-only requests
+	StatusToken string `yaml:"status_token"`
+}
+
+type HealthSection struct {
+	Addr string `yaml:"addr"`
+}
+
+type LoggingSection struct {
+	Debug bool `yaml:"debug"`
+
+	//	"true" to log to the systemd journal, "auto" to only do so when stderr is connected to it
+	Journal string `yaml:"journal"`
+
+	//	"syslog" or a file to append auth failures to
+	AuthFailures string `yaml:"auth_failures"`
+}
+
+type MetricsSection struct {
+	DeltaWindow    string `yaml:"delta_window"`
+	DeltaQueueCap  int    `yaml:"delta_queue_cap"`
+	UsageSamples   int    `yaml:"usage_samples"`
+	Watchdog       bool   `yaml:"watchdog"`
+	WatchdogReport bool   `yaml:"watchdog_report"`
+}
+
+type LimitsSection struct {
+	Egress       string `yaml:"egress"`
+	KernelPacing bool   `yaml:"kernel_pacing"`
+	BridgeLinger string `yaml:"bridge_linger"`
+
+	//	password attempts of a single client ip
+	AuthRatelimitMode          string `yaml:"auth_ratelimit_mode"`
+	AuthRatelimitOffenderQuota int    `yaml:"auth_ratelimit_offender_quota"`
+}
+
+type BlocklistSection struct {
+	URL     string `yaml:"url"`
+	Refresh string `yaml:"refresh"`
+}
+
+type GeoIPSection struct {
+	DB string `yaml:"db"`
+}
+
+type FramedIPSection struct {
+	CheckInterval string `yaml:"check_interval"`
+
+	//	tcp address dialed from every framed ip to make sure it's routable
+	Probe string `yaml:"probe"`
+
+	//	interface to add framed ips of configured peers to; linux only
+	Interface string `yaml:"interface"`
+
+	//	send gratuitous ARP or unsolicited neighbor advertisements for added addresses
+	Announce bool `yaml:"announce"`
+}
+
+func IsStructuredConfig(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+func ReadStructuredConfig(name string) (ConfigEntries, error) {
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var cfg StructuredConfig
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	//	an empty file is a valid config with nothing set
+	if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parse yaml: %v", err)
+	}
+
+	return cfg.Entries(), nil
+}
+
+// Returns config options as flat config entries; unset options are left out
+func (cfg *StructuredConfig) Entries() ConfigEntries {
+
+	entries := ConfigEntries{}
+
+	var setString = func(key string, val string) {
+		if val = strings.TrimSpace(val); val != "" {
+			entries[key] = val
+		}
+	}
+
+	var setBool = func(key string, val bool) {
+		if val {
+			entries[key] = "true"
+		}
+	}
+
+	var setInt = func(key string, val int) {
+		if val != 0 {
+			entries[key] = strconv.Itoa(val)
+		}
+	}
+
+	setString("AUTH_URL", cfg.Auth.URL)
+	setString("SECRET_TOKEN", cfg.Auth.SecretToken)
+	setBool("SKIP_STARTUP_PING", cfg.Auth.SkipStartupPing)
+
+	setString("STANDALONE_CONFIG", cfg.Standalone.Config)
+
+	setString("ADMIN_ADDR", cfg.Admin.Addr)
+	setString("ADMIN_TOKEN", cfg.Admin.Token)
+	setString("ADMIN_STATUS_TOKEN", cfg.Admin.StatusToken)
+
+	setString("HEALTH_ADDR", cfg.Health.Addr)
+
+	setBool("DEBUG", cfg.Logging.Debug)
+	setString("LOG_JOURNAL", cfg.Logging.Journal)
+	setString("AUTH_FAILURE_LOG", cfg.Logging.AuthFailures)
+
+	setString("DELTA_WINDOW", cfg.Metrics.DeltaWindow)
+	setInt("DELTA_QUEUE_CAP", cfg.Metrics.DeltaQueueCap)
+	setInt("USAGE_SAMPLES", cfg.Metrics.UsageSamples)
+	setBool("WATCHDOG", cfg.Metrics.Watchdog)
+	setBool("WATCHDOG_REPORT", cfg.Metrics.WatchdogReport)
+
+	setString("EGRESS_LIMIT", cfg.Limits.Egress)
+	setBool("KERNEL_PACING", cfg.Limits.KernelPacing)
+	setString("BRIDGE_LINGER", cfg.Limits.BridgeLinger)
+	setString("AUTH_RATELIMIT_MODE", cfg.Limits.AuthRatelimitMode)
+	setInt("AUTH_RATELIMIT_OFFENDER_QUOTA", cfg.Limits.AuthRatelimitOffenderQuota)
+
+	setString("BLOCKLIST_URL", cfg.Blocklist.URL)
+	setString("BLOCKLIST_REFRESH", cfg.Blocklist.Refresh)
+
+	setString("GEOIP_DB", cfg.GeoIP.DB)
+
+	setString("FRAMED_IP_CHECK_INTERVAL", cfg.FramedIP.CheckInterval)
+	setString("FRAMED_IP_PROBE", cfg.FramedIP.Probe)
+	setString("FRAMED_IP_INTERFACE", cfg.FramedIP.Interface)
+	setBool("FRAMED_IP_ANNOUNCE", cfg.FramedIP.Announce)
+
+	return entries
+}
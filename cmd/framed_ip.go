@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+const defaultFramedIPCheckInterval = time.Minute
+
+// Periodically checks that peer framed IPs are still assigned to the node and, optionally, routable
+type FramedIPMonitor struct {
+	Hub      *ServiceHub
+	Interval time.Duration
+	Probe    string
+}
+
+func (mon *FramedIPMonitor) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(mon.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mon.Hub.CheckFramedIPs(ctx, &nxproxy.FramedIPProbe{Addr: mon.Probe})
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Keeps secondary addresses of an interface in sync with the framed IPs of configured peers.
+// Only addresses added by the provisioner get removed; the ones that were already there are left alone.
+// Requires CAP_NET_ADMIN and the iproute2 'ip' tool
+type FramedIPProvisioner struct {
+	Interface string
+
+	//	announce added addresses with gratuitous ARP or unsolicited neighbor advertisements
+	Announce bool
+
+	//	addresses added by this instance
+	owned map[string]bool
+	mtx   sync.Mutex
+}
+
+// Adds framed IPs referenced by the services that are missing on the interface and removes the previously added ones
+// that are no longer referenced. Failing addresses are logged and skipped, so that a single bad entry doesn't block the rest
+func (prov *FramedIPProvisioner) Sync(services []nxproxy.ServiceOptions) {
+
+	prov.mtx.Lock()
+	defer prov.mtx.Unlock()
+
+	if prov.owned == nil {
+		prov.owned = map[string]bool{}
+	}
+
+	assigned, err := interfaceIPs(prov.Interface)
+	if err != nil {
+		slog.Error("Framed IP provisioning: List interface addresses",
+			slog.String("interface", prov.Interface),
+			slog.String("err", err.Error()))
+		return
+	}
+
+	wanted := map[string]bool{}
+
+	for _, svc := range services {
+		for _, peer := range svc.Peers {
+			if ip := net.ParseIP(peer.LocalFramedIP()); ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
+				wanted[ip.String()] = true
+			}
+		}
+	}
+
+	for addr := range wanted {
+
+		if assigned[addr] {
+			continue
+		}
+
+		if err := prov.exec("add", addr); err != nil {
+			slog.Error("Framed IP provisioning: Add address",
+				slog.String("interface", prov.Interface),
+				slog.String("addr", addr),
+				slog.String("err", err.Error()))
+			continue
+		}
+
+		prov.owned[addr] = true
+
+		slog.Info("Framed IP provisioning: Address added",
+			slog.String("interface", prov.Interface),
+			slog.String("addr", addr))
+
+		if prov.Announce {
+			go prov.announce(net.ParseIP(addr))
+		}
+	}
+
+	for addr := range prov.owned {
+
+		if wanted[addr] {
+			continue
+		}
+
+		//	the address could have been removed by hand already
+		if assigned[addr] {
+			if err := prov.exec("del", addr); err != nil {
+				slog.Error("Framed IP provisioning: Remove address",
+					slog.String("interface", prov.Interface),
+					slog.String("addr", addr),
+					slog.String("err", err.Error()))
+				continue
+			}
+		}
+
+		delete(prov.owned, addr)
+
+		slog.Info("Framed IP provisioning: Address removed",
+			slog.String("interface", prov.Interface),
+			slog.String("addr", addr))
+	}
+}
+
+// Announces an address a few times in case some of the announcements get lost
+func (prov *FramedIPProvisioner) announce(ip net.IP) {
+
+	const count = 3
+	const interval = time.Second
+
+	var lastErr error
+	var sent int
+
+	for idx := range count {
+
+		if idx > 0 {
+			time.Sleep(interval)
+		}
+
+		if err := AnnounceAddr(prov.Interface, ip); err != nil {
+			lastErr = err
+			continue
+		}
+
+		sent++
+	}
+
+	if sent == 0 {
+		slog.Warn("Framed IP provisioning: Announce address",
+			slog.String("interface", prov.Interface),
+			slog.String("addr", ip.String()),
+			slog.String("err", lastErr.Error()))
+		return
+	}
+
+	slog.Debug("Framed IP provisioning: Address announced",
+		slog.String("interface", prov.Interface),
+		slog.String("addr", ip.String()),
+		slog.Int("sent", sent))
+}
+
+func (prov *FramedIPProvisioner) exec(action string, addr string) error {
+
+	prefix := addr + "/32"
+	if net.ParseIP(addr).To4() == nil {
+		prefix = addr + "/128"
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("ip", "addr", action, prefix, "dev", prov.Interface)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Returns the set of addresses assigned to an interface
+func interfaceIPs(name string) (map[string]bool, error) {
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]bool{}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			result[ipNet.IP.String()] = true
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Loads a GeoIP database from a CSV file
+func ReadGeoIP(name string) (*nxproxy.GeoIP, int, error) {
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer file.Close()
+
+	return nxproxy.ParseGeoIP(file)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+const journalIdentifier = "nx-proxy"
+
+// Fields set by the handler itself; attributes with the same names get prefixed
+var journalReservedFields = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+}
+
+// Writes log records to the systemd journal over its native protocol. Record attributes are kept
+// as separate journal fields, so that entries can be filtered with 'journalctl CLIENT_IP=198.51.100.20'
+type JournalHandler struct {
+	conn  *net.UnixConn
+	level slog.Leveler
+
+	//	attributes added via WithAttrs, already prefixed with their groups
+	attrs  []slog.Attr
+	groups []string
+
+	mtx *sync.Mutex
+}
+
+func NewJournalHandler(level slog.Leveler) (slog.Handler, error) {
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &JournalHandler{conn: conn, level: level, mtx: &sync.Mutex{}}, nil
+}
+
+// Checks whether stderr is connected to the journal, which is what systemd indicates with JOURNAL_STREAM
+func JournalStreamAttached() bool {
+
+	val := os.Getenv("JOURNAL_STREAM")
+	if val == "" {
+		return false
+	}
+
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	return val == fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+}
+
+func (handler *JournalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= handler.level.Level()
+}
+
+func (handler *JournalHandler) Handle(_ context.Context, record slog.Record) error {
+
+	var attrs []slog.Attr
+	attrs = append(attrs, handler.attrs...)
+
+	prefix := strings.Join(handler.groups, ".")
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, flattenAttr(prefix, attr)...)
+		return true
+	})
+
+	//	attributes are also kept in the message, so that they show up in the default journalctl output
+	message := record.Message
+	for _, attr := range attrs {
+		message += " " + attr.Key + "=" + quoteLogValue(attr.Value.String())
+	}
+
+	var buff bytes.Buffer
+
+	appendJournalField(&buff, "MESSAGE", message)
+	appendJournalField(&buff, "PRIORITY", journalPriority(record.Level))
+	appendJournalField(&buff, "SYSLOG_IDENTIFIER", journalIdentifier)
+
+	for _, attr := range attrs {
+		appendJournalField(&buff, journalFieldName(attr.Key), attr.Value.String())
+	}
+
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+
+	if _, err := handler.conn.Write(buff.Bytes()); err != nil {
+		//	records that can't be sent, like ones over the datagram size limit, still end up in stderr
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", record.Time.Format(time.DateTime), record.Level.String(), message)
+	}
+
+	return nil
+}
+
+func (handler *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	next := *handler
+	next.attrs = append([]slog.Attr{}, handler.attrs...)
+
+	prefix := strings.Join(handler.groups, ".")
+	for _, attr := range attrs {
+		next.attrs = append(next.attrs, flattenAttr(prefix, attr)...)
+	}
+
+	return &next
+}
+
+func (handler *JournalHandler) WithGroup(name string) slog.Handler {
+
+	if name == "" {
+		return handler
+	}
+
+	next := *handler
+	next.groups = append(append([]string{}, handler.groups...), name)
+
+	return &next
+}
+
+// Expands group attributes into plain ones with dotted keys
+func flattenAttr(prefix string, attr slog.Attr) []slog.Attr {
+
+	attr.Value = attr.Value.Resolve()
+
+	if attr.Equal(slog.Attr{}) {
+		return nil
+	}
+
+	if prefix != "" && attr.Key != "" {
+		attr.Key = prefix + "." + attr.Key
+	} else if attr.Key == "" {
+		attr.Key = prefix
+	}
+
+	if attr.Value.Kind() != slog.KindGroup {
+		return []slog.Attr{attr}
+	}
+
+	var attrs []slog.Attr
+	for _, item := range attr.Value.Group() {
+		attrs = append(attrs, flattenAttr(attr.Key, item)...)
+	}
+
+	return attrs
+}
+
+func quoteLogValue(val string) string {
+	if val == "" || strings.ContainsAny(val, " \"=\n\t") {
+		return fmt.Sprintf("%q", val)
+	}
+	return val
+}
+
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+// Converts an attribute key into a valid journal field name: uppercase letters, digits and underscores,
+// starting with a letter and shorter than 64 characters
+func journalFieldName(key string) string {
+
+	name := []byte(strings.ToUpper(key))
+	for idx, char := range name {
+		if (char < 'A' || char > 'Z') && (char < '0' || char > '9') {
+			name[idx] = '_'
+		}
+	}
+
+	val := string(name)
+	if val == "" || val[0] < 'A' || val[0] > 'Z' || journalReservedFields[val] {
+		val = "ATTR_" + val
+	}
+
+	if len(val) > 63 {
+		val = val[:63]
+	}
+
+	return val
+}
+
+// Serializes a field in the journal native format. Values with line breaks use the length-prefixed binary form
+func appendJournalField(buff *bytes.Buffer, name string, val string) {
+
+	buff.WriteString(name)
+
+	if !strings.Contains(val, "\n") {
+		buff.WriteByte('=')
+		buff.WriteString(val)
+		buff.WriteByte('\n')
+		return
+	}
+
+	buff.WriteByte('\n')
+	_ = binary.Write(buff, binary.LittleEndian, uint64(len(val)))
+	buff.WriteString(val)
+	buff.WriteByte('\n')
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func NewJournalHandler(level slog.Leveler) (slog.Handler, error) {
+	return nil, errors.New("systemd journal is only available on linux")
+}
+
+func JournalStreamAttached() bool {
+	return false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultKubeConfigPath = "/config/nx-proxy.conf"
+const defaultKubeHealthAddr = ":8081"
+
+// Kube mode is selected via the environment only, as the config file location depends on it
+func IsKubeMode() bool {
+	val, _ := GetConfigOpt(nil, "KUBE_MODE")
+	return strings.ToLower(val) == "true"
+}
+
+func KubeConfigPath() string {
+	if val, ok := GetConfigOpt(nil, "CONFIG_PATH"); ok {
+		return val
+	}
+	return defaultKubeConfigPath
+}
+
+func NewJsonLogger(level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+type HealthServer struct {
+	Ready atomic.Bool
+
+	srv http.Server
+}
+
+func (hs *HealthServer) ListenAndServe(addr string) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /healthz", http.HandlerFunc(func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.Handle("GET /readyz", http.HandlerFunc(func(wrt http.ResponseWriter, _ *http.Request) {
+		if !hs.Ready.Load() {
+			wrt.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		wrt.WriteHeader(http.StatusNoContent)
+	}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	hs.srv.Addr = addr
+	hs.srv.Handler = mux
+
+	go hs.srv.Serve(listener)
+
+	return nil
+}
+
+func (hs *HealthServer) Close() error {
+	hs.Ready.Store(false)
+	return hs.srv.Close()
+}
+
+// Watches a config file for changes and calls onChange with the reloaded contents.
+// Mounted ConfigMaps are updated by swapping the '..data' symlink in their directory rather than by writing
+// to the file, so the whole directory is watched, and the resolved path is compared along with the mod time
+// to tell actual changes apart from unrelated events
+func WatchConfigFile(ctx context.Context, name string, onChange func(entries ConfigEntries)) {
+
+	//	a swap comes as a burst of events; the file is read once they settle
+	const settleDelay = 250 * time.Millisecond
+
+	type fileState struct {
+		target  string
+		modTime time.Time
+		size    int64
+	}
+
+	var readState = func() (fileState, bool) {
+
+		target, err := filepath.EvalSymlinks(name)
+		if err != nil {
+			return fileState{}, false
+		}
+
+		stat, err := os.Stat(target)
+		if err != nil {
+			return fileState{}, false
+		}
+
+		return fileState{target: target, modTime: stat.ModTime(), size: stat.Size()}, true
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("Config reload: Unable to create a file watcher; Changes won't be picked up",
+			slog.String("err", err.Error()))
+		return
+	}
+
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(name)); err != nil {
+		slog.Error("Config reload: Unable to watch the config directory; Changes won't be picked up",
+			slog.String("loc", name),
+			slog.String("err", err.Error()))
+		return
+	}
+
+	lastState, _ := readState()
+
+	settle := time.NewTimer(settleDelay)
+	settle.Stop()
+	defer settle.Stop()
+
+	for {
+
+		select {
+
+		case <-ctx.Done():
+			return
+
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			settle.Reset(settleDelay)
+			continue
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Config reload: File watcher",
+				slog.String("loc", name),
+				slog.String("err", err.Error()))
+			continue
+
+		case <-settle.C:
+		}
+
+		state, ok := readState()
+		if !ok || state == lastState {
+			continue
+		}
+
+		lastState = state
+
+		entries, err := ReadConfigFile(name)
+		if err != nil {
+			slog.Error("Config reload: Read file",
+				slog.String("loc", name),
+				slog.String("err", err.Error()))
+			continue
+		}
+
+		slog.Info("Config file changed; Reloading",
+			slog.String("loc", name))
+
+		onChange(entries)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfigFile_ConfigMapSwap(t *testing.T) {
+
+	dir := t.TempDir()
+
+	//	mounted ConfigMaps keep their data in a timestamped directory that '..data' points to
+	var writeData = func(version string, contents string) {
+
+		dataDir := filepath.Join(dir, "..2026_10_16_"+version)
+
+		if err := os.Mkdir(dataDir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dataDir, "nx-proxy.conf"), []byte(contents), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		if err := os.Symlink(filepath.Base(dataDir), filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatalf("rename: %v", err)
+		}
+	}
+
+	writeData("1", "DEBUG=false\n")
+
+	location := filepath.Join(dir, "nx-proxy.conf")
+	if err := os.Symlink(filepath.Join("..data", "nx-proxy.conf"), location); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan ConfigEntries, 1)
+
+	go WatchConfigFile(ctx, location, func(entries ConfigEntries) {
+		changes <- entries
+	})
+
+	//	the watcher has to be set up before the swap
+	time.Sleep(100 * time.Millisecond)
+
+	writeData("2", "DEBUG=true\n")
+
+	select {
+	case entries := <-changes:
+		if entries["DEBUG"] != "true" {
+			t.Errorf("unexpected entries: %v", entries)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("config swap not picked up")
+	}
+}
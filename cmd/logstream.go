@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Streams can't be requested for longer than this
+const maxLogStreamDuration = 15 * time.Minute
+
+// Max number of entries buffered between batches; the rest is dropped
+const logStreamBufferSize = 1000
+
+// A log handler that passes records on to the next one and additionally captures them while a log stream is active.
+// Captured records include the ones below the current log level
+type LogTap struct {
+	next  slog.Handler
+	state *logTapState
+
+	//	attributes added via WithAttrs; needed to match records against stream filters
+	attrs  []slog.Attr
+	groups []string
+}
+
+type logTapState struct {
+	stream  *model.LogStream
+	until   time.Time
+	entries []model.LogEntry
+	dropped int
+	done    bool
+
+	//	ids of streams that already ran, so that they aren't restarted by repeated config pulls
+	finished map[uuid.UUID]struct{}
+
+	mtx sync.Mutex
+}
+
+func NewLogTap(next slog.Handler) *LogTap {
+	return &LogTap{next: next, state: &logTapState{finished: map[uuid.UUID]struct{}{}}}
+}
+
+// Starts a new stream or stops the current one when called with nil
+func (tap *LogTap) SetStream(stream *model.LogStream) {
+
+	if duration, started := tap.state.start(stream); started {
+		slog.Info("Log stream started",
+			slog.String("id", stream.ID.String()),
+			slog.String("duration", duration.String()),
+			slog.String("peer", stream.Peer),
+			slog.String("slot", stream.Slot))
+	}
+}
+
+// Must not log anything, since records are handled under the same lock
+func (state *logTapState) start(stream *model.LogStream) (time.Duration, bool) {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if current := state.stream; current != nil {
+
+		if stream != nil && stream.ID == current.ID {
+			return 0, false
+		}
+
+		state.finished[current.ID] = struct{}{}
+		state.done = true
+	}
+
+	if stream == nil {
+		return 0, false
+	}
+
+	if _, has := state.finished[stream.ID]; has {
+		return 0, false
+	}
+
+	duration := min(time.Duration(stream.Duration)*time.Second, maxLogStreamDuration)
+	if duration <= 0 {
+		return 0, false
+	}
+
+	//	batches of the previous stream that haven't been taken yet are lost
+	val := *stream
+	state.stream = &val
+	state.until = time.Now().Add(duration)
+	state.entries = nil
+	state.dropped = 0
+	state.done = false
+
+	return duration, true
+}
+
+// Returns entries captured since the previous call; returns nil when there's nothing to send
+func (tap *LogTap) Take() *model.LogBatch {
+
+	state := tap.state
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	stream := state.stream
+	if stream == nil {
+		return nil
+	}
+
+	if time.Now().After(state.until) {
+		state.finished[stream.ID] = struct{}{}
+		state.done = true
+	}
+
+	if len(state.entries) == 0 && state.dropped == 0 && !state.done {
+		return nil
+	}
+
+	batch := model.LogBatch{
+		StreamID: stream.ID,
+		Entries:  state.entries,
+		Dropped:  state.dropped,
+		Done:     state.done,
+	}
+
+	state.entries = nil
+	state.dropped = 0
+
+	if state.done {
+		state.stream = nil
+		state.done = false
+	}
+
+	return &batch
+}
+
+func (state *logTapState) active() bool {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	return state.stream != nil && !state.done && time.Now().Before(state.until)
+}
+
+func (tap *LogTap) Enabled(ctx context.Context, level slog.Level) bool {
+	return tap.next.Enabled(ctx, level) || tap.state.active()
+}
+
+func (tap *LogTap) Handle(ctx context.Context, record slog.Record) error {
+
+	if tap.state.active() {
+		tap.capture(record)
+	}
+
+	if tap.next.Enabled(ctx, record.Level) {
+		return tap.next.Handle(ctx, record)
+	}
+
+	return nil
+}
+
+func (tap *LogTap) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	next := *tap
+	next.next = tap.next.WithAttrs(attrs)
+
+	prefix := strings.Join(tap.groups, ".")
+	for _, attr := range attrs {
+		if prefix != "" {
+			attr.Key = prefix + "." + attr.Key
+		}
+		next.attrs = append(append([]slog.Attr{}, next.attrs...), attr)
+	}
+
+	return &next
+}
+
+func (tap *LogTap) WithGroup(name string) slog.Handler {
+
+	next := *tap
+	next.next = tap.next.WithGroup(name)
+	next.groups = append(append([]string{}, tap.groups...), name)
+
+	return &next
+}
+
+func (tap *LogTap) capture(record slog.Record) {
+
+	attrs := map[string]string{}
+
+	//	groups, such as slot identities, are flattened into dotted keys
+	var addAttr func(key string, val slog.Value)
+	addAttr = func(key string, val slog.Value) {
+
+		val = val.Resolve()
+
+		if val.Kind() == slog.KindGroup {
+			for _, attr := range val.Group() {
+				addAttr(key+"."+attr.Key, attr.Value)
+			}
+			return
+		}
+
+		attrs[key] = val.String()
+	}
+
+	for _, attr := range tap.attrs {
+		addAttr(attr.Key, attr.Value)
+	}
+
+	prefix := strings.Join(tap.groups, ".")
+	record.Attrs(func(attr slog.Attr) bool {
+		if prefix != "" {
+			attr.Key = prefix + "." + attr.Key
+		}
+		addAttr(attr.Key, attr.Value)
+		return true
+	})
+
+	state := tap.state
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if state.stream == nil || !logStreamMatch(state.stream, attrs) {
+		return
+	}
+
+	if len(state.entries) >= logStreamBufferSize {
+		state.dropped++
+		return
+	}
+
+	state.entries = append(state.entries, model.LogEntry{
+		Time:    record.Time,
+		Level:   record.Level.String(),
+		Message: record.Message,
+		Attrs:   attrs,
+	})
+}
+
+// Checks a log record against stream filters. Records without peer or slot attributes don't match the respective filters
+func logStreamMatch(stream *model.LogStream, attrs map[string]string) bool {
+
+	var anyOf = func(val string, keys ...string) bool {
+		for _, key := range keys {
+			if attr, has := attrs[key]; has && attr == val {
+				return true
+			}
+		}
+		return false
+	}
+
+	if stream.Peer != "" && !anyOf(stream.Peer, "peer", "peer_id", "id", "name") {
+		return false
+	}
+
+	if stream.Slot != "" && !anyOf(stream.Slot, "proxy_addr", "addr", "bind_addr", "slot.bind_addr", "slot.id") {
+		return false
+	}
+
+	return true
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"log"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,91 +21,497 @@ import (
 	"github.com/maddsua/nx-proxy/rest/model"
 )
 
+// Interval between status reports sent to the auth backend
+const statusInterval = 10 * time.Second
+
 func main() {
 
-	lock, err := NewInstanceLock()
-	if err != nil {
-		slog.Error("Another running instance detected. Aborting")
-		os.Exit(1)
+	if code, ok := RunSubcommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	kubeMode := IsKubeMode()
+
+	var logLevel slog.LevelVar
+
+	if kubeMode {
+		slog.SetDefault(NewJsonLogger(&logLevel))
+	}
+
+	//	lets the auth backend request node logs
+	logTap := NewLogTap(slog.Default().Handler())
+	slog.SetDefault(slog.New(logTap))
+
+	//	the log package gets redirected to slog by this point, and since the default slog handler
+	//	writes through the log package, that would create a loop
+	if !kubeMode {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}
+
+	//	set when logs go to the systemd journal instead of stderr
+	var journalMode bool
+
+	var setDebug = func(enabled bool) {
+
+		level := slog.LevelInfo
+		if enabled {
+			level = slog.LevelDebug
+		}
+
+		if kubeMode || journalMode {
+			logLevel.Set(level)
+		} else {
+			slog.SetLogLoggerLevel(level)
+		}
+
+		if enabled {
+			slog.Debug("ENABLED")
+		}
 	}
 
-	defer lock.Unlock()
+	if !kubeMode {
+
+		lock, err := NewInstanceLock()
+		if err != nil {
+			slog.Error("Another running instance detected. Aborting")
+			os.Exit(1)
+		}
+
+		defer lock.Unlock()
+
+	} else {
+		slog.Info("Running in kube mode; Instance lock disabled")
+	}
+
+	var cfgEntries ConfigEntries
+	var cfgLocation string
+
+	if kubeMode {
+
+		cfgLocation = KubeConfigPath()
+
+		entries, err := ReadConfigFile(cfgLocation)
+		if err != nil {
+			slog.Error("Load config",
+				slog.String("loc", cfgLocation),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		cfgEntries = entries
+
+	} else {
+		cfgEntries, cfgLocation = LoadConfigFile()
+	}
 
-	cfgEntries, cfgLocation := LoadConfigFile()
 	if cfgEntries == nil {
 		slog.Warn("No config files found")
 	} else {
+
 		slog.Info("Loaded config",
 			slog.String("loc", cfgLocation))
+
+		if !IsStructuredConfig(cfgLocation) {
+			slog.Warn("Flat KEY=value config files are deprecated. Consider moving to a structured yaml config")
+		}
+	}
+
+	if val, _ := GetConfigOpt(cfgEntries, "LOG_JOURNAL"); !kubeMode && val != "" {
+
+		switch strings.ToLower(val) {
+		case "true":
+			journalMode = true
+		case "auto":
+			journalMode = JournalStreamAttached()
+		}
+
+		if journalMode {
+
+			handler, err := NewJournalHandler(&logLevel)
+			if err != nil {
+				journalMode = false
+				slog.Warn("Systemd journal unavailable; Logging to stderr",
+					slog.String("err", err.Error()))
+			} else {
+				logTap = NewLogTap(handler)
+				slog.SetDefault(slog.New(logTap))
+				slog.Info("Logging to systemd journal")
+			}
+		}
 	}
 
 	if val, _ := GetConfigOpt(cfgEntries, "DEBUG"); strings.ToLower(val) == "true" {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-		slog.Debug("ENABLED")
+		setDebug(true)
 	}
 
-	var client rest.Client
+	var client atomic.Pointer[rest.Client]
 
-	if val, ok := GetConfigOpt(cfgEntries, "AUTH_URL"); ok {
+	//	standalone nodes serve a local config and don't talk to an auth backend at all
+	standaloneConfig, standalone := GetConfigOpt(cfgEntries, "STANDALONE_CONFIG")
 
-		url, err := ParseAuthUrl(val)
-		if err != nil {
-			slog.Error("Parse auth server url",
-				slog.String("err", err.Error()),
-				slog.String("url", val))
+	var logConnecting = func(client *rest.Client) {
+
+		attrs := []any{slog.String("url", client.URL.String())}
+		if client.Token != nil {
+			attrs = append(attrs, slog.String("node_id", client.Token.ID.String()))
+		}
+
+		slog.Info("Connecting to auth backend", attrs...)
+
+		if client.URL.Scheme != "https" && client.URL.Hostname() != "localhost" {
+			slog.Warn("Auth backend connection insecure. Make sure to use https instead")
+		}
+	}
+
+	if standalone {
+
+		if _, err := ReadStandaloneConfig(standaloneConfig); err != nil {
+			slog.Error("Standalone config",
+				slog.String("location", standaloneConfig),
+				slog.String("err", err.Error()))
 			os.Exit(1)
 		}
-		client.URL = url
+
+		slog.Info("Running standalone",
+			slog.String("config", standaloneConfig))
 
 	} else {
-		slog.Error("Auth server url not provided")
+
+		if val, err := NewAuthClient(cfgEntries); err != nil {
+			slog.Error("Auth client",
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		} else {
+			client.Store(val)
+		}
+
+		if client.Load().Token == nil {
+			slog.Warn("Secret token not provided")
+		}
+
+		logConnecting(client.Load())
+
+		if val, _ := GetConfigOpt(cfgEntries, "SKIP_STARTUP_PING"); strings.ToLower(val) != "true" {
+
+			if err := client.Load().Ping(); err != nil {
+				slog.Error("Auth backend ping failed",
+					slog.String("err", err.Error()))
+				os.Exit(1)
+			}
+
+			slog.Info("Auth backend OK")
+
+		} else {
+			slog.Warn("Skipped auth backend check")
+		}
+	}
+
+	var health HealthServer
+
+	if kubeMode {
+
+		addr := defaultKubeHealthAddr
+		if val, ok := GetConfigOpt(cfgEntries, "HEALTH_ADDR"); ok {
+			addr = val
+		}
+
+		if err := health.ListenAndServe(addr); err != nil {
+			slog.Error("Health endpoint",
+				slog.String("addr", addr),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		defer health.Close()
+
+		slog.Info("Health endpoint listening",
+			slog.String("addr", addr))
+	}
+
+	var hub ServiceHub
+	var wg sync.WaitGroup
+
+	//	egress bucket always exists so that the limit can be changed on config reloads
+	slotEnv := nxproxy.SlotEnv{
+		Egress:       nxproxy.NewTokenBucket(0),
+		BridgeLinger: nxproxy.DefaultBridgeLinger,
+	}
+
+	if val, ok := GetConfigOpt(cfgEntries, "USAGE_SAMPLES"); ok {
+
+		samples, err := strconv.Atoi(val)
+		if err != nil || samples < 0 {
+			slog.Error("Invalid usage sample count",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		slotEnv.UsageSamples = samples
+
+		slog.Info("Peer usage sampling enabled",
+			slog.Int("samples", samples))
+	}
+
+	var setEgressLimit = func(entries ConfigEntries) error {
+
+		var rate int64
+
+		if val, ok := GetConfigOpt(entries, "EGRESS_LIMIT"); ok {
+
+			var err error
+			if rate, err = ParseBitRate(val); err != nil {
+				return err
+			}
+		}
+
+		if rate != slotEnv.Egress.Rate() {
+			slog.Info("Egress limit set",
+				slog.Int64("bytes_per_second", rate))
+		}
+
+		slotEnv.Egress.SetRate(rate)
+
+		return nil
+	}
+
+	if val, _ := GetConfigOpt(cfgEntries, "KERNEL_PACING"); strings.ToLower(val) == "true" {
+
+		slotEnv.KernelPacing = true
+
+		slog.Info("Kernel pacing enabled",
+			slog.Bool("supported", runtime.GOOS == "linux"))
+	}
+
+	if val, ok := GetConfigOpt(cfgEntries, "BRIDGE_LINGER"); ok {
+
+		linger, err := time.ParseDuration(val)
+		if err != nil || linger < 0 {
+			slog.Error("Invalid bridge linger",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		slotEnv.BridgeLinger = linger
+
+		slog.Info("Bridge linger set",
+			slog.String("linger", linger.String()))
+	}
+
+	//	limits password attempts of every client ip across all slots; slots may override it with their own limits
+	authRateLimit := nxproxy.DefaultRatelimiter
+
+	if val, ok := GetConfigOpt(cfgEntries, "AUTH_RATELIMIT_MODE"); ok {
+		authRateLimit.Mode = nxproxy.RateLimitMode(strings.ToLower(val))
+	}
+
+	if val, ok := GetConfigOpt(cfgEntries, "AUTH_RATELIMIT_OFFENDER_QUOTA"); ok {
+
+		quota, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || quota < 1 {
+			slog.Error("Invalid auth rate limit offender quota",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		authRateLimit.ClassQuotas = map[nxproxy.RateLimitClass]int64{nxproxy.RateLimitClassOffender: quota}
+	}
+
+	if err := authRateLimit.Validate(); err != nil {
+		slog.Error("Invalid auth rate limit",
+			slog.String("err", err.Error()))
 		os.Exit(1)
 	}
 
-	if val, ok := GetConfigOpt(cfgEntries, "SECRET_TOKEN"); ok {
-		token, err := nxproxy.ParseServerToken(val)
+	if authRateLimit.Mode != "" || authRateLimit.ClassQuotas != nil {
+		slog.Info("Auth rate limit set",
+			slog.String("mode", string(authRateLimit.Mode)),
+			slog.Int64("quota", authRateLimit.Quota),
+			slog.Int64("offender_quota", authRateLimit.ClassQuotas[nxproxy.RateLimitClassOffender]))
+	}
+
+	//	shared by all slots, so that clients can't get a fresh quota by moving on to the next slot
+	slotEnv.AuthLimiter = &nxproxy.RateLimiter{RateLimiterOptions: authRateLimit, Shared: true}
+
+	if url, ok := GetConfigOpt(cfgEntries, "BLOCKLIST_URL"); ok {
+
+		feed := BlocklistFeed{
+			URL:      url,
+			Interval: defaultBlocklistRefresh,
+			List:     &nxproxy.Blocklist{},
+		}
+
+		if val, ok := GetConfigOpt(cfgEntries, "BLOCKLIST_REFRESH"); ok {
+
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval < time.Minute {
+				slog.Error("Invalid blocklist refresh interval; Must be at least 1m",
+					slog.String("val", val))
+				os.Exit(1)
+			}
+
+			feed.Interval = interval
+		}
+
+		if err := feed.Refresh(context.Background()); err != nil {
+			slog.Error("Blocklist: Initial fetch failed; Will retry on the next refresh",
+				slog.String("url", url),
+				slog.String("err", err.Error()))
+		}
+
+		feedCtx, cancelFeed := context.WithCancel(context.Background())
+		defer cancelFeed()
+
+		go feed.Run(feedCtx)
+
+		slotEnv.Blocklist = feed.List
+
+		slog.Info("Blocklist subscription enabled",
+			slog.String("url", url),
+			slog.String("refresh", feed.Interval.String()))
+	}
+
+	if location, ok := GetConfigOpt(cfgEntries, "GEOIP_DB"); ok {
+
+		geo, skipped, err := ReadGeoIP(location)
 		if err != nil {
-			slog.Error("Parse secret token",
+			slog.Error("Load GeoIP database",
+				slog.String("location", location),
 				slog.String("err", err.Error()))
 			os.Exit(1)
 		}
-		client.Token = token
-	} else {
-		slog.Warn("Secret token not provided")
+
+		slotEnv.GeoIP = geo
+
+		slog.Info("GeoIP database loaded",
+			slog.String("location", location),
+			slog.Int("ranges", geo.Len()),
+			slog.Int("skipped", skipped))
 	}
 
-	slog.Info("Connecting to auth backend",
-		slog.String("url", client.URL.String()),
-		slog.String("node_id", client.Token.ID.String()))
+	if location, ok := GetConfigOpt(cfgEntries, "AUTH_FAILURE_LOG"); ok {
 
-	if client.URL.Scheme != "https" && client.URL.Hostname() != "localhost" {
-		slog.Warn("Auth backend connection insecure. Make sure to use https instead")
+		authLog, closer, err := OpenAuthFailureLog(location)
+		if err != nil {
+			slog.Error("Open auth failure log",
+				slog.String("location", location),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		defer closer.Close()
+
+		slotEnv.AuthLog = authLog
+
+		slog.Info("Auth failure log enabled",
+			slog.String("location", location))
 	}
 
-	if val, _ := GetConfigOpt(cfgEntries, "SKIP_STARTUP_PING"); strings.ToLower(val) != "true" {
+	if err := setEgressLimit(cfgEntries); err != nil {
+		slog.Error("Invalid egress limit",
+			slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	hub.SetEnv(slotEnv)
+
+	framedIPMonitor := FramedIPMonitor{
+		Hub:      &hub,
+		Interval: defaultFramedIPCheckInterval,
+	}
+
+	if val, ok := GetConfigOpt(cfgEntries, "FRAMED_IP_CHECK_INTERVAL"); ok {
+
+		interval, err := time.ParseDuration(val)
+		if err != nil || (interval != 0 && interval < time.Second) {
+			slog.Error("Invalid framed IP check interval; Must be zero or at least 1s",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		framedIPMonitor.Interval = interval
+	}
+
+	framedIPMonitor.Probe, _ = GetConfigOpt(cfgEntries, "FRAMED_IP_PROBE")
+
+	var framedIPProvisioner *FramedIPProvisioner
+
+	if iface, ok := GetConfigOpt(cfgEntries, "FRAMED_IP_INTERFACE"); ok {
 
-		if err := client.Ping(); err != nil {
-			slog.Error("Auth backend ping failed",
+		if runtime.GOOS != "linux" {
+			slog.Error("Framed IP provisioning is only supported on linux")
+			os.Exit(1)
+		}
+
+		if _, err := net.InterfaceByName(iface); err != nil {
+			slog.Error("Invalid framed IP interface",
+				slog.String("interface", iface),
 				slog.String("err", err.Error()))
 			os.Exit(1)
 		}
 
-		slog.Info("Auth backend OK")
+		framedIPProvisioner = &FramedIPProvisioner{Interface: iface}
 
-	} else {
-		slog.Warn("Skipped auth backend check")
+		if val, _ := GetConfigOpt(cfgEntries, "FRAMED_IP_ANNOUNCE"); strings.ToLower(val) == "true" {
+			framedIPProvisioner.Announce = true
+		}
+
+		slog.Info("Framed IP provisioning enabled",
+			slog.String("interface", iface),
+			slog.Bool("announce", framedIPProvisioner.Announce))
 	}
 
-	var hub ServiceHub
-	var wg sync.WaitGroup
+	if framedIPMonitor.Interval > 0 {
+
+		monitorCtx, cancelMonitor := context.WithCancel(context.Background())
+		defer cancelMonitor()
+
+		go framedIPMonitor.Run(monitorCtx)
+
+		slog.Debug("Framed IP monitor enabled",
+			slog.String("interval", framedIPMonitor.Interval.String()),
+			slog.String("probe", framedIPMonitor.Probe))
+	}
+
+	if addr, ok := GetConfigOpt(cfgEntries, "ADMIN_ADDR"); ok {
+
+		admin := AdminServer{Hub: &hub}
+		admin.Token, _ = GetConfigOpt(cfgEntries, "ADMIN_TOKEN")
+		admin.StatusToken, _ = GetConfigOpt(cfgEntries, "ADMIN_STATUS_TOKEN")
+
+		if err := admin.ListenAndServe(addr); err != nil {
+			slog.Error("Admin API",
+				slog.String("addr", addr),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		defer admin.Close()
+
+		slog.Info("Admin API listening",
+			slog.String("addr", addr))
+
+		if host, _, _ := net.SplitHostPort(addr); admin.Token == "" && admin.StatusToken == "" && !isLoopbackHost(host) {
+			slog.Warn("Admin API exposed without a token. Make sure to set ADMIN_TOKEN")
+		}
+	}
 
 	runID := uuid.New()
 	runAt := time.Now()
 	doneCh := make(chan struct{})
 
+	var pullConfig = func() (*model.FullConfig, error) {
+
+		if standalone {
+			return ReadStandaloneConfig(standaloneConfig)
+		}
+
+		return client.Load().PullConfig()
+	}
+
 	var doConfigPull = func() {
 
-		cfg, err := client.PullConfig()
+		cfg, err := pullConfig()
 		if err != nil {
 			slog.Error("API: Pulling config",
 				slog.String("err", err.Error()))
@@ -109,43 +520,248 @@ func main() {
 
 		slog.Debug("API: Updating config")
 
+		//	framed ips have to be in place before peers get created, otherwise they'd start out marked as unavailable
+		if framedIPProvisioner != nil {
+			framedIPProvisioner.Sync(cfg.Services)
+		}
+
 		hub.SetConfig(cfg)
+		health.Ready.Store(true)
+
+		//	log streams are delivered to the auth backend, which standalone nodes don't have
+		if !standalone {
+			logTap.SetStream(cfg.LogStream)
+		}
 
 		slog.Debug("API: Config updated")
 	}
 
+	//	deltas are accumulated for the duration of this window before being reported
+	var deltaWindow time.Duration
+
+	if val, ok := GetConfigOpt(cfgEntries, "DELTA_WINDOW"); ok {
+
+		window, err := time.ParseDuration(val)
+		if err != nil || window < 0 {
+			slog.Error("Invalid delta window",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		deltaWindow = window
+
+		slog.Info("Delta aggregation window set",
+			slog.String("window", deltaWindow.String()))
+	}
+
+	//	queued deltas get summarized per peer beyond this number of entries, so that a long backend outage doesn't exhaust memory
+	deltaQueueCap := nxproxy.DefaultDeltaQueueCap
+
+	if val, ok := GetConfigOpt(cfgEntries, "DELTA_QUEUE_CAP"); ok {
+
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 0 {
+			slog.Error("Invalid delta queue cap",
+				slog.String("val", val))
+			os.Exit(1)
+		}
+
+		deltaQueueCap = limit
+
+		slog.Info("Delta queue cap set",
+			slog.Int("entries", deltaQueueCap))
+	}
+
+	var watchdog *Watchdog
+	var watchdogReport bool
+
+	if val, _ := GetConfigOpt(cfgEntries, "WATCHDOG"); strings.ToLower(val) == "true" {
+
+		watchdog = &Watchdog{Hub: &hub}
+
+		watchdogCtx, cancelWatchdog := context.WithCancel(context.Background())
+		defer cancelWatchdog()
+
+		go watchdog.Run(watchdogCtx)
+
+		val, _ := GetConfigOpt(cfgEntries, "WATCHDOG_REPORT")
+		watchdogReport = strings.ToLower(val) == "true"
+
+		slog.Info("Watchdog enabled",
+			slog.Bool("report", watchdogReport))
+	}
+
 	deltasQueue := make([]nxproxy.PeerDelta, 0)
+	deltasFlushedAt := time.Now()
+
+	//	queued deltas cover the traffic between these two collections
+	deltasFrom := runAt
+	var deltasTo time.Time
 
-	var doStatusPush = func() {
+	//	duration of the last status push, reported with the next one along with the number of skipped pushes
+	var pushLatency time.Duration
+	var pushesSkipped uint64
 
-		newDeltas := hub.Deltas()
+	//	flush forces queued deltas to be sent regardless of the aggregation window.
+	//	pushes never overlap: the first one runs before the status ticker starts, the rest on its goroutine
+	var doStatusPush = func(flush bool) {
+
+		deltasQueue = nxproxy.MergePeerDeltas(append(deltasQueue, hub.Deltas()...))
+
+		if capped, summarized := nxproxy.CapPeerDeltas(deltasQueue, deltaQueueCap); summarized > 0 {
+
+			slog.Warn("API: Delta queue full; Summarized per peer",
+				slog.Int("cap", deltaQueueCap),
+				slog.Int("summarized", summarized),
+				slog.Int("queued", len(capped)))
+
+			deltasQueue = capped
+		}
+		deltasTo = time.Now()
+
+		flush = flush || time.Since(deltasFlushedAt) >= deltaWindow
+
+		deltas := make([]nxproxy.PeerDelta, 0)
+		if flush {
+			deltas = append(deltas, deltasQueue...)
+		}
 
 		metrics := model.Status{
-			Deltas: append(deltasQueue, newDeltas...),
-			Slots:  hub.SlotInfo(),
+			Deltas:    deltas,
+			Slots:     hub.SlotInfo(),
+			Failures:  hub.Failures(),
+			Latency:   hub.Latency(),
+			Activity:  hub.Activity(),
+			Blocked:   hub.BlockedDests(),
+			Leaks:     hub.LeakEvents(),
+			Disables:  hub.PendingDisables(),
+			FramedIPs: hub.FramedIPIssues(),
+			Config:    hub.ConfigReport(),
 			Service: model.ServiceInfo{
-				RunID:  runID,
-				Uptime: int64(time.Since(runAt).Seconds()),
+				RunID:         runID,
+				PushLatency:   pushLatency.Milliseconds(),
+				PushesSkipped: pushesSkipped,
 			},
 		}
 
-		if err := client.PostStatus(&metrics); err != nil {
-			slog.Error("API: PostMetrics",
+		//	runAt carries a monotonic clock reading, which time.Since uses over the wall clock
+		now := time.Now()
+		metrics.Service.Uptime = int64(now.Sub(runAt).Seconds())
+		metrics.Service.Time = now
+		metrics.Service.Timezone, metrics.Service.UtcOffset = now.Zone()
+
+		if flush {
+			metrics.DeltaWindow = &model.DeltaWindow{Start: deltasFrom, End: deltasTo}
+		}
+
+		metrics.ConfigChecksum, metrics.ExpectedChecksum = hub.ConfigChecksums()
+
+		if watchdogReport {
+			metrics.Watchdog = watchdog.Report()
+		}
+
+		if slotEnv.Blocklist != nil {
+			stats := slotEnv.Blocklist.TakeStats()
+			metrics.Blocklist = &stats
+		}
+
+		if addrs, err := PublicAddrs(); err != nil {
+			slog.Debug("Unable to list node addresses",
 				slog.String("err", err.Error()))
-			deltasQueue = append(deltasQueue, newDeltas...)
-			return
+		} else {
+			metrics.Addrs = addrs
+		}
+
+		//	there's nowhere to send status reports in standalone mode, so they're just dropped
+		if !standalone {
+
+			pushStarted := time.Now()
+			err := client.Load().PostStatus(&metrics)
+			pushLatency = time.Since(pushStarted)
+
+			if pushLatency > statusInterval {
+				slog.Warn("API: Status push slower than the push interval",
+					slog.String("latency", pushLatency.Round(time.Millisecond).String()),
+					slog.String("interval", statusInterval.String()))
+			}
+
+			if err != nil {
+				slog.Error("API: PostMetrics",
+					slog.String("err", err.Error()))
+				return
+			}
 		}
 
-		deltasQueue = make([]nxproxy.PeerDelta, 0)
+		if flush {
+			deltasQueue = make([]nxproxy.PeerDelta, 0)
+			deltasFlushedAt = time.Now()
+			deltasFrom = deltasTo
+		}
+
+		if metrics.Config != nil {
+			hub.ConfigReportSent(metrics.Config)
+		}
 
 		slog.Debug("API: Metrics sent",
-			slog.Int("deltas", len(metrics.Deltas)))
+			slog.Int("deltas", len(metrics.Deltas)),
+			slog.Int("queued", len(deltasQueue)),
+			slog.String("latency", pushLatency.Round(time.Millisecond).String()))
+	}
+
+	var doLogsPush = func() {
+
+		batch := logTap.Take()
+		if batch == nil {
+			return
+		}
+
+		if err := client.Load().PostLogs(batch); err != nil {
+			slog.Error("API: PostLogs",
+				slog.String("err", err.Error()))
+			return
+		}
+
+		if batch.Done {
+			slog.Info("Log stream finished",
+				slog.String("id", batch.StreamID.String()))
+		}
 	}
 
 	doConfigPull()
-	doStatusPush()
+	doStatusPush(false)
+
+	if kubeMode {
+
+		watchCtx, cancelWatch := context.WithCancel(context.Background())
+		defer cancelWatch()
+
+		go WatchConfigFile(watchCtx, cfgLocation, func(entries ConfigEntries) {
+
+			val, _ := GetConfigOpt(entries, "DEBUG")
+			setDebug(strings.ToLower(val) == "true")
 
-	wg.Add(2)
+			if err := setEgressLimit(entries); err != nil {
+				slog.Error("Config reload: Invalid egress limit; Keeping the current one",
+					slog.String("err", err.Error()))
+			}
+
+			if standalone {
+				return
+			}
+
+			next, err := NewAuthClient(entries)
+			if err != nil {
+				slog.Error("Config reload: Auth client; Keeping the current one",
+					slog.String("err", err.Error()))
+				return
+			}
+
+			client.Store(next)
+			logConnecting(next)
+		})
+	}
+
+	wg.Add(3)
 
 	go func() {
 
@@ -168,14 +784,43 @@ func main() {
 
 		defer wg.Done()
 
-		ticker := time.NewTicker(10 * time.Second)
+		ticker := time.NewTicker(statusInterval)
+
+		for {
+			select {
+			case <-ticker.C:
+
+				doStatusPush(false)
+
+				//	a push that outlasts the interval leaves a tick behind, which would start the next one right away;
+				//	it's dropped instead so that a slow backend gets the full interval to catch up
+				select {
+				case <-ticker.C:
+					pushesSkipped++
+					slog.Debug("API: Status push outlasted the interval; Skipped a tick")
+				default:
+				}
+
+			case <-doneCh:
+				doStatusPush(true)
+				return
+			}
+		}
+	}()
+
+	go func() {
+
+		defer wg.Done()
+
+		ticker := time.NewTicker(2 * time.Second)
 
 		for {
 			select {
 			case <-ticker.C:
-				doStatusPush()
+				doLogsPush()
 			case <-doneCh:
-				doStatusPush()
+				logTap.SetStream(nil)
+				doLogsPush()
 				return
 			}
 		}
@@ -188,6 +833,8 @@ func main() {
 	slog.Warn("Received an exit signal",
 		slog.String("type", exitSignal.String()))
 
+	health.Ready.Store(false)
+
 	close(doneCh)
 	hub.CloseSlots()
 
@@ -209,3 +856,13 @@ func (prov *dnsProvider) Addr() string {
 func (prov *dnsProvider) Resolver() *net.Resolver {
 	return prov.resolver
 }
+
+func isLoopbackHost(host string) bool {
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+func runPeers(args []string) int {
+
+	if len(args) == 0 {
+		printUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "export":
+		return runPeersExport(args[1:])
+	case "import":
+		return runPeersImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown peers command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+// Fetches the running config of a node from its admin API; admin address and token are taken from the node config unless set
+func runPeersExport(args []string) int {
+
+	flags := flag.NewFlagSet("peers export", flag.ContinueOnError)
+	cfgPath := flags.String("config", "", "config file location")
+	adminAddr := flags.String("admin", "", "admin API address")
+	adminToken := flags.String("token", "", "admin API token")
+	outPath := flags.String("o", "", "output file; printed to stdout when not set")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	entries, location, err := loadSubcommandConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config %s: %v\n", location, err)
+		return 1
+	}
+
+	if *adminAddr == "" {
+		*adminAddr, _ = GetConfigOpt(entries, "ADMIN_ADDR")
+	}
+
+	if *adminToken == "" {
+		*adminToken, _ = GetConfigOpt(entries, "ADMIN_TOKEN")
+	}
+
+	if *adminAddr == "" {
+		fmt.Fprintln(os.Stderr, "admin API address not set")
+		return 2
+	}
+
+	cfg, err := fetchExport(*adminAddr, *adminToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+
+	if *outPath != "" {
+
+		if err := WriteStandaloneConfig(*outPath, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "write %s: %v\n", *outPath, err)
+			return 1
+		}
+
+		fmt.Fprintf(os.Stderr, "exported %d services to %s\n", len(cfg.Services), *outPath)
+		return 0
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "write: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func fetchExport(addr string, token string) (*model.FullConfig, error) {
+
+	req, err := http.NewRequest("GET", (&url.URL{Scheme: "http", Host: addr, Path: "/admin/v1/config/export"}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var payload rest.Response[model.FullConfig]
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPreviewConfigSize)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("http %d: %v", resp.StatusCode, err)
+	}
+
+	if payload.Error != nil {
+		return nil, payload.Error
+	}
+
+	if payload.Data == nil {
+		return nil, fmt.Errorf("http %d: empty response", resp.StatusCode)
+	}
+
+	return payload.Data, nil
+}
+
+// Installs an exported config as the standalone config of the node; a running node picks it up on the next reload
+func runPeersImport(args []string) int {
+
+	flags := flag.NewFlagSet("peers import", flag.ContinueOnError)
+	cfgPath := flags.String("config", "", "config file location")
+	dryRun := flags.Bool("dry-run", false, "only print what would change")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "import file not set")
+		return 2
+	}
+
+	entries, location, err := loadSubcommandConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config %s: %v\n", location, err)
+		return 1
+	}
+
+	target, ok := GetConfigOpt(entries, "STANDALONE_CONFIG")
+	if !ok {
+		fmt.Fprintln(os.Stderr, "STANDALONE_CONFIG not set; peers can only be imported into standalone nodes")
+		return 1
+	}
+
+	next, err := ReadStandaloneConfig(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+
+	//	entries that the node would skip are most likely a mistake, so they aren't imported at all
+	if issues := nxproxy.ValidateServices(next.Services); len(issues) > 0 {
+		for _, issue := range issues {
+			fmt.Fprintf(os.Stderr, "invalid entry: %s %s: %s\n", issue.Slot, issue.Peer, issue.Reason)
+		}
+		return 1
+	}
+
+	current := map[string]nxproxy.ServiceOptions{}
+
+	if cfg, err := ReadStandaloneConfig(target); err == nil {
+		for _, entry := range cfg.Services {
+
+			//	resolved the same way the running node does it, so that entries get compared by actual addresses
+			if resolved, err := nxproxy.ResolveBindAddr(entry.BindAddr); err == nil {
+				entry.BindAddr = resolved
+			}
+
+			if bindAddr, err := nxproxy.ServiceBindAddr(entry.BindAddr, entry.Proto); err == nil {
+				current[bindAddr] = entry
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "read %s: %v\n", target, err)
+		return 1
+	}
+
+	changes := DiffServices(current, next.Services)
+
+	fmt.Printf("slots: %d added, %d removed, %d replaced, %d updated\n",
+		changes.SlotsAdded, changes.SlotsRemoved, changes.SlotsReplaced, changes.SlotsUpdated)
+	fmt.Printf("peers: %d added, %d removed, %d changed (%d credential rotations, %d framed ip changes, %d disabled, %d enabled)\n",
+		changes.PeersAdded, changes.PeersRemoved, changes.PeersChanged,
+		changes.CredentialRotations, changes.FramedIPChanges, changes.PeersDisabled, changes.PeersEnabled)
+
+	if *dryRun {
+		return 0
+	}
+
+	if err := WriteStandaloneConfig(target, next); err != nil {
+		fmt.Fprintf(os.Stderr, "write %s: %v\n", target, err)
+		return 1
+	}
+
+	fmt.Printf("imported %d services into %s\n", len(next.Services), target)
+
+	return 0
+}
@@ -1,27 +1,86 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	nxproxy "github.com/maddsua/nx-proxy"
 
 	http_proxy "github.com/maddsua/nx-proxy/http"
 	"github.com/maddsua/nx-proxy/rest/model"
+	reverse_proxy "github.com/maddsua/nx-proxy/reverse"
 	socks5_proxy "github.com/maddsua/nx-proxy/socks5"
 )
 
 type ServiceHub struct {
 	dns       dnsProvider
+	env       nxproxy.SlotEnv
 	bindMap   map[string]nxproxy.SlotService
 	mtx       sync.Mutex
 	oldDeltas []nxproxy.PeerDelta
 	errSlots  []nxproxy.SlotInfo
+
+	//	options that running slots were last applied with; used to recreate them on restarts
+	services map[string]nxproxy.ServiceOptions
+
+	//	the latest config report and the one that hasn't been delivered yet
+	lastReport    *nxproxy.ConfigReport
+	pendingReport *nxproxy.ConfigReport
+
+	//	entries of the latest config that were actually applied, as they were received, along with the checksum
+	//	the backend has sent with the config
+	applied          []appliedService
+	expectedChecksum string
+
+	//	disables made by the node in slots that had to be recreated, by bind address; kept until the new slots take them over
+	heldDisables map[string][]nxproxy.PeerDisable
+
+	//	creates slot services in place of the protocol implementations; only set by tests
+	slotFactory func(opts nxproxy.SlotOptions, env nxproxy.SlotEnv) (nxproxy.SlotService, error)
+}
+
+type appliedService struct {
+	opts nxproxy.ServiceOptions
+	slot nxproxy.SlotService
+}
+
+// Sets node-wide slot settings; only applies to slots created afterwards
+func (hub *ServiceHub) SetEnv(env nxproxy.SlotEnv) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	hub.env = env
+}
+
+func (hub *ServiceHub) slotEnv() nxproxy.SlotEnv {
+	env := hub.env
+	env.DNS = &hub.dns
+	return env
 }
 
 func (hub *ServiceHub) SetConfig(cfg *model.FullConfig) {
+
 	hub.SetDns(cfg.DNS)
 	hub.SetServices(cfg.Services)
+
+	hub.mtx.Lock()
+	hub.expectedChecksum = cfg.Checksum
+	hub.mtx.Unlock()
+
+	if applied, expected := hub.ConfigChecksums(); expected != "" && applied != expected {
+		slog.Warn("Applied config differs from the one sent by the backend",
+			slog.String("checksum", applied),
+			slog.String("expected", expected))
+	}
 }
 
 func (hub *ServiceHub) SetDns(addr string) {
@@ -57,14 +116,138 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 	hub.errSlots = nil
 
 	newBindMap := map[string]nxproxy.SlotService{}
+	newServices := map[string]nxproxy.ServiceOptions{}
+
+	report := nxproxy.ConfigReport{Applied: time.Now()}
+
+	//	templated bind addresses are resolved up front, so that the rest only deals with actual addresses;
+	//	slots keep their original handles in the report though, so that backends can tell them apart
+	var resolved []nxproxy.ServiceOptions
+	var templates []string
 
 	for _, entry := range entries {
 
+		bindAddr, err := nxproxy.ResolveBindAddr(entry.BindAddr)
+		if err != nil {
+			slog.Error("Unable to resolve slot bind address",
+				slog.String("val", entry.BindAddr),
+				slog.String("err", err.Error()))
+			hub.errSlots = append(hub.errSlots, nxproxy.SlotInfo{
+				ID:       entry.ID,
+				Proto:    entry.Proto,
+				BindAddr: entry.BindAddr,
+				Up:       false,
+				Error:    err.Error(),
+			})
+			report.Rejected = append(report.Rejected, nxproxy.ConfigIssue{
+				Slot:   entry.SlotOptions.Handle(),
+				Reason: "resolve bind address: " + err.Error(),
+			})
+			continue
+		}
+
+		if bindAddr != entry.BindAddr {
+			slog.Debug("Resolved slot bind address",
+				slog.String("val", entry.BindAddr),
+				slog.String("addr", bindAddr))
+		}
+
+		templates = append(templates, entry.BindAddr)
+		entry.BindAddr = bindAddr
+		resolved = append(resolved, entry)
+	}
+
+	entries = resolved
+
+	//	bind conflicts are sorted out before anything gets applied, so that the earlier one
+	//	of the conflicting slots always wins regardless of what's running at the moment
+	var binds nxproxy.BindSet
+	var acceptedBinds []string
+	bindErrs := make([]error, len(entries))
+
+	var applied []appliedService
+
+	for idx, entry := range entries {
+
+		bindAddr, err := nxproxy.ServiceBindAddr(entry.BindAddr, entry.Proto)
+		if err != nil {
+			continue
+		}
+
+		if bindErrs[idx] = binds.Add(bindAddr, entry.SlotOptions.Handle()); bindErrs[idx] == nil {
+			acceptedBinds = append(acceptedBinds, bindAddr)
+		}
+	}
+
+	//	slots that are going away have to free their addresses before the overlapping ones can take them
+	for key, svc := range hub.bindMap {
+
+		if slices.Contains(acceptedBinds, key) || !slices.ContainsFunc(acceptedBinds, func(addr string) bool {
+			return nxproxy.BindAddrsOverlap(key, addr)
+		}) {
+			continue
+		}
+
+		info := svc.Info()
+
+		if err := svc.Close(); err != nil {
+			slog.Error("Release slot address: Close slot",
+				slog.String("addr", info.BindAddr),
+				slog.String("err", err.Error()))
+			continue
+		}
+
+		slog.Info("Remove slot",
+			slog.String("type", string(info.Proto)),
+			slog.String("addr", info.BindAddr))
+
+		report.Changes.SlotsRemoved++
+
+		hub.oldDeltas = append(hub.oldDeltas, svc.Deltas()...)
+
+		delete(hub.bindMap, key)
+	}
+
+	for idx, entry := range entries {
+
+		var rejectSlot = func(reason string) {
+			report.Rejected = append(report.Rejected, nxproxy.ConfigIssue{
+				Slot:   entry.SlotOptions.Handle(),
+				Reason: reason,
+			})
+		}
+
+		var markApplied = func(slot nxproxy.SlotService) {
+			opts := entry
+			opts.BindAddr = templates[idx]
+			applied = append(applied, appliedService{opts: opts, slot: slot})
+		}
+
+		var storeSlotErr = func(err error) {
+			hub.errSlots = append(hub.errSlots, nxproxy.SlotInfo{
+				ID:       entry.ID,
+				Proto:    entry.Proto,
+				BindAddr: entry.BindAddr,
+				Up:       false,
+				Error:    err.Error(),
+			})
+		}
+
 		bindAddr, err := nxproxy.ServiceBindAddr(entry.BindAddr, entry.Proto)
 		if err != nil {
 			slog.Error("ServiceBindAddr invalid",
 				slog.String("val", entry.BindAddr),
 				slog.String("err", err.Error()))
+			rejectSlot("invalid bind address: " + err.Error())
+			continue
+		}
+
+		if err := bindErrs[idx]; err != nil {
+			slog.Error("Slot bind address conflict",
+				slog.String("val", entry.BindAddr),
+				slog.String("err", err.Error()))
+			storeSlotErr(err)
+			rejectSlot(err.Error())
 			continue
 		}
 
@@ -72,10 +255,17 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 
 			if err := slot.SetOptions(entry.SlotOptions); err == nil {
 
-				slot.SetPeers(entry.Peers)
+				report.Slots++
+				report.Merge(slot.SetPeers(entry.Peers))
+				markApplied(slot)
+
+				if !reflect.DeepEqual(hub.services[bindAddr].SlotOptions, entry.SlotOptions) {
+					report.Changes.SlotsUpdated++
+				}
 
 				//	remove from the old bind map
 				newBindMap[bindAddr] = slot
+				newServices[bindAddr] = entry
 				delete(hub.bindMap, bindAddr)
 
 				info := slot.Info()
@@ -87,46 +277,37 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 				continue
 			}
 
+			disables := slot.PendingDisables()
+
 			if err := slot.Close(); err != nil {
 				info := slot.Info()
 				slog.Error("Replace slot: Close outdated slot",
 					slog.String("addr", info.BindAddr),
 					slog.String("err", err.Error()))
+				rejectSlot("unable to replace the running slot: " + err.Error())
 				continue
 			}
 
 			hub.oldDeltas = append(hub.oldDeltas, slot.Deltas()...)
+			hub.holdDisables(bindAddr, disables)
 		}
 
-		var storeSlotErr = func(err error) {
-			hub.errSlots = append(hub.errSlots, nxproxy.SlotInfo{
-				Proto:    entry.Proto,
-				BindAddr: entry.BindAddr,
-				Up:       false,
-				Error:    err.Error(),
-			})
-		}
-
-		var slot nxproxy.SlotService
-		switch entry.Proto {
-		case nxproxy.ProxyProtoSocks:
-			slot, err = socks5_proxy.NewService(entry.SlotOptions, &hub.dns)
-		case nxproxy.ProxyProtoHttp:
-			slot, err = http_proxy.NewService(entry.SlotOptions, &hub.dns)
-		default:
-			err = nxproxy.ErrUnsupportedProto
-		}
-
+		slot, err := hub.newSlot(entry.SlotOptions)
 		if err != nil {
 			slog.Error("Unable to create slot",
 				slog.String("proto", string(entry.Proto)),
 				slog.String("bind_addr", entry.BindAddr),
 				slog.String("err", err.Error()))
 			storeSlotErr(err)
+			rejectSlot(err.Error())
 			continue
 		}
 
-		slot.SetPeers(entry.Peers)
+		hub.restoreDisables(bindAddr, slot)
+
+		report.Slots++
+		report.Merge(slot.SetPeers(entry.Peers))
+		markApplied(slot)
 
 		info := slot.Info()
 
@@ -134,13 +315,16 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 			slog.Info("Replace slot",
 				slog.String("type", string(info.Proto)),
 				slog.String("addr", info.BindAddr))
+			report.Changes.SlotsReplaced++
 		} else {
 			slog.Info("Create slot",
 				slog.String("type", string(info.Proto)),
 				slog.String("addr", info.BindAddr))
+			report.Changes.SlotsAdded++
 		}
 
 		newBindMap[bindAddr] = slot
+		newServices[bindAddr] = entry
 	}
 
 	//	remove slot entries that weren't updated
@@ -179,6 +363,7 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 				slog.String("addr", info.BindAddr),
 				slog.String("err", err.Error()))
 			newBindMap[key] = svc
+			newServices[key] = hub.services[key]
 			continue
 		}
 
@@ -186,12 +371,344 @@ func (hub *ServiceHub) SetServices(entries []nxproxy.ServiceOptions) {
 			slog.String("type", string(info.Proto)),
 			slog.String("addr", info.BindAddr))
 
+		report.Changes.SlotsRemoved++
+
 		hub.oldDeltas = append(hub.oldDeltas, svc.Deltas()...)
 
 		delete(hub.bindMap, key)
 	}
 
 	hub.bindMap = newBindMap
+	hub.services = newServices
+	hub.applied = applied
+
+	//	held disables are only kept for slots that are still in the config but failed to start
+	for key := range hub.heldDisables {
+		if !slices.Contains(acceptedBinds, key) {
+			delete(hub.heldDisables, key)
+		}
+	}
+
+	if report.Changes.Empty() {
+		slog.Debug("Config unchanged")
+	} else {
+		slog.Info("Config changes applied", report.Changes.LogAttrs()...)
+	}
+
+	hub.storeReport(report)
+}
+
+// Makes a config report the latest one. Must be called with the hub lock held
+func (hub *ServiceHub) storeReport(report nxproxy.ConfigReport) {
+
+	//	changes that haven't been delivered yet are carried over, so that the backend sees all of them
+	if hub.pendingReport != nil {
+		pending := hub.pendingReport.Changes
+		pending.Merge(report.Changes)
+		report.Changes = pending
+	}
+
+	//	unchanged outcomes aren't reported again unless the config actually changed something
+	if hub.lastReport == nil || !hub.lastReport.SameOutcome(&report) || !report.Changes.Empty() {
+		hub.pendingReport = &report
+	}
+
+	hub.lastReport = &report
+}
+
+// Returns the checksum of the config entries that the node is running, along with the one the backend has sent.
+// Rejected entries are left out, and peers disabled by the node that the backend hasn't acknowledged yet
+// are counted as disabled, so that the checksums only match when the node runs the config exactly as intended
+func (hub *ServiceHub) ConfigChecksums() (string, string) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.ServiceOptions
+
+	for _, entry := range hub.applied {
+
+		accepted := map[uuid.UUID]bool{}
+		for _, peer := range entry.slot.Peers() {
+			accepted[peer.ID] = true
+		}
+
+		disabled := map[uuid.UUID]bool{}
+		for _, item := range entry.slot.PendingDisables() {
+			disabled[item.PeerID] = true
+		}
+
+		opts := entry.opts
+		opts.Peers = nil
+
+		for _, peer := range entry.opts.Peers {
+
+			//	out of duplicate ids only the first one gets accepted
+			if !accepted[peer.ID] {
+				continue
+			}
+
+			accepted[peer.ID] = false
+
+			if disabled[peer.ID] {
+				peer.Disabled = true
+			}
+
+			opts.Peers = append(opts.Peers, peer)
+		}
+
+		entries = append(entries, opts)
+	}
+
+	return nxproxy.ConfigChecksum(entries), hub.expectedChecksum
+}
+
+// Computes what SetServices would change without applying anything. Entries that would be rejected are left out,
+// and so are peers disabled by the leak check, since only the running config entries are compared
+func (hub *ServiceHub) PreviewServices(entries []nxproxy.ServiceOptions) nxproxy.ConfigChanges {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	return DiffServices(hub.services, entries)
+}
+
+// Computes what replacing services keyed by their bind addresses with new entries would change
+func DiffServices(current map[string]nxproxy.ServiceOptions, entries []nxproxy.ServiceOptions) nxproxy.ConfigChanges {
+
+	var changes nxproxy.ConfigChanges
+
+	var binds nxproxy.BindSet
+	accepted := map[string]struct{}{}
+
+	for _, entry := range entries {
+
+		resolved, err := nxproxy.ResolveBindAddr(entry.BindAddr)
+		if err != nil {
+			continue
+		}
+
+		entry.BindAddr = resolved
+
+		bindAddr, err := nxproxy.ServiceBindAddr(entry.BindAddr, entry.Proto)
+		if err != nil {
+			continue
+		}
+
+		if err := binds.Add(bindAddr, entry.SlotOptions.Handle()); err != nil {
+			continue
+		}
+
+		accepted[bindAddr] = struct{}{}
+
+		prev, has := current[bindAddr]
+		if !has {
+			changes.SlotsAdded++
+			changes.Merge(nxproxy.DiffPeers(nil, entry.Peers))
+			continue
+		}
+
+		if !prev.SlotOptions.Compatible(&entry.SlotOptions) {
+			changes.SlotsReplaced++
+			changes.Merge(nxproxy.DiffPeers(nil, entry.Peers))
+			continue
+		}
+
+		if !reflect.DeepEqual(prev.SlotOptions, entry.SlotOptions) {
+			changes.SlotsUpdated++
+		}
+
+		changes.Merge(nxproxy.DiffPeers(prev.Peers, entry.Peers))
+	}
+
+	for key := range current {
+		if _, has := accepted[key]; !has {
+			changes.SlotsRemoved++
+		}
+	}
+
+	return changes
+}
+
+// Returns the running config with effective peer options and services ordered by bind address.
+// Rejected entries are left out, so the result can be applied as it is
+func (hub *ServiceHub) ExportConfig() *model.FullConfig {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	entries := []nxproxy.ServiceOptions{}
+
+	for key, svc := range hub.bindMap {
+
+		entry, has := hub.services[key]
+		if !has {
+			continue
+		}
+
+		entries = append(entries, nxproxy.ServiceOptions{
+			SlotOptions: entry.SlotOptions,
+			Peers:       svc.Peers(),
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b nxproxy.ServiceOptions) int {
+		return strings.Compare(a.BindAddr, b.BindAddr)
+	})
+
+	return &model.FullConfig{Services: entries, DNS: hub.dns.Addr()}
+}
+
+func (hub *ServiceHub) newSlot(opts nxproxy.SlotOptions) (nxproxy.SlotService, error) {
+
+	if hub.slotFactory != nil {
+		return hub.slotFactory(opts, hub.slotEnv())
+	}
+
+	switch opts.Proto {
+	case nxproxy.ProxyProtoSocks:
+		return socks5_proxy.NewService(opts, hub.slotEnv())
+	case nxproxy.ProxyProtoHttp, nxproxy.ProxyProtoHttps:
+		return http_proxy.NewService(opts, hub.slotEnv())
+	case nxproxy.ProxyProtoReverse:
+		return reverse_proxy.NewService(opts, hub.slotEnv())
+	default:
+		return nil, nxproxy.ErrUnsupportedProto
+	}
+}
+
+var ErrSlotNotFound = errors.New("slot not found")
+
+// Closes a single slot and creates it anew with the options and peers it was last applied with,
+// leaving other slots intact. The slot is matched by its bind address, handle, like 'socks@0.0.0.0:1080', or its id
+func (hub *ServiceHub) RestartSlot(addr string) (nxproxy.SlotInfo, error) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var bindAddr string
+	for key, entry := range hub.services {
+		if key == addr || entry.BindAddr == addr || entry.Handle() == addr || (entry.ID != "" && entry.ID == addr) {
+			bindAddr = key
+			break
+		}
+	}
+
+	slot, has := hub.bindMap[bindAddr]
+	if !has {
+		return nxproxy.SlotInfo{}, ErrSlotNotFound
+	}
+
+	entry := hub.services[bindAddr]
+	disables := slot.PendingDisables()
+	peers := len(slot.Peers())
+
+	if err := slot.Close(); err != nil {
+		slog.Error("Restart slot: Close",
+			slog.String("addr", entry.BindAddr),
+			slog.String("err", err.Error()))
+		return slot.Info(), fmt.Errorf("close slot: %v", err)
+	}
+
+	hub.oldDeltas = append(hub.oldDeltas, slot.Deltas()...)
+	hub.holdDisables(bindAddr, disables)
+
+	delete(hub.bindMap, bindAddr)
+	delete(hub.services, bindAddr)
+
+	next, err := hub.newSlot(entry.SlotOptions)
+	if err != nil {
+
+		slog.Error("Restart slot: Unable to create slot",
+			slog.String("proto", string(entry.Proto)),
+			slog.String("bind_addr", entry.BindAddr),
+			slog.String("err", err.Error()))
+
+		info := nxproxy.SlotInfo{
+			ID:       entry.ID,
+			Proto:    entry.Proto,
+			BindAddr: entry.BindAddr,
+			Up:       false,
+			Error:    err.Error(),
+		}
+
+		hub.errSlots = append(hub.errSlots, info)
+
+		hub.applied = slices.DeleteFunc(hub.applied, func(item appliedService) bool {
+			return item.slot == slot
+		})
+
+		report := hub.restartReport(nxproxy.ConfigChanges{SlotsRemoved: 1})
+		report.Slots--
+		report.Peers -= peers
+		report.Rejected = append(report.Rejected, nxproxy.ConfigIssue{
+			Slot:   entry.SlotOptions.Handle(),
+			Reason: "restart: " + err.Error(),
+		})
+
+		hub.storeReport(report)
+
+		return info, err
+	}
+
+	hub.restoreDisables(bindAddr, next)
+	restart := next.SetPeers(entry.Peers)
+	restart.Changes.SlotsReplaced++
+
+	for idx := range hub.applied {
+		if hub.applied[idx].slot == slot {
+			hub.applied[idx].slot = next
+		}
+	}
+
+	hub.storeReport(hub.restartReport(restart.Changes))
+
+	hub.bindMap[bindAddr] = next
+	hub.services[bindAddr] = entry
+
+	info := next.Info()
+
+	slog.Info("Restart slot",
+		slog.String("type", string(info.Proto)),
+		slog.String("addr", info.BindAddr))
+
+	return info, nil
+}
+
+// Returns the outcome of the latest config along with changes made by a slot restart. Restarted slots get
+// the same peers, so their peer issues are already in there. Must be called with the hub lock held
+func (hub *ServiceHub) restartReport(changes nxproxy.ConfigChanges) nxproxy.ConfigReport {
+
+	report := nxproxy.ConfigReport{Applied: time.Now(), Changes: changes}
+
+	if last := hub.lastReport; last != nil {
+		report.Slots = last.Slots
+		report.Peers = last.Peers
+		report.Rejected = slices.Clone(last.Rejected)
+		report.Warnings = slices.Clone(last.Warnings)
+	}
+
+	return report
+}
+
+// Returns the config report that hasn't been delivered to the auth backend yet
+func (hub *ServiceHub) ConfigReport() *nxproxy.ConfigReport {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	return hub.pendingReport
+}
+
+// Marks a config report as delivered, unless a newer one has been made since
+func (hub *ServiceHub) ConfigReportSent(report *nxproxy.ConfigReport) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	if hub.pendingReport == report {
+		hub.pendingReport = nil
+	}
 }
 
 func (hub *ServiceHub) Deltas() []nxproxy.PeerDelta {
@@ -209,6 +726,192 @@ func (hub *ServiceHub) Deltas() []nxproxy.PeerDelta {
 	return entries
 }
 
+func (hub *ServiceHub) Failures() []nxproxy.PeerFailures {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.PeerFailures
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.Failures()...)
+	}
+
+	return entries
+}
+
+func (hub *ServiceHub) Activity() []nxproxy.PeerActivity {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.PeerActivity
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.Activity()...)
+	}
+
+	return entries
+}
+
+func (hub *ServiceHub) FramedIPIssues() []nxproxy.FramedIPIssue {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.FramedIPIssue
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.FramedIPIssues()...)
+	}
+
+	return entries
+}
+
+// Checks framed IPs of all slot peers; slots are checked without holding the hub lock as probes may take a while
+func (hub *ServiceHub) CheckFramedIPs(ctx context.Context, probe *nxproxy.FramedIPProbe) {
+
+	hub.mtx.Lock()
+	var slots []nxproxy.SlotService
+	for _, slot := range hub.bindMap {
+		slots = append(slots, slot)
+	}
+	hub.mtx.Unlock()
+
+	for _, slot := range slots {
+		slot.CheckFramedIPs(ctx, probe)
+	}
+}
+
+func (hub *ServiceHub) Latency() []nxproxy.PeerLatency {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.PeerLatency
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.Latency()...)
+	}
+
+	return entries
+}
+
+func (hub *ServiceHub) BlockedDests() []nxproxy.BlockedDest {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.BlockedDest
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.BlockedDests()...)
+	}
+
+	return entries
+}
+
+func (hub *ServiceHub) LeakEvents() []nxproxy.LeakEvent {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.LeakEvent
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.LeakEvents()...)
+	}
+
+	return entries
+}
+
+func (hub *ServiceHub) PendingDisables() []nxproxy.PeerDisable {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.PeerDisable
+
+	for _, slot := range hub.bindMap {
+		entries = append(entries, slot.PendingDisables()...)
+	}
+
+	for _, held := range hub.heldDisables {
+		entries = append(entries, held...)
+	}
+
+	return entries
+}
+
+// Keeps disables made by the node in a slot that's being recreated, so that the new slot can take them over.
+// Must be called with the hub lock held
+func (hub *ServiceHub) holdDisables(bindAddr string, entries []nxproxy.PeerDisable) {
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if hub.heldDisables == nil {
+		hub.heldDisables = map[string][]nxproxy.PeerDisable{}
+	}
+
+	hub.heldDisables[bindAddr] = append(hub.heldDisables[bindAddr], entries...)
+}
+
+// Hands held disables over to a slot created in place of the one that made them.
+// Must be called with the hub lock held, before the slot peers are set
+func (hub *ServiceHub) restoreDisables(bindAddr string, slot nxproxy.SlotService) {
+
+	if entries, has := hub.heldDisables[bindAddr]; has {
+		slot.RestoreDisables(entries)
+		delete(hub.heldDisables, bindAddr)
+	}
+}
+
+// Rate limited keys of a slot, or of the limiter shared by all slots when the slot isn't set
+type SlotRateLimit struct {
+	Slot *nxproxy.SlotIdentity    `json:"slot,omitempty"`
+	Keys []nxproxy.RateLimitedKey `json:"keys"`
+}
+
+// Returns keys that are rate limited at the moment, the ones of the shared limiter first;
+// limiters that don't limit anyone are left out
+func (hub *ServiceHub) RateLimitedKeys() []SlotRateLimit {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []SlotRateLimit
+
+	for key, slot := range hub.bindMap {
+
+		keys := slot.RateLimitedKeys()
+		if len(keys) == 0 {
+			continue
+		}
+
+		opts := hub.services[key]
+		identity := opts.Identity()
+
+		entries = append(entries, SlotRateLimit{
+			Slot: &identity,
+			Keys: keys,
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b SlotRateLimit) int {
+		return strings.Compare(a.Slot.BindAddr, b.Slot.BindAddr)
+	})
+
+	if shared := hub.env.AuthLimiter; shared != nil {
+		if keys := shared.LimitedKeys(); len(keys) > 0 {
+			entries = append([]SlotRateLimit{{Keys: keys}}, entries...)
+		}
+	}
+
+	return entries
+}
+
 func (hub *ServiceHub) SlotInfo() []nxproxy.SlotInfo {
 
 	hub.mtx.Lock()
@@ -217,6 +920,28 @@ func (hub *ServiceHub) SlotInfo() []nxproxy.SlotInfo {
 	entries := append([]nxproxy.SlotInfo{}, hub.errSlots...)
 	hub.errSlots = nil
 
+	for _, slot := range hub.bindMap {
+
+		info := slot.Info()
+
+		//	this is only called for status reports, so the counters are reset for the next one
+		stats := slot.TakeStats()
+		info.Stats = &stats
+
+		entries = append(entries, info)
+	}
+
+	return entries
+}
+
+// Returns info of running slots without resetting their stats; meant for polling by monitoring systems
+func (hub *ServiceHub) SlotSnapshot() []nxproxy.SlotInfo {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var entries []nxproxy.SlotInfo
+
 	for _, slot := range hub.bindMap {
 		entries = append(entries, slot.Info())
 	}
@@ -251,3 +976,58 @@ func (hub *ServiceHub) CloseSlots() {
 		delete(hub.bindMap, key)
 	}
 }
+
+func (hub *ServiceHub) PeerUsage(id uuid.UUID) ([]nxproxy.UsageSample, bool) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	for _, slot := range hub.bindMap {
+		if samples, has := slot.PeerUsage(id); has {
+			return samples, true
+		}
+	}
+
+	return nil, false
+}
+
+func (hub *ServiceHub) PeerLatency(id uuid.UUID) (nxproxy.PeerLatencyDetails, bool) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	for _, slot := range hub.bindMap {
+		if val, has := slot.PeerLatency(id); has {
+			return val, true
+		}
+	}
+
+	return nxproxy.PeerLatencyDetails{}, false
+}
+
+func (hub *ServiceHub) CapturePeer(id uuid.UUID, capture *nxproxy.PeerCapture) (bool, error) {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	for _, slot := range hub.bindMap {
+		if has, err := slot.CapturePeer(id, capture); has {
+			return true, err
+		}
+	}
+
+	return false, nil
+}
+
+func (hub *ServiceHub) ActiveConnections() int {
+
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	var n int
+	for _, slot := range hub.bindMap {
+		n += slot.ActiveConnections()
+	}
+
+	return n
+}
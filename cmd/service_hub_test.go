@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Slot service that doesn't listen on anything; its deltas and close errors are set by tests
+type fakeSlot struct {
+	nxproxy.Slot
+	closeErr error
+	closed   bool
+	deltas   []nxproxy.PeerDelta
+}
+
+func (slot *fakeSlot) Close() error {
+
+	if slot.closeErr != nil {
+		return slot.closeErr
+	}
+
+	slot.closed = true
+
+	return nil
+}
+
+func (slot *fakeSlot) Deltas() []nxproxy.PeerDelta {
+	entries := slot.deltas
+	slot.deltas = nil
+	return entries
+}
+
+type fakeSlotFactory struct {
+	created []*fakeSlot
+
+	//	bind addresses that fail to be listened on
+	bindErrs map[string]error
+}
+
+func (factory *fakeSlotFactory) newSlot(opts nxproxy.SlotOptions, env nxproxy.SlotEnv) (nxproxy.SlotService, error) {
+
+	if err := factory.bindErrs[opts.BindAddr]; err != nil {
+		return nil, err
+	}
+
+	slot := fakeSlot{Slot: nxproxy.Slot{DNS: env.DNS}}
+
+	if err := slot.SetOptions(opts); err != nil {
+		return nil, err
+	}
+
+	factory.created = append(factory.created, &slot)
+
+	return &slot, nil
+}
+
+func newTestHub() (*ServiceHub, *fakeSlotFactory) {
+	factory := fakeSlotFactory{bindErrs: map[string]error{}}
+	return &ServiceHub{slotFactory: factory.newSlot}, &factory
+}
+
+func testPeer(user string) nxproxy.PeerOptions {
+	return nxproxy.PeerOptions{
+		ID:           uuid.New(),
+		PasswordAuth: &nxproxy.UserPassword{User: user, Password: "1"},
+	}
+}
+
+func TestServiceHub_UpdateInPlace(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	entry := nxproxy.ServiceOptions{
+		SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"},
+		Peers:       []nxproxy.PeerOptions{testPeer("maddsua")},
+	}
+
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	entry.MaxClientConnections = 5
+	entry.Peers = append(entry.Peers, testPeer("other"))
+
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	if len(factory.created) != 1 || factory.created[0].closed {
+		t.Fatalf("slot got recreated on a reloadable change")
+	}
+
+	if peers := factory.created[0].Peers(); len(peers) != 2 {
+		t.Errorf("peers not updated: %+v", peers)
+	}
+
+	report := hub.ConfigReport()
+	if report == nil {
+		t.Fatalf("config report missing")
+	}
+
+	if report.Changes.SlotsAdded != 1 || report.Changes.SlotsUpdated != 1 || report.Changes.SlotsReplaced != 0 || report.Changes.PeersAdded != 2 {
+		t.Errorf("unexpected changes: %+v", report.Changes)
+	}
+}
+
+func TestServiceHub_ProtoChange(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	peer := testPeer("maddsua")
+
+	hub.SetServices([]nxproxy.ServiceOptions{{
+		SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"},
+		Peers:       []nxproxy.PeerOptions{peer},
+	}})
+
+	factory.created[0].deltas = []nxproxy.PeerDelta{{ID: peer.ID, Rx: 100, Tx: 10}}
+
+	hub.SetServices([]nxproxy.ServiceOptions{{
+		SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "127.0.0.1:1080"},
+		Peers:       []nxproxy.PeerOptions{peer},
+	}})
+
+	if len(factory.created) != 2 || !factory.created[0].closed || factory.created[1].closed {
+		t.Fatalf("slot not replaced")
+	}
+
+	if report := hub.ConfigReport(); report == nil || report.Changes.SlotsReplaced != 1 {
+		t.Errorf("replacement not reported: %+v", report)
+	}
+
+	//	deltas of the replaced slot are kept until the next collection
+	if deltas := hub.Deltas(); len(deltas) != 1 || deltas[0].Rx != 100 {
+		t.Errorf("deltas of the replaced slot lost: %+v", deltas)
+	}
+
+	if deltas := hub.Deltas(); len(deltas) != 0 {
+		t.Errorf("deltas reported twice: %+v", deltas)
+	}
+}
+
+func TestServiceHub_FailedClose(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	peer := testPeer("maddsua")
+
+	hub.SetServices([]nxproxy.ServiceOptions{{
+		SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"},
+		Peers:       []nxproxy.PeerOptions{peer},
+	}})
+
+	slot := factory.created[0]
+	slot.closeErr = errors.New("close failed")
+	slot.deltas = []nxproxy.PeerDelta{{ID: peer.ID, Rx: 100, Tx: 10}}
+
+	hub.SetServices(nil)
+
+	//	slots that fail to close are kept around and closed on the next update
+	if infos := hub.SlotInfo(); len(infos) != 1 || infos[0].BindAddr != "127.0.0.1:1080" {
+		t.Fatalf("slot that failed to close dropped: %+v", infos)
+	}
+
+	slot.closeErr = nil
+	slot.deltas = append(slot.deltas, nxproxy.PeerDelta{ID: peer.ID, Rx: 50, Tx: 5})
+
+	hub.SetServices(nil)
+
+	if !slot.closed {
+		t.Errorf("retained slot not closed on retry")
+	}
+
+	if infos := hub.SlotInfo(); len(infos) != 0 {
+		t.Errorf("removed slot still reported: %+v", infos)
+	}
+
+	//	deltas made before and after the failed close both make it into the report
+	if deltas := nxproxy.MergePeerDeltas(hub.Deltas()); len(deltas) != 1 || deltas[0].Rx != 150 || deltas[0].Tx != 15 {
+		t.Errorf("deltas of the removed slot lost: %+v", deltas)
+	}
+}
+
+func TestServiceHub_BindError(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	factory.bindErrs["127.0.0.1:8080"] = errors.New("address already in use")
+
+	hub.SetServices([]nxproxy.ServiceOptions{
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "127.0.0.1:8080"}},
+	})
+
+	report := hub.ConfigReport()
+	if report == nil {
+		t.Fatalf("config report missing")
+	}
+
+	if report.Slots != 1 || len(report.Rejected) != 1 || report.Rejected[0].Slot != "http@127.0.0.1:8080" {
+		t.Errorf("bind error not reported: %+v", report)
+	}
+
+	var failed *nxproxy.SlotInfo
+
+	infos := hub.SlotInfo()
+	for idx := range infos {
+		if infos[idx].BindAddr == "127.0.0.1:8080" {
+			failed = &infos[idx]
+		}
+	}
+
+	if len(infos) != 2 || failed == nil || failed.Up || failed.Error == "" {
+		t.Errorf("failed slot not listed: %+v", infos)
+	}
+}
+
+func TestServiceHub_ReplaceKeepsDisables(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	peer := testPeer("maddsua")
+
+	entry := nxproxy.ServiceOptions{
+		SlotOptions: nxproxy.SlotOptions{
+			Proto:     nxproxy.ProxyProtoSocks,
+			BindAddr:  "127.0.0.1:1080",
+			LeakCheck: &nxproxy.LeakCheckOptions{MaxClientIPs: 1, AutoDisable: true},
+		},
+		Peers: []nxproxy.PeerOptions{peer},
+	}
+
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	factory.created[0].LookupWithPassword(net.ParseIP("198.51.100.1"), "maddsua", "1")
+	factory.created[0].LookupWithPassword(net.ParseIP("198.51.100.2"), "maddsua", "1")
+
+	pending := hub.PendingDisables()
+	if len(pending) != 1 {
+		t.Fatalf("peer not disabled by the leak check: %+v", pending)
+	}
+
+	var peerDisabled = func() bool {
+		slot := factory.created[len(factory.created)-1]
+		peers := slot.Peers()
+		return !slot.closed && len(peers) == 1 && peers[0].Disabled
+	}
+
+	//	slots replaced due to a proto change keep the disables
+	entry.Proto = nxproxy.ProxyProtoHttp
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	if len(factory.created) != 2 || !peerDisabled() {
+		t.Errorf("peer enabled by a slot replace")
+	}
+
+	if disables := hub.PendingDisables(); len(disables) != 1 || disables[0].PeerID != peer.ID || !disables[0].Time.Equal(pending[0].Time) {
+		t.Errorf("disable lost by a slot replace: %+v", disables)
+	}
+
+	//	so do the ones that fail to start until they're back up
+	entry.Proto = nxproxy.ProxyProtoSocks
+	factory.bindErrs["127.0.0.1:1080"] = errors.New("address already in use")
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	if disables := hub.PendingDisables(); len(disables) != 1 {
+		t.Errorf("disable lost by a failed slot replace: %+v", disables)
+	}
+
+	delete(factory.bindErrs, "127.0.0.1:1080")
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	if len(factory.created) != 3 || !peerDisabled() {
+		t.Errorf("peer enabled after the slot got back up")
+	}
+
+	//	acks are still honored by the new slots
+	ack := pending[0].Time
+	entry.Peers[0].DisableAck = &ack
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+
+	if peerDisabled() || len(hub.PendingDisables()) != 0 {
+		t.Errorf("acknowledged disable kept")
+	}
+}
+
+func TestServiceHub_RestartSlot(t *testing.T) {
+
+	hub, factory := newTestHub()
+
+	entry := nxproxy.ServiceOptions{
+		SlotOptions: nxproxy.SlotOptions{
+			Proto:     nxproxy.ProxyProtoSocks,
+			BindAddr:  "127.0.0.1:1080",
+			LeakCheck: &nxproxy.LeakCheckOptions{MaxClientIPs: 1, AutoDisable: true},
+		},
+		Peers: []nxproxy.PeerOptions{testPeer("maddsua")},
+	}
+
+	hub.SetServices([]nxproxy.ServiceOptions{entry})
+	hub.ConfigReportSent(hub.ConfigReport())
+
+	factory.created[0].LookupWithPassword(net.ParseIP("198.51.100.1"), "maddsua", "1")
+	factory.created[0].LookupWithPassword(net.ParseIP("198.51.100.2"), "maddsua", "1")
+
+	checksum, _ := hub.ConfigChecksums()
+
+	if _, err := hub.RestartSlot("127.0.0.1:1080"); err != nil {
+		t.Fatalf("restart slot: %v", err)
+	}
+
+	if len(factory.created) != 2 || !factory.created[0].closed {
+		t.Fatalf("slot not recreated")
+	}
+
+	if peers := factory.created[1].Peers(); len(peers) != 1 || !peers[0].Disabled {
+		t.Errorf("peer enabled by a restart: %+v", peers)
+	}
+
+	//	the restarted slot runs the same config, disables included
+	if restarted, _ := hub.ConfigChecksums(); restarted != checksum {
+		t.Errorf("checksum changed by a restart: %s != %s", restarted, checksum)
+	}
+
+	report := hub.ConfigReport()
+	if report == nil || report.Slots != 1 || report.Peers != 1 || report.Changes.SlotsReplaced != 1 || report.Changes.PeersAdded != 1 {
+		t.Fatalf("restart not reported: %+v", report)
+	}
+
+	hub.ConfigReportSent(report)
+
+	//	slots that fail to come back up are no longer counted as applied
+	factory.bindErrs["127.0.0.1:1080"] = errors.New("address already in use")
+
+	if _, err := hub.RestartSlot("127.0.0.1:1080"); err == nil {
+		t.Fatalf("failed restart not reported")
+	}
+
+	if failed, _ := hub.ConfigChecksums(); failed == checksum {
+		t.Errorf("failed slot still counted as applied")
+	}
+
+	report = hub.ConfigReport()
+	if report == nil || report.Slots != 0 || report.Peers != 0 || len(report.Rejected) != 1 || report.Changes.SlotsRemoved != 1 {
+		t.Errorf("failed restart not reported: %+v", report)
+	}
+
+	if disables := hub.PendingDisables(); len(disables) != 1 {
+		t.Errorf("disable lost by a failed restart: %+v", disables)
+	}
+}
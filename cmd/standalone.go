@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/maddsua/nx-proxy/rest/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Reads a local config that's used in place of the one served by an auth backend.
+// It has the same shape as the config returned by the config procedure, either as json or,
+// for files with a .yml or .yaml extension, as yaml with the same field names
+func ReadStandaloneConfig(location string) (*model.FullConfig, error) {
+
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsStructuredConfig(location) {
+		if data, err = yamlToJson(data); err != nil {
+			return nil, fmt.Errorf("parse yaml: %v", err)
+		}
+	}
+
+	var cfg model.FullConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse json: %v", err)
+	}
+
+	return &cfg, nil
+}
+
+// Replaces a standalone config file. The file is swapped in at once so that a running node
+// never reads a partially written one, and it's only readable by the owner as it holds peer passwords.
+// Files with a yaml extension are written as yaml
+func WriteStandaloneConfig(location string, cfg *model.FullConfig) error {
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	if IsStructuredConfig(location) {
+		if data, err = jsonToYaml(data); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(location), "."+filepath.Base(location)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), location)
+}
+
+// Converts a yaml document to json, so that yaml configs are decoded with the json field names and types of the models
+func yamlToJson(data []byte) ([]byte, error) {
+
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+func jsonToYaml(data []byte) ([]byte, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buff)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(yamlNumbers(doc)); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Replaces json numbers with integers where possible, as yaml would otherwise write them as strings
+// or, when decoded as floats, in exponent notation
+func yamlNumbers(val any) any {
+
+	switch val := val.(type) {
+
+	case map[string]any:
+		for key, item := range val {
+			val[key] = yamlNumbers(item)
+		}
+		return val
+
+	case []any:
+		for idx, item := range val {
+			val[idx] = yamlNumbers(item)
+		}
+		return val
+
+	case json.Number:
+		if num, err := val.Int64(); err == nil {
+			return num
+		} else if num, err := strconv.ParseUint(val.String(), 10, 64); err == nil {
+			return num
+		} else if num, err := val.Float64(); err == nil {
+			return num
+		}
+		return val.String()
+
+	default:
+		return val
+	}
+}
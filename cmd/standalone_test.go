@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStandaloneConfig_Yaml(t *testing.T) {
+
+	dir := t.TempDir()
+	location := filepath.Join(dir, "services.yml")
+
+	content := `
+dns: 1.1.1.1:53
+services:
+  - proto: socks
+    bind_addr: 0.0.0.0:1080
+    max_client_connections: 10
+    peers:
+      - id: 6b4a5f1e-8d7c-4a34-9b2f-4c1b2f0b6a10
+        password_auth:
+          user: maddsua
+          password: "0123"
+        bandwidth:
+          rx: 4294967295
+          tx: 1000000
+        disable_at: 2030-01-01T00:00:00Z
+`
+
+	if err := os.WriteFile(location, []byte(content), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	cfg, err := ReadStandaloneConfig(location)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if cfg.DNS != "1.1.1.1:53" || len(cfg.Services) != 1 || len(cfg.Services[0].Peers) != 1 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	svc := cfg.Services[0]
+	if svc.Proto != "socks" || svc.BindAddr != "0.0.0.0:1080" || svc.MaxClientConnections != 10 {
+		t.Errorf("unexpected slot options: %+v", svc.SlotOptions)
+	}
+
+	peer := svc.Peers[0]
+
+	if peer.ID != uuid.MustParse("6b4a5f1e-8d7c-4a34-9b2f-4c1b2f0b6a10") {
+		t.Errorf("unexpected peer id: %v", peer.ID)
+	}
+
+	if peer.PasswordAuth == nil || peer.PasswordAuth.User != "maddsua" || peer.PasswordAuth.Password != "0123" {
+		t.Errorf("unexpected password auth: %+v", peer.PasswordAuth)
+	}
+
+	if peer.Bandwidth.Rx != 4294967295 || peer.Bandwidth.Tx != 1000000 {
+		t.Errorf("unexpected bandwidth: %+v", peer.Bandwidth)
+	}
+
+	if peer.DisableAt == nil || !peer.DisableAt.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected disable time: %v", peer.DisableAt)
+	}
+
+	//	configs written as yaml read back the same
+	copyLocation := filepath.Join(dir, "copy.yaml")
+
+	if err := WriteStandaloneConfig(copyLocation, cfg); err != nil {
+		t.Fatalf("write copy: %v", err)
+	}
+
+	copied, err := ReadStandaloneConfig(copyLocation)
+	if err != nil {
+		t.Fatalf("read copy: %v", err)
+	}
+
+	want, _ := json.Marshal(cfg)
+	got, _ := json.Marshal(copied)
+
+	if string(want) != string(got) {
+		t.Errorf("config changed by a yaml round trip:\nwant %s\ngot  %s", want, got)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Config keys read by the daemon, in the order they're printed
+var configKeys = []string{
+	"AUTH_URL",
+	"SECRET_TOKEN",
+	"SKIP_STARTUP_PING",
+	"STANDALONE_CONFIG",
+	"ADMIN_ADDR",
+	"ADMIN_TOKEN",
+	"ADMIN_STATUS_TOKEN",
+	"HEALTH_ADDR",
+	"DEBUG",
+	"LOG_JOURNAL",
+	"AUTH_FAILURE_LOG",
+	"DELTA_WINDOW",
+	"DELTA_QUEUE_CAP",
+	"USAGE_SAMPLES",
+	"WATCHDOG",
+	"WATCHDOG_REPORT",
+	"EGRESS_LIMIT",
+	"KERNEL_PACING",
+	"BRIDGE_LINGER",
+	"AUTH_RATELIMIT_MODE",
+	"AUTH_RATELIMIT_OFFENDER_QUOTA",
+	"FRAMED_IP_CHECK_INTERVAL",
+	"FRAMED_IP_PROBE",
+	"FRAMED_IP_INTERFACE",
+	"FRAMED_IP_ANNOUNCE",
+	"BLOCKLIST_URL",
+	"BLOCKLIST_REFRESH",
+	"GEOIP_DB",
+}
+
+var secretConfigKeys = map[string]bool{
+	"SECRET_TOKEN":       true,
+	"ADMIN_TOKEN":        true,
+	"ADMIN_STATUS_TOKEN": true,
+}
+
+// Runs a subcommand if one is given in args; returns false when the daemon has to be started instead
+func RunSubcommand(args []string) (int, bool) {
+
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	switch args[0] {
+	case "validate":
+		return runValidate(args[1:]), true
+	case "print-config":
+		return runPrintConfig(args[1:]), true
+	case "token":
+		return runToken(args[1:]), true
+	case "peers":
+		return runPeers(args[1:]), true
+	case "help", "-h", "--help":
+		printUsage()
+		return 0, true
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
+		printUsage()
+		return 2, true
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: nx-proxy [command]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Runs the proxy service when no command is given. Commands:")
+	fmt.Fprintln(os.Stderr, "  validate [-config path] [-offline]  check the config, auth backend access and slot bind addresses")
+	fmt.Fprintln(os.Stderr, "  print-config [-config path]         print the effective config with secrets redacted")
+	fmt.Fprintln(os.Stderr, "  token new [-env [-auth-url url]]    generate a node token")
+	fmt.Fprintln(os.Stderr, "  token inspect [token]               print token id and key fingerprint; reads stdin when no token is given")
+	fmt.Fprintln(os.Stderr, "  peers export [-admin addr] [-o path] export services and peers of a running node via its admin API")
+	fmt.Fprintln(os.Stderr, "  peers import [-dry-run] path        install exported services as the standalone config")
+}
+
+// Loads the config file the same way the service does, unless a location is set explicitly
+func loadSubcommandConfig(location string) (ConfigEntries, string, error) {
+
+	if location == "" && IsKubeMode() {
+		location = KubeConfigPath()
+	}
+
+	if location != "" {
+		entries, err := ReadConfigFile(location)
+		return entries, location, err
+	}
+
+	entries, location := LoadConfigFile()
+	return entries, location, nil
+}
+
+func runPrintConfig(args []string) int {
+
+	flags := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	cfgPath := flags.String("config", "", "config file location")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	entries, location, err := loadSubcommandConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config %s: %v\n", location, err)
+		return 1
+	}
+
+	if location != "" {
+		fmt.Printf("# config file: %s\n", location)
+	} else {
+		fmt.Println("# no config file found")
+	}
+
+	for _, key := range configKeys {
+
+		val, ok := GetConfigOpt(entries, key)
+		if !ok {
+			continue
+		}
+
+		if secretConfigKeys[key] {
+			val = "<redacted>"
+		}
+
+		source := "file"
+		if os.Getenv("NXPROXY_"+key) != "" {
+			source = "env"
+		}
+
+		fmt.Printf("%s=%s # %s\n", key, val, source)
+	}
+
+	return 0
+}
+
+func runValidate(args []string) int {
+
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	cfgPath := flags.String("config", "", "config file location")
+	offline := flags.Bool("offline", false, "skip auth backend and bind checks")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var failed bool
+
+	var report = func(check string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", check, err)
+		} else {
+			fmt.Printf("ok    %s\n", check)
+		}
+	}
+
+	var warn = func(check string, message string) {
+		fmt.Printf("warn  %s: %s\n", check, message)
+	}
+
+	entries, cfgLocation, err := loadSubcommandConfig(*cfgPath)
+	if err != nil {
+		report("config file "+cfgLocation, err)
+		return 1
+	} else if cfgLocation == "" {
+		warn("config file", "not found; using environment only")
+	} else {
+		report("config file "+cfgLocation, nil)
+	}
+
+	results := validateConfigOpts(entries)
+	for _, key := range configKeys {
+		if err, has := results[key]; has {
+			report("option "+key, err)
+		}
+	}
+
+	var cfg *model.FullConfig
+
+	if location, ok := GetConfigOpt(entries, "STANDALONE_CONFIG"); ok {
+
+		if cfg, err = ReadStandaloneConfig(location); err != nil {
+			report("standalone config "+location, err)
+			return exitStatus(failed)
+		}
+
+		for _, issue := range nxproxy.ValidateServices(cfg.Services) {
+			warn("standalone config", fmt.Sprintf("%s %s: %s", issue.Slot, issue.Peer, issue.Reason))
+		}
+
+		report(fmt.Sprintf("standalone config: %d services", len(cfg.Services)), nil)
+
+		if *offline {
+			return exitStatus(failed)
+		}
+
+	} else {
+
+		client, err := NewAuthClient(entries)
+		report("auth client", err)
+
+		if client == nil || *offline {
+			return exitStatus(failed)
+		}
+
+		if client.Token == nil {
+			warn("auth client", "secret token not provided")
+		}
+
+		if err := client.Ping(); err != nil {
+			report("auth backend ping", err)
+			return exitStatus(failed)
+		}
+
+		report("auth backend ping", nil)
+
+		if cfg, err = client.PullConfig(); err != nil {
+			report("pull config", err)
+			return exitStatus(failed)
+		}
+
+		report(fmt.Sprintf("pull config: %d services", len(cfg.Services)), nil)
+	}
+
+	var bindCheck = func(check string, proto string, addr string) {
+
+		//	the running instance is expected to hold its ports while a new config is being checked
+		if err := bindTest(proto, addr); errors.Is(err, syscall.EADDRINUSE) {
+			warn(check, "address in use, possibly by the running instance")
+		} else {
+			report(check, err)
+		}
+	}
+
+	for _, entry := range cfg.Services {
+
+		check := fmt.Sprintf("slot %s@%s", entry.Proto, entry.BindAddr)
+
+		resolved, err := nxproxy.ResolveBindAddr(entry.BindAddr)
+		if err != nil {
+			report(check+" bind addr", err)
+			continue
+		}
+
+		entry.BindAddr = resolved
+
+		var slot nxproxy.Slot
+		if err := slot.SetOptions(entry.SlotOptions); err != nil {
+			report(check+" options", err)
+			continue
+		}
+
+		bindAddr, err := nxproxy.ServiceBindAddr(entry.BindAddr, entry.Proto)
+		if err != nil {
+			report(check+" bind addr", err)
+			continue
+		}
+
+		addr, proto, _ := nxproxy.SplitAddrNet(bindAddr)
+		bindCheck(check+" bind", proto, addr)
+	}
+
+	if addr, ok := GetConfigOpt(entries, "ADMIN_ADDR"); ok {
+		bindCheck("admin api bind", "tcp", addr)
+	}
+
+	if IsKubeMode() {
+
+		addr := defaultKubeHealthAddr
+		if val, ok := GetConfigOpt(entries, "HEALTH_ADDR"); ok {
+			addr = val
+		}
+
+		bindCheck("health endpoint bind", "tcp", addr)
+	}
+
+	return exitStatus(failed)
+}
+
+// Checks the formats of config options that the service parses on startup
+func validateConfigOpts(entries ConfigEntries) map[string]error {
+
+	results := map[string]error{}
+
+	var check = func(key string, parse func(val string) error) {
+		if val, ok := GetConfigOpt(entries, key); ok {
+			results[key] = parse(val)
+		}
+	}
+
+	//	flags are only enabled by 'true', so anything else is most likely a typo
+	var parseBool = func(val string) error {
+		if val := strings.ToLower(val); val != "true" && val != "false" {
+			return fmt.Errorf("expected 'true' or 'false': '%s'", val)
+		}
+		return nil
+	}
+
+	for _, key := range []string{"DEBUG", "SKIP_STARTUP_PING", "WATCHDOG", "WATCHDOG_REPORT", "KERNEL_PACING", "FRAMED_IP_ANNOUNCE"} {
+		check(key, parseBool)
+	}
+
+	check("LOG_JOURNAL", func(val string) error {
+		if val := strings.ToLower(val); val != "true" && val != "false" && val != "auto" {
+			return fmt.Errorf("expected 'true', 'false' or 'auto': '%s'", val)
+		}
+		return nil
+	})
+
+	check("USAGE_SAMPLES", func(val string) error {
+		if samples, err := strconv.Atoi(val); err != nil || samples < 0 {
+			return fmt.Errorf("invalid sample count: '%s'", val)
+		}
+		return nil
+	})
+
+	check("DELTA_WINDOW", func(val string) error {
+		if window, err := time.ParseDuration(val); err != nil || window < 0 {
+			return fmt.Errorf("invalid duration: '%s'", val)
+		}
+		return nil
+	})
+
+	check("DELTA_QUEUE_CAP", func(val string) error {
+		if limit, err := strconv.Atoi(val); err != nil || limit < 0 {
+			return fmt.Errorf("invalid entry count: '%s'", val)
+		}
+		return nil
+	})
+
+	check("BRIDGE_LINGER", func(val string) error {
+		if linger, err := time.ParseDuration(val); err != nil || linger < 0 {
+			return fmt.Errorf("invalid duration: '%s'", val)
+		}
+		return nil
+	})
+
+	check("AUTH_RATELIMIT_MODE", func(val string) error {
+		if mode := nxproxy.RateLimitMode(strings.ToLower(val)); mode == "" || !mode.Valid() {
+			return fmt.Errorf("expected 'fixed' or 'gcra': '%s'", val)
+		}
+		return nil
+	})
+
+	check("AUTH_RATELIMIT_OFFENDER_QUOTA", func(val string) error {
+		if quota, err := strconv.ParseInt(val, 10, 64); err != nil || quota < 1 {
+			return fmt.Errorf("invalid quota: '%s'", val)
+		}
+		return nil
+	})
+
+	check("FRAMED_IP_CHECK_INTERVAL", func(val string) error {
+		if interval, err := time.ParseDuration(val); err != nil || (interval != 0 && interval < time.Second) {
+			return fmt.Errorf("must be zero or a duration of at least 1s: '%s'", val)
+		}
+		return nil
+	})
+
+	check("FRAMED_IP_PROBE", func(val string) error {
+		if _, _, err := net.SplitHostPort(val); err != nil {
+			return fmt.Errorf("invalid probe address: '%s'", val)
+		}
+		return nil
+	})
+
+	check("STANDALONE_CONFIG", func(val string) error {
+		_, err := ReadStandaloneConfig(val)
+		return err
+	})
+
+	check("FRAMED_IP_INTERFACE", func(val string) error {
+		_, err := net.InterfaceByName(val)
+		return err
+	})
+
+	check("EGRESS_LIMIT", func(val string) error {
+		_, err := ParseBitRate(val)
+		return err
+	})
+
+	check("BLOCKLIST_REFRESH", func(val string) error {
+		if interval, err := time.ParseDuration(val); err != nil || interval < time.Minute {
+			return fmt.Errorf("must be a duration of at least 1m: '%s'", val)
+		}
+		return nil
+	})
+
+	check("GEOIP_DB", func(val string) error {
+		geo, _, err := ReadGeoIP(val)
+		if err == nil && geo.Len() == 0 {
+			return fmt.Errorf("no valid entries in '%s'", val)
+		}
+		return err
+	})
+
+	for _, key := range []string{"ADMIN_ADDR", "HEALTH_ADDR"} {
+		check(key, func(val string) error {
+			_, _, err := net.SplitHostPort(val)
+			return err
+		})
+	}
+
+	return results
+}
+
+// Binds an address and releases it right away
+func bindTest(proto string, addr string) error {
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return err
+	}
+
+	return listener.Close()
+}
+
+func exitStatus(failed bool) int {
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func runToken(args []string) int {
+
+	if len(args) == 0 {
+		printUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "new":
+		return runTokenNew(args[1:])
+	case "inspect":
+		return runTokenInspect(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+func runTokenNew(args []string) int {
+
+	flags := flag.NewFlagSet("token new", flag.ContinueOnError)
+	envFile := flags.Bool("env", false, "print as systemd EnvironmentFile lines")
+	authUrl := flags.String("auth-url", "", "auth backend url to include in EnvironmentFile output")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *authUrl != "" {
+
+		if !*envFile {
+			fmt.Fprintln(os.Stderr, "-auth-url is only used together with -env")
+			return 2
+		}
+
+		if _, err := ParseAuthUrl(*authUrl); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid auth url: %v\n", err)
+			return 2
+		}
+	}
+
+	token, err := nxproxy.NewServerToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate token: %v\n", err)
+		return 1
+	}
+
+	if !*envFile {
+		fmt.Println(token.String())
+		return 0
+	}
+
+	fmt.Printf("# node id: %s\n", token.ID)
+	if *authUrl != "" {
+		fmt.Printf("NXPROXY_AUTH_URL=%s\n", *authUrl)
+	}
+	fmt.Printf("NXPROXY_SECRET_TOKEN=%s\n", token.String())
+
+	return 0
+}
+
+// Prints token details without revealing the secret key; the token is read from stdin unless passed as an argument
+func runTokenInspect(args []string) int {
+
+	var raw string
+
+	if len(args) > 0 {
+		raw = args[0]
+	} else {
+
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() {
+			fmt.Fprintln(os.Stderr, "no token provided")
+			return 2
+		}
+
+		raw = scanner.Text()
+	}
+
+	//	accept EnvironmentFile and config lines as they are
+	if _, val, has := strings.Cut(raw, "="); has {
+		raw = val
+	} else if _, val, has := strings.Cut(raw, ":"); has {
+		raw = val
+	}
+
+	token, err := nxproxy.ParseServerToken(strings.Trim(strings.TrimSpace(raw), `"'`))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid token: %v\n", err)
+		return 1
+	}
+
+	fingerprint := sha256.Sum256(token.SecretKey)
+
+	fmt.Printf("id:              %s\n", token.ID)
+	fmt.Printf("key length:      %d bytes\n", len(token.SecretKey))
+	fmt.Printf("key fingerprint: %s\n", hex.EncodeToString(fingerprint[:8]))
+
+	if len(token.SecretKey) < 32 {
+		fmt.Println("warning:         key is shorter than 32 bytes")
+	}
+
+	return 0
+}
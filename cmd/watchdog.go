@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Periodically samples runtime resource usage and reports values that don't add up
+type Watchdog struct {
+	Hub *ServiceHub
+
+	baseGoroutines int
+	baseFiles      int
+	lastOrphaned   int
+	report         *model.WatchdogReport
+	mtx            sync.Mutex
+}
+
+func (wd *Watchdog) Run(ctx context.Context) {
+
+	const sampleInterval = 30 * time.Second
+
+	wd.baseGoroutines = runtime.NumGoroutine()
+	wd.baseFiles = countOpenFiles()
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wd.sample()
+		}
+	}
+}
+
+func (wd *Watchdog) sample() {
+
+	//	rough upper bounds of resources a single proxied connection may hold:
+	//	a handler routine, two splice routines and a couple of server-side routines; client and remote sockets
+	const goroutinesPerConn = 6
+	const filesPerConn = 2
+	const slack = 64
+
+	live := int(nxproxy.LiveConnections())
+	tracked := wd.Hub.ActiveConnections()
+
+	report := model.WatchdogReport{
+		Goroutines:  runtime.NumGoroutine(),
+		OpenFiles:   countOpenFiles(),
+		Connections: tracked,
+	}
+
+	var anomaly = func(message string, attrs ...any) {
+		report.Anomalies = append(report.Anomalies, message)
+		slog.Warn("Watchdog: "+message, attrs...)
+	}
+
+	if limit := wd.baseGoroutines + goroutinesPerConn*live + slack; report.Goroutines > limit {
+		anomaly(fmt.Sprintf("goroutine count exceeds expected limit of %d", limit),
+			slog.Int("goroutines", report.Goroutines),
+			slog.Int("connections", live))
+	}
+
+	if limit := wd.baseFiles + filesPerConn*live + slack; wd.baseFiles >= 0 && report.OpenFiles > limit {
+		anomaly(fmt.Sprintf("open file count exceeds expected limit of %d", limit),
+			slog.Int("open_files", report.OpenFiles),
+			slog.Int("connections", live))
+	}
+
+	//	connections that are still open but don't belong to any active peer;
+	//	these show up briefly while closing, so they're only reported when seen twice in a row
+	orphaned := max(live-tracked, 0)
+	if orphaned > 0 && wd.lastOrphaned > 0 {
+		report.OrphanedConnections = min(orphaned, wd.lastOrphaned)
+		anomaly("connections outlive their peers",
+			slog.Int("orphaned", report.OrphanedConnections))
+	}
+
+	wd.lastOrphaned = orphaned
+
+	slog.Debug("Watchdog: Sample",
+		slog.Int("goroutines", report.Goroutines),
+		slog.Int("open_files", report.OpenFiles),
+		slog.Int("connections", tracked),
+		slog.Int("live_connections", live))
+
+	wd.mtx.Lock()
+	defer wd.mtx.Unlock()
+
+	wd.report = &report
+}
+
+// Returns the most recent sample report
+func (wd *Watchdog) Report() *model.WatchdogReport {
+
+	wd.mtx.Lock()
+	defer wd.mtx.Unlock()
+
+	return wd.report
+}
+
+// Returns -1 when the number of open files can't be determined
+func countOpenFiles() int {
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return len(entries)
+}
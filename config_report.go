@@ -0,0 +1,239 @@
+package nxproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config entry that couldn't be applied as it is
+type ConfigIssue struct {
+
+	//	slot handle, formatted as proto@bind_addr
+	Slot string `json:"slot"`
+
+	//	set for peer entries
+	PeerID *uuid.UUID `json:"peer_id,omitempty"`
+	Peer   string     `json:"peer,omitempty"`
+
+	Reason string `json:"reason"`
+}
+
+// Outcome of applying a config. Rejected entries are skipped entirely,
+// while the ones with warnings are applied with some of their options ignored
+type ConfigReport struct {
+	Applied time.Time `json:"applied"`
+
+	//	number of accepted entries
+	Slots int `json:"slots"`
+	Peers int `json:"peers"`
+
+	Rejected []ConfigIssue `json:"rejected,omitempty"`
+	Warnings []ConfigIssue `json:"warnings,omitempty"`
+
+	//	what the config changed compared to the one that was running before
+	Changes ConfigChanges `json:"changes"`
+}
+
+// Summary of changes made by applying a config. Peers of created and replaced slots are counted as added
+type ConfigChanges struct {
+	SlotsAdded    int `json:"slots_added"`
+	SlotsRemoved  int `json:"slots_removed"`
+	SlotsReplaced int `json:"slots_replaced"`
+	SlotsUpdated  int `json:"slots_updated"`
+
+	PeersAdded   int `json:"peers_added"`
+	PeersRemoved int `json:"peers_removed"`
+	PeersChanged int `json:"peers_changed"`
+
+	//	notable peer changes, also counted as changed peers
+	CredentialRotations int `json:"credential_rotations"`
+	FramedIPChanges     int `json:"framed_ip_changes"`
+	PeersDisabled       int `json:"peers_disabled"`
+	PeersEnabled        int `json:"peers_enabled"`
+}
+
+func (changes *ConfigChanges) Merge(other ConfigChanges) {
+	changes.SlotsAdded += other.SlotsAdded
+	changes.SlotsRemoved += other.SlotsRemoved
+	changes.SlotsReplaced += other.SlotsReplaced
+	changes.SlotsUpdated += other.SlotsUpdated
+	changes.PeersAdded += other.PeersAdded
+	changes.PeersRemoved += other.PeersRemoved
+	changes.PeersChanged += other.PeersChanged
+	changes.CredentialRotations += other.CredentialRotations
+	changes.FramedIPChanges += other.FramedIPChanges
+	changes.PeersDisabled += other.PeersDisabled
+	changes.PeersEnabled += other.PeersEnabled
+}
+
+// Counts changes between the running and the updated options of a peer
+func (changes *ConfigChanges) addPeer(current *PeerOptions, next *PeerOptions) {
+
+	if reflect.DeepEqual(*current, *next) {
+		return
+	}
+
+	changes.PeersChanged++
+
+	switch {
+	case !current.CmpCredentials(*next):
+		changes.CredentialRotations++
+	case current.FramedIP != next.FramedIP || current.EgressNat != next.EgressNat:
+		changes.FramedIPChanges++
+	}
+
+	switch {
+	case current.Disabled == next.Disabled:
+	case next.Disabled:
+		changes.PeersDisabled++
+	default:
+		changes.PeersEnabled++
+	}
+}
+
+// Computes what replacing peer entries would change, the same way Slot.SetPeers counts it.
+// Invalid entries are left out, as they'd never be applied
+func DiffPeers(current []PeerOptions, next []PeerOptions) ConfigChanges {
+
+	var changes ConfigChanges
+
+	var running peerSet
+	currentMap := map[uuid.UUID]*PeerOptions{}
+
+	for idx := range current {
+		if running.add(&current[idx]) == nil {
+			currentMap[current[idx].ID] = &current[idx]
+		}
+	}
+
+	var imported peerSet
+
+	for idx := range next {
+
+		entry := &next[idx]
+
+		if imported.add(entry) != nil {
+			continue
+		}
+
+		if prev, has := currentMap[entry.ID]; has {
+			changes.addPeer(prev, entry)
+			delete(currentMap, entry.ID)
+			continue
+		}
+
+		changes.PeersAdded++
+	}
+
+	changes.PeersRemoved = len(currentMap)
+
+	return changes
+}
+
+// Reports whether the config didn't change anything
+func (changes ConfigChanges) Empty() bool {
+	return changes == ConfigChanges{}
+}
+
+// Returns change counts as log attributes, skipping the zero ones
+func (changes ConfigChanges) LogAttrs() []any {
+
+	var attrs []any
+
+	var add = func(key string, val int) {
+		if val != 0 {
+			attrs = append(attrs, slog.Int(key, val))
+		}
+	}
+
+	add("slots_added", changes.SlotsAdded)
+	add("slots_removed", changes.SlotsRemoved)
+	add("slots_replaced", changes.SlotsReplaced)
+	add("slots_updated", changes.SlotsUpdated)
+	add("peers_added", changes.PeersAdded)
+	add("peers_removed", changes.PeersRemoved)
+	add("peers_changed", changes.PeersChanged)
+	add("credential_rotations", changes.CredentialRotations)
+	add("framed_ip_changes", changes.FramedIPChanges)
+	add("peers_disabled", changes.PeersDisabled)
+	add("peers_enabled", changes.PeersEnabled)
+
+	return attrs
+}
+
+func (report *ConfigReport) Merge(other ConfigReport) {
+	report.Slots += other.Slots
+	report.Peers += other.Peers
+	report.Rejected = append(report.Rejected, other.Rejected...)
+	report.Warnings = append(report.Warnings, other.Warnings...)
+	report.Changes.Merge(other.Changes)
+}
+
+// Reports whether two reports have the same outcome, regardless of when they were made and what they changed
+func (report *ConfigReport) SameOutcome(other *ConfigReport) bool {
+	return report.Slots == other.Slots &&
+		report.Peers == other.Peers &&
+		slices.EqualFunc(report.Rejected, other.Rejected, ConfigIssue.equal) &&
+		slices.EqualFunc(report.Warnings, other.Warnings, ConfigIssue.equal)
+}
+
+func (issue ConfigIssue) equal(other ConfigIssue) bool {
+
+	if (issue.PeerID == nil) != (other.PeerID == nil) {
+		return false
+	}
+
+	if issue.PeerID != nil && *issue.PeerID != *other.PeerID {
+		return false
+	}
+
+	return issue.Slot == other.Slot && issue.Peer == other.Peer && issue.Reason == other.Reason
+}
+
+func peerIssue(slotHandle string, entry *PeerOptions, err error) ConfigIssue {
+
+	id := entry.ID
+
+	return ConfigIssue{
+		Slot:   slotHandle,
+		PeerID: &id,
+		Peer:   entry.DisplayName(),
+		Reason: err.Error(),
+	}
+}
+
+// Returns a checksum of service entries that doesn't depend on the order of slots and peers. Backends send it
+// along with the config, and nodes report the checksum of the entries they've actually applied, so that
+// partially applied configs and node-side overrides can be told apart from the intended config
+func ConfigChecksum(entries []ServiceOptions) string {
+
+	sorted := make([]ServiceOptions, len(entries))
+
+	for idx, entry := range entries {
+
+		entry.Peers = slices.Clone(entry.Peers)
+		slices.SortStableFunc(entry.Peers, func(a, b PeerOptions) int {
+			return strings.Compare(a.ID.String(), b.ID.String())
+		})
+
+		sorted[idx] = entry
+	}
+
+	slices.SortStableFunc(sorted, func(a, b ServiceOptions) int {
+		return strings.Compare(a.SlotOptions.Handle(), b.SlotOptions.Handle())
+	})
+
+	//	encoding only fails on unsupported values, which options don't have
+	data, _ := json.Marshal(sorted)
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:16])
+}
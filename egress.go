@@ -0,0 +1,86 @@
+package nxproxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A token bucket that limits the combined data rate of all connections sharing it.
+// It sits above per-peer bandwidth limits and is meant to cap node-wide egress
+type TokenBucket struct {
+	rate   atomic.Int64
+	tokens float64
+	last   time.Time
+	mtx    sync.Mutex
+}
+
+// Rate is in bytes per second, zero disables the limit
+func NewTokenBucket(rate int64) *TokenBucket {
+	var tb TokenBucket
+	tb.SetRate(rate)
+	return &tb
+}
+
+func (tb *TokenBucket) SetRate(rate int64) {
+	tb.rate.Store(max(rate, 0))
+}
+
+func (tb *TokenBucket) Rate() int64 {
+	return tb.rate.Load()
+}
+
+// Takes size tokens from the bucket and returns how long the caller has to wait to stay within the rate.
+// The bucket may go into debt, so that chunks larger than the burst size are let through eventually
+func (tb *TokenBucket) reserve(size int) time.Duration {
+
+	rate := tb.rate.Load()
+	if rate <= 0 || size <= 0 {
+		return 0
+	}
+
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	//	allow bursts of up to 100ms worth of data
+	burst := float64(rate) / 10
+
+	now := time.Now()
+
+	if tb.last.IsZero() {
+		tb.tokens = burst
+	} else {
+		tb.tokens = min(tb.tokens+now.Sub(tb.last).Seconds()*float64(rate), burst)
+	}
+
+	tb.last = now
+	tb.tokens -= float64(size)
+
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / float64(rate) * float64(time.Second))
+}
+
+// Blocks until size bytes may be sent or the context is cancelled
+func (tb *TokenBucket) Wait(ctx context.Context, size int) {
+
+	if tb == nil {
+		return
+	}
+
+	delay := tb.reserve(size)
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
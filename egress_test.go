@@ -0,0 +1,60 @@
+package nxproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestTokenBucket_1(t *testing.T) {
+
+	const rate = 1_000_000
+
+	bucket := nxproxy.NewTokenBucket(rate)
+
+	started := time.Now()
+
+	//	the first 100ms worth of data goes through as a burst, the rest is paced
+	for range 30 {
+		bucket.Wait(context.Background(), 10_000)
+	}
+
+	if elapsed := time.Since(started); elapsed < 180*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("unexpected elapsed time: %v", elapsed)
+	}
+}
+
+func TestTokenBucket_Unlimited(t *testing.T) {
+
+	bucket := nxproxy.NewTokenBucket(0)
+
+	started := time.Now()
+
+	for range 100 {
+		bucket.Wait(context.Background(), 1_000_000)
+	}
+
+	if elapsed := time.Since(started); elapsed > 10*time.Millisecond {
+		t.Errorf("unlimited bucket blocked for %v", elapsed)
+	}
+
+	var unset *nxproxy.TokenBucket
+	unset.Wait(context.Background(), 1_000_000)
+}
+
+func TestTokenBucket_Cancel(t *testing.T) {
+
+	bucket := nxproxy.NewTokenBucket(1_000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	bucket.Wait(ctx, 1_000_000)
+
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Errorf("wait wasn't cancelled: %v", elapsed)
+	}
+}
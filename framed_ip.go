@@ -0,0 +1,239 @@
+package nxproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrFramedIPUnavailable = errors.New("framed ip unavailable")
+
+const DefaultFramedIPProbeTimeout = 5 * time.Second
+
+type FramedIPReason string
+
+const (
+	FramedIPNotAssigned = FramedIPReason("not_assigned")
+	FramedIPProbeFailed = FramedIPReason("probe_failed")
+)
+
+// Reported for peers whose framed IP can't be used at the moment
+type FramedIPIssue struct {
+	PeerID    uuid.UUID      `json:"peer_id"`
+	ProxyAddr string         `json:"proxy_addr"`
+	IP        string         `json:"ip"`
+	Reason    FramedIPReason `json:"reason"`
+	Error     string         `json:"error,omitempty"`
+	Since     time.Time      `json:"since"`
+
+	//	set when the peer dials from the default source address meanwhile, unset when its dials are refused
+	Fallback bool `json:"fallback"`
+}
+
+// Checks whether framed IPs are still usable. Results are kept for the duration of a single round of checks,
+// so that addresses shared by many peers are only checked once
+type FramedIPProbe struct {
+
+	//	optional tcp address dialed from every framed ip to make sure that it's actually routable
+	Addr    string
+	Timeout time.Duration
+
+	results map[string]framedIPResult
+	mtx     sync.Mutex
+}
+
+type framedIPResult struct {
+	reason FramedIPReason
+	err    error
+}
+
+func (probe *FramedIPProbe) check(ctx context.Context, ip net.IP) (FramedIPReason, error) {
+
+	probe.mtx.Lock()
+	defer probe.mtx.Unlock()
+
+	if result, has := probe.results[ip.String()]; has {
+		return result.reason, result.err
+	}
+
+	var result framedIPResult
+
+	if assigned, err := AddrAssigned(ip); err != nil || !assigned {
+		result = framedIPResult{reason: FramedIPNotAssigned, err: err}
+	} else if probe.Addr != "" {
+
+		timeout := probe.Timeout
+		if timeout <= 0 {
+			timeout = DefaultFramedIPProbeTimeout
+		}
+
+		dialer := net.Dialer{LocalAddr: TcpDialAddr(ip), Timeout: timeout}
+
+		if conn, err := dialer.DialContext(ctx, "tcp", probe.Addr); err != nil {
+			result = framedIPResult{reason: FramedIPProbeFailed, err: err}
+		} else {
+			conn.Close()
+		}
+	}
+
+	if probe.results == nil {
+		probe.results = map[string]framedIPResult{}
+	}
+
+	probe.results[ip.String()] = result
+
+	return result.reason, result.err
+}
+
+type peerFramedIP struct {
+	down   atomic.Bool
+	ip     net.IP
+	reason FramedIPReason
+	err    error
+	since  time.Time
+	mtx    sync.Mutex
+}
+
+// Updates the framed ip state; returns true when availability has changed
+func (state *peerFramedIP) set(ip net.IP, reason FramedIPReason, err error) bool {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	wasDown := state.down.Load()
+	isDown := ip != nil && reason != ""
+
+	if !ip.Equal(state.ip) || wasDown != isDown {
+		state.since = time.Now()
+	}
+
+	state.ip = ip
+	state.reason = reason
+	state.err = err
+	state.down.Store(isDown)
+
+	return wasDown != isDown
+}
+
+func (state *peerFramedIP) issue() (FramedIPIssue, bool) {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if !state.down.Load() {
+		return FramedIPIssue{}, false
+	}
+
+	issue := FramedIPIssue{
+		IP:     state.ip.String(),
+		Reason: state.reason,
+		Since:  state.since,
+	}
+
+	if state.err != nil {
+		issue.Error = state.err.Error()
+	}
+
+	return issue, true
+}
+
+// Sets the framed ip of a peer along with the error returned when checking it
+func (peer *Peer) setFramedIP(ip net.IP, err error) {
+
+	var reason FramedIPReason
+	if err != nil {
+		reason = FramedIPNotAssigned
+	}
+
+	peer.framed.set(ip, reason, err)
+}
+
+// Reports whether the peer has a framed ip that can't be used at the moment
+func (peer *Peer) FramedIPDown() bool {
+	return peer.framed.down.Load()
+}
+
+// Checks framed IPs of slot peers and updates their state. Peers whose framed ip becomes unavailable either
+// dial from the default source address or get their dials refused, depending on the slot options
+func (slot *Slot) CheckFramedIPs(ctx context.Context, probe *FramedIPProbe) {
+
+	//	probes may take a while, so they're run without holding the slot lock
+	slot.mtx.Lock()
+	var peers []*Peer
+	for _, peer := range slot.peerMap {
+		if peer.LocalFramedIP() != "" {
+			peers = append(peers, peer)
+		}
+	}
+	slot.mtx.Unlock()
+
+	opts := slot.Options()
+
+	for _, peer := range peers {
+
+		ip := net.ParseIP(peer.LocalFramedIP())
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+
+		reason, err := probe.check(ctx, ip)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !peer.framed.set(ip, reason, err) {
+			continue
+		}
+
+		if reason == "" {
+			slog.Info("Peer framed IP restored",
+				slog.String("id", peer.ID.String()),
+				slog.String("name", peer.DisplayName()),
+				slog.Any("slot", peer.Slot),
+				slog.String("addr", ip.String()))
+			continue
+		}
+
+		errMessage := string(reason)
+		if err != nil {
+			errMessage = fmt.Sprintf("%s: %v", reason, err)
+		}
+
+		slog.Warn("Peer framed IP unavailable",
+			slog.String("id", peer.ID.String()),
+			slog.String("name", peer.DisplayName()),
+			slog.Any("slot", peer.Slot),
+			slog.String("addr", ip.String()),
+			slog.String("err", errMessage),
+			slog.Bool("fallback", !opts.StrictFramedIP))
+	}
+}
+
+// Returns peers whose framed IPs are unavailable at the moment
+func (slot *Slot) FramedIPIssues() []FramedIPIssue {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	opts := slot.Options()
+
+	var entries []FramedIPIssue
+
+	for _, peer := range slot.peerMap {
+		if issue, has := peer.framed.issue(); has {
+			issue.PeerID = peer.ID
+			issue.ProxyAddr = opts.BindAddr
+			issue.Fallback = !peer.StrictFramedIP
+			entries = append(entries, issue)
+		}
+	}
+
+	return entries
+}
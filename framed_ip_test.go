@@ -0,0 +1,124 @@
+package nxproxy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_FramedIPEgressNat(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080", StrictFramedIP: true}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	report := slot.SetPeers([]nxproxy.PeerOptions{
+		{
+			ID:           uuid.New(),
+			PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"},
+			FramedIP:     "203.0.113.10",
+			EgressNat:    true,
+		},
+	})
+
+	if len(report.Warnings) > 0 {
+		t.Errorf("unexpected warnings: %+v", report.Warnings)
+	}
+
+	slot.CheckFramedIPs(context.Background(), &nxproxy.FramedIPProbe{})
+
+	if issues := slot.FramedIPIssues(); len(issues) > 0 {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+
+	peer, err := slot.LookupWithPassword(net.ParseIP("127.0.0.1"), "maddsua", "1")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	if peer.Dialer.LocalAddr != nil || peer.FramedIPDown() {
+		t.Errorf("natted framed ip bound locally: %v", peer.Dialer.LocalAddr)
+	}
+}
+
+func TestSlot_FramedIPUnavailable(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	slotOpts := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(slotOpts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{
+			ID:           uuid.New(),
+			PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"},
+			FramedIP:     "203.0.113.10",
+		},
+	}
+
+	slot.SetPeers(peers)
+	slot.CheckFramedIPs(context.Background(), &nxproxy.FramedIPProbe{})
+
+	issues := slot.FramedIPIssues()
+	if len(issues) != 1 {
+		t.Fatalf("unexpected issues: %+v", issues)
+	}
+
+	if issue := issues[0]; issue.IP != "203.0.113.10" || issue.Reason != nxproxy.FramedIPNotAssigned || !issue.Fallback {
+		t.Errorf("unexpected issue: %+v", issue)
+	}
+
+	peer, err := slot.LookupWithPassword(net.ParseIP("127.0.0.1"), "maddsua", "1")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	conn, err := peer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("fallback dial: %v", err)
+	}
+
+	conn.Close()
+
+	slotOpts.StrictFramedIP = true
+
+	if err := slot.SetOptions(slotOpts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	slot.SetPeers(peers)
+
+	if _, err := peer.DialContext(context.Background(), "tcp", listener.Addr().String()); !errors.Is(err, nxproxy.ErrFramedIPUnavailable) {
+		t.Errorf("strict dial: unexpected error: %v", err)
+	}
+
+	if issues := slot.FramedIPIssues(); len(issues) != 1 || issues[0].Fallback {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+}
@@ -0,0 +1,148 @@
+package nxproxy
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Country lookup table built from a local GeoIP database in CSV form. Each line holds either
+// an address range followed by a country code ("first,last,country", the DB-IP and ipinfo layout)
+// or a CIDR followed by a country code ("cidr,country"). Extra columns are ignored
+type GeoIP struct {
+
+	//	sorted by the first address; ranges are expected not to overlap
+	ranges []geoRange
+}
+
+type geoRange struct {
+	first   netip.Addr
+	last    netip.Addr
+	country string
+}
+
+func (geo *GeoIP) Len() int {
+	return len(geo.ranges)
+}
+
+// Parses a GeoIP database. Returns the number of lines that were skipped for being invalid, which includes headers
+func ParseGeoIP(reader io.Reader) (*GeoIP, int, error) {
+
+	var geo GeoIP
+	var skipped int
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+
+	for {
+
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+
+			if parseErr := (*csv.ParseError)(nil); errors.As(err, &parseErr) {
+				skipped++
+				continue
+			}
+
+			return nil, skipped, err
+		}
+
+		entry, ok := parseGeoRange(record)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		geo.ranges = append(geo.ranges, entry)
+	}
+
+	sort.Slice(geo.ranges, func(i, j int) bool {
+		return geo.ranges[i].first.Less(geo.ranges[j].first)
+	})
+
+	return &geo, skipped, nil
+}
+
+func parseGeoRange(record []string) (geoRange, bool) {
+
+	var entry geoRange
+	var country string
+
+	if prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0])); err == nil && len(record) >= 2 {
+
+		prefix = prefix.Masked()
+
+		entry.first = prefix.Addr().Unmap()
+		entry.last = prefixLastAddr(prefix).Unmap()
+		country = record[1]
+
+	} else if len(record) >= 3 {
+
+		first, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return entry, false
+		}
+
+		last, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return entry, false
+		}
+
+		entry.first = first.Unmap()
+		entry.last = last.Unmap()
+		country = record[2]
+
+	} else {
+		return entry, false
+	}
+
+	if entry.first.Is4() != entry.last.Is4() || entry.last.Less(entry.first) {
+		return entry, false
+	}
+
+	//	databases mark unassigned ranges with placeholders
+	if entry.country = strings.ToUpper(strings.TrimSpace(country)); entry.country == "" || entry.country == "-" {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+func prefixLastAddr(prefix netip.Prefix) netip.Addr {
+
+	addr := prefix.Addr()
+	bytes := addr.AsSlice()
+
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+
+	last, _ := netip.AddrFromSlice(bytes)
+	return last
+}
+
+// Returns the country code of an address
+func (geo *GeoIP) Country(addr netip.Addr) (string, bool) {
+
+	if geo == nil || !addr.IsValid() {
+		return "", false
+	}
+
+	addr = addr.Unmap().WithZone("")
+
+	idx := sort.Search(len(geo.ranges), func(i int) bool {
+		return addr.Less(geo.ranges[i].first)
+	}) - 1
+
+	if idx < 0 || geo.ranges[idx].last.Less(addr) {
+		return "", false
+	}
+
+	return geo.ranges[idx].country, true
+}
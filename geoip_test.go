@@ -0,0 +1,50 @@
+package nxproxy_test
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestParseGeoIP(t *testing.T) {
+
+	feed := strings.Join([]string{
+		"start_ip,end_ip,country",
+		"# comment",
+		"1.0.0.0,1.0.0.255,au",
+		"2.16.0.0/13,FR",
+		"2001:db8::,2001:db8::ffff,DE",
+		"192.0.2.0,192.0.2.255,-",
+		"198.51.100.10,198.51.100.1,US",
+		"\"203.0.113.0\",\"203.0.113.255\",\"NL\",\"Europe\"",
+	}, "\n")
+
+	geo, skipped, err := nxproxy.ParseGeoIP(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if geo.Len() != 4 || skipped != 3 {
+		t.Errorf("expected 4 ranges and 3 skipped lines, got %d and %d", geo.Len(), skipped)
+	}
+
+	for addr, want := range map[string]string{
+		"1.0.0.1":            "AU",
+		"::ffff:1.0.0.255":   "AU",
+		"2.23.255.255":       "FR",
+		"2001:db8::1":        "DE",
+		"203.0.113.7":        "NL",
+		"1.0.1.0":            "",
+		"2.24.0.0":           "",
+		"192.0.2.1":          "",
+		"2001:db8::1:0":      "",
+		"0.0.0.0":            "",
+		"ffff:ffff::ffff:ff": "",
+	} {
+		if country, _ := geo.Country(netip.MustParseAddr(addr)); country != want {
+			t.Errorf("%s: expected '%s', got '%s'", addr, want, country)
+		}
+	}
+}
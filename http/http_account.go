@@ -0,0 +1,42 @@
+package http
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+const accountPath = "/account"
+
+// Tells whether a proxied request is meant for the account page of the slot rather than for a destination
+func accountRequest(req *http.Request, opts *nxproxy.SlotOptions) bool {
+
+	if opts.HttpAccountHost == "" || !req.URL.IsAbs() || req.URL.Path != accountPath {
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Host
+	}
+
+	return strings.EqualFold(host, opts.HttpAccountHost)
+}
+
+func writeAccount(wrt http.ResponseWriter, req *http.Request, peer *nxproxy.Peer) {
+
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.Header().Set("Cache-Control", "no-store")
+	wrt.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		json.NewEncoder(wrt).Encode(peer.Account())
+	}
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_Account(t *testing.T) {
+
+	svc, addr := newTestService(t)
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:           nxproxy.ProxyProtoHttp,
+		BindAddr:        "127.0.0.1:0",
+		HttpAccountHost: "proxy.local",
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peerID := uuid.New()
+
+	svc.SetPeers([]nxproxy.PeerOptions{{
+		ID:             peerID,
+		PasswordAuth:   &nxproxy.UserPassword{User: "maddsua", Password: "test"},
+		MaxConnections: 8,
+		Paused:         true,
+	}})
+
+	var fetch = func(user *url.Userinfo) *http.Response {
+
+		client := http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addr, User: user}),
+			},
+		}
+
+		resp, err := client.Get("http://proxy.local/account")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+
+		return resp
+	}
+
+	resp := fetch(nil)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("unexpected status without credentials: %d", resp.StatusCode)
+	}
+
+	resp = fetch(url.UserPassword("maddsua", "test"))
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var account nxproxy.PeerAccount
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if account.ID != peerID || account.User != "maddsua" || account.MaxConnections != 8 || !account.Paused {
+		t.Errorf("unexpected account: %+v", account)
+	}
+
+	if account.UsageSince == nil {
+		t.Errorf("usage start time not set")
+	}
+}
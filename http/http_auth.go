@@ -0,0 +1,103 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func proxyAuthScheme(req *http.Request) nxproxy.HttpAuthScheme {
+	schema, _, _ := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	return nxproxy.HttpAuthScheme(strings.ToLower(strings.TrimSpace(schema)))
+}
+
+// Resolves the peer of a request using any of the schemes enabled for the slot
+func (svc *service) authenticate(req *http.Request, opts *nxproxy.SlotOptions, clientIP string) (*nxproxy.Peer, error) {
+
+	scheme := proxyAuthScheme(req)
+
+	//	requests without credentials are let through only when the slot accepts anonymous clients
+	if scheme == "" {
+
+		if !opts.AuthAllowed(nxproxy.SlotAuthNone) {
+			return nil, ErrUnauthorized
+		}
+
+		return svc.Slot.LookupAnonymous()
+	}
+
+	if !slices.Contains(opts.HttpAuthPriority(), scheme) {
+		return nil, fmt.Errorf("auth scheme '%s' not enabled", scheme)
+	}
+
+	switch scheme {
+
+	case nxproxy.HttpAuthBasic:
+
+		creds, err := proxyRequestCredentials(req)
+		if err != nil {
+			return nil, err
+		}
+
+		return svc.Slot.LookupWithPassword(net.ParseIP(clientIP), creds.User, creds.Password)
+
+	case nxproxy.HttpAuthDigest:
+
+		creds, err := proxyRequestDigest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if creds.Realm != opts.HttpAuthRealm() {
+			return nil, fmt.Errorf("realm mismatch")
+		}
+
+		if err := svc.nonces.Check(creds.Nonce); err != nil {
+			return nil, err
+		}
+
+		peer, err := svc.Slot.LookupWithVerifier(net.ParseIP(clientIP), creds.Username, func(password string) bool {
+			return creds.Verify(req.Method, password)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		//	a valid response with a reused counter is a replay; the client is asked to get a new nonce
+		if !svc.nonces.Use(creds.Nonce, creds.NC) {
+			return nil, ErrStaleNonce
+		}
+
+		return peer, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported auth schema '%s'", scheme)
+	}
+}
+
+// Sets a Proxy-Authenticate header for each of the schemes enabled for the slot
+func (svc *service) writeChallenge(wrt http.ResponseWriter, opts *nxproxy.SlotOptions, stale bool) {
+	svc.setChallenge(wrt, opts, stale)
+	wrt.WriteHeader(http.StatusProxyAuthRequired)
+}
+
+func (svc *service) setChallenge(wrt http.ResponseWriter, opts *nxproxy.SlotOptions, stale bool) {
+
+	realm := opts.HttpAuthRealm()
+
+	for _, scheme := range opts.HttpAuthPriority() {
+		switch scheme {
+		case nxproxy.HttpAuthBasic:
+			wrt.Header().Add("Proxy-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
+		case nxproxy.HttpAuthDigest:
+			nonce := svc.nonces.Issue()
+			for _, algorithm := range digestAlgorithms {
+				wrt.Header().Add("Proxy-Authenticate", digestChallenge(realm, nonce, algorithm, stale))
+			}
+		}
+	}
+}
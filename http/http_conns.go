@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Counts connections of an http server against the per-ip client limit of a slot. Clients send any number
+// of requests over a single connection, so they're counted once it's accepted and released once it's closed
+type ClientConns struct {
+	Slot *nxproxy.Slot
+
+	//	clients that aren't counted per connection, such as trusted proxies that carry requests of many clients; optional
+	Exempt func(ip string) bool
+
+	conns sync.Map
+}
+
+// Connection of a client counted against the limit
+type ClientConn struct {
+	IP string
+
+	//	set when the client is over the limit; requests made over such connections must be refused
+	Err error
+
+	release  func()
+	hijacked atomic.Bool
+}
+
+// Releases the connection if it got hijacked, as the server no longer tracks those. Hijacked connections
+// live as long as the handler that took them over, so it's meant to be deferred by handlers
+func (conn *ClientConn) ReleaseHijacked() {
+	if conn != nil && conn.release != nil && conn.hijacked.Load() {
+		conn.release()
+	}
+}
+
+type clientConnKey struct{}
+
+// Hooks connection tracking into the server
+func (cc *ClientConns) Attach(srv *http.Server) {
+	srv.ConnContext = cc.connContext
+	srv.ConnState = cc.connState
+}
+
+func (cc *ClientConns) connContext(ctx context.Context, conn net.Conn) context.Context {
+
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	if cc.Exempt != nil && cc.Exempt(ip) {
+		return ctx
+	}
+
+	release, err := cc.Slot.AcquireClient(ip)
+	entry := ClientConn{IP: ip, Err: err, release: release}
+
+	if err == nil {
+		cc.conns.Store(conn, &entry)
+	}
+
+	return context.WithValue(ctx, clientConnKey{}, &entry)
+}
+
+func (cc *ClientConns) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateClosed:
+		if entry, has := cc.conns.LoadAndDelete(conn); has {
+			entry.(*ClientConn).release()
+		}
+	case http.StateHijacked:
+		if entry, has := cc.conns.LoadAndDelete(conn); has {
+			entry.(*ClientConn).hijacked.Store(true)
+		}
+	}
+}
+
+// Returns the connection that a request came over; nil for exempt clients
+func RequestClientConn(req *http.Request) *ClientConn {
+	entry, _ := req.Context().Value(clientConnKey{}).(*ClientConn)
+	return entry
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_ClientConnLimit(t *testing.T) {
+
+	slot, err := NewService(nxproxy.SlotOptions{
+		Proto:                nxproxy.ProxyProtoHttp,
+		BindAddr:             "127.0.0.1:0",
+		AuthMethods:          []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+		HttpPac:              &nxproxy.HttpPac{},
+		MaxClientConnections: 1,
+	}, nxproxy.SlotEnv{DNS: stubDns{}})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	svc := slot.(*service)
+	t.Cleanup(func() { svc.Close() })
+
+	addr := svc.listener.Addr().String()
+
+	var dial = func() (net.Conn, *bufio.Reader) {
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	var fetchPac = func(conn net.Conn, reader *bufio.Reader) int {
+
+		if _, err := io.WriteString(conn, "GET /proxy.pac HTTP/1.1\r\nHost: proxy.example.com:3128\r\n\r\n"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	first, firstReader := dial()
+
+	//	requests sent over a single connection count as one client connection
+	for idx := 0; idx < 3; idx++ {
+		if status := fetchPac(first, firstReader); status != http.StatusOK {
+			t.Fatalf("request %d over a kept alive connection refused: %d", idx, status)
+		}
+	}
+
+	second, secondReader := dial()
+	defer second.Close()
+
+	if status := fetchPac(second, secondReader); status != http.StatusTooManyRequests {
+		t.Errorf("connection over the limit accepted: %d", status)
+	}
+
+	first.Close()
+
+	//	the server takes a moment to notice the closed connection
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+
+		third, thirdReader := dial()
+		status := fetchPac(third, thirdReader)
+		third.Close()
+
+		if status == http.StatusOK {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("closed connection not released")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,258 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrStaleNonce = errors.New("stale nonce")
+
+// Algorithms offered in digest challenges, most preferred first
+var digestAlgorithms = []string{"SHA-256", "MD5"}
+
+const digestNonceTTL = 10 * time.Minute
+
+// Issues stateless nonces signed with a per-slot key, so that unauthenticated clients can't make it allocate anything.
+// Nonce counters are only tracked for nonces that were used in a successful auth to prevent replays
+type digestNonces struct {
+	key         []byte
+	used        map[string]uint64
+	lastCleanup time.Time
+	mtx         sync.Mutex
+}
+
+func newDigestNonces() *digestNonces {
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return &digestNonces{key: key, used: map[string]uint64{}}
+}
+
+func (dn *digestNonces) sign(issued []byte) []byte {
+	mac := hmac.New(sha256.New, dn.key)
+	mac.Write(issued)
+	return mac.Sum(nil)[:16]
+}
+
+func (dn *digestNonces) Issue() string {
+
+	issued := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(append(issued, dn.sign(issued)...))
+}
+
+// Checks that the nonce was issued by this slot and hasn't expired yet
+func (dn *digestNonces) Check(nonce string) error {
+
+	buff, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buff) != 24 {
+		return errors.New("malformed nonce")
+	}
+
+	if !hmac.Equal(buff[8:], dn.sign(buff[:8])) {
+		return errors.New("invalid nonce signature")
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(buff[:8])), 0)
+	if time.Since(issued) > digestNonceTTL {
+		return ErrStaleNonce
+	}
+
+	return nil
+}
+
+// Records the nonce counter of an authenticated request; returns false when the counter was already used
+func (dn *digestNonces) Use(nonce string, nc uint64) bool {
+
+	dn.mtx.Lock()
+	defer dn.mtx.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(dn.lastCleanup) > time.Minute {
+
+		for key := range dn.used {
+			if dn.Check(key) != nil {
+				delete(dn.used, key)
+			}
+		}
+
+		dn.lastCleanup = now
+	}
+
+	if last, has := dn.used[nonce]; has && nc <= last {
+		return false
+	}
+
+	dn.used[nonce] = nc
+
+	return true
+}
+
+type digestCredentials struct {
+	Username  string
+	Realm     string
+	Nonce     string
+	URI       string
+	Algorithm string
+	Response  string
+	CNonce    string
+	QOP       string
+	NC        uint64
+}
+
+func proxyRequestDigest(req *http.Request) (*digestCredentials, error) {
+
+	proxyAuth := req.Header.Get("Proxy-Authorization")
+	if proxyAuth == "" {
+		return nil, ErrUnauthorized
+	}
+
+	schema, value, _ := strings.Cut(proxyAuth, " ")
+	if strings.ToLower(strings.TrimSpace(schema)) != "digest" {
+		return nil, fmt.Errorf("invalid auth schema '%s'", schema)
+	}
+
+	params := parseAuthParams(value)
+
+	if strings.EqualFold(params["userhash"], "true") {
+		return nil, errors.New("userhash is not supported")
+	}
+
+	creds := digestCredentials{
+		Username:  params["username"],
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		URI:       params["uri"],
+		Algorithm: params["algorithm"],
+		Response:  strings.ToLower(params["response"]),
+		CNonce:    params["cnonce"],
+		QOP:       params["qop"],
+	}
+
+	if creds.Username == "" || creds.Nonce == "" || creds.Response == "" {
+		return nil, errors.New("incomplete digest credentials")
+	}
+
+	if creds.Algorithm == "" {
+		creds.Algorithm = "MD5"
+	}
+
+	if digestHash(creds.Algorithm) == nil {
+		return nil, fmt.Errorf("unsupported digest algorithm '%s'", creds.Algorithm)
+	}
+
+	//	the legacy rfc 2069 mode without qop is too weak to bother with
+	if creds.QOP != "auth" {
+		return nil, fmt.Errorf("unsupported qop '%s'", creds.QOP)
+	}
+
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || creds.CNonce == "" {
+		return nil, errors.New("invalid nonce count or cnonce")
+	}
+
+	creds.NC = nc
+
+	return &creds, nil
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	default:
+		return nil
+	}
+}
+
+// Checks the client response against a known password
+func (creds *digestCredentials) Verify(method, password string) bool {
+
+	newHash := digestHash(creds.Algorithm)
+
+	var digest = func(parts ...string) string {
+		hash := newHash()
+		hash.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	ha1 := digest(creds.Username, creds.Realm, password)
+	ha2 := digest(method, creds.URI)
+	want := digest(ha1, creds.Nonce, fmt.Sprintf("%08x", creds.NC), creds.CNonce, creds.QOP, ha2)
+
+	return subtle.ConstantTimeCompare([]byte(want), []byte(creds.Response)) == 1
+}
+
+func digestChallenge(realm, nonce, algorithm string, stale bool) string {
+
+	challenge := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s"`, realm, algorithm, nonce)
+	if stale {
+		challenge += ", stale=true"
+	}
+
+	return challenge
+}
+
+// Parses comma separated key=value pairs, values may be quoted strings
+func parseAuthParams(value string) map[string]string {
+
+	params := map[string]string{}
+
+	for value = strings.TrimSpace(value); value != ""; {
+
+		key, rest, found := strings.Cut(value, "=")
+		if !found {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var val strings.Builder
+
+		if strings.HasPrefix(rest, `"`) {
+
+			idx := 1
+			for ; idx < len(rest) && rest[idx] != '"'; idx++ {
+				if rest[idx] == '\\' && idx+1 < len(rest) {
+					idx++
+				}
+				val.WriteByte(rest[idx])
+			}
+
+			rest = rest[min(idx+1, len(rest)):]
+
+		} else {
+			token, _, _ := strings.Cut(rest, ",")
+			val.WriteString(strings.TrimSpace(token))
+			rest = rest[len(token):]
+		}
+
+		params[key] = val.String()
+
+		_, value, _ = strings.Cut(rest, ",")
+		value = strings.TrimSpace(value)
+	}
+
+	return params
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Examples from https://datatracker.ietf.org/doc/html/rfc7616#section-3.9.1
+func TestDigest_Verify(t *testing.T) {
+
+	header := `Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", ` +
+		`algorithm=%s, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, ` +
+		`cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth, response="%s", ` +
+		`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+
+	for _, test := range tests {
+
+		req := httptest.NewRequest(http.MethodGet, "/dir/index.html", nil)
+		req.Header.Set("Proxy-Authorization", fmt.Sprintf(header, test.algorithm, test.response))
+
+		creds, err := proxyRequestDigest(req)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", test.algorithm, err)
+		}
+
+		if !creds.Verify(req.Method, "Circle of Life") {
+			t.Errorf("%s: valid response rejected", test.algorithm)
+		}
+
+		if creds.Verify(req.Method, "Circle of Death") {
+			t.Errorf("%s: invalid password accepted", test.algorithm)
+		}
+	}
+}
+
+type stubDns struct{}
+
+func (stubDns) Resolver() *net.Resolver {
+	return net.DefaultResolver
+}
+
+func TestDigest_Authenticate(t *testing.T) {
+
+	svc := service{
+		Slot:   nxproxy.Slot{DNS: stubDns{}},
+		nonces: newDigestNonces(),
+	}
+
+	opts := nxproxy.SlotOptions{
+		Proto:           nxproxy.ProxyProtoHttp,
+		BindAddr:        "127.0.0.1:8080",
+		HttpAuthSchemes: []nxproxy.HttpAuthScheme{nxproxy.HttpAuthDigest},
+	}
+
+	if err := svc.SetOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.SetPeers([]nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "user", Password: "pass"}},
+	})
+
+	nonce := svc.nonces.Issue()
+
+	var md5hex = func(val string) string {
+		sum := md5.Sum([]byte(val))
+		return hex.EncodeToString(sum[:])
+	}
+
+	var newRequest = func(nc int, password string) *http.Request {
+
+		ha1 := md5hex("user:" + nxproxy.DefaultHttpRealm + ":" + password)
+		ha2 := md5hex("CONNECT:example.com:443")
+		response := md5hex(fmt.Sprintf("%s:%s:%08x:abcdef:auth:%s", ha1, nonce, nc, ha2))
+
+		raw := "CONNECT example.com:443 HTTP/1.1\r\n" +
+			"Host: example.com:443\r\n" +
+			fmt.Sprintf(`Proxy-Authorization: Digest username="user", realm="%s", nonce="%s", uri="example.com:443", `+
+				`cnonce="abcdef", nc=%08x, qop=auth, response="%s", algorithm=MD5`,
+				nxproxy.DefaultHttpRealm, nonce, nc, response) + "\r\n\r\n"
+
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+
+		return req
+	}
+
+	if _, err := svc.authenticate(newRequest(1, "pass"), &opts, "127.0.0.1"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if _, err := svc.authenticate(newRequest(1, "pass"), &opts, "127.0.0.1"); err != ErrStaleNonce {
+		t.Errorf("replayed counter accepted: %v", err)
+	}
+
+	if _, err := svc.authenticate(newRequest(2, "pass"), &opts, "127.0.0.1"); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+
+	if _, err := svc.authenticate(newRequest(3, "wrong"), &opts, "127.0.0.1"); err == nil {
+		t.Errorf("invalid password accepted")
+	}
+
+	//	basic is disabled for the slot
+	basic := httptest.NewRequest(http.MethodConnect, "example.com:443", nil)
+	basic.Header.Set("Proxy-Authorization", "Basic dXNlcjpwYXNz")
+	if _, err := svc.authenticate(basic, &opts, "127.0.0.1"); err == nil {
+		t.Errorf("disabled scheme accepted")
+	}
+
+	wrt := httptest.NewRecorder()
+	svc.writeChallenge(wrt, &opts, true)
+
+	challenges := wrt.Header().Values("Proxy-Authenticate")
+	if len(challenges) != len(digestAlgorithms) {
+		t.Fatalf("unexpected challenges: %v", challenges)
+	}
+
+	for _, challenge := range challenges {
+		if !strings.HasPrefix(challenge, "Digest ") || !strings.Contains(challenge, "stale=true") {
+			t.Errorf("unexpected challenge: %s", challenge)
+		}
+	}
+}
+
+func TestDigest_ParseAuthParams(t *testing.T) {
+
+	params := parseAuthParams(`username="us\"er", realm="a, b", nc=00000001 , qop=auth,response="abc"`)
+
+	want := map[string]string{
+		"username": `us"er`,
+		"realm":    "a, b",
+		"nc":       "00000001",
+		"qop":      "auth",
+		"response": "abc",
+	}
+
+	for key, val := range want {
+		if params[key] != val {
+			t.Errorf("%s: expected: %q; got: %q", key, val, params[key])
+		}
+	}
+}
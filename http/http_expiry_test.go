@@ -0,0 +1,68 @@
+package http
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_ExpiryWarning(t *testing.T) {
+
+	svc := service{Slot: nxproxy.Slot{DNS: stubDns{}}, nonces: newDigestNonces()}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:            nxproxy.ProxyProtoHttp,
+		AuthMethods:      []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+		ExpiryWarningMin: 10,
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	var serve = func() *httptest.ResponseRecorder {
+
+		//	local destinations get refused, which is enough to see the response headers
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET http://127.0.0.1/ HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n")))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+
+		req.RemoteAddr = "198.51.100.20:50000"
+
+		wrt := httptest.NewRecorder()
+		svc.ServeHTTP(wrt, req)
+
+		return wrt
+	}
+
+	peer := nxproxy.PeerOptions{ID: uuid.New()}
+
+	disableAt := time.Now().Add(time.Hour)
+	peer.DisableAt = &disableAt
+	svc.SetPeers([]nxproxy.PeerOptions{peer})
+
+	if val := serve().Header().Get(HeaderExpires); val != "" {
+		t.Errorf("expiry announced too early: %s", val)
+	}
+
+	disableAt = time.Now().Add(5 * time.Minute)
+	peer.DisableAt = &disableAt
+	svc.SetPeers([]nxproxy.PeerOptions{peer})
+
+	if val := serve().Header().Get(HeaderExpires); val != disableAt.UTC().Format(http.TimeFormat) {
+		t.Errorf("expiry not announced: '%s'", val)
+	}
+
+	disableAt = time.Now().Add(-time.Second)
+	peer.DisableAt = &disableAt
+	svc.SetPeers([]nxproxy.PeerOptions{peer})
+
+	if wrt := serve(); wrt.Code != http.StatusPaymentRequired {
+		t.Errorf("expired peer not refused, got status %d", wrt.Code)
+	}
+}
@@ -1,19 +1,31 @@
 package http
 
 import (
+	"compress/gzip"
 	"io"
 	"net/http"
+	"strings"
 )
 
+// Carries the scheduled disable time of a peer on responses to its requests, once it's close enough
+const HeaderExpires = "X-NX-Expires"
+
+// Creates an upstream request streaming the body of a client request. Content length is kept so that uploads
+// aren't turned into chunked ones, trailers of chunked ones are passed on, and 'Expect: 100-continue' is left in place: the transport holds the body back
+// until the destination agrees to take it, and reading the body is what makes the server send 100 Continue to the client
 func forwardRequest(req *http.Request) (*http.Request, error) {
 
-	fwreq, err := http.NewRequest(req.Method, req.URL.String(), req.Body)
+	fwreq, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), req.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	fwreq.ContentLength = req.ContentLength
 	fwreq.Header = req.Header.Clone()
 
+	//	filled in by the server once the body is read through, which is when the transport sends them on
+	fwreq.Trailer = req.Trailer
+
 	fwreq.Header.Set("Host", fwreq.Host)
 	fwreq.Header.Del("Connection")
 	fwreq.Header.Del("Upgrade")
@@ -21,12 +33,87 @@ func forwardRequest(req *http.Request) (*http.Request, error) {
 	return fwreq, nil
 }
 
+// Headers that tell destinations that a request came through a proxy
+var proxyRequestHeaders = []string{
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// Strips proxy headers off a forwarded request. The go http client would also send its own user agent
+// with requests that don't have one, which an empty value prevents
+func stripProxyHeaders(req *http.Request) {
+
+	for _, key := range proxyRequestHeaders {
+		req.Header.Del(key)
+	}
+
+	if _, has := req.Header["User-Agent"]; !has {
+		req.Header["User-Agent"] = []string{""}
+	}
+}
+
+// Asks for a gzip response on behalf of a client that hasn't set Accept-Encoding, under the same conditions
+// the go http transport does. Returns true when the response has to go through decompressForwarded
+func requestCompression(req *http.Request) bool {
+
+	if req.Method == http.MethodHead || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return false
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	return true
+}
+
+// Decompresses a gzip response body and drops the headers that no longer match it
+func decompressForwarded(resp *http.Response) error {
+
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = &gzipBody{Reader: reader, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return nil
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (body *gzipBody) Close() error {
+	body.Reader.Close()
+	return body.body.Close()
+}
+
+// Writes a forwarded response. Framing is left to the server: bodies of unknown length go out chunked,
+// one chunk per read from the destination, and trailers are passed on once the body is through
 func writeForwarded(resp *http.Response, wrt http.ResponseWriter) error {
 
 	headers := resp.Header.Clone()
 
 	headers.Del("TE")
 	headers.Del("Transfer-Encoding")
+	headers.Del("Trailer")
+
+	//	only ever set by the proxy itself
+	headers.Del(HeaderExpires)
 
 	for header, entries := range headers {
 		for _, val := range entries {
@@ -34,9 +121,33 @@ func writeForwarded(resp *http.Response, wrt http.ResponseWriter) error {
 		}
 	}
 
+	//	trailers have to be announced before the body for the server to send them
+	announced := map[string]bool{}
+	for key := range resp.Trailer {
+		wrt.Header().Add("Trailer", key)
+		announced[key] = true
+	}
+
 	wrt.WriteHeader(resp.StatusCode)
 
-	return streamBody(resp.Body, wrt)
+	if err := streamBody(resp.Body, wrt); err != nil {
+		return err
+	}
+
+	//	trailers that the destination didn't announce are only known at this point
+	for key, entries := range resp.Trailer {
+
+		name := key
+		if !announced[key] {
+			name = http.TrailerPrefix + key
+		}
+
+		for _, val := range entries {
+			wrt.Header().Add(name, val)
+		}
+	}
+
+	return nil
 }
 
 func streamBody(body io.Reader, wrt http.ResponseWriter) error {
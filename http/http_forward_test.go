@@ -0,0 +1,245 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForward_Compression(t *testing.T) {
+
+	var payload bytes.Buffer
+	writer := gzip.NewWriter(&payload)
+	writer.Write([]byte("hello world"))
+	writer.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		if req.Header.Get("Accept-Encoding") != "gzip" {
+			wrt.Write([]byte("hello world"))
+			return
+		}
+
+		wrt.Header().Set("Content-Encoding", "gzip")
+		wrt.Write(payload.Bytes())
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	var fetch = func(req *http.Request, decompress bool) (*http.Response, []byte) {
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+
+		defer resp.Body.Close()
+
+		if decompress {
+			if err := decompressForwarded(resp); err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		return resp, body
+	}
+
+	//	clients without Accept-Encoding get decompressed responses
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if !requestCompression(req) {
+		t.Fatalf("compression not requested")
+	}
+
+	if resp, body := fetch(req, true); string(body) != "hello world" || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("unexpected response: %q %v", body, resp.Header)
+	}
+
+	//	clients asking for an encoding get the response as it is
+	req, _ = http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if requestCompression(req) {
+		t.Fatalf("compression requested over the client's own Accept-Encoding")
+	}
+
+	if resp, body := fetch(req, false); !bytes.Equal(body, payload.Bytes()) || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("response altered: %q %v", body, resp.Header)
+	}
+
+	//	range requests would get mismatched offsets
+	req, _ = http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Range", "bytes=0-4")
+	if requestCompression(req) {
+		t.Errorf("compression requested for a range request")
+	}
+}
+
+func TestForward_ExpectContinue(t *testing.T) {
+
+	const size = 8 << 20
+
+	origin := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		if req.ContentLength != size || len(req.TransferEncoding) > 0 {
+			t.Errorf("upload length not kept: %d %v", req.ContentLength, req.TransferEncoding)
+		}
+
+		//	uploads over the limit are refused before the client sends them
+		if req.URL.Query().Has("refuse") {
+			wrt.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if read, _ := io.Copy(io.Discard, req.Body); read != size {
+			t.Errorf("unexpected body size: %d", read)
+		}
+	}))
+	defer origin.Close()
+
+	upstream := http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+
+	proxy := forwardingProxy(t, &upstream)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	//	a client that isn't sent 100 Continue would only start uploading once its own timeout passes
+	client := http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyURL(proxyURL),
+		ExpectContinueTimeout: time.Minute,
+	}}
+
+	var upload = func(path string) (int, *countingReader) {
+
+		body := &countingReader{Reader: bytes.NewReader(make([]byte, size))}
+
+		req, _ := http.NewRequest("POST", origin.URL+path, body)
+		req.ContentLength = size
+		req.Header.Set("Expect", "100-continue")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode, body
+	}
+
+	if status, body := upload("/"); status != http.StatusOK || body.read != size {
+		t.Errorf("upload failed: %d, %d bytes sent", status, body.read)
+	}
+
+	if status, body := upload("/?refuse"); status != http.StatusRequestEntityTooLarge || body.read != 0 {
+		t.Errorf("refused upload sent anyway: %d, %d bytes sent", status, body.read)
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (reader *countingReader) Read(buff []byte) (int, error) {
+	n, err := reader.Reader.Read(buff)
+	reader.read += n
+	return n, err
+}
+
+// Forwards requests the same way the service does, minus the auth and destination checks
+func forwardingProxy(t *testing.T, upstream *http.Client) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		fwreq, err := forwardRequest(req)
+		if err != nil {
+			t.Errorf("forward request: %v", err)
+			return
+		}
+
+		resp, err := upstream.Do(fwreq)
+		if err != nil {
+			t.Errorf("forward: %v", err)
+			wrt.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		defer resp.Body.Close()
+
+		if err := writeForwarded(resp, wrt); err != nil {
+			t.Errorf("write forwarded: %v", err)
+		}
+	}))
+}
+
+func TestForward_Trailers(t *testing.T) {
+
+	origin := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		io.Copy(io.Discard, req.Body)
+
+		if val := req.Trailer.Get("X-Checksum"); val != "abc" {
+			t.Errorf("request trailer not forwarded: '%s'", val)
+		}
+
+		wrt.Header().Set("Trailer", "Grpc-Status")
+		wrt.WriteHeader(http.StatusOK)
+
+		wrt.Write([]byte("first"))
+		wrt.(http.Flusher).Flush()
+		wrt.Write([]byte("second"))
+
+		wrt.Header().Set("Grpc-Status", "0")
+		wrt.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}))
+	defer origin.Close()
+
+	proxy := forwardingProxy(t, &http.Client{})
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	req, _ := http.NewRequest("POST", origin.URL, io.NopCloser(strings.NewReader("payload")))
+	req.Trailer = http.Header{"X-Checksum": nil}
+	req.Trailer.Set("X-Checksum", "abc")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("response not chunked: %v", resp.TransferEncoding)
+	}
+
+	if body, _ := io.ReadAll(resp.Body); string(body) != "firstsecond" {
+		t.Errorf("unexpected body: '%s'", body)
+	}
+
+	if val := resp.Trailer.Get("Grpc-Status"); val != "0" {
+		t.Errorf("announced trailer missing: '%s'", val)
+	}
+
+	if val := resp.Trailer.Get("Grpc-Message"); val != "done" {
+		t.Errorf("unannounced trailer missing: '%s'", val)
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func newTestService(t *testing.T) (*service, string) {
+
+	slot, err := NewService(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		BindAddr:    "127.0.0.1:0",
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+	}, nxproxy.SlotEnv{DNS: stubDns{}})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	svc := slot.(*service)
+	t.Cleanup(func() { svc.Close() })
+
+	return svc, svc.listener.Addr().String()
+}
+
+func TestService_HeaderSizeLimit(t *testing.T) {
+
+	_, addr := newTestService(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	//	the server may stop reading before the whole head is written
+	go io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nX-Padding: "+strings.Repeat("a", 2*maxHeaderBytes)+"\r\n\r\n")
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
+func TestService_HandshakeTimeout(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("waits for the handshake timeout")
+	}
+
+	_, addr := newTestService(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	//	a client that never finishes its request head
+	if _, err := io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(2 * handshakeTimeout))
+	started := time.Now()
+
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("connection not closed by the server: %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > handshakeTimeout+time.Second {
+		t.Errorf("connection closed too late: %v", elapsed)
+	}
+}
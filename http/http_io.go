@@ -3,6 +3,7 @@ package http
 import (
 	"context"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"time"
@@ -17,9 +18,13 @@ type PeeredConn struct {
 
 func (conn *PeeredConn) Read(buff []byte) (int, error) {
 
+	if conn.CapReached() {
+		return 0, nxproxy.ErrSessionCapReached
+	}
+
 	if bandwidth, limited := conn.BandwidthRx(); limited {
 
-		chunkSize := min(bandwidth, len(buff))
+		chunkSize := min(nxproxy.ChunkSizeTCIO(bandwidth), len(buff))
 		chunk := make([]byte, chunkSize)
 		started := time.Now()
 
@@ -29,10 +34,11 @@ func (conn *PeeredConn) Read(buff []byte) (int, error) {
 		}
 
 		conn.AccountRx(read)
+		conn.WaitEgress(read)
 
 		copy(buff, chunk[:read])
 
-		nxproxy.WaitTCIO(bandwidth, read, started)
+		nxproxy.WaitTCIO(conn.Context(), bandwidth, read, started)
 
 		return read, err
 	}
@@ -40,6 +46,7 @@ func (conn *PeeredConn) Read(buff []byte) (int, error) {
 	bytesRead, err := conn.Conn.Read(buff)
 
 	conn.AccountRx(bytesRead)
+	conn.WaitEgress(bytesRead)
 
 	return bytesRead, err
 }
@@ -50,6 +57,10 @@ func (conn *PeeredConn) Write(buff []byte) (int, error) {
 		return 0, nil
 	}
 
+	if conn.CapReached() {
+		return 0, nxproxy.ErrSessionCapReached
+	}
+
 	if bandwidth, limited := conn.BandwidthTx(); limited {
 
 		var total int
@@ -57,23 +68,27 @@ func (conn *PeeredConn) Write(buff []byte) (int, error) {
 
 		for total < buffSize {
 
-			chunkSize := min(bandwidth, buffSize-total)
+			chunkSize := min(nxproxy.ChunkSizeTCIO(bandwidth), buffSize-total)
 			chunk := buff[total : total+chunkSize]
 
 			started := time.Now()
 			written, err := conn.Conn.Write(chunk)
 
 			conn.AccountTx(written)
+			conn.WaitEgress(written)
 
 			total += written
 
 			if err != nil {
 				return total, err
 			} else if written < chunkSize {
+				conn.Logger().Warn("HTTP: Forward: Short write",
+					slog.Int("chunk", chunkSize),
+					slog.Int("written", written))
 				return total, io.ErrShortWrite
 			}
 
-			nxproxy.WaitTCIO(bandwidth, written, started)
+			nxproxy.WaitTCIO(conn.Context(), bandwidth, written, started)
 		}
 
 		return total, nil
@@ -82,6 +97,7 @@ func (conn *PeeredConn) Write(buff []byte) (int, error) {
 	written, err := conn.Conn.Write(buff)
 
 	conn.AccountTx(written)
+	conn.WaitEgress(written)
 
 	return written, err
 }
@@ -93,6 +109,9 @@ func (conn *PeeredConn) Close() error {
 
 type PeerDialer struct {
 	*nxproxy.Peer
+
+	//	optional; returns bandwidth weight of a connection to the address
+	Weight func(addr string) uint32
 }
 
 func (peer *PeerDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
@@ -102,8 +121,16 @@ func (peer *PeerDialer) DialContext(ctx context.Context, network, address string
 		return nil, err
 	}
 
-	baseConn, err := peer.Dialer.DialContext(ctx, network, address)
+	connCtl.SetLogAttrs(slog.String("host", address))
+
+	if peer.Weight != nil {
+		connCtl.SetWeight(peer.Weight(address))
+	}
+
+	baseConn, err := peer.Peer.DialContext(ctx, network, address)
+	peer.ReportDial(err)
 	if err != nil {
+		connCtl.Close()
 		return nil, err
 	}
 
@@ -113,21 +140,35 @@ func (peer *PeerDialer) DialContext(ctx context.Context, network, address string
 	}, nil
 }
 
-func NewPeerClient(peer *nxproxy.Peer) *http.Client {
+// Creates an http client for forwarded requests of a peer. Transparent compression is left to the caller
+// as it depends on peer options that may change while the client is in use. Redirects are only followed up to the peer's limit,
+// and only to destinations that allowDest accepts; the last redirect response is returned otherwise
+func NewPeerClient(peer *nxproxy.Peer, weight func(addr string) uint32, allowDest func(host string) bool) *http.Client {
 
-	dialer := PeerDialer{Peer: peer}
+	dialer := PeerDialer{Peer: peer, Weight: weight}
 
 	return &http.Client{
 		Transport: &http.Transport{
 			DialContext:           dialer.DialContext,
 			ForceAttemptHTTP2:     false,
+			DisableCompression:    true,
 			MaxIdleConns:          10,
 			IdleConnTimeout:       30 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 5 * time.Second,
 		},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+
+			if len(via) > int(peer.MaxRedirects) {
+				return http.ErrUseLastResponse
+			}
+
+			//	clients get to follow redirects to denied destinations themselves, running into the regular checks
+			if allowDest != nil && !allowDest(req.URL.Host) {
+				return http.ErrUseLastResponse
+			}
+
+			return nil
 		},
 	}
 }
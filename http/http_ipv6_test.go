@@ -0,0 +1,80 @@
+package http
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_LocalIPv6Denied(t *testing.T) {
+
+	svc := service{Slot: nxproxy.Slot{DNS: stubDns{}}, nonces: newDigestNonces()}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	svc.SetPeers([]nxproxy.PeerOptions{{ID: uuid.New()}})
+
+	requests := map[string]string{
+		"connect ipv6 loopback":      "CONNECT [::1]:443 HTTP/1.1\r\nHost: [::1]:443\r\n\r\n",
+		"connect ipv4-mapped":        "CONNECT [::ffff:127.0.0.1]:443 HTTP/1.1\r\nHost: [::ffff:127.0.0.1]:443\r\n\r\n",
+		"connect link-local":         "CONNECT [fe80::1]:22 HTTP/1.1\r\nHost: [fe80::1]:22\r\n\r\n",
+		"forward without port":       "GET http://[::1]/ HTTP/1.1\r\nHost: [::1]\r\n\r\n",
+		"forward with port":          "GET http://[::1]:8080/ HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n",
+		"forward unique local range": "GET http://[fd12::1]/ HTTP/1.1\r\nHost: [fd12::1]\r\n\r\n",
+	}
+
+	for name, raw := range requests {
+
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("%s: parse: %v", name, err)
+		}
+
+		req.RemoteAddr = "[2001:db8::7]:50000"
+
+		wrt := httptest.NewRecorder()
+		svc.ServeHTTP(wrt, req)
+
+		if wrt.Code != http.StatusBadGateway {
+			t.Errorf("%s: expected the destination to be denied, got status %d", name, wrt.Code)
+		}
+	}
+
+	if stats := svc.TakeStats(); stats.DeniedDest != uint64(len(requests)) {
+		t.Errorf("expected %d denied dests, got %d", len(requests), stats.DeniedDest)
+	}
+}
+
+func TestProxyRequestHost_IPv6(t *testing.T) {
+
+	raw := "CONNECT [2001:db8::1]:443 HTTP/1.1\r\nHost: [2001:db8::1]:443\r\n\r\n"
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	host := proxyRequestHost(req)
+	if host != "[2001:db8::1]:443" {
+		t.Fatalf("unexpected host: %s", host)
+	}
+
+	if ip, port, err := net.SplitHostPort(host); err != nil || ip != "2001:db8::1" || port != "443" {
+		t.Errorf("host doesn't split into an ipv6 address and a port: %s %s %v", ip, port, err)
+	}
+
+	if nxproxy.IsLocalAddress(host) {
+		t.Errorf("global ipv6 destination treated as local")
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Tells whether a request is likely to be a browser loading a page, as opposed to an API client or a tunnel
+func browserNavigation(req *http.Request) bool {
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// Responds to a browser that came without credentials with the landing page of the slot.
+// Browsers that open the proxy address directly get the page itself or a redirect, while proxied requests
+// still get an auth challenge that carries the page, which browsers show when the credentials prompt is dismissed
+func (svc *service) writeLanding(wrt http.ResponseWriter, req *http.Request, opts *nxproxy.SlotOptions) {
+
+	landing := opts.HttpLanding
+
+	wrt.Header().Set("Cache-Control", "no-store")
+
+	direct := !req.URL.IsAbs()
+
+	if direct && landing.RedirectURL != "" {
+		http.Redirect(wrt, req, landing.RedirectURL, http.StatusFound)
+		return
+	}
+
+	page := landing.Html
+	if page == "" {
+		//	redirects of proxied requests would be made through the proxy again, so a link is shown instead
+		page = fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Sign in required</title></head>`+
+			`<body><p>Sign in to continue: <a href="%s">%s</a></p></body></html>`,
+			html.EscapeString(landing.RedirectURL), html.EscapeString(landing.RedirectURL))
+	}
+
+	wrt.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if direct {
+		wrt.WriteHeader(http.StatusOK)
+	} else {
+		svc.setChallenge(wrt, opts, false)
+		wrt.WriteHeader(http.StatusProxyAuthRequired)
+	}
+
+	if req.Method != http.MethodHead {
+		io.WriteString(wrt, page)
+	}
+}
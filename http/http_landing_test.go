@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_Landing(t *testing.T) {
+
+	slot, err := NewService(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		BindAddr:    "127.0.0.1:0",
+		HttpLanding: &nxproxy.HttpLanding{Html: "<h1>welcome</h1>"},
+	}, nxproxy.SlotEnv{DNS: stubDns{}})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	svc := slot.(*service)
+	defer svc.Close()
+
+	addr := svc.listener.Addr().String()
+
+	var roundTrip = func(head string) (*http.Response, string) {
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := io.WriteString(conn, head); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := roundTrip("GET / HTTP/1.1\r\nHost: " + addr + "\r\nAccept: text/html\r\n\r\n")
+	if resp.StatusCode != http.StatusOK || body != "<h1>welcome</h1>" {
+		t.Errorf("unexpected direct response: %d '%s'", resp.StatusCode, body)
+	}
+
+	resp, body = roundTrip("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nAccept: text/html\r\n\r\n")
+	if resp.StatusCode != http.StatusProxyAuthRequired || body != "<h1>welcome</h1>" {
+		t.Errorf("unexpected proxied response: %d '%s'", resp.StatusCode, body)
+	}
+
+	if resp.Header.Get("Proxy-Authenticate") == "" {
+		t.Errorf("challenge missing")
+	}
+
+	resp, body = roundTrip("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n")
+	if resp.StatusCode != http.StatusProxyAuthRequired || body != "" {
+		t.Errorf("unexpected non-browser response: %d '%s'", resp.StatusCode, body)
+	}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		BindAddr:    "127.0.0.1:0",
+		HttpLanding: &nxproxy.HttpLanding{RedirectURL: "https://example.com/signup?a=1&b=2"},
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	resp, _ = roundTrip("GET / HTTP/1.1\r\nHost: " + addr + "\r\nAccept: text/html\r\n\r\n")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://example.com/signup?a=1&b=2" {
+		t.Errorf("unexpected direct redirect: %d '%s'", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, body = roundTrip("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nAccept: text/html\r\n\r\n")
+	if resp.StatusCode != http.StatusProxyAuthRequired || !strings.Contains(body, `href="https://example.com/signup?a=1&amp;b=2"`) {
+		t.Errorf("unexpected proxied redirect: %d '%s'", resp.StatusCode, body)
+	}
+}
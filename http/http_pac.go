@@ -0,0 +1,68 @@
+package http
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Tells whether a request asks for the pac file of the slot, rather than for something to be proxied
+func pacRequest(req *http.Request, opts *nxproxy.SlotOptions) bool {
+
+	if opts.HttpPac == nil || req.URL.IsAbs() {
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return req.URL.Path == opts.HttpPac.PacPath()
+}
+
+// Returns the address a client has reached the slot at. The request host is preferred, as the listener
+// may be bound to a wildcard address; the local one is used when the host doesn't look like a valid address
+func pacSlotAddr(req *http.Request) string {
+
+	localAddr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if localAddr == nil {
+		return req.Host
+	}
+
+	_, localPort, _ := net.SplitHostPort(localAddr.String())
+
+	//	the address ends up in a script, so anything unusual is ignored
+	if req.Host == "" || strings.Trim(req.Host, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-:[]") != "" {
+		return localAddr.String()
+	}
+
+	if host, port, err := net.SplitHostPort(req.Host); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+
+	return net.JoinHostPort(strings.Trim(req.Host, "[]"), localPort)
+}
+
+func (svc *service) writePac(wrt http.ResponseWriter, req *http.Request, opts *nxproxy.SlotOptions, clientIP string) {
+
+	content, err := opts.HttpPac.Render(pacSlotAddr(req), opts.Proto == nxproxy.ProxyProtoHttps)
+	if err != nil {
+		slog.Warn("HTTP: Render pac file",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", opts.BindAddr),
+			slog.String("err", err.Error()))
+		wrt.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	wrt.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	wrt.Header().Set("Cache-Control", "no-cache")
+	wrt.WriteHeader(http.StatusOK)
+
+	if req.Method != http.MethodHead {
+		wrt.Write(content)
+	}
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_Pac(t *testing.T) {
+
+	svc, addr := newTestService(t)
+
+	_, port, _ := net.SplitHostPort(addr)
+
+	var fetch = func(path string, host string) (*http.Response, string) {
+
+		req, err := http.NewRequest("GET", "http://"+addr+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+
+		req.Host = host
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, _ := fetch("/proxy.pac", "proxy.example.com:3128"); resp.StatusCode == http.StatusOK {
+		t.Errorf("pac served while not enabled")
+	}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		BindAddr:    "127.0.0.1:0",
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+		HttpPac:     &nxproxy.HttpPac{},
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	resp, body := fetch("/proxy.pac", "proxy.example.com:3128")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"PROXY proxy.example.com:3128"`) {
+		t.Errorf("unexpected pac: %d '%s'", resp.StatusCode, body)
+	}
+
+	if val := resp.Header.Get("Content-Type"); val != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("unexpected content type: '%s'", val)
+	}
+
+	if _, body := fetch("/proxy.pac", "proxy.example.com"); !strings.Contains(body, `"PROXY proxy.example.com:`+port+`"`) {
+		t.Errorf("local port not used: '%s'", body)
+	}
+
+	if _, body := fetch("/proxy.pac", `evil";alert(1);"`); !strings.Contains(body, `"PROXY `+addr+`"`) {
+		t.Errorf("local address not used: '%s'", body)
+	}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttp,
+		BindAddr:    "127.0.0.1:0",
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+		HttpPac: &nxproxy.HttpPac{
+			Path:     "/wpad.dat",
+			Template: `function FindProxyForURL(url, host) { return "PROXY {{.Host}}:{{.Port}}; DIRECT"; }`,
+		},
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	if _, body := fetch("/wpad.dat", "proxy.example.com:3128"); !strings.Contains(body, `"PROXY proxy.example.com:3128; DIRECT"`) {
+		t.Errorf("unexpected templated pac: '%s'", body)
+	}
+}
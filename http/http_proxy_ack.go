@@ -34,3 +34,13 @@ func writeAck(writer *bufio.Writer, headers http.Header) error {
 
 	return nil
 }
+
+// Writes a CONNECT ack that doesn't tell anything about the proxy
+func writeBareAck(writer *bufio.Writer) error {
+
+	if _, err := writer.WriteString("HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
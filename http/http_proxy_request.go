@@ -4,7 +4,9 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 
 	nxproxy "github.com/maddsua/nx-proxy"
@@ -30,8 +32,8 @@ func proxyRequestCredentials(req *http.Request) (*nxproxy.UserPassword, error) {
 	}
 
 	username, password, _ := strings.Cut(string(userauth), ":")
-	if username == "" {
-		return nil, errors.New("username is empty")
+	if _, err := nxproxy.NormalizeUsername(username); err != nil {
+		return nil, err
 	}
 
 	return &nxproxy.UserPassword{
@@ -51,3 +53,46 @@ func proxyRequestHost(req *http.Request) string {
 
 	return req.Host
 }
+
+// Returns the request's client IP. Forwarding headers are only taken into account
+// when the request comes from a trusted proxy, in which case the rightmost untrusted X-Forwarded-For entry is used
+func requestClientIP(req *http.Request, trusted []netip.Prefix) string {
+
+	remoteIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+
+	if len(trusted) == 0 {
+		return remoteIP
+	}
+
+	if addr, err := netip.ParseAddr(remoteIP); err != nil || !nxproxy.PrefixListContains(trusted, addr) {
+		return remoteIP
+	}
+
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+
+		var hops []netip.Addr
+		for _, line := range xff {
+			for _, entry := range strings.Split(line, ",") {
+				if addr, err := netip.ParseAddr(strings.TrimSpace(entry)); err == nil {
+					hops = append(hops, addr)
+				}
+			}
+		}
+
+		for idx := len(hops) - 1; idx >= 0; idx-- {
+			if !nxproxy.PrefixListContains(trusted, hops[idx]) {
+				return hops[idx].Unmap().String()
+			}
+		}
+
+		if len(hops) > 0 {
+			return hops[0].Unmap().String()
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+
+	return remoteIP
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeerClient_Redirects(t *testing.T) {
+
+	//	every hop redirects to the next one until the last one answers with 200
+	srv := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		hop, _ := strconv.Atoi(req.URL.Query().Get("hop"))
+		if hop < 2 {
+			http.Redirect(wrt, req, "/?hop="+strconv.Itoa(hop+1), http.StatusFound)
+			return
+		}
+
+		wrt.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "127.0.0.1:8080", AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone}}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	var fetch = func(maxRedirects uint, allowDest func(host string) bool) int {
+
+		slot.SetPeers([]nxproxy.PeerOptions{{ID: uuid.Nil, MaxRedirects: maxRedirects}})
+
+		peer, err := slot.LookupAnonymous()
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+
+		client := NewPeerClient(peer, nil, allowDest)
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(srv.URL + "/?hop=0")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := fetch(0, nil); status != http.StatusFound {
+		t.Errorf("redirect followed by default: %d", status)
+	}
+
+	if status := fetch(1, nil); status != http.StatusFound {
+		t.Errorf("redirect limit exceeded: %d", status)
+	}
+
+	if status := fetch(2, nil); status != http.StatusOK {
+		t.Errorf("redirects not followed: %d", status)
+	}
+
+	if status := fetch(2, func(host string) bool { return false }); status != http.StatusFound {
+		t.Errorf("redirect to a denied destination followed: %d", status)
+	}
+}
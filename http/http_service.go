@@ -1,37 +1,69 @@
 package http
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"time"
 
 	nxproxy "github.com/maddsua/nx-proxy"
 )
 
-func NewService(opts nxproxy.SlotOptions, dns nxproxy.DnsProvider) (nxproxy.SlotService, error) {
+// Time a client has to send request headers, and to take the ack of a CONNECT request, before its connection gets closed
+const handshakeTimeout = 5 * time.Second
+
+// Largest request head accepted from clients; proxy requests carry little more than credentials and a host
+const maxHeaderBytes = 32 << 10
+
+func NewService(opts nxproxy.SlotOptions, env nxproxy.SlotEnv) (nxproxy.SlotService, error) {
 
 	svc := service{
 		Slot: nxproxy.Slot{
-			SlotOptions: opts,
-			Rl: &nxproxy.RateLimiter{
-				RateLimiterOptions: nxproxy.DefaultRatelimiter,
-			},
-			DNS: dns,
+			Rl:           env.NewAuthRateLimiter(),
+			DNS:          env.DNS,
+			UsageSamples: env.UsageSamples,
+			Egress:       env.Egress,
+			KernelPacing: env.KernelPacing,
+			BridgeLinger: env.BridgeLinger,
+			Blocklist:    env.Blocklist,
+			GeoIP:        env.GeoIP,
+			AuthLog:      env.AuthLog,
+			ListenFunc:   env.Listen,
+			DialFunc:     env.Dial,
 		},
+		nonces: newDigestNonces(),
+	}
+
+	if err := svc.SetOptions(opts); err != nil {
+		return nil, err
 	}
 
-	addr, proto, _ := nxproxy.SplitAddrNet(opts.BindAddr)
+	addr, _, _ := nxproxy.SplitAddrNet(opts.BindAddr)
 
-	listener, err := net.Listen(proto, addr)
+	listener, err := svc.Listen()
 	if err != nil {
 		return nil, err
 	}
 
 	svc.srv.Addr = addr
 	svc.srv.Handler = http.HandlerFunc(svc.ServeHTTP)
+	svc.srv.ReadHeaderTimeout = handshakeTimeout
+	svc.srv.MaxHeaderBytes = maxHeaderBytes
+	svc.listener = listener
+
+	svc.conns = ClientConns{Slot: &svc.Slot, Exempt: svc.trustedProxy}
+	svc.conns.Attach(&svc.srv)
+
+	//	the server performs handshakes itself, bounded by the header timeout
+	if opts.Proto == nxproxy.ProxyProtoHttps {
+		svc.listener = tls.NewListener(listener, svc.TlsConfig())
+	}
 
-	go svc.srv.Serve(listener)
+	go svc.srv.Serve(svc.listener)
 
 	return &svc, nil
 }
@@ -39,107 +71,218 @@ func NewService(opts nxproxy.SlotOptions, dns nxproxy.DnsProvider) (nxproxy.Slot
 type service struct {
 	nxproxy.Slot
 
-	srv http.Server
+	srv      http.Server
+	listener net.Listener
+	nonces   *digestNonces
+	conns    ClientConns
 }
 
-func (svc *service) SetOptions(opts nxproxy.SlotOptions) error {
+// Checks whether a client is a trusted proxy. Those carry requests of many clients over each connection,
+// so their clients are counted per request instead
+func (svc *service) trustedProxy(ip string) bool {
 
-	if !svc.SlotOptions.Compatible(&opts) {
-		return nxproxy.ErrSlotOptionsIncompatible
-	}
+	opts := svc.Options()
 
-	svc.SlotOptions = opts
+	//	options are validated when set, so this can't fail
+	trusted, _ := nxproxy.ParsePrefixList(opts.TrustedProxies)
 
-	return nil
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && nxproxy.PrefixListContains(trusted, addr)
 }
 
 func (svc *service) Close() error {
+
 	err := svc.srv.Close()
+
+	//	the server only tracks the listener once Serve gets to run, so it's closed here
+	//	as well to make sure that the address is released by the time Close returns
+	if lnErr := svc.listener.Close(); err == nil && lnErr != nil && !errors.Is(lnErr, net.ErrClosed) {
+		err = lnErr
+	}
+
 	svc.Slot.ClosePeerConnections()
+
 	return err
 }
 
 func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
-	clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
-	host := proxyRequestHost(req)
+	opts := svc.Options()
+	proxyAddr := opts.BindAddr
 
-	wrt.Header().Set("Via", "nx-proxy")
-	wrt.Header().Set("X-Forwarded", fmt.Sprintf("to=%s", host))
+	//	options are validated when set, so this can't fail
+	trustedProxies, _ := nxproxy.ParsePrefixList(opts.TrustedProxies)
 
-	creds, err := proxyRequestCredentials(req)
-	if err != nil {
+	clientIP := requestClientIP(req, trustedProxies)
 
-		slog.Debug("HTTP: Request auth invalid",
+	svc.Counters.Accepted.Add(1)
+
+	clientConn := RequestClientConn(req)
+
+	var acquireClient = func() (func(), error) {
+
+		if clientConn != nil {
+			return func() {}, clientConn.Err
+		}
+
+		return svc.AcquireClient(clientIP)
+	}
+
+	releaseClient, err := acquireClient()
+	if err != nil {
+		slog.Debug("HTTP: Client connection limit reached",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.srv.Addr),
-			slog.String("err", err.Error()))
+			slog.String("proxy_addr", proxyAddr))
+		svc.Tarpit(req.Context())
+		wrt.Header().Set("Connection", "close")
+		wrt.Header().Set("Proxy-Connection", "Close")
+		wrt.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
 
-		wrt.Header().Set("Proxy-Authenticate", "Basic")
-		wrt.WriteHeader(http.StatusProxyAuthRequired)
+	defer releaseClient()
+
+	//	pac files are fetched by browsers before they get to know the proxy, so they're served without auth
+	if pacRequest(req, &opts) {
+		svc.writePac(wrt, req, &opts, clientIP)
 		return
 	}
 
-	peer, err := svc.Slot.LookupWithPassword(net.ParseIP(clientIP), creds.User, creds.Password)
-	if err != nil {
+	host := proxyRequestHost(req)
 
-		wrt.Header().Set("Proxy-Connection", "Close")
+	if !opts.Stealth {
+		wrt.Header().Set("Via", "nx-proxy")
+		wrt.Header().Set("X-Forwarded", fmt.Sprintf("to=%s", host))
+	}
+
+	peer, err := svc.authenticate(req, &opts, clientIP)
+	if err != nil {
 
 		switch err := err.(type) {
 
 		case *nxproxy.RateLimitError:
+			svc.AuthFailed(clientIP, "", nxproxy.AuthFailRateLimit)
+			svc.Tarpit(req.Context())
+			wrt.Header().Set("Proxy-Connection", "Close")
 			wrt.Header().Set("Retry-After", err.Expires.String())
 			wrt.WriteHeader(http.StatusTooManyRequests)
 
 		case *nxproxy.CredentialsError:
+			svc.AuthFailed(clientIP, err.User(), nxproxy.AuthFailCredentials)
+			wrt.Header().Set("Proxy-Connection", "Close")
 			slog.Debug("HTTP: Invalid credentials",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("err", err.Error()))
-			wrt.WriteHeader(http.StatusProxyAuthRequired)
+			svc.writeChallenge(wrt, &opts, false)
 
 		default:
-			slog.Debug("HTTP: Password auth rejected",
+
+			//	missing credentials and stale nonces are a regular part of the challenge flow
+			if err != ErrUnauthorized && err != ErrStaleNonce {
+				svc.AuthFailed(clientIP, "", nxproxy.AuthFailMalformed)
+			}
+
+			slog.Debug("HTTP: Request auth invalid",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("err", err.Error()))
-			wrt.WriteHeader(http.StatusProxyAuthRequired)
+
+			if err == ErrUnauthorized && opts.HttpLanding != nil && browserNavigation(req) {
+				svc.writeLanding(wrt, req, &opts)
+				return
+			}
+
+			svc.writeChallenge(wrt, &opts, err == ErrStaleNonce)
 		}
 
 		return
 	}
 
-	if peer.Disabled {
-		slog.Debug("HTTP: Request cancelled; Peer disabled",
+	svc.Counters.Authenticated.Add(1)
+
+	//	answered even for disabled peers, so that users can tell why they've been cut off
+	if accountRequest(req, &opts) {
+		writeAccount(wrt, req, peer)
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		peer.RecordRequest(nxproxy.RequestHttpConnect, host)
+	} else {
+		peer.RecordRequest(nxproxy.RequestHttpForward, host)
+	}
+
+	if !peer.AcceptsSessions() {
+		slog.Debug("HTTP: Request cancelled; Peer disabled or paused",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
-			slog.String("host", host))
+			slog.String("host", host),
+			slog.Bool("paused", peer.Paused))
 		wrt.WriteHeader(http.StatusPaymentRequired)
 		return
 	}
 
+	//	lets client software warn users before their sessions get dropped
+	if disableAt, soon := peer.DisableWithin(opts.ExpiryWarning(), time.Now()); soon {
+		wrt.Header().Set(HeaderExpires, disableAt.UTC().Format(http.TimeFormat))
+	}
+
 	if nxproxy.IsLocalAddress(host) {
+		svc.DenyDest(peer, clientIP, host, nxproxy.DenyLocalAddr)
 		slog.Warn("HTTP: Dest addr not allowed",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("host", host))
 		wrt.Header().Set("Proxy-Connection", "Close")
 		wrt.WriteHeader(http.StatusBadGateway)
 		return
 	}
 
+	if opts.DomainBlocked(host) {
+		svc.DenyDest(peer, clientIP, host, nxproxy.DenyBlockedDomain)
+		slog.Warn("HTTP: Dest domain blocked",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", host))
+		wrt.Header().Set("Proxy-Connection", "Close")
+		wrt.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if rule, blocked := svc.Blocklist.Match(host); blocked {
+		svc.DenyDest(peer, clientIP, host, nxproxy.DenyBlocklist)
+		slog.Warn("HTTP: Dest blocklisted",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", host),
+			slog.String("rule", rule))
+		wrt.Header().Set("Proxy-Connection", "Close")
+		wrt.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	if req.Method != http.MethodConnect {
 
 		if peer.HttpClient == nil {
-			peer.HttpClient = NewPeerClient(peer)
+			peer.HttpClient = NewPeerClient(peer, func(addr string) uint32 {
+				opts := svc.Options()
+				return opts.ConnectionWeights.Weight(nxproxy.ConnectionForward, addr)
+			}, func(host string) bool {
+				opts := svc.Options()
+				_, blocked := svc.Blocklist.Match(host)
+				return !nxproxy.IsLocalAddress(host) && !opts.DomainBlocked(host) && !blocked
+			})
 		}
 
 		fwreq, err := forwardRequest(req)
 		if err != nil {
 			slog.Debug("HTTP: Forward: Unable to create forward request",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("peer", peer.DisplayName()),
 				slog.String("host", host),
 				slog.String("err", err.Error()))
@@ -147,11 +290,27 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if opts.Stealth {
+			stripProxyHeaders(fwreq)
+		}
+
+		decompress := peer.HttpCompression != nxproxy.HttpCompressionPassthrough && requestCompression(fwreq)
+
 		fwresp, err := peer.HttpClient.Do(fwreq)
-		if err != nil {
+		if errors.Is(err, nxproxy.ErrDestBlocklisted) {
+			svc.DenyDest(peer, clientIP, host, nxproxy.DenyBlocklist)
+			slog.Warn("HTTP: Forward: Resolved dest addr blocklisted",
+				slog.String("client_ip", clientIP),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("host", host))
+			wrt.WriteHeader(http.StatusForbidden)
+			return
+		} else if err != nil {
+			svc.Counters.DialFailed.Add(1)
 			slog.Debug("HTTP: Forward: Request",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("peer", peer.DisplayName()),
 				slog.String("host", host),
 				slog.String("err", err.Error()))
@@ -161,10 +320,23 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
 		defer fwresp.Body.Close()
 
+		if decompress {
+			if err := decompressForwarded(fwresp); err != nil {
+				slog.Debug("HTTP: Forward: Decompress response",
+					slog.String("client_ip", clientIP),
+					slog.String("proxy_addr", proxyAddr),
+					slog.String("peer", peer.DisplayName()),
+					slog.String("host", host),
+					slog.String("err", err.Error()))
+				wrt.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		}
+
 		if err := writeForwarded(fwresp, wrt); err != nil {
 			slog.Debug("HTTP: Forward: Write",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("peer", peer.DisplayName()),
 				slog.String("host", host),
 				slog.String("err", err.Error()))
@@ -173,7 +345,7 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
 		slog.Debug("HTTP: Forward",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host))
 		return
@@ -184,7 +356,7 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
 		slog.Debug("HTTP: Connect: Peer connection rejected",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host),
 			slog.String("err", err.Error()))
@@ -202,12 +374,36 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
 	defer connCtl.Close()
 
-	dstConn, err := peer.Dialer.DialContext(connCtl.Context(), "tcp", host)
-	if err != nil {
+	connCtl.SetLogAttrs(
+		slog.String("client_ip", clientIP),
+		slog.String("proxy_addr", proxyAddr),
+		slog.String("host", host))
+
+	connCtl.SetWeight(opts.ConnectionWeights.Weight(nxproxy.ConnectionTunnel, host))
+
+	dstConn, err := peer.DialContext(connCtl.Context(), "tcp", host)
+	peer.ReportDial(err)
+	if errors.Is(err, nxproxy.ErrDestBlocklisted) {
+
+		svc.DenyDest(peer, clientIP, host, nxproxy.DenyBlocklist)
+
+		slog.Warn("HTTP: Connect: Resolved dest addr blocklisted",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", host))
+
+		wrt.Header().Set("Proxy-Connection", "Close")
+		wrt.WriteHeader(http.StatusForbidden)
+		return
+
+	} else if err != nil {
+
+		svc.Counters.DialFailed.Add(1)
 
 		slog.Debug("HTTP: Dial destination",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host),
 			slog.String("err", err.Error()))
@@ -223,7 +419,7 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		slog.Error("HTTP: Connection hijack failed",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("host", host),
 			slog.String("err", err.Error()))
 		wrt.WriteHeader(http.StatusNotImplemented)
@@ -231,33 +427,97 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 	}
 
 	defer conn.Close()
+	defer clientConn.ReleaseHijacked()
 
-	if err := writeAck(rw.Writer, wrt.Header().Clone()); err != nil {
+	//	the server doesn't look after hijacked connections, so clients that don't take the ack are dropped here
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	var writeConnectAck = func() error {
+		if opts.Stealth {
+			return writeBareAck(rw.Writer)
+		}
+		return writeAck(rw.Writer, wrt.Header().Clone())
+	}
+
+	if err := writeConnectAck(); err != nil {
 		slog.Debug("HTTP: Tunnel: Failed to write ack",
 			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("host", host),
 			slog.String("err", err.Error()))
 		return
 	}
 
+	var trailer []byte
 	if trailLen := rw.Reader.Buffered(); trailLen > 0 {
 
-		trailer, err := rw.Reader.Peek(trailLen)
-		if err != nil {
+		if trailer, err = rw.Reader.Peek(trailLen); err != nil {
 			slog.Debug("HTTP: Tunnel: Failed to read trailer",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("host", host),
 				slog.String("err", err.Error()))
 			return
 		}
+	}
+
+	var onClientHello = func(hello *nxproxy.ClientHello) error {
+
+		if opts := svc.Options(); hello.ServerName != "" && opts.DomainBlocked(hello.ServerName) {
+			svc.DenyDest(peer, clientIP, hello.ServerName, nxproxy.DenyBlockedSni)
+			slog.Warn("HTTP: Connect: TLS server name blocked",
+				slog.String("client_ip", clientIP),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("remote", host),
+				slog.String("sni", hello.ServerName))
+			return nxproxy.ErrDomainBlocked
+		}
+
+		if !peer.TlsClientAllowed(hello) {
+			svc.DenyDest(peer, clientIP, host, nxproxy.DenyTlsFingerprint)
+			slog.Warn("HTTP: Connect: TLS client fingerprint not allowed",
+				slog.String("client_ip", clientIP),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("remote", host),
+				slog.String("ja3", hello.JA3()),
+				slog.String("ja4", hello.JA4()))
+			return nxproxy.ErrTlsFingerprintDenied
+		}
+
+		slog.Debug("HTTP: Connect: TLS",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("remote", host),
+			slog.String("sni", hello.ServerName),
+			slog.String("ja3", hello.JA3()),
+			slog.String("ja4", hello.JA4()))
+
+		return nil
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		slog.Debug("HTTP: Tunnel: Reset io timeouts",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("host", host),
+			slog.String("err", err.Error()))
+		return
+	}
+
+	mitm, intercept := svc.MitmConfig()
+	inspect := !intercept && (opts.InspectTls() || len(peer.TlsFingerprints) > 0)
+
+	//	the trailer may already contain a part of the client hello, so it has to be looked at too
+	if len(trailer) > 0 && !intercept && !inspect {
 
 		written, err := dstConn.Write(trailer)
 		if err != nil {
 			slog.Debug("HTTP: Tunnel: Failed to write trailer",
 				slog.String("client_ip", clientIP),
-				slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+				slog.String("proxy_addr", proxyAddr),
 				slog.String("host", host),
 				slog.String("err", err.Error()))
 			return
@@ -268,16 +528,32 @@ func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 
 	slog.Debug("HTTP: Connect",
 		slog.String("client_ip", clientIP),
-		slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+		slog.String("proxy_addr", proxyAddr),
 		slog.String("peer", peer.DisplayName()),
 		slog.String("remote", host))
 
-	if err := nxproxy.ProxyBridge(connCtl, conn, dstConn); err != nil {
-		slog.Debug("HTTP: Connect: Broken pipe",
-			slog.String("client_ip", clientIP),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
-			slog.String("peer", peer.DisplayName()),
-			slog.String("remote", host),
+	//	intercepted tunnels aren't bridged as is, so there's no splice result for them
+	var result nxproxy.BridgeResult
+
+	switch {
+	case intercept:
+		mitm.OnHello = onClientHello
+		mitm.OnUrlBlocked = func(url string) {
+			svc.DenyDest(peer, clientIP, url, nxproxy.DenyBlockedUrl)
+		}
+		err = nxproxy.InterceptTls(connCtl, conn, trailer, dstConn, host, mitm)
+	case inspect:
+		result, err = nxproxy.ProxyBridge(connCtl, nxproxy.InspectClientHello(conn, trailer, onClientHello), dstConn)
+	default:
+		result, err = nxproxy.ProxyBridge(connCtl, conn, dstConn)
+	}
+
+	logger := connCtl.Logger().With(result.LogAttrs()...)
+
+	if err != nil {
+		logger.Debug("HTTP: Connect: Broken pipe",
 			slog.String("err", err.Error()))
+	} else {
+		logger.Debug("HTTP: Connect: Closed")
 	}
 }
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestService_Stealth(t *testing.T) {
+
+	svc := service{Slot: nxproxy.Slot{DNS: stubDns{}}, nonces: newDigestNonces()}
+
+	var challenge = func(stealth bool) http.Header {
+
+		if err := svc.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, Stealth: stealth}); err != nil {
+			t.Fatalf("set options: %v", err)
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+
+		req.RemoteAddr = "198.51.100.20:50000"
+
+		wrt := httptest.NewRecorder()
+		svc.ServeHTTP(wrt, req)
+
+		if wrt.Code != http.StatusProxyAuthRequired {
+			t.Fatalf("unexpected status: %d", wrt.Code)
+		}
+
+		return wrt.Header()
+	}
+
+	if header := challenge(false); header.Get("Via") == "" || !strings.Contains(header.Get("Proxy-Authenticate"), nxproxy.DefaultHttpRealm) {
+		t.Errorf("transparent slot headers missing: %v", header)
+	}
+
+	header := challenge(true)
+
+	if header.Get("Via") != "" || header.Get("X-Forwarded") != "" {
+		t.Errorf("proxy headers sent by a stealth slot: %v", header)
+	}
+
+	if val := header.Get("Proxy-Authenticate"); val != `Basic realm="`+nxproxy.StealthHttpRealm+`"` {
+		t.Errorf("unexpected challenge: %s", val)
+	}
+
+	var ack bytes.Buffer
+	writer := bufio.NewWriter(&ack)
+
+	if err := writeBareAck(writer); err != nil || ack.String() != "HTTP/1.1 200 OK\r\n\r\n" {
+		t.Errorf("unexpected ack: %q %v", ack.String(), err)
+	}
+}
+
+func TestStripProxyHeaders(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Proxy-Authorization", "Basic dXNlcjpwYXNz")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("X-Forwarded-For", "198.51.100.20")
+	req.Header.Set("Accept", "*/*")
+
+	stripProxyHeaders(req)
+
+	var dump bytes.Buffer
+	if err := req.Write(&dump); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	for _, key := range []string{"Proxy-Authorization", "Proxy-Connection", "X-Forwarded-For", "User-Agent"} {
+		if strings.Contains(dump.String(), key+":") {
+			t.Errorf("%s left in the request:\n%s", key, dump.String())
+		}
+	}
+
+	if !strings.Contains(dump.String(), "Accept: */*") {
+		t.Errorf("regular header stripped:\n%s", dump.String())
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func newTestCert(t *testing.T, serial int64) *nxproxy.TlsOptions {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "proxy.example.com"},
+		DNSNames:     []string{"proxy.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Duration(serial) * 24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	return &nxproxy.TlsOptions{
+		Cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func TestService_Https(t *testing.T) {
+
+	opts := nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoHttps,
+		BindAddr:    "127.0.0.1:0",
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+		HttpPac:     &nxproxy.HttpPac{},
+	}
+
+	if _, err := NewService(opts, nxproxy.SlotEnv{DNS: stubDns{}}); err == nil {
+		t.Fatalf("https slot created without a certificate")
+	}
+
+	opts.Tls = newTestCert(t, 1)
+
+	slot, err := NewService(opts, nxproxy.SlotEnv{DNS: stubDns{}})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	svc := slot.(*service)
+	t.Cleanup(func() { svc.Close() })
+
+	addr := svc.listener.Addr().String()
+
+	var handshake = func() *x509.Certificate {
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+		if err != nil {
+			t.Fatalf("tls dial: %v", err)
+		}
+
+		defer conn.Close()
+
+		if proto := conn.ConnectionState().NegotiatedProtocol; proto != "http/1.1" {
+			t.Errorf("unexpected alpn protocol: '%s'", proto)
+		}
+
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	if cert := handshake(); cert.SerialNumber.Int64() != 1 {
+		t.Errorf("unexpected certificate: %v", cert.SerialNumber)
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   5 * time.Second,
+	}
+
+	req, err := http.NewRequest("GET", "https://"+addr+"/proxy.pac", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	req.Host = "proxy.example.com:3128"
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"HTTPS proxy.example.com:3128"`) {
+		t.Errorf("unexpected pac: %d '%s'", resp.StatusCode, body)
+	}
+
+	//	plain http clients don't get proxied
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+	if reply, _ := io.ReadAll(conn); strings.HasPrefix(string(reply), "HTTP/1.1 200") {
+		t.Errorf("cleartext request accepted")
+	}
+
+	//	rotated certificates are used by new connections
+	opts.Tls = newTestCert(t, 2)
+
+	if err := svc.SetOptions(opts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	if cert := handshake(); cert.SerialNumber.Int64() != 2 {
+		t.Errorf("certificate not rotated: %v", cert.SerialNumber)
+	}
+
+	if info := svc.Info(); info.CertExpires == nil || time.Until(*info.CertExpires) < 24*time.Hour {
+		t.Errorf("unexpected certificate expiry: %v", info.CertExpires)
+	}
+}
@@ -0,0 +1,46 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Largest landing page accepted in slot options
+const MaxHttpLandingSize = 64 << 10
+
+// Page shown to browsers that hit an http slot without credentials, in place of a bare auth challenge.
+// Either the page itself or the url of an external one has to be set
+type HttpLanding struct {
+
+	//	html document returned to browsers
+	Html string `json:"html,omitempty"`
+
+	//	absolute http(s) url that browsers get sent to instead
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+func (opts *HttpLanding) Validate() error {
+
+	if (opts.Html == "") == (opts.RedirectURL == "") {
+		return errors.New("either html or a redirect url has to be set")
+	}
+
+	if len(opts.Html) > MaxHttpLandingSize {
+		return fmt.Errorf("html may not exceed %d bytes", MaxHttpLandingSize)
+	}
+
+	if opts.RedirectURL != "" {
+
+		val, err := url.Parse(opts.RedirectURL)
+		if err != nil {
+			return fmt.Errorf("redirect url: %v", err)
+		}
+
+		if (val.Scheme != "http" && val.Scheme != "https") || val.Host == "" {
+			return errors.New("redirect url: must be an absolute http(s) url")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package nxproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+const DefaultPacPath = "/proxy.pac"
+
+// Sends all requests through the slot
+const DefaultPacTemplate = `function FindProxyForURL(url, host) {
+	return "{{.Keyword}} {{.Addr}}";
+}
+`
+
+// Proxy auto-config file served by an http slot, so that browsers can be set up with a single url
+type HttpPac struct {
+
+	//	path the file is served at; /proxy.pac when unset
+	Path string `json:"path,omitempty"`
+
+	//	text/template of the file, which gets the address clients reach the slot at as .Addr, .Host and .Port,
+	//	and the PAC keyword of the slot, PROXY or HTTPS, as .Keyword; all requests are sent through the slot when unset
+	Template string `json:"template,omitempty"`
+}
+
+func (opts *HttpPac) PacPath() string {
+
+	if opts.Path == "" {
+		return DefaultPacPath
+	}
+
+	return opts.Path
+}
+
+func (opts *HttpPac) Validate() error {
+
+	if !strings.HasPrefix(opts.PacPath(), "/") {
+		return errors.New("path must start with a slash")
+	}
+
+	if _, err := opts.parse(); err != nil {
+		return fmt.Errorf("template: %v", err)
+	}
+
+	return nil
+}
+
+func (opts *HttpPac) parse() (*template.Template, error) {
+
+	text := opts.Template
+	if text == "" {
+		text = DefaultPacTemplate
+	}
+
+	return template.New("pac").Option("missingkey=error").Parse(text)
+}
+
+// Renders the file for a slot address; secure slots are reached over TLS
+func (opts *HttpPac) Render(addr string, secure bool) ([]byte, error) {
+
+	tmpl, err := opts.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	keyword := "PROXY"
+	if secure {
+		keyword = "HTTPS"
+	}
+
+	var buff bytes.Buffer
+
+	err = tmpl.Execute(&buff, struct {
+		Addr    string
+		Host    string
+		Port    string
+		Keyword string
+	}{
+		Addr:    addr,
+		Host:    host,
+		Port:    port,
+		Keyword: keyword,
+	})
+
+	return buff.Bytes(), err
+}
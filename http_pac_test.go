@@ -0,0 +1,38 @@
+package nxproxy_test
+
+import (
+	"strings"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestHttpPac_Validate(t *testing.T) {
+
+	for _, entry := range []nxproxy.HttpPac{
+		{Path: "proxy.pac"},
+		{Template: "{{.Addr"},
+	} {
+		if err := entry.Validate(); err == nil {
+			t.Errorf("invalid pac options accepted: %+v", entry)
+		}
+	}
+
+	if err := (&nxproxy.HttpPac{}).Validate(); err != nil {
+		t.Errorf("default pac options rejected: %v", err)
+	}
+}
+
+func TestHttpPac_Render(t *testing.T) {
+
+	pac := nxproxy.HttpPac{Template: `{{.Host}} {{.Port}} {{.Addr}}`}
+
+	content, err := pac.Render("[::1]:3128", false)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	if val := strings.TrimSpace(string(content)); val != "::1 3128 [::1]:3128" {
+		t.Errorf("unexpected content: '%s'", val)
+	}
+}
@@ -3,11 +3,13 @@ package nxproxy
 import (
 	"context"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 )
 
+// Reads exactly n bytes; a stream that ends early results in io.EOF
 func ReadN(reader io.Reader, n int) ([]byte, error) {
 
 	if n <= 0 {
@@ -15,58 +17,265 @@ func ReadN(reader io.Reader, n int) ([]byte, error) {
 	}
 
 	buff := make([]byte, n)
-	bytesRead, err := reader.Read(buff)
-	if bytesRead == len(buff) {
-		return buff, nil
-	} else if err == nil && bytesRead != len(buff) {
-		return nil, io.EOF
+	if _, err := io.ReadFull(reader, buff); err != nil {
+
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+
+		return nil, err
 	}
 
-	return buff, err
+	return buff, nil
 }
 
 func ReadByte(reader io.Reader) (byte, error) {
+
 	buff, err := ReadN(reader, 1)
-	return buff[0], err
+	if err != nil {
+		return 0, err
+	}
+
+	return buff[0], nil
+}
+
+// Time the remaining direction of a broken tunnel gets to flush its data, unless configured otherwise
+const DefaultBridgeLinger = 2 * time.Second
+
+type SpliceDirection string
+
+const (
+	//	data sent by the client to the destination
+	SpliceTx = SpliceDirection("tx")
+	//	data sent by the destination back to the client
+	SpliceRx = SpliceDirection("rx")
+)
+
+const (
+	//	the sending side of the direction has closed its connection normally
+	BridgeCloseEOF = "eof"
+	//	the connection was closed by the proxy, like when a peer gets disabled or a slot stops
+	BridgeCloseCancelled  = "cancelled"
+	BridgeCloseCapReached = "cap_reached"
+)
+
+// Describes how a bridged session has ended
+type BridgeResult struct {
+
+	//	direction that has ended the session; empty when it was closed by the proxy
+	Direction SpliceDirection
+
+	//	one of the BridgeClose values or a network error cause as returned by ClassifyNetError
+	Cause string
+
+	//	set when the session was broken by a destination side failure
+	Upstream bool
+
+	//	data volume spliced in each direction
+	Tx int64
+	Rx int64
 }
 
-// Bridges two connections together to create a proxy
-func ProxyBridge(ctl *PeerConnection, clientConn net.Conn, remoteConn net.Conn) (err error) {
+// Returns the result as log attributes; there are none for an empty result
+func (result BridgeResult) LogAttrs() []any {
+
+	if result.Cause == "" {
+		return nil
+	}
+
+	return []any{
+		slog.String("closed_by", string(result.Direction)),
+		slog.String("cause", result.Cause),
+		slog.Int64("tx", result.Tx),
+		slog.Int64("rx", result.Rx),
+	}
+}
+
+// Bridges two connections together to create a proxy. A direction that reaches EOF gets its write side
+// shut down on the other connection, while the opposite one keeps going, so that half-closing protocols work as expected.
+// The error is only set when the session has ended with something other than a normal close
+func ProxyBridge(ctl *PeerConnection, clientConn net.Conn, remoteConn net.Conn) (BridgeResult, error) {
 
 	ctx := ctl.Context()
+	logger := ctl.Logger()
 
 	txCtx, cancelTx := context.WithCancel(ctx)
 	rxCtx, cancelRx := context.WithCancel(ctx)
 
-	doneCh := make(chan error, 2)
+	doneCh := make(chan SpliceDirection, 2)
 	defer close(doneCh)
 
+	var txErr, rxErr error
+	var result BridgeResult
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	//	both directions leave the node, so both count towards egress
+	var withEgress = func(acct AccountFn) AccountFn {
+		return func(delta int) {
+			acct(delta)
+			ctl.WaitEgress(delta)
+		}
+	}
+
+	//	hands rate limiting over to the kernel by setting the pacing rate on the destination socket;
+	//	falls back to userspace shaping if that doesn't work out
+	var withPacing = func(dst net.Conn, bw BandwidthFn) BandwidthFn {
+
+		if !ctl.pacing {
+			return bw
+		}
+
+		current := -1
+		failed := false
+
+		return func() (int, bool) {
+
+			val, limited := bw()
+			if failed {
+				return val, limited
+			}
+
+			if val != current {
+
+				if !SetPacingRate(dst, val) {
+					failed = true
+					return val, limited
+				}
+
+				current = val
+			}
+
+			return 0, false
+		}
+	}
+
 	go func() {
 		defer wg.Done()
-		doneCh <- SpliceConn(txCtx, remoteConn, clientConn, ctl.BandwidthTx, ctl.AccountTx)
+		result.Tx, txErr = SpliceConn(txCtx, remoteConn, clientConn, withPacing(remoteConn, ctl.BandwidthTx), withEgress(ctl.AccountTx),
+			logger.With(slog.String("direction", string(SpliceTx))))
+		doneCh <- SpliceTx
 	}()
 
 	go func() {
 		defer wg.Done()
-		doneCh <- SpliceConn(rxCtx, clientConn, remoteConn, ctl.BandwidthRx, ctl.AccountRx)
+		result.Rx, rxErr = SpliceConn(rxCtx, clientConn, remoteConn, withPacing(clientConn, ctl.BandwidthRx), withEgress(ctl.AccountRx),
+			logger.With(slog.String("direction", string(SpliceRx))))
+		doneCh <- SpliceRx
 	}()
 
-	select {
-	case err = <-doneCh:
-	case <-ctx.Done():
+	var errOf = func(dir SpliceDirection) error {
+		if dir == SpliceTx {
+			return txErr
+		}
+		return rxErr
+	}
+
+	var dstOf = func(dir SpliceDirection) net.Conn {
+		if dir == SpliceTx {
+			return remoteConn
+		}
+		return clientConn
+	}
+
+	var err error
+
+	//	the session ends once both directions are done, or as soon as one of them fails
+wait:
+	for pending := 2; pending > 0; pending-- {
+
+		select {
+
+		case dir := <-doneCh:
+
+			if err = errOf(dir); err != nil || result.Direction == "" {
+				result.Direction = dir
+			}
+
+			if err != nil || !closeWrite(dstOf(dir)) {
+				break wait
+			}
+
+		case <-ctx.Done():
+			break wait
+		}
 	}
 
-	cancelRx()
-	cancelTx()
+	var teardown = func() {
 
-	_ = remoteConn.SetReadDeadline(time.Unix(1, 0))
-	_ = clientConn.SetReadDeadline(time.Unix(1, 0))
+		cancelRx()
+		cancelTx()
+
+		_ = remoteConn.SetReadDeadline(time.Unix(1, 0))
+		_ = clientConn.SetReadDeadline(time.Unix(1, 0))
+	}
+
+	//	a direction that's still running may have data on the way, like the rest of a download
+	//	when the client upload has failed, so it's given some time to pass that on
+	if result.Direction != "" && ctl.linger > 0 && ctx.Err() == nil && !ctl.CapReached() {
+
+		stop := context.AfterFunc(ctx, teardown)
+
+		deadline := time.Now().Add(ctl.linger)
+		_ = remoteConn.SetReadDeadline(deadline)
+		_ = clientConn.SetReadDeadline(deadline)
+
+		wg.Wait()
+
+		stop()
+	}
+
+	teardown()
 
 	wg.Wait()
-	return
+
+	switch {
+
+	case ctl.CapReached():
+		result.Direction = ""
+		result.Cause = BridgeCloseCapReached
+		return result, ErrSessionCapReached
+
+	case result.Direction == "" || (err == nil && ctx.Err() != nil):
+		result.Direction = ""
+		result.Cause = BridgeCloseCancelled
+		return result, nil
+
+	case err == nil:
+		result.Cause = BridgeCloseEOF
+		return result, nil
+	}
+
+	result.Cause = ClassifyNetError(err)
+	result.Upstream = isRemoteConnError(err, remoteConn)
+
+	if result.Upstream && ctl.onUpstreamError != nil {
+		ctl.onUpstreamError(err)
+	}
+
+	return result, err
+}
+
+// Shuts down the writing side of a connection, looking through wrappers that expose the underlying one.
+// Returns false when the connection can't be half-closed
+func closeWrite(conn net.Conn) bool {
+
+	for conn != nil {
+
+		if val, ok := conn.(interface{ CloseWrite() error }); ok {
+			return val.CloseWrite() == nil
+		}
+
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return false
+		}
+
+		conn = wrapper.NetConn()
+	}
+
+	return false
 }
 
 // Implementations of BandwidthFn must return the data volume in bytes that a connection may copy in one second at most
@@ -74,21 +283,49 @@ type BandwidthFn func() (int, bool)
 
 type AccountFn func(delta int)
 
-// Forwards data from src to dst while limiting data rate and accounting for traffic volume
-func SpliceConn(ctx context.Context, dst io.Writer, src io.Reader, bw BandwidthFn, acct AccountFn) error {
+// Time it may take to pass a single chunk on, not counting the wait for the source to produce it,
+// before the splice is considered stalled by shaping or by the destination not keeping up
+const spliceStallThreshold = 10 * time.Second
+
+// Forwards data from src to dst while limiting data rate and accounting for traffic volume. Returns the number of bytes written;
+// the error is nil when src has reached EOF. Short writes and stalls get reported to the logger, which falls back to the default one when nil
+func SpliceConn(ctx context.Context, dst io.Writer, src io.Reader, bw BandwidthFn, acct AccountFn, logger *slog.Logger) (int64, error) {
 
-	const defaultChunkSize = 32 * 1024
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var total int64
+
+	//	a stalled destination tends to stay that way, so it's only reported once
+	var stallReported bool
+
+	var checkStall = func(started time.Time, size int, bandwidth int) {
+
+		if elapsed := time.Since(started); elapsed > spliceStallThreshold && !stallReported {
+
+			stallReported = true
+
+			logger.Warn("Splice: Transfer stalled",
+				slog.Duration("elapsed", elapsed),
+				slog.Int("size", size),
+				slog.Int("bandwidth", bandwidth))
+		}
+	}
 
 	var copyLimit = func(bandwidth int) error {
 
-		chunk := make([]byte, bandwidth)
+		chunk := make([]byte, ChunkSizeTCIO(bandwidth))
 		started := time.Now()
 
 		read, err := src.Read(chunk)
 
 		if read > 0 {
 
+			readDone := time.Now()
+
 			written, err := dst.Write(chunk[:read])
+			total += int64(written)
 
 			if acct != nil {
 				acct(written)
@@ -97,10 +334,15 @@ func SpliceConn(ctx context.Context, dst io.Writer, src io.Reader, bw BandwidthF
 			if err != nil {
 				return err
 			} else if written < read {
+				logger.Warn("Splice: Short write",
+					slog.Int("read", read),
+					slog.Int("written", written))
 				return io.ErrShortWrite
 			}
 
-			WaitTCIO(bandwidth, min(written, read), started)
+			WaitTCIO(ctx, bandwidth, min(written, read), started)
+
+			checkStall(readDone, written, bandwidth)
 		}
 
 		return err
@@ -108,10 +350,18 @@ func SpliceConn(ctx context.Context, dst io.Writer, src io.Reader, bw BandwidthF
 
 	var copyDirect = func() error {
 
-		written, err := io.CopyN(dst, src, defaultChunkSize)
+		written, err := io.CopyN(dst, src, unshapedChunkSize)
+		total += written
+
+		if err == io.ErrShortWrite {
+			logger.Warn("Splice: Short write",
+				slog.Int64("written", written))
+		}
 
 		if acct != nil {
+			started := time.Now()
 			acct(int(written))
+			checkStall(started, int(written), 0)
 		}
 
 		return err
@@ -134,20 +384,56 @@ func SpliceConn(ctx context.Context, dst io.Writer, src io.Reader, bw BandwidthF
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return err
+			return total, err
 		}
 	}
 
-	return nil
+	return total, nil
 }
 
-// Creates a fake delay that can be used to limit data transfer rate
-func WaitTCIO(bandwidth int, size int, started time.Time) {
-	elapsed := time.Since(started)
-	time.Sleep(DurationTCIO(bandwidth, size) - elapsed)
+// Longest shaping delay of a single io operation. Shaped connections move data in chunks of this much transfer time,
+// so that a connection never sleeps for long and picks up cancellation and bandwidth changes quickly
+const MaxTCIOWait = 250 * time.Millisecond
+
+// Chunk size of unshaped transfers
+const unshapedChunkSize = 32 * 1024
+
+// Returns the size in bytes of a shaped io chunk for the bandwidth in bytes per second,
+// so that waiting for it never takes longer than MaxTCIOWait. Unshaped chunk size is returned for non-positive bandwidth
+func ChunkSizeTCIO(bandwidth int) int {
+
+	if bandwidth <= 0 {
+		return unshapedChunkSize
+	}
+
+	return max(int(int64(bandwidth)*int64(MaxTCIOWait)/int64(time.Second)), 1)
+}
+
+// Creates a fake delay that can be used to limit data transfer rate; see DurationTCIO for units.
+// Returns early when the context is cancelled
+func WaitTCIO(ctx context.Context, bandwidth int, size int, started time.Time) {
+
+	delay := DurationTCIO(bandwidth, size) - time.Since(started)
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
-// Returns the amount of time it's expected for an IO operation to take. Bandwidth in bps, size in bytes
+// Returns the amount of time it's expected for an IO operation to take. Bandwidth in bytes per second, size in bytes.
+// Non-positive bandwidth means no shaping and non-positive sizes take no time, so zero is returned for both
 func DurationTCIO(bandwidth int, size int) time.Duration {
+
+	if bandwidth <= 0 || size <= 0 {
+		return 0
+	}
+
 	return time.Duration(int64(time.Second) * int64(size) / int64(bandwidth))
 }
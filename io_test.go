@@ -0,0 +1,232 @@
+package nxproxy_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+type shortWriter struct{}
+
+func (shortWriter) Write(buff []byte) (int, error) {
+	return len(buff) / 2, nil
+}
+
+func TestSpliceConn_ShortWriteLogged(t *testing.T) {
+
+	var logBuff bytes.Buffer
+
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logBuff, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID:           uuid.New(),
+			PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "test"},
+		},
+		Slot: nxproxy.SlotIdentity{ID: "edge", Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"},
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ctl.Close()
+
+	ctl.SetLogAttrs(slog.String("host", "example.com:443"))
+
+	var bw = func() (int, bool) {
+		return 1024 * 1024, true
+	}
+
+	written, err := nxproxy.SpliceConn(context.Background(), shortWriter{}, strings.NewReader("test data"), bw, nil, ctl.Logger())
+	if err != io.ErrShortWrite {
+		t.Fatalf("unexpected error: %v", err)
+	} else if written != 4 {
+		t.Errorf("unexpected number of bytes written: %d", written)
+	}
+
+	entry := logBuff.String()
+
+	for _, expect := range []string{"Short write", "peer=maddsua", "slot.id=edge", "slot.proto=socks", "host=example.com:443", "read=9", "written=4"} {
+		if !strings.Contains(entry, expect) {
+			t.Errorf("log entry is missing %q: %s", expect, entry)
+		}
+	}
+}
+
+func TestProxyBridge_HalfClose(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New()},
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ctl.Close()
+
+	appConn, clientConn := loopbackPair(t)
+	remoteConn, serverConn := loopbackPair(t)
+
+	//	the server only responds after the client is done sending
+	go func() {
+
+		if _, err := io.ReadAll(serverConn); err != nil {
+			t.Errorf("server read: %v", err)
+		}
+
+		serverConn.Write([]byte("bye"))
+		serverConn.Close()
+	}()
+
+	clientDone := make(chan struct{})
+
+	go func() {
+
+		defer close(clientDone)
+
+		appConn.Write([]byte("hello"))
+		appConn.(*net.TCPConn).CloseWrite()
+
+		if resp, err := io.ReadAll(appConn); err != nil || string(resp) != "bye" {
+			t.Errorf("unexpected response: %q (%v)", resp, err)
+		}
+	}()
+
+	result, err := nxproxy.ProxyBridge(ctl, clientConn, remoteConn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	<-clientDone
+
+	if result.Direction != nxproxy.SpliceTx || result.Cause != nxproxy.BridgeCloseEOF || result.Upstream {
+		t.Errorf("unexpected bridge result: %+v", result)
+	}
+
+	if result.Tx != 5 || result.Rx != 3 {
+		t.Errorf("unexpected data volume: tx %d rx %d", result.Tx, result.Rx)
+	}
+
+	if _, has := peer.Failures(); has {
+		t.Errorf("normal close counted as a failure")
+	}
+}
+
+type brokenUploadConn struct {
+	net.Conn
+}
+
+func (conn *brokenUploadConn) Read(buff []byte) (int, error) {
+	return 0, errors.New("upload broken")
+}
+
+func TestProxyBridge_Linger(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions:  nxproxy.PeerOptions{ID: uuid.New()},
+		BridgeLinger: time.Second,
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ctl.Close()
+
+	appConn, clientConn := loopbackPair(t)
+	remoteConn, serverConn := loopbackPair(t)
+
+	//	the rest of the download arrives after the upload has already failed
+	time.AfterFunc(50*time.Millisecond, func() {
+		serverConn.Write([]byte("data"))
+		serverConn.Close()
+	})
+
+	result, err := nxproxy.ProxyBridge(ctl, &brokenUploadConn{Conn: clientConn}, remoteConn)
+	if err == nil {
+		t.Fatalf("bridge didn't fail")
+	}
+
+	if result.Direction != nxproxy.SpliceTx || result.Rx != 4 {
+		t.Errorf("unexpected bridge result: %+v", result)
+	}
+
+	buff := make([]byte, 4)
+	if _, err := io.ReadFull(appConn, buff); err != nil || string(buff) != "data" {
+		t.Errorf("unexpected download: %q (%v)", buff, err)
+	}
+}
+
+func TestWaitTCIO_Cancelled(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+
+	//	a thousand bytes at a byte per second
+	nxproxy.WaitTCIO(ctx, 1, 1000, started)
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("wait not cancelled, took %v", elapsed)
+	}
+}
+
+func TestChunkSizeTCIO(t *testing.T) {
+
+	if size := nxproxy.ChunkSizeTCIO(0); size <= 0 {
+		t.Errorf("unshaped chunk size %d", size)
+	}
+
+	for _, bandwidth := range []int{1, 3, 1000, 125_000, 12_500_000} {
+
+		size := nxproxy.ChunkSizeTCIO(bandwidth)
+		if size < 1 {
+			t.Errorf("bandwidth %d: empty chunk", bandwidth)
+		}
+
+		if bandwidth >= 4 && nxproxy.DurationTCIO(bandwidth, size) > nxproxy.MaxTCIOWait {
+			t.Errorf("bandwidth %d: chunk of %d takes %v", bandwidth, size, nxproxy.DurationTCIO(bandwidth, size))
+		}
+	}
+}
+
+func TestDurationTCIO(t *testing.T) {
+
+	cases := []struct {
+		bandwidth int
+		size      int
+		want      time.Duration
+	}{
+		{bandwidth: 1000, size: 1000, want: time.Second},
+		{bandwidth: 1000, size: 250, want: 250 * time.Millisecond},
+		{bandwidth: 125_000, size: 32 * 1024, want: 262_144 * time.Microsecond},
+		{bandwidth: 3, size: 1, want: 333_333_333 * time.Nanosecond},
+		{bandwidth: 1000, size: 0, want: 0},
+		{bandwidth: 1000, size: -10, want: 0},
+		{bandwidth: 0, size: 1000, want: 0},
+		{bandwidth: -1, size: 1000, want: 0},
+	}
+
+	for _, entry := range cases {
+		if val := nxproxy.DurationTCIO(entry.bandwidth, entry.size); val != entry.want {
+			t.Errorf("%d bytes at %d B/s: expected %v, got %v", entry.size, entry.bandwidth, entry.want, val)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package nxproxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS interception settings. Enabling them makes the slot terminate TLS inside tunnels using certificates
+// issued by the provided CA, which clients must trust. Only meant for deployments that are required to inspect traffic
+type MitmOptions struct {
+
+	//	PEM-encoded CA certificate and its private key
+	CaCert string `json:"ca_cert"`
+	CaKey  string `json:"ca_key"`
+
+	//	headers set on every inspected request
+	InjectHeaders map[string]string `json:"inject_headers,omitempty"`
+
+	//	requests to urls starting with any of these prefixes are refused; prefixes don't include the scheme, like 'example.com/admin'
+	BlockedUrls []string `json:"blocked_urls,omitempty"`
+}
+
+// Checks whether an inspected request url, written without the scheme, is blocked
+func (opts *MitmOptions) UrlBlocked(url string) bool {
+
+	url = strings.ToLower(url)
+
+	for _, prefix := range opts.BlockedUrls {
+		if strings.HasPrefix(url, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// How long issued certificates stay valid
+const mitmCertTTL = 24 * time.Hour
+
+// Max number of cached host certificates
+const mitmCertCacheSize = 1024
+
+// Issues substitute server certificates for intercepted TLS connections
+type MitmAuthority struct {
+	certPEM string
+	keyPEM  string
+
+	caCert  *x509.Certificate
+	caKey   crypto.Signer
+	leafKey *ecdsa.PrivateKey
+
+	certs map[string]*tls.Certificate
+	mtx   sync.Mutex
+}
+
+func NewMitmAuthority(certPEM, keyPEM string) (*MitmAuthority, error) {
+
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !caCert.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+
+	caKey, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+
+	//	all issued certificates share the same key, which saves a key generation per host
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MitmAuthority{
+		certPEM: certPEM,
+		keyPEM:  keyPEM,
+		caCert:  caCert,
+		caKey:   caKey,
+		leafKey: leafKey,
+		certs:   map[string]*tls.Certificate{},
+	}, nil
+}
+
+// Checks whether the authority was created from the same CA
+func (auth *MitmAuthority) Matches(certPEM, keyPEM string) bool {
+	return auth.certPEM == certPEM && auth.keyPEM == keyPEM
+}
+
+// Returns a certificate for the host, issuing a new one if needed
+func (auth *MitmAuthority) Certificate(host string) (*tls.Certificate, error) {
+
+	host = strings.ToLower(host)
+
+	auth.mtx.Lock()
+	defer auth.mtx.Unlock()
+
+	if cert, has := auth.certs[host]; has && time.Until(cert.Leaf.NotAfter) > time.Hour {
+		return cert, nil
+	}
+
+	cert, err := auth.issue(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(auth.certs) >= mitmCertCacheSize {
+		clear(auth.certs)
+	}
+
+	auth.certs[host] = cert
+
+	return cert, nil
+}
+
+func (auth *MitmAuthority) issue(host string) (*tls.Certificate, error) {
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	notAfter := now.Add(mitmCertTTL)
+	if notAfter.After(auth.caCert.NotAfter) {
+		notAfter = auth.caCert.NotAfter
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, auth.caCert, auth.leafKey.Public(), auth.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("issue certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, auth.caCert.Raw},
+		PrivateKey:  auth.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
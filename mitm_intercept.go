@@ -0,0 +1,297 @@
+package nxproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUrlBlocked = errors.New("url blocked")
+
+// How long to wait for a client to start a TLS handshake before treating the tunnel as a plain one
+const mitmHelloTimeout = 5 * time.Second
+
+// Runtime TLS interception settings of a slot
+type MitmConfig struct {
+	MitmOptions
+	Authority *MitmAuthority
+
+	//	settings used to connect to destinations; system defaults are used when unset
+	UpstreamTLS *tls.Config
+
+	//	called with the client hello before accepting a handshake; returning an error aborts it
+	OnHello func(hello *ClientHello) error
+
+	//	called with every request url that got blocked
+	OnUrlBlocked func(url string)
+}
+
+// Terminates TLS inside a tunnel and forwards inspected HTTP requests over a new TLS connection to the destination.
+// Tunnels that don't start with a TLS handshake are bridged as is. The prefix holds client data that has already been read
+func InterceptTls(ctl *PeerConnection, clientConn net.Conn, prefix []byte, remoteConn net.Conn, host string, cfg MitmConfig) error {
+
+	ctx := ctl.Context()
+
+	stop := context.AfterFunc(ctx, func() {
+		clientConn.Close()
+		remoteConn.Close()
+	})
+	defer stop()
+
+	//	clients of server-first protocols won't send anything on their own
+	if len(prefix) == 0 {
+
+		first := make([]byte, 1)
+
+		_ = clientConn.SetReadDeadline(time.Now().Add(mitmHelloTimeout))
+		read, err := clientConn.Read(first)
+		_ = clientConn.SetReadDeadline(time.Time{})
+
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+
+		prefix = first[:read]
+	}
+
+	if len(prefix) == 0 || prefix[0] != 0x16 {
+		_, err := ProxyBridge(ctl, &helloInspector{Conn: clientConn, pending: prefix, done: true}, remoteConn)
+		return err
+	}
+
+	var hello *ClientHello
+
+	client := &helloInspector{
+		Conn:    clientConn,
+		pending: prefix,
+		onHello: func(val *ClientHello) error {
+			hello = val
+			return nil
+		},
+	}
+
+	var serverName string
+
+	tlsClient := tls.Server(client, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+			if serverName = info.ServerName; serverName == "" {
+				serverName = hostName(host)
+			}
+
+			if cfg.OnHello != nil {
+
+				//	hellos split across multiple records aren't parsed by the inspector
+				if hello == nil {
+					hello = &ClientHello{
+						ServerName:        info.ServerName,
+						CipherSuites:      info.CipherSuites,
+						Extensions:        info.Extensions,
+						SupportedVersions: info.SupportedVersions,
+						PointFormats:      info.SupportedPoints,
+						ALPN:              info.SupportedProtos,
+					}
+					for _, curve := range info.SupportedCurves {
+						hello.SupportedGroups = append(hello.SupportedGroups, uint16(curve))
+					}
+				}
+
+				if err := cfg.OnHello(hello); err != nil {
+					return nil, err
+				}
+			}
+
+			return cfg.Authority.Certificate(serverName)
+		},
+	})
+
+	defer tlsClient.Close()
+
+	if err := tlsClient.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("client handshake: %v", err)
+	}
+
+	upstreamCfg := &tls.Config{}
+	if cfg.UpstreamTLS != nil {
+		upstreamCfg = cfg.UpstreamTLS.Clone()
+	}
+
+	upstreamCfg.ServerName = serverName
+	upstreamCfg.NextProtos = []string{"http/1.1"}
+
+	tlsRemote := tls.Client(&meteredConn{Conn: remoteConn, ctl: ctl}, upstreamCfg)
+	defer tlsRemote.Close()
+
+	if err := tlsRemote.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("upstream handshake: %v", err)
+	}
+
+	clientReader := bufio.NewReader(tlsClient)
+	remoteReader := bufio.NewReader(tlsRemote)
+
+	for ctx.Err() == nil {
+
+		req, err := http.ReadRequest(clientReader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if reqUrl := strings.TrimSuffix(req.Host, ":443") + req.URL.RequestURI(); cfg.UrlBlocked(reqUrl) {
+
+			if cfg.OnUrlBlocked != nil {
+				cfg.OnUrlBlocked(reqUrl)
+			}
+
+			resp := http.Response{
+				StatusCode: http.StatusForbidden,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Close:      true,
+				Request:    req,
+			}
+
+			_ = resp.Write(tlsClient)
+
+			return ErrUrlBlocked
+		}
+
+		for key, val := range cfg.InjectHeaders {
+			req.Header.Set(key, val)
+		}
+
+		if err := req.Write(tlsRemote); err != nil {
+			return err
+		}
+
+		resp, err := http.ReadResponse(remoteReader, req)
+		if err != nil {
+			return err
+		}
+
+		err = resp.Write(tlsClient)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		//	upgraded connections, such as websockets, aren't http anymore
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			bridgeStreams(clientReader, tlsClient, remoteReader, tlsRemote)
+			return nil
+		}
+
+		if req.Close || resp.Close {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Copies data both ways until either of the sides is done
+func bridgeStreams(clientReader io.Reader, clientConn net.Conn, remoteReader io.Reader, remoteConn net.Conn) {
+
+	var once sync.Once
+	var closeBoth = func() {
+		once.Do(func() {
+			clientConn.Close()
+			remoteConn.Close()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		_, _ = io.Copy(remoteConn, clientReader)
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		_, _ = io.Copy(clientConn, remoteReader)
+	}()
+
+	wg.Wait()
+}
+
+// Accounts and shapes destination connection traffic on behalf of a peer connection
+type meteredConn struct {
+	net.Conn
+	ctl *PeerConnection
+}
+
+func (conn *meteredConn) Read(buff []byte) (int, error) {
+
+	bandwidth, _ := conn.ctl.BandwidthRx()
+	if bandwidth > 0 {
+		buff = buff[:min(len(buff), ChunkSizeTCIO(bandwidth))]
+	}
+
+	started := time.Now()
+
+	read, err := conn.Conn.Read(buff)
+	if read > 0 {
+
+		conn.ctl.AccountRx(read)
+		conn.ctl.WaitEgress(read)
+
+		if bandwidth > 0 {
+			WaitTCIO(conn.ctl.Context(), bandwidth, read, started)
+		}
+	}
+
+	return read, err
+}
+
+func (conn *meteredConn) Write(buff []byte) (int, error) {
+
+	var total int
+
+	for len(buff) > 0 {
+
+		chunk := buff
+		bandwidth, _ := conn.ctl.BandwidthTx()
+		if bandwidth > 0 {
+			chunk = chunk[:min(len(chunk), ChunkSizeTCIO(bandwidth))]
+		}
+
+		started := time.Now()
+
+		written, err := conn.Conn.Write(chunk)
+		total += written
+
+		if written > 0 {
+
+			conn.ctl.AccountTx(written)
+			conn.ctl.WaitEgress(written)
+
+			if bandwidth > 0 {
+				WaitTCIO(conn.ctl.Context(), bandwidth, written, started)
+			}
+		}
+
+		if err != nil {
+			return total, err
+		}
+
+		buff = buff[written:]
+	}
+
+	return total, nil
+}
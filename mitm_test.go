@@ -0,0 +1,177 @@
+package nxproxy_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Returns PEM-encoded certificate and key of a fresh CA
+func testCA(t *testing.T) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nx-proxy test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return string(certPEM), string(keyPEM)
+}
+
+func TestInterceptTls(t *testing.T) {
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		wrt.Header().Set("X-Seen-Header", req.Header.Get("X-Inspected-By"))
+		wrt.Write([]byte(req.URL.Path))
+	}))
+	defer upstream.Close()
+
+	upstreamRoots := x509.NewCertPool()
+	upstreamRoots.AddCert(upstream.Certificate())
+
+	caCert, caKey := testCA(t)
+
+	authority, err := nxproxy.NewMitmAuthority(caCert, caKey)
+	if err != nil {
+		t.Fatalf("authority: %v", err)
+	}
+
+	clientRoots := x509.NewCertPool()
+	clientRoots.AppendCertsFromPEM([]byte(caCert))
+
+	peer := nxproxy.Peer{PeerOptions: nxproxy.PeerOptions{ID: uuid.New()}}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	defer ctl.Close()
+
+	remoteConn, err := net.Dial("tcp", upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial upstream: %v", err)
+	}
+
+	appConn, clientConn := loopbackPair(t)
+
+	var seenName string
+
+	done := make(chan error, 1)
+	go func() {
+		done <- nxproxy.InterceptTls(ctl, clientConn, nil, remoteConn, upstream.Listener.Addr().String(), nxproxy.MitmConfig{
+			MitmOptions: nxproxy.MitmOptions{
+				InjectHeaders: map[string]string{"X-Inspected-By": "nx"},
+				BlockedUrls:   []string{"example.com/admin"},
+			},
+			Authority:   authority,
+			UpstreamTLS: &tls.Config{RootCAs: upstreamRoots},
+			OnHello: func(hello *nxproxy.ClientHello) error {
+				seenName = hello.ServerName
+				return nil
+			},
+		})
+	}()
+
+	tlsConn := tls.Client(appConn, &tls.Config{ServerName: "example.com", RootCAs: clientRoots})
+	reader := bufio.NewReader(tlsConn)
+
+	var get = func(path string) *http.Response {
+
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		if err := req.Write(tlsConn); err != nil {
+			t.Fatalf("write request: %v", err)
+		}
+
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+
+		return resp
+	}
+
+	resp := get("/hello")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "/hello" {
+		t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+
+	if val := resp.Header.Get("X-Seen-Header"); val != "nx" {
+		t.Errorf("header not injected: '%s'", val)
+	}
+
+	if seenName != "example.com" {
+		t.Errorf("unexpected server name: '%s'", seenName)
+	}
+
+	if resp := get("/admin/users"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("blocked url not refused: %d", resp.StatusCode)
+	}
+
+	if err := <-done; err != nxproxy.ErrUrlBlocked {
+		t.Errorf("unexpected intercept result: %v", err)
+	}
+}
+
+func TestSlot_MitmConfig(t *testing.T) {
+
+	var slot nxproxy.Slot
+
+	if _, ok := slot.MitmConfig(); ok {
+		t.Errorf("interception enabled by default")
+	}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Mitm: &nxproxy.MitmOptions{CaCert: "nope"}}); err == nil {
+		t.Errorf("invalid CA accepted")
+	}
+
+	caCert, caKey := testCA(t)
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Mitm: &nxproxy.MitmOptions{CaCert: caCert, CaKey: caKey}}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	if _, ok := slot.MitmConfig(); !ok || !slot.Info().Mitm {
+		t.Errorf("interception not enabled")
+	}
+}
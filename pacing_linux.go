@@ -0,0 +1,45 @@
+package nxproxy
+
+import (
+	"math"
+	"net"
+	"syscall"
+)
+
+// Not exported by the syscall package on all architectures; the value is the same for all supported ones
+const soMaxPacingRate = 0x2f
+
+// Sets SO_MAX_PACING_RATE on a TCP connection, letting the kernel pace outgoing data.
+// Rate is in bytes per second, zero removes the limit. Returns false if the rate can't be set
+func SetPacingRate(conn net.Conn, rate int) bool {
+
+	//	wrappers that count client traffic expose the underlying connection
+	if wrapper, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapper.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	//	the option is a u32 on older kernels, where ~0 means unlimited
+	val := int(int32(-1))
+	if rate > 0 {
+		val = min(rate, math.MaxInt32)
+	}
+
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soMaxPacingRate, val)
+	}); err != nil {
+		return false
+	}
+
+	return sockErr == nil
+}
@@ -0,0 +1,27 @@
+package nxproxy_test
+
+import (
+	"net"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Loopback traffic isn't necessarily paced by the kernel, so this only checks that the option gets applied
+func TestSetPacingRate(t *testing.T) {
+
+	tcpConn, _ := loopbackPair(t)
+
+	for _, rate := range []int{64_000, 1 << 40, 0} {
+		if !nxproxy.SetPacingRate(tcpConn, rate) {
+			t.Errorf("failed to set pacing rate %d", rate)
+		}
+	}
+
+	pipeConn, _ := net.Pipe()
+	defer pipeConn.Close()
+
+	if nxproxy.SetPacingRate(pipeConn, 64_000) {
+		t.Errorf("pacing rate set on a non-tcp connection")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package nxproxy
+
+import "net"
+
+// Kernel pacing is only available on Linux; connections are shaped in userspace elsewhere
+func SetPacingRate(conn net.Conn, rate int) bool {
+	return false
+}
@@ -3,6 +3,7 @@ package nxproxy
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
@@ -14,15 +15,21 @@ import (
 )
 
 var ErrTooManyConnections = errors.New("too many connections")
+var ErrConnIDsExhausted = errors.New("connection ids exhausted")
+var ErrPeerClosed = errors.New("peer closed")
 
 type PeerOptions struct {
 
 	//	unique peer ID used for accounting identification
 	ID uuid.UUID `json:"id"`
 
-	//	optional (not so) paasword auth data
+	//	optional (not so) paasword auth data; peers without one are anonymous
 	PasswordAuth *UserPassword `json:"password_auth"`
 
+	//	auth methods of the peer; password entries are used along with PasswordAuth, other types are kept for
+	//	nodes that support them. Peers with any entries here aren't anonymous, even when they have no password
+	Auth []PeerAuth `json:"auth,omitempty"`
+
 	//	maximal number of open connections
 	MaxConnections uint `json:"max_connections"`
 
@@ -32,8 +39,121 @@ type PeerOptions struct {
 	//	public ip to use for outbound connections, optional
 	FramedIP string `json:"framed_ip,omitempty"`
 
+	//	egress traffic gets translated to the framed ip further down the path, as with 1:1 NAT of cloud providers.
+	//	the framed ip is informational only then and isn't bound locally
+	EgressNat bool `json:"egress_nat,omitempty"`
+
+	//	lets destinations unreachable over the framed ip's address family be dialed over the other one
+	FamilyFallback bool `json:"family_fallback,omitempty"`
+
+	//	destination dial timings; slot defaults are used when unset
+	DialOptions
+
+	//	max data volume of a single connection per direction; unlimited when zero
+	SessionCapRx uint64 `json:"session_cap_rx,omitempty"`
+	SessionCapTx uint64 `json:"session_cap_tx,omitempty"`
+
+	//	number of redirects followed by the proxy itself for forwarded http requests.
+	//	redirect responses are passed to clients as they are when zero; http only
+	MaxRedirects uint `json:"max_redirects,omitempty"`
+
+	//	whether forwarded http responses may be decompressed by the proxy; http only
+	HttpCompression HttpCompression `json:"http_compression,omitempty"`
+
+	//	number of recently connected destinations to keep a pre-dialed spare connection for; socks only
+	UpstreamPool uint `json:"upstream_pool,omitempty"`
+
+	//	JA3 or JA4 fingerprints of clients allowed to open TLS tunnels; any client is allowed when empty
+	TlsFingerprints []string `json:"tls_fingerprints,omitempty"`
+
 	//	used to disable a peer without completely removing it
 	Disabled bool `json:"disabled"`
+
+	//	stops the peer from opening new sessions while letting the open ones finish, unlike Disabled that drops them
+	Paused bool `json:"paused,omitempty"`
+
+	//	scheduled disable; once reached, the peer is treated the same as with Disabled set
+	DisableAt *time.Time `json:"disable_at,omitempty"`
+
+	//	time of the latest node-side disable the backend has acknowledged, as reported in PeerDisable.
+	//	peers disabled by the node stay disabled until it's sent, after which Disabled takes effect again
+	DisableAck *time.Time `json:"disable_ack,omitempty"`
+}
+
+// Returns the framed ip that outbound connections have to be bound to; empty when it's unset or NATed
+func (opts *PeerOptions) LocalFramedIP() string {
+
+	if opts.EgressNat {
+		return ""
+	}
+
+	return opts.FramedIP
+}
+
+// Checks whether the peer may open new sessions; neither disabled, paused nor expired peers may
+func (opts *PeerOptions) AcceptsSessions() bool {
+	return !opts.Disabled && !opts.Paused && !opts.DisableDue(time.Now())
+}
+
+// Checks whether the scheduled disable time has been reached
+func (opts *PeerOptions) DisableDue(now time.Time) bool {
+	return opts.DisableAt != nil && !now.Before(*opts.DisableAt)
+}
+
+// Returns the scheduled disable time if it's due within the period
+func (opts *PeerOptions) DisableWithin(period time.Duration, now time.Time) (time.Time, bool) {
+
+	if opts.DisableAt == nil || period <= 0 {
+		return time.Time{}, false
+	}
+
+	return *opts.DisableAt, opts.DisableAt.Sub(now) <= period
+}
+
+// Returns how long before scheduled peer disables they're announced to clients
+func (opts *SlotOptions) ExpiryWarning() time.Duration {
+	return time.Duration(opts.ExpiryWarningMin) * time.Minute
+}
+
+// Destination dial timings in milliseconds; zero values fall back to the defaults
+type DialOptions struct {
+	DialTimeoutMs uint `json:"dial_timeout_ms,omitempty"`
+	KeepAliveMs   uint `json:"keepalive_ms,omitempty"`
+}
+
+const DefaultDialTimeout = 30 * time.Second
+const DefaultDialKeepAlive = 30 * time.Second
+
+// Fills unset timings from the fallback options
+func (opts DialOptions) Or(fallback DialOptions) DialOptions {
+
+	if opts.DialTimeoutMs == 0 {
+		opts.DialTimeoutMs = fallback.DialTimeoutMs
+	}
+
+	if opts.KeepAliveMs == 0 {
+		opts.KeepAliveMs = fallback.KeepAliveMs
+	}
+
+	return opts
+}
+
+func (opts DialOptions) Timeout() time.Duration {
+
+	if opts.DialTimeoutMs == 0 {
+		return DefaultDialTimeout
+	}
+
+	return time.Duration(opts.DialTimeoutMs) * time.Millisecond
+}
+
+func (opts DialOptions) KeepAlive() time.Duration {
+
+	if opts.KeepAliveMs == 0 {
+		return DefaultDialKeepAlive
+	}
+
+	return time.Duration(opts.KeepAliveMs) * time.Millisecond
 }
 
 type UserPassword struct {
@@ -41,13 +161,27 @@ type UserPassword struct {
 	Password string `json:"password"`
 }
 
+type HttpCompression string
+
+func (val HttpCompression) Valid() bool {
+	return val == "" || val == HttpCompressionAuto || val == HttpCompressionPassthrough
+}
+
+const (
+	//	the default; requests without Accept-Encoding ask for gzip and get decompressed responses, as with the go http client
+	HttpCompressionAuto = HttpCompression("auto")
+
+	//	requests and responses are passed as they are, so that clients get byte-exact response bodies
+	HttpCompressionPassthrough = HttpCompression("passthrough")
+)
+
 type PeerBandwidth struct {
 
-	//	total connection bandwidth for up/down streams
+	//	total connection bandwidth for up/down streams, bytes per second
 	Rx uint32 `json:"rx"`
 	Tx uint32 `json:"tx"`
 
-	//	respective minimal speed per connection
+	//	respective minimal speed per connection, bytes per second
 	MinRx uint32 `json:"min_rx"`
 	MinTx uint32 `json:"min_tx"`
 }
@@ -57,23 +191,106 @@ type PeerDelta struct {
 	//	unique peer ID
 	ID uuid.UUID `json:"id"`
 
+	//	slot the traffic went through, as returned by SlotOptions.Handle, along with its id and protocol.
+	//	peers may be served by multiple slots, whose deltas are kept apart
+	Slot   string     `json:"slot,omitempty"`
+	SlotID string     `json:"slot_id,omitempty"`
+	Proto  ProxyProto `json:"proto,omitempty"`
+
 	//	data transferred
 	Rx uint64 `json:"rx"`
 	Tx uint64 `json:"tx"`
 }
 
+// Sums up deltas that belong to the same peer and slot
+func MergePeerDeltas(deltas []PeerDelta) []PeerDelta {
+
+	type deltaKey struct {
+		id   uuid.UUID
+		slot string
+	}
+
+	peerMap := map[deltaKey]*PeerDelta{}
+
+	for _, delta := range deltas {
+
+		key := deltaKey{id: delta.ID, slot: delta.Slot}
+
+		entry := peerMap[key]
+		if entry == nil {
+			entry = &delta
+			peerMap[key] = entry
+		} else {
+			entry.Rx += delta.Rx
+			entry.Tx += delta.Tx
+		}
+	}
+
+	var entries []PeerDelta
+	for _, val := range peerMap {
+		entries = append(entries, *val)
+	}
+
+	return entries
+}
+
+// Default number of entries a node keeps in its delta queue before summarizing them
+const DefaultDeltaQueueCap = 100_000
+
+// Keeps merged deltas within a number of entries. Once there are more entries than that, per-slot deltas are
+// collapsed into a single entry for each peer, without a slot, so that the traffic is still accounted for.
+// Returns the resulting deltas along with the number of entries that have been summarized; the result may still
+// exceed the cap when there are more peers than that, as peer totals are never dropped
+func CapPeerDeltas(deltas []PeerDelta, limit int) ([]PeerDelta, int) {
+
+	if limit <= 0 || len(deltas) <= limit {
+		return deltas, 0
+	}
+
+	var summarized int
+
+	peerMap := map[uuid.UUID]*PeerDelta{}
+
+	for _, delta := range deltas {
+
+		if delta.Slot != "" {
+			summarized++
+		}
+
+		entry := peerMap[delta.ID]
+		if entry == nil {
+			peerMap[delta.ID] = &PeerDelta{ID: delta.ID, Rx: delta.Rx, Tx: delta.Tx}
+		} else {
+			entry.Rx += delta.Rx
+			entry.Tx += delta.Tx
+		}
+	}
+
+	entries := make([]PeerDelta, 0, len(peerMap))
+	for _, val := range peerMap {
+		entries = append(entries, *val)
+	}
+
+	return entries, summarized
+}
+
 func (peer *PeerOptions) CmpCredentials(other PeerOptions) bool {
 
 	if peer.ID != other.ID {
 		return false
 	}
 
-	if auth := peer.PasswordAuth; auth != nil && other.PasswordAuth != nil {
-		return auth.User == other.PasswordAuth.User &&
-			auth.Password == other.PasswordAuth.Password
+	if !sameExtraAuth(peer.Auth, other.Auth) {
+		return false
+	}
+
+	if auth, otherAuth := peer.Password(), other.Password(); auth != nil && otherAuth != nil {
+		return auth.User == otherAuth.User &&
+			auth.Password == otherAuth.Password
 	}
 
-	return false
+	//	neither peer has a password
+	return peer.Password() == nil && other.Password() == nil
 }
 
 func (peer *PeerOptions) DisplayName() string {
@@ -88,17 +305,56 @@ func (peer *PeerOptions) DisplayName() string {
 type Peer struct {
 	PeerOptions
 
+	//	slot that has imported the peer; used to tag peer log lines and metrics
+	Slot SlotIdentity
+
 	BaseContext context.Context
 	Dialer      net.Dialer
 	HttpClient  *http.Client
 
+	//	used in place of the dialer when set; see SlotEnv.Dial
+	DialFunc DialFunc
+
 	DeltaRx atomic.Uint64
 	DeltaTx atomic.Uint64
 
+	//	optional per-second usage samples
+	Usage *UsageRing
+
+	//	optional node-wide egress limit
+	Egress *TokenBucket
+
+	//	let the kernel enforce connection bandwidth where possible
+	KernelPacing bool
+
+	//	time given to the remaining direction of a broken tunnel to flush its data
+	BridgeLinger time.Duration
+
+	//	refuse dials while the framed IP is unavailable instead of using the default source address
+	StrictFramedIP bool
+
+	failures peerFailures
+	latency  peerLatency
+	activity peerActivity
+	leak     peerLeakCheck
+	local    peerLocalDisable
+	upstream upstreamPool
+	capture  atomic.Pointer[PeerCapture]
+	framed   peerFramedIP
+	totals   peerTotals
+
 	nextConnID    uint64
 	connMap       map[uint64]*PeerConnection
 	mtx           sync.Mutex
 	refreshActive atomic.Bool
+
+	//	wakes up the refresh routine to apply bandwidth changes right away
+	rebalance chan struct{}
+
+	//	set once the peer is removed; closeCh stops the refresh routine, which closes refreshDone on exit
+	closed      bool
+	closeCh     chan struct{}
+	refreshDone chan struct{}
 }
 
 func (peer *Peer) Connection() (*PeerConnection, error) {
@@ -110,35 +366,36 @@ func (peer *Peer) Connection() (*PeerConnection, error) {
 		peer.connMap = map[uint64]*PeerConnection{}
 	}
 
-	if peer.refreshActive.CompareAndSwap(false, true) {
-		go peer.refresh()
+	if peer.closed {
+		return nil, ErrPeerClosed
 	}
 
-	if peer.MaxConnections > 0 && len(peer.connMap) > int(peer.MaxConnections) {
-		return nil, ErrTooManyConnections
+	if peer.rebalance == nil {
+		peer.rebalance = make(chan struct{}, 1)
 	}
 
-	var pickNextId = func() (uint64, error) {
-
-		if peer.nextConnID < math.MaxInt64 {
-			peer.nextConnID++
-			return peer.nextConnID, nil
-		}
+	if peer.closeCh == nil {
+		peer.closeCh = make(chan struct{})
+	}
 
-		for idx := range math.MaxInt64 {
-			if _, has := peer.connMap[uint64(idx)]; !has {
-				return uint64(idx), nil
-			}
-		}
+	if peer.refreshActive.CompareAndSwap(false, true) {
+		peer.refreshDone = make(chan struct{})
+		go peer.refresh(peer.closeCh, peer.refreshDone)
+	}
 
-		return 0, ErrTooManyConnections
+	if peer.MaxConnections > 0 && len(peer.connMap) > int(peer.MaxConnections) {
+		return nil, ErrTooManyConnections
 	}
 
-	nextID, err := pickNextId()
-	if err != nil {
-		return nil, err
+	//	connection ids are never reused, so that they stay unique in logs and captures over the peer lifetime.
+	//	running out of them takes centuries of non-stop connecting, but the counter still mustn't wrap around
+	if peer.nextConnID == math.MaxUint64 {
+		return nil, ErrConnIDsExhausted
 	}
 
+	peer.nextConnID++
+	nextID := peer.nextConnID
+
 	bandwidth := peer.Bandwidth
 
 	var baseBandwidth = func(base uint32, min uint32) (val atomic.Uint32) {
@@ -158,9 +415,23 @@ func (peer *Peer) Connection() (*PeerConnection, error) {
 	}
 
 	conn := PeerConnection{
-		id:     nextID,
-		bandRx: baseBandwidth(bandwidth.Rx, bandwidth.MinRx),
-		bandTx: baseBandwidth(bandwidth.Tx, bandwidth.MinTx),
+		id:      nextID,
+		bandRx:  baseBandwidth(bandwidth.Rx, bandwidth.MinRx),
+		bandTx:  baseBandwidth(bandwidth.Tx, bandwidth.MinTx),
+		capture: &peer.capture,
+		capRx:   peer.SessionCapRx,
+		capTx:   peer.SessionCapTx,
+		egress:  peer.Egress,
+		pacing:  peer.KernelPacing,
+		linger:  peer.BridgeLinger,
+		counted: true,
+
+		onUpstreamError: peer.ReportUpstreamError,
+
+		logger: slog.Default().With(
+			slog.String("peer", peer.DisplayName()),
+			slog.Any("slot", peer.Slot),
+			slog.Uint64("conn_id", nextID)),
 	}
 
 	baseCtx := peer.BaseContext
@@ -171,34 +442,41 @@ func (peer *Peer) Connection() (*PeerConnection, error) {
 	conn.ctx, conn.cancelFn = context.WithCancel(baseCtx)
 
 	peer.connMap[nextID] = &conn
+	liveConnections.Add(1)
 
 	return &conn, nil
 }
 
-func (peer *Peer) refresh() {
+func (peer *Peer) refresh(closeCh <-chan struct{}, done chan<- struct{}) {
 
 	ticker := time.NewTicker(time.Second)
 
 	defer func() {
 		ticker.Stop()
 		peer.refreshActive.Store(false)
+		close(done)
 	}()
 
-	//	removes all closed connections and returns a list of remaining ones
-	var connCleanup = func() []*PeerConnection {
+	//	removes all closed connections and returns a list of remaining ones along with the data volume of removed ones
+	var connCleanup = func() ([]*PeerConnection, uint64, uint64) {
 
 		peer.mtx.Lock()
 		defer peer.mtx.Unlock()
 
 		var entries []*PeerConnection
+		var closedRx, closedTx uint64
 
 		for key, conn := range peer.connMap {
 
 			if conn.ctx.Err() != nil {
 
 				//	copy data volume back to the peer
-				peer.DeltaRx.Add(conn.deltaRx.Load())
-				peer.DeltaTx.Add(conn.deltaTx.Load())
+				rx, tx := conn.deltaRx.Load(), conn.deltaTx.Load()
+				peer.DeltaRx.Add(rx)
+				peer.DeltaTx.Add(tx)
+
+				closedRx += rx
+				closedTx += tx
 
 				//	and nuke the connection entirely
 				delete(peer.connMap, key)
@@ -208,26 +486,76 @@ func (peer *Peer) refresh() {
 			entries = append(entries, conn)
 		}
 
-		return entries
+		return entries, closedRx, closedTx
 	}
 
-	var slurpDeltas = func(entries []*PeerConnection) {
+	var slurpDeltas = func(entries []*PeerConnection) (uint64, uint64) {
+
+		var totalRx, totalTx uint64
+
 		for _, conn := range entries {
-			peer.DeltaRx.Add(conn.deltaRx.Swap(0))
-			peer.DeltaTx.Add(conn.deltaTx.Swap(0))
+
+			rx, tx := conn.deltaRx.Swap(0), conn.deltaTx.Swap(0)
+			peer.DeltaRx.Add(rx)
+			peer.DeltaTx.Add(tx)
+
+			totalRx += rx
+			totalTx += tx
 		}
+
+		return totalRx, totalTx
 	}
 
 	//	should prevent early exits in some conditions
 	var lastNconn int
 
+	//	data volume collected by out of schedule rebalances that goes into the next usage sample
+	var pendingRx, pendingTx uint64
+
 	for peer.refreshActive.Load() {
 
-		<-ticker.C
+		var now time.Time
+		var scheduled bool
 
-		conns := connCleanup()
+		select {
+		case now = <-ticker.C:
+			scheduled = true
+		case <-peer.rebalance:
+			now = time.Now()
+		case <-closeCh:
+			return
+		}
+
+		conns, closedRx, closedTx := connCleanup()
+
+		//	scheduled disables take effect on open connections as well
+		if len(conns) > 0 && peer.DisableDue(now) {
+			slog.Info("Peer scheduled disable reached; Dropping connections",
+				slog.String("id", peer.ID.String()),
+				slog.String("name", peer.DisplayName()),
+				slog.Any("slot", peer.Slot),
+				slog.Int("connections", len(conns)))
+			peer.CloseConnections()
+		}
 		RedistributePeerBandwidth(conns, peer.Bandwidth)
-		slurpDeltas(conns)
+		rx, tx := slurpDeltas(conns)
+
+		pendingRx += closedRx + rx
+		pendingTx += closedTx + tx
+
+		if !scheduled {
+			continue
+		}
+
+		if peer.Usage != nil {
+			peer.Usage.Push(UsageSample{
+				Time: now,
+				Rx:   pendingRx,
+				Tx:   pendingTx,
+			})
+		}
+
+		pendingRx, pendingTx = 0, 0
 
 		//	check if have any other connections left, and if not - exit routine
 		if max(len(conns), lastNconn) < 1 {
@@ -238,6 +566,23 @@ func (peer *Peer) refresh() {
 	}
 }
 
+// Recomputes connection bandwidth without waiting for the next refresh, so that plan changes apply to open connections right away
+func (peer *Peer) RebalanceBandwidth() {
+
+	peer.mtx.Lock()
+	rebalance := peer.rebalance
+	peer.mtx.Unlock()
+
+	if rebalance == nil {
+		return
+	}
+
+	select {
+	case rebalance <- struct{}{}:
+	default:
+	}
+}
+
 func (peer *Peer) ConnectionList() []*PeerConnection {
 
 	peer.mtx.Lock()
@@ -251,6 +596,22 @@ func (peer *Peer) ConnectionList() []*PeerConnection {
 	return entries
 }
 
+// Returns the number of connections that haven't been closed yet
+func (peer *Peer) ActiveConnections() int {
+
+	peer.mtx.Lock()
+	defer peer.mtx.Unlock()
+
+	var n int
+	for _, conn := range peer.connMap {
+		if conn.ctx.Err() == nil {
+			n++
+		}
+	}
+
+	return n
+}
+
 func (peer *Peer) CloseConnections() {
 
 	peer.mtx.Lock()
@@ -261,22 +622,56 @@ func (peer *Peer) CloseConnections() {
 		peer.HttpClient.CloseIdleConnections()
 	}
 
+	peer.upstream.drain()
+
 	for key, conn := range peer.connMap {
 
 		conn.Close()
 
-		peer.DeltaRx.Add(conn.deltaRx.Load())
-		peer.DeltaTx.Add(conn.deltaTx.Load())
+		//	swapped rather than loaded as the refresh routine may be collecting them at the same time
+		peer.DeltaRx.Add(conn.deltaRx.Swap(0))
+		peer.DeltaTx.Add(conn.deltaTx.Swap(0))
 
 		delete(peer.connMap, key)
 	}
 }
 
+// Closes peer connections for good and stops its refresh routine. Returns the data volume that hasn't been collected yet,
+// including the one harvested by a refresh cycle that was running at the moment. The peer can't open new connections afterwards
+func (peer *Peer) Close() (PeerDelta, bool) {
+
+	peer.mtx.Lock()
+
+	if !peer.closed {
+
+		peer.closed = true
+
+		if peer.closeCh != nil {
+			close(peer.closeCh)
+		}
+	}
+
+	refreshDone := peer.refreshDone
+	peer.mtx.Unlock()
+
+	//	a refresh cycle moves connection deltas into the peer, so it has to be over before the final delta is taken
+	if refreshDone != nil {
+		<-refreshDone
+	}
+
+	peer.CloseConnections()
+
+	return peer.Delta()
+}
+
 func (peer *Peer) Delta() (PeerDelta, bool) {
 
 	rx := peer.DeltaRx.Swap(0)
 	tx := peer.DeltaTx.Swap(0)
 
+	peer.totals.rx.Add(rx)
+	peer.totals.tx.Add(tx)
+
 	if rx > 0 || tx > 0 {
 		return PeerDelta{
 			ID: peer.ID,
@@ -0,0 +1,72 @@
+package nxproxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account details of a peer as shown to its own users. Usage only covers the time the node has been serving the peer,
+// as reported traffic is handed over to the auth backend, which is the one keeping the full history
+type PeerAccount struct {
+	ID   uuid.UUID `json:"id"`
+	User string    `json:"user,omitempty"`
+
+	//	data transferred since the node has started serving the peer
+	UsageRx    uint64     `json:"usage_rx"`
+	UsageTx    uint64     `json:"usage_tx"`
+	UsageSince *time.Time `json:"usage_since,omitempty"`
+
+	Connections    int           `json:"connections"`
+	MaxConnections uint          `json:"max_connections"`
+	Bandwidth      PeerBandwidth `json:"bandwidth"`
+	SessionCapRx   uint64        `json:"session_cap_rx,omitempty"`
+	SessionCapTx   uint64        `json:"session_cap_tx,omitempty"`
+
+	Disabled  bool       `json:"disabled"`
+	Paused    bool       `json:"paused"`
+	DisableAt *time.Time `json:"disable_at,omitempty"`
+}
+
+// Keeps the data volume that's already been handed over in deltas
+type peerTotals struct {
+	since time.Time
+	rx    atomic.Uint64
+	tx    atomic.Uint64
+}
+
+func (peer *Peer) Account() PeerAccount {
+
+	account := PeerAccount{
+		ID:             peer.ID,
+		Connections:    peer.ActiveConnections(),
+		MaxConnections: peer.MaxConnections,
+		Bandwidth:      peer.Bandwidth,
+		SessionCapRx:   peer.SessionCapRx,
+		SessionCapTx:   peer.SessionCapTx,
+		Disabled:       peer.Disabled || peer.DisableDue(time.Now()),
+		Paused:         peer.Paused,
+		DisableAt:      peer.DisableAt,
+	}
+
+	if peer.PasswordAuth != nil {
+		account.User = peer.PasswordAuth.User
+	}
+
+	if !peer.totals.since.IsZero() {
+		since := peer.totals.since
+		account.UsageSince = &since
+	}
+
+	//	the figures may be off by a refresh cycle moving connection deltas at the same time, which is fine for a rough summary
+	account.UsageRx = peer.totals.rx.Load() + peer.DeltaRx.Load()
+	account.UsageTx = peer.totals.tx.Load() + peer.DeltaTx.Load()
+
+	for _, conn := range peer.ConnectionList() {
+		account.UsageRx += conn.deltaRx.Load()
+		account.UsageTx += conn.deltaTx.Load()
+	}
+
+	return account
+}
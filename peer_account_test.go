@@ -0,0 +1,24 @@
+package nxproxy_test
+
+import (
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_AccountUsage(t *testing.T) {
+
+	var peer nxproxy.Peer
+
+	peer.DeltaRx.Add(100)
+	peer.DeltaTx.Add(10)
+
+	//	reported volume still counts towards the usage
+	peer.Delta()
+
+	peer.DeltaRx.Add(50)
+
+	if account := peer.Account(); account.UsageRx != 150 || account.UsageTx != 10 {
+		t.Errorf("unexpected usage: rx=%d tx=%d", account.UsageRx, account.UsageTx)
+	}
+}
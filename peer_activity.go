@@ -0,0 +1,123 @@
+package nxproxy
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type RequestKind string
+
+const (
+	RequestHttpForward  = RequestKind("http_forward")
+	RequestHttpConnect  = RequestKind("http_connect")
+	RequestReverse      = RequestKind("reverse")
+	RequestSocksConnect = RequestKind("socks_connect")
+	RequestSocksBind    = RequestKind("socks_bind")
+	RequestSocksUdp     = RequestKind("socks_udp")
+	RequestSocksOther   = RequestKind("socks_other")
+)
+
+// Destination ports that regular browsing and apps connect to; anything else is counted as unusual
+var CommonPorts = []uint16{80, 443, 853, 993, 995, 465, 587, 5222, 5223, 5228, 8080, 8443}
+
+// Only this many distinct unusual ports are tracked per report; the rest are counted under PortOther
+const maxUnusualPorts = 8
+
+const PortOther = "other"
+
+// Summary of the kinds of requests a peer made since the previous status report.
+// Shifts in it, like a browsing peer suddenly connecting to mail ports, may indicate leaked credentials
+type PeerActivity struct {
+	ID uuid.UUID `json:"id"`
+
+	Requests map[RequestKind]uint64 `json:"requests"`
+
+	//	connections to ports outside of CommonPorts by port number
+	UnusualPorts map[string]uint64 `json:"unusual_ports,omitempty"`
+}
+
+type peerActivity struct {
+	val PeerActivity
+	mtx sync.Mutex
+}
+
+func (pa *peerActivity) record(kind RequestKind, port uint16) {
+
+	pa.mtx.Lock()
+	defer pa.mtx.Unlock()
+
+	if pa.val.Requests == nil {
+		pa.val.Requests = map[RequestKind]uint64{}
+	}
+
+	pa.val.Requests[kind]++
+
+	if port == 0 || slices.Contains(CommonPorts, port) {
+		return
+	}
+
+	if pa.val.UnusualPorts == nil {
+		pa.val.UnusualPorts = map[string]uint64{}
+	}
+
+	key := strconv.Itoa(int(port))
+	if _, has := pa.val.UnusualPorts[key]; !has && len(pa.val.UnusualPorts) >= maxUnusualPorts {
+		key = PortOther
+	}
+
+	pa.val.UnusualPorts[key]++
+}
+
+func (pa *peerActivity) take() PeerActivity {
+
+	pa.mtx.Lock()
+	defer pa.mtx.Unlock()
+
+	val := pa.val
+	pa.val = PeerActivity{}
+
+	return val
+}
+
+// Records a client request. The destination port is taken from the address when it has one
+func (peer *Peer) RecordRequest(kind RequestKind, dest string) {
+
+	var port uint16
+	if _, val, err := net.SplitHostPort(dest); err == nil {
+		if num, err := strconv.ParseUint(val, 10, 16); err == nil {
+			port = uint16(num)
+		}
+	}
+
+	peer.activity.record(kind, port)
+}
+
+// Returns requests recorded since the previous call, if there were any
+func (peer *Peer) Activity() (PeerActivity, bool) {
+
+	val := peer.activity.take()
+	val.ID = peer.ID
+
+	return val, len(val.Requests) > 0
+}
+
+// Returns peer activity summaries accumulated since the previous call
+func (slot *Slot) Activity() []PeerActivity {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	var entries []PeerActivity
+
+	for _, peer := range slot.peerMap {
+		if val, has := peer.Activity(); has {
+			entries = append(entries, val)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,61 @@
+package nxproxy_test
+
+import (
+	"fmt"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_Activity(t *testing.T) {
+
+	peer := &nxproxy.Peer{}
+
+	if _, has := peer.Activity(); has {
+		t.Fatalf("activity reported without any requests")
+	}
+
+	peer.RecordRequest(nxproxy.RequestHttpForward, "example.com")
+	peer.RecordRequest(nxproxy.RequestHttpConnect, "example.com:443")
+	peer.RecordRequest(nxproxy.RequestSocksConnect, "203.0.113.7:25")
+	peer.RecordRequest(nxproxy.RequestSocksConnect, "203.0.113.8:25")
+
+	for port := range 10 {
+		peer.RecordRequest(nxproxy.RequestSocksUdp, fmt.Sprintf("203.0.113.7:%d", 10000+port))
+	}
+
+	summary, has := peer.Activity()
+	if !has {
+		t.Fatalf("activity not reported")
+	}
+
+	expectRequests := map[nxproxy.RequestKind]uint64{
+		nxproxy.RequestHttpForward:  1,
+		nxproxy.RequestHttpConnect:  1,
+		nxproxy.RequestSocksConnect: 2,
+		nxproxy.RequestSocksUdp:     10,
+	}
+
+	for kind, count := range expectRequests {
+		if summary.Requests[kind] != count {
+			t.Errorf("%s: expected %d requests, got %d", kind, count, summary.Requests[kind])
+		}
+	}
+
+	if summary.UnusualPorts["25"] != 2 {
+		t.Errorf("expected 2 connections to port 25, got %v", summary.UnusualPorts)
+	}
+
+	if _, has := summary.UnusualPorts["443"]; has {
+		t.Errorf("common port counted as unusual")
+	}
+
+	//	port 25 takes one of the tracked entries, so 3 out of the 10 udp ports overflow
+	if summary.UnusualPorts[nxproxy.PortOther] != 3 {
+		t.Errorf("expected 3 overflowing ports, got %v", summary.UnusualPorts)
+	}
+
+	if _, has := peer.Activity(); has {
+		t.Errorf("activity summary not reset")
+	}
+}
@@ -0,0 +1,157 @@
+package nxproxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type PeerAuthType string
+
+const (
+	PeerAuthPassword    = PeerAuthType("password")
+	PeerAuthSourceIP    = PeerAuthType("source_ip")
+	PeerAuthClientCert  = PeerAuthType("client_cert")
+	PeerAuthShadowsocks = PeerAuthType("shadowsocks")
+)
+
+// A single way for a peer to authenticate, discriminated by its type. Only the fields of the type are used
+type PeerAuth struct {
+	Type PeerAuthType `json:"type"`
+
+	//	password
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	//	source_ip: client addresses or CIDRs that are let in without credentials
+	SourceIPs []string `json:"source_ips,omitempty"`
+
+	//	client_cert: hex-encoded sha-256 fingerprint of the client certificate
+	CertSha256 string `json:"cert_sha256,omitempty"`
+
+	//	shadowsocks: AEAD cipher name and key
+	Cipher string `json:"cipher,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// Checks the fields of known auth types. Unknown types pass, so that configs meant for newer nodes don't get rejected
+func (auth *PeerAuth) Validate() error {
+
+	switch auth.Type {
+
+	case PeerAuthPassword:
+		if _, err := NormalizeUsername(auth.User); err != nil {
+			return err
+		}
+
+	case PeerAuthSourceIP:
+		if len(auth.SourceIPs) == 0 {
+			return errors.New("no source ips")
+		} else if _, err := ParsePrefixList(auth.SourceIPs); err != nil {
+			return err
+		}
+
+	case PeerAuthClientCert:
+		if val, err := hex.DecodeString(auth.CertSha256); err != nil || len(val) != 32 {
+			return errors.New("cert fingerprint must be a hex-encoded sha-256 hash")
+		}
+
+	case PeerAuthShadowsocks:
+		if auth.Cipher == "" || auth.Key == "" {
+			return errors.New("cipher or key missing")
+		}
+
+	case "":
+		return errors.New("type missing")
+	}
+
+	return nil
+}
+
+// Returns the password credentials of a peer, taken from PasswordAuth or the first password entry of Auth
+func (opts *PeerOptions) Password() *UserPassword {
+
+	if opts.PasswordAuth != nil {
+		return opts.PasswordAuth
+	}
+
+	for _, entry := range opts.Auth {
+		if entry.Type == PeerAuthPassword {
+			return &UserPassword{User: entry.User, Password: entry.Password}
+		}
+	}
+
+	return nil
+}
+
+// Peers without any auth methods are anonymous; peers that only have methods the node doesn't support aren't,
+// they just can't be authenticated
+func (opts *PeerOptions) Anonymous() bool {
+	return opts.PasswordAuth == nil && len(opts.Auth) == 0
+}
+
+// Returns auth entries that the node won't use: password entries after the first one and entries of other types,
+// which are only kept for nodes that support them
+func (opts *PeerOptions) UnusedAuth() []PeerAuth {
+
+	var entries []PeerAuth
+	hasPassword := opts.PasswordAuth != nil
+
+	for _, entry := range opts.Auth {
+
+		if entry.Type == PeerAuthPassword && !hasPassword {
+			hasPassword = true
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Fills in PasswordAuth from Auth, since that's what the rest of the node works with
+func (opts *PeerOptions) normalizeAuth() error {
+
+	for idx, entry := range opts.Auth {
+		if err := entry.Validate(); err != nil {
+			return fmt.Errorf("auth: entry %d: %v", idx, err)
+		}
+	}
+
+	password := opts.Password()
+	if password == nil {
+		return nil
+	}
+
+	//	the first password entry is the one that's used, so it has to match the legacy field if that's set as well
+	for _, entry := range opts.Auth {
+		if entry.Type == PeerAuthPassword {
+			if entry.User != password.User || entry.Password != password.Password {
+				return errors.New("auth: password entry doesn't match password auth")
+			}
+			break
+		}
+	}
+
+	opts.PasswordAuth = password
+
+	return nil
+}
+
+// Compares auth entries other than the password ones, which are compared by their effective values
+func sameExtraAuth(a []PeerAuth, b []PeerAuth) bool {
+
+	var extra = func(entries []PeerAuth) []PeerAuth {
+		var result []PeerAuth
+		for _, entry := range entries {
+			if entry.Type != PeerAuthPassword {
+				result = append(result, entry)
+			}
+		}
+		return result
+	}
+
+	return reflect.DeepEqual(extra(a), extra(b))
+}
@@ -0,0 +1,71 @@
+package nxproxy_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_SetPeersAuth(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	report := slot.SetPeers([]nxproxy.PeerOptions{
+		{ID: uuid.New(), Auth: []nxproxy.PeerAuth{
+			{Type: nxproxy.PeerAuthPassword, User: "maddsua", Password: "1"},
+			{Type: "future_method"},
+		}},
+		{ID: uuid.New(), Auth: []nxproxy.PeerAuth{
+			{Type: nxproxy.PeerAuthSourceIP, SourceIPs: []string{"203.0.113.0/24"}},
+		}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "other", Password: "1"}, Auth: []nxproxy.PeerAuth{
+			{Type: nxproxy.PeerAuthPassword, User: "other", Password: "2"},
+		}},
+		{ID: uuid.New(), Auth: []nxproxy.PeerAuth{
+			{Type: nxproxy.PeerAuthClientCert, CertSha256: "nope"},
+		}},
+	})
+
+	if report.Peers != 2 {
+		t.Errorf("expected 2 accepted peers, got %d", report.Peers)
+	}
+
+	if len(report.Rejected) != 2 {
+		t.Errorf("expected a conflicting password and an invalid cert to be rejected, got %v", report.Rejected)
+	}
+
+	if len(report.Warnings) != 2 {
+		t.Errorf("expected unused auth warnings, got %v", report.Warnings)
+	}
+
+	if _, err := slot.LookupWithPassword(net.IPv4(127, 0, 0, 1), "maddsua", "1"); err != nil {
+		t.Errorf("password entry not used: %v", err)
+	}
+
+	//	the source ip peer isn't supported yet, but it must not let anyone in either
+	if _, err := slot.LookupAnonymous(); err == nil {
+		t.Errorf("peer with auth methods used as anonymous")
+	}
+}
+
+func TestPeerOptions_CmpCredentials(t *testing.T) {
+
+	legacy := nxproxy.PeerOptions{PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}}
+	typed := nxproxy.PeerOptions{Auth: []nxproxy.PeerAuth{{Type: nxproxy.PeerAuthPassword, User: "maddsua", Password: "1"}}}
+
+	if !legacy.CmpCredentials(typed) {
+		t.Errorf("moving a password into auth entries counted as a rotation")
+	}
+
+	typed.Auth = append(typed.Auth, nxproxy.PeerAuth{Type: nxproxy.PeerAuthSourceIP, SourceIPs: []string{"203.0.113.7"}})
+
+	if legacy.CmpCredentials(typed) {
+		t.Errorf("added auth method not counted as a rotation")
+	}
+}
@@ -2,83 +2,104 @@ package nxproxy
 
 import "time"
 
+// Connections using at least this fraction of their base share are considered saturated
+const bandwidthSaturationRatio = 0.9
+
+// Splits peer bandwidth between its connections.
+//
+// Every connection is entitled to a base share of the peer's bandwidth proportional to its weight.
+// Connections are judged by their data rate measured over the window since the previous redistribution:
+// the ones using less than their base share give the difference away,
+// which then gets split between the saturated ones, again by weight.
+//
+// This provides the following properties:
+//   - no connection gets less than its base share or the peer's min rate,
+//     so an idle connection can always get back to its fair share within one window;
+//   - saturated connections get extra shares proportional to their weights, regardless of what they were allowed before;
+//   - measured rates of unsaturated connections plus allotments of saturated ones never exceed peer bandwidth,
+//     unless min rates require more;
+//   - results depend on measured rates only, not on the window length.
 func RedistributePeerBandwidth(conns []*PeerConnection, bandwidth PeerBandwidth) {
+	RedistributePeerBandwidthAt(conns, bandwidth, time.Now())
+}
 
-	var getBaseBandwidth = func(val uint32) uint32 {
+// Same as RedistributePeerBandwidth, with rates measured over the window ending at the given time
+func RedistributePeerBandwidthAt(conns []*PeerConnection, bandwidth PeerBandwidth, now time.Time) {
 
-		if n := len(conns); n > 1 {
-			return val / uint32(n)
-		}
+	ratesRx := make([]float64, len(conns))
+	ratesTx := make([]float64, len(conns))
+	weights := make([]uint32, len(conns))
 
-		return val
-	}
+	for idx, conn := range conns {
 
-	var equivalentBandwidth = func(base uint32, updatedAt time.Time) uint64 {
-
-		if !updatedAt.IsZero() {
-			if elapsed := time.Since(updatedAt); elapsed > time.Second {
-				return uint64(elapsed.Seconds() * float64(base))
+		//	first measurement of a connection is assumed to cover one refresh interval
+		window := time.Second
+		if !conn.updated.IsZero() {
+			if elapsed := now.Sub(conn.updated); elapsed > 0 {
+				window = elapsed
 			}
 		}
 
-		return uint64(base)
+		ratesRx[idx] = float64(conn.deltaRx.Load()) / window.Seconds()
+		ratesTx[idx] = float64(conn.deltaTx.Load()) / window.Seconds()
+		weights[idx] = conn.Weight()
+
+		conn.updated = now
 	}
 
-	baseRx := getBaseBandwidth(bandwidth.Rx)
-	baseTx := getBaseBandwidth(bandwidth.Tx)
+	bandRx := splitBandwidth(bandwidth.Rx, bandwidth.MinRx, ratesRx, weights)
+	bandTx := splitBandwidth(bandwidth.Tx, bandwidth.MinTx, ratesTx, weights)
 
-	var unusedRx uint32
-	var unusedTx uint32
+	for idx, conn := range conns {
+		conn.bandRx.Store(bandRx[idx])
+		conn.bandTx.Store(bandTx[idx])
+	}
+}
 
-	now := time.Now()
+// Returns bandwidth allotments for connections with given measured rates in bytes per second and relative weights
+func splitBandwidth(total uint32, minRate uint32, rates []float64, weights []uint32) []uint32 {
 
-	var saturationThreshold = func(val uint64) uint64 {
-		return val - (val / 10)
-	}
+	result := make([]uint32, len(rates))
 
-	satThresholdRx := saturationThreshold(uint64(baseRx))
-	satThresholdTx := saturationThreshold(uint64(baseTx))
+	var totalWeight uint64
+	for _, weight := range weights {
+		totalWeight += uint64(weight)
+	}
 
-	var nsatRx, nsatTx int
+	var baseShare = func(idx int) uint64 {
+		if totalWeight == 0 {
+			return 0
+		}
+		return uint64(total) * uint64(weights[idx]) / totalWeight
+	}
 
-	//	calculate unused bandwidth
-	for _, conn := range conns {
+	var unused uint64
+	var satWeight uint64
 
-		equivRx := equivalentBandwidth(baseRx, conn.updated)
-		equivTx := equivalentBandwidth(baseTx, conn.updated)
+	saturated := make([]bool, len(rates))
 
-		volRx := conn.deltaRx.Load()
-		volTx := conn.deltaTx.Load()
+	for idx, rate := range rates {
 
-		if volRx >= satThresholdRx {
-			nsatRx++
-		} else if delta := equivRx - volRx; delta > 0 {
-			unusedRx += uint32(delta)
-		}
+		base := float64(baseShare(idx))
 
-		if volTx >= satThresholdTx {
-			nsatTx++
-		} else if delta := equivTx - volTx; delta > 0 {
-			unusedTx += uint32(delta)
+		if rate >= base*bandwidthSaturationRatio {
+			saturated[idx] = true
+			satWeight += uint64(weights[idx])
+			continue
 		}
 
-		conn.updated = now
+		unused += uint64(base - rate)
 	}
 
-	//	redistribute extra bandwidth and take data volume stats
-	for _, conn := range conns {
+	for idx := range rates {
 
-		var extraRx, extraTx uint32
-
-		if nsatRx > 0 && conn.deltaRx.Load() >= satThresholdRx {
-			extraRx = unusedRx / uint32(nsatRx)
-		}
-
-		if nsatTx > 0 && conn.deltaTx.Load() >= satThresholdTx {
-			extraTx = unusedTx / uint32(nsatTx)
+		val := baseShare(idx)
+		if saturated[idx] && satWeight > 0 {
+			val += unused * uint64(weights[idx]) / satWeight
 		}
 
-		conn.bandRx.Store(max(baseRx+extraRx, bandwidth.MinRx))
-		conn.bandTx.Store(max(baseTx+extraTx, bandwidth.MinTx))
+		result[idx] = max(uint32(min(val, uint64(total))), minRate)
 	}
+
+	return result
 }
@@ -0,0 +1,145 @@
+package nxproxy
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// Checks invariants of bandwidth redistribution over randomized connection states,
+// with extreme values showing up more often than they would by chance
+func TestRedistributePeerBandwidth_Invariants(t *testing.T) {
+
+	rng := rand.New(rand.NewPCG(1754, 1))
+
+	var pickUint32 = func() uint32 {
+		switch rng.IntN(4) {
+		case 0:
+			return 0
+		case 1:
+			return math.MaxUint32
+		case 2:
+			return uint32(rng.IntN(1000))
+		default:
+			return rng.Uint32()
+		}
+	}
+
+	var pickVolume = func() uint64 {
+		switch rng.IntN(4) {
+		case 0:
+			return 0
+		case 1:
+			return math.MaxUint32 * 4
+		default:
+			return uint64(rng.Uint32())
+		}
+	}
+
+	type direction struct {
+		name    string
+		total   uint32
+		minRate uint32
+		rates   []float64
+		allots  []uint32
+	}
+
+	var check = func(iter int, dir direction, weights []uint32) {
+
+		var totalWeight uint64
+		for _, weight := range weights {
+			totalWeight += uint64(weight)
+		}
+
+		//	measured rates of unsaturated connections plus allotments of saturated ones
+		var used float64
+
+		for idx, allot := range dir.allots {
+
+			if allot < dir.minRate {
+				t.Fatalf("iter %d %s: allotment %d under min rate %d", iter, dir.name, allot, dir.minRate)
+			}
+
+			if allot > max(dir.total, dir.minRate) {
+				t.Fatalf("iter %d %s: allotment %d over peer bandwidth %d", iter, dir.name, allot, dir.total)
+			}
+
+			if dir.total == 0 && dir.minRate == 0 && allot != 0 {
+				t.Fatalf("iter %d %s: unlimited peer got limited to %d", iter, dir.name, allot)
+			}
+
+			base := float64(uint64(dir.total) * uint64(weights[idx]) / totalWeight)
+			if dir.rates[idx] >= base*bandwidthSaturationRatio {
+				used += float64(allot)
+			} else {
+				used += dir.rates[idx]
+			}
+		}
+
+		if dir.minRate == 0 && used > float64(dir.total)*(1+1e-9) {
+			t.Fatalf("iter %d %s: %.0f B/s handed out over peer bandwidth %d", iter, dir.name, used, dir.total)
+		}
+	}
+
+	for iter := range 5000 {
+
+		bandwidth := PeerBandwidth{Rx: pickUint32(), Tx: pickUint32()}
+		if rng.IntN(2) == 0 {
+			bandwidth.MinRx = pickUint32()
+			bandwidth.MinTx = pickUint32()
+		}
+
+		conns := make([]*PeerConnection, rng.IntN(17))
+		weights := make([]uint32, len(conns))
+
+		for idx := range conns {
+			conns[idx] = &PeerConnection{}
+			conns[idx].SetWeight(pickUint32())
+			weights[idx] = conns[idx].Weight()
+		}
+
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		//	the first round assumes a second long window, the following ones measure it
+		for round := range 3 {
+
+			window := time.Second
+			if round > 0 {
+				window = time.Duration(1+rng.IntN(5000)) * time.Millisecond
+				now = now.Add(window)
+			}
+
+			ratesRx := make([]float64, len(conns))
+			ratesTx := make([]float64, len(conns))
+
+			for idx, conn := range conns {
+
+				rx, tx := pickVolume(), pickVolume()
+				conn.deltaRx.Store(rx)
+				conn.deltaTx.Store(tx)
+
+				ratesRx[idx] = float64(rx) / window.Seconds()
+				ratesTx[idx] = float64(tx) / window.Seconds()
+			}
+
+			RedistributePeerBandwidthAt(conns, bandwidth, now)
+
+			allotsRx := make([]uint32, len(conns))
+			allotsTx := make([]uint32, len(conns))
+
+			for idx, conn := range conns {
+				allotsRx[idx] = conn.bandRx.Load()
+				allotsTx[idx] = conn.bandTx.Load()
+			}
+
+			check(iter, direction{name: "rx", total: bandwidth.Rx, minRate: bandwidth.MinRx, rates: ratesRx, allots: allotsRx}, weights)
+			check(iter, direction{name: "tx", total: bandwidth.Tx, minRate: bandwidth.MinTx, rates: ratesTx, allots: allotsTx}, weights)
+		}
+	}
+}
+
+func TestRedistributePeerBandwidth_NoConnections(t *testing.T) {
+	RedistributePeerBandwidth(nil, PeerBandwidth{Rx: math.MaxUint32, MinRx: math.MaxUint32})
+	RedistributePeerBandwidth([]*PeerConnection{}, PeerBandwidth{})
+}
@@ -0,0 +1,190 @@
+package nxproxy_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Transfers known data volumes through bridged loopback connections
+// and checks that the achieved data rates match peer bandwidth settings
+func TestPeer_BandwidthAccuracy(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("transfers take a few seconds each")
+	}
+
+	const tolerance = 0.15
+
+	tests := []struct {
+		name      string
+		bandwidth nxproxy.PeerBandwidth
+		conns     int
+		upload    bool
+
+		//	expected aggregate rate of all connections in bytes per second
+		want int
+	}{
+		{
+			name:      "single connection rx",
+			bandwidth: nxproxy.PeerBandwidth{Rx: 128_000},
+			conns:     1,
+			want:      128_000,
+		},
+		{
+			name:      "single connection tx",
+			bandwidth: nxproxy.PeerBandwidth{Tx: 96_000},
+			conns:     1,
+			upload:    true,
+			want:      96_000,
+		},
+		{
+			name:      "shared rx",
+			bandwidth: nxproxy.PeerBandwidth{Rx: 128_000},
+			conns:     4,
+			want:      128_000,
+		},
+		{
+			name:      "shared tx",
+			bandwidth: nxproxy.PeerBandwidth{Tx: 128_000},
+			conns:     2,
+			upload:    true,
+			want:      128_000,
+		},
+		{
+			//	min rate takes precedence over the peer's total bandwidth
+			name:      "min rate rx",
+			bandwidth: nxproxy.PeerBandwidth{Rx: 64_000, MinRx: 32_000},
+			conns:     4,
+			want:      128_000,
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Parallel()
+
+			peer := nxproxy.Peer{
+				PeerOptions: nxproxy.PeerOptions{
+					ID:        uuid.New(),
+					Bandwidth: test.bandwidth,
+				},
+			}
+
+			rate := measurePeerRate(t, &peer, test.conns, test.upload)
+
+			if diff := (rate - float64(test.want)) / float64(test.want); diff > tolerance || diff < -tolerance {
+				t.Errorf("rate off by %.1f%%; expected: %d; got: %.0f", diff*100, test.want, rate)
+			}
+		})
+	}
+}
+
+// Returns the aggregate data rate of all peer connections in bytes per second
+func measurePeerRate(t *testing.T, peer *nxproxy.Peer, nconns int, upload bool) float64 {
+
+	//	how long each connection stays saturated
+	const transferSeconds = 3
+
+	var ctls []*nxproxy.PeerConnection
+	for range nconns {
+
+		ctl, err := peer.Connection()
+		if err != nil {
+			t.Fatalf("connection: %v", err)
+		}
+
+		defer ctl.Close()
+		ctls = append(ctls, ctl)
+	}
+
+	//	let connections start with their fair share instead of the initial estimate
+	nxproxy.RedistributePeerBandwidth(peer.ConnectionList(), peer.Bandwidth)
+
+	var total int
+	var wg sync.WaitGroup
+
+	started := time.Now()
+
+	for _, ctl := range ctls {
+
+		bandwidth, _ := ctl.BandwidthRx()
+		if upload {
+			bandwidth, _ = ctl.BandwidthTx()
+		}
+
+		volume := bandwidth * transferSeconds
+		total += volume
+
+		appConn, clientConn := loopbackPair(t)
+		remoteConn, serverConn := loopbackPair(t)
+
+		//	the bridge returns once both sides are done and the last chunk is paced
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := nxproxy.ProxyBridge(ctl, clientConn, remoteConn); err != nil {
+				t.Errorf("bridge: %v", err)
+			}
+		}()
+
+		src, dst := serverConn, appConn
+		if upload {
+			src, dst = appConn, serverConn
+		}
+
+		go func() {
+			if _, err := src.Write(make([]byte, volume)); err != nil {
+				t.Errorf("write: %v", err)
+			}
+			src.(*net.TCPConn).CloseWrite()
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := io.ReadFull(dst, make([]byte, volume)); err != nil {
+				t.Errorf("read: %v", err)
+			}
+			dst.(*net.TCPConn).CloseWrite()
+		}()
+	}
+
+	wg.Wait()
+
+	return float64(total) / time.Since(started).Seconds()
+}
+
+func loopbackPair(t *testing.T) (net.Conn, net.Conn) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+
+	return dialed, accepted
+}
@@ -0,0 +1,119 @@
+package nxproxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCaptureActive = errors.New("peer capture already active")
+
+// Max number of records a single capture may hold, which keeps its memory use bounded
+const maxCaptureRecords = 100_000
+
+// Traffic metadata of a single io operation; payloads are never captured
+type CaptureRecord struct {
+	Time   time.Time
+	ConnID uint64
+	Rx     bool
+	Size   int
+}
+
+// Records peer traffic metadata for a limited time or data volume
+type PeerCapture struct {
+	until     time.Time
+	maxBytes  int64
+	bytes     int64
+	records   []CaptureRecord
+	truncated bool
+
+	doneCh chan struct{}
+	done   bool
+	mtx    sync.Mutex
+}
+
+// Creates a capture that runs for the duration or until maxBytes are transferred; zero maxBytes means no volume limit
+func NewPeerCapture(duration time.Duration, maxBytes int64) *PeerCapture {
+	return &PeerCapture{
+		until:    time.Now().Add(duration),
+		maxBytes: maxBytes,
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Adds a record; returns false once the capture is complete
+func (pc *PeerCapture) record(connID uint64, rx bool, size int) bool {
+
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+
+	if pc.done {
+		return false
+	}
+
+	now := time.Now()
+	if now.After(pc.until) {
+		pc.finish()
+		return false
+	}
+
+	if len(pc.records) < maxCaptureRecords {
+		pc.records = append(pc.records, CaptureRecord{Time: now, ConnID: connID, Rx: rx, Size: size})
+	} else {
+		pc.truncated = true
+	}
+
+	if pc.bytes += int64(size); pc.maxBytes > 0 && pc.bytes >= pc.maxBytes {
+		pc.finish()
+	}
+
+	return true
+}
+
+func (pc *PeerCapture) finish() {
+	if !pc.done {
+		pc.done = true
+		close(pc.doneCh)
+	}
+}
+
+// Blocks until the capture is complete and returns captured records.
+// The second value is true when some records were dropped for exceeding the record limit
+func (pc *PeerCapture) Wait(ctx context.Context) ([]CaptureRecord, bool) {
+
+	timer := time.NewTimer(time.Until(pc.until))
+	defer timer.Stop()
+
+	select {
+	case <-pc.doneCh:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+
+	pc.finish()
+
+	return pc.records, pc.truncated
+}
+
+// Attaches a capture to the peer; it's detached automatically once complete
+func (peer *Peer) StartCapture(capture *PeerCapture) error {
+
+	if current := peer.capture.Load(); current != nil {
+
+		current.mtx.Lock()
+		done := current.done
+		current.mtx.Unlock()
+
+		if !done {
+			return ErrCaptureActive
+		}
+	}
+
+	peer.capture.Store(capture)
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package nxproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_Capture(t *testing.T) {
+
+	peer := nxproxy.Peer{PeerOptions: nxproxy.PeerOptions{ID: uuid.New()}}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	defer ctl.Close()
+
+	//	traffic before the capture starts isn't recorded
+	ctl.AccountRx(100)
+
+	capture := nxproxy.NewPeerCapture(time.Minute, 1000)
+	if err := peer.StartCapture(capture); err != nil {
+		t.Fatalf("start capture: %v", err)
+	}
+
+	if err := peer.StartCapture(nxproxy.NewPeerCapture(time.Minute, 0)); err != nxproxy.ErrCaptureActive {
+		t.Errorf("concurrent capture allowed: %v", err)
+	}
+
+	ctl.AccountTx(200)
+	ctl.AccountRx(900)
+
+	//	the byte limit has been reached by now
+	ctl.AccountRx(300)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	records, truncated := capture.Wait(ctx)
+	if ctx.Err() != nil {
+		t.Fatalf("capture not completed by the byte limit")
+	}
+
+	if truncated || len(records) != 2 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	if records[0].Rx || records[0].Size != 200 || !records[1].Rx || records[1].Size != 900 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	if err := peer.StartCapture(nxproxy.NewPeerCapture(time.Minute, 0)); err != nil {
+		t.Errorf("new capture not allowed after the previous one completed: %v", err)
+	}
+}
+
+func TestPeerCapture_Timeout(t *testing.T) {
+
+	capture := nxproxy.NewPeerCapture(50*time.Millisecond, 0)
+
+	started := time.Now()
+	records, _ := capture.Wait(context.Background())
+
+	if elapsed := time.Since(started); elapsed > time.Second || len(records) != 0 {
+		t.Errorf("unexpected capture result after %v: %+v", elapsed, records)
+	}
+}
@@ -2,11 +2,22 @@ package nxproxy
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var ErrSessionCapReached = errors.New("session data cap reached")
+
+// Number of connections created by peers that haven't been closed yet
+var liveConnections atomic.Int64
+
+func LiveConnections() int64 {
+	return liveConnections.Load()
+}
+
 type PeerConnection struct {
 	id uint64
 
@@ -16,10 +27,39 @@ type PeerConnection struct {
 	bandRx atomic.Uint32
 	bandTx atomic.Uint32
 
+	//	data volume transferred over the connection lifetime and its limits
+	totalRx atomic.Uint64
+	totalTx atomic.Uint64
+	capRx   uint64
+	capTx   uint64
+	capped  atomic.Bool
+
+	//	relative share of peer bandwidth; treated as 1 when not set
+	weight atomic.Uint32
+
+	egress  *TokenBucket
+	pacing  bool
+	linger  time.Duration
+	capture *atomic.Pointer[PeerCapture]
+
+	onUpstreamError func(err error)
+
+	//	carries the attributes identifying the session, so that the bridging code can tie its warnings to it
+	logger *slog.Logger
+
 	mtx      sync.Mutex
 	ctx      context.Context
 	cancelFn context.CancelFunc
 	updated  time.Time
+
+	//	set for connections accounted in liveConnections
+	counted bool
+	closed  atomic.Bool
+}
+
+// Returns the connection id, unique over the lifetime of the peer
+func (conn *PeerConnection) ID() uint64 {
+	return conn.id
 }
 
 func (conn *PeerConnection) Context() context.Context {
@@ -33,25 +73,98 @@ func (conn *PeerConnection) Context() context.Context {
 	return conn.ctx
 }
 
+// Returns the connection logger; falls back to the default one for connections not created by a peer
+func (conn *PeerConnection) Logger() *slog.Logger {
+
+	conn.mtx.Lock()
+	defer conn.mtx.Unlock()
+
+	if conn.logger == nil {
+		return slog.Default()
+	}
+	return conn.logger
+}
+
+// Adds attributes to the connection logger. Meant to be called by the services once the session
+// destination is known, before the connection gets bridged
+func (conn *PeerConnection) SetLogAttrs(attrs ...slog.Attr) {
+
+	conn.mtx.Lock()
+	defer conn.mtx.Unlock()
+
+	if conn.logger == nil {
+		conn.logger = slog.Default()
+	}
+
+	args := make([]any, len(attrs))
+	for idx, attr := range attrs {
+		args[idx] = attr
+	}
+
+	conn.logger = conn.logger.With(args...)
+}
+
+// Returns the current downstream bandwidth in bytes per second; the flag is unset when it's unlimited
 func (conn *PeerConnection) BandwidthRx() (int, bool) {
 	val := conn.bandRx.Load()
 	return int(val), val > 0
 }
 
+// Returns the current upstream bandwidth in bytes per second; the flag is unset when it's unlimited
 func (conn *PeerConnection) BandwidthTx() (int, bool) {
 	val := conn.bandTx.Load()
 	return int(val), val > 0
 }
 
+func (conn *PeerConnection) Weight() uint32 {
+	return max(conn.weight.Load(), 1)
+}
+
+// Sets relative bandwidth share of the connection; applied on the next bandwidth redistribution
+func (conn *PeerConnection) SetWeight(weight uint32) {
+	conn.weight.Store(weight)
+}
+
 func (conn *PeerConnection) AccountRx(delta int) {
 	if delta > 0 {
 		conn.deltaRx.Add(uint64(delta))
+		conn.enforceCap(conn.totalRx.Add(uint64(delta)), conn.capRx)
+		conn.captureIO(true, delta)
 	}
 }
 
 func (conn *PeerConnection) AccountTx(delta int) {
 	if delta > 0 {
 		conn.deltaTx.Add(uint64(delta))
+		conn.enforceCap(conn.totalTx.Add(uint64(delta)), conn.capTx)
+		conn.captureIO(false, delta)
+	}
+}
+
+// Terminates the connection once it has transferred more data than allowed
+func (conn *PeerConnection) enforceCap(total uint64, limit uint64) {
+	if limit > 0 && total > limit && conn.capped.CompareAndSwap(false, true) {
+		conn.Close()
+	}
+}
+
+func (conn *PeerConnection) captureIO(rx bool, size int) {
+	if conn.capture != nil {
+		if capture := conn.capture.Load(); capture != nil && !capture.record(conn.id, rx, size) {
+			conn.capture.CompareAndSwap(capture, nil)
+		}
+	}
+}
+
+// Reports whether the connection was terminated for exceeding its data cap
+func (conn *PeerConnection) CapReached() bool {
+	return conn.capped.Load()
+}
+
+// Blocks until size bytes may be sent without exceeding the node-wide egress limit
+func (conn *PeerConnection) WaitEgress(size int) {
+	if conn.egress != nil {
+		conn.egress.Wait(conn.Context(), size)
 	}
 }
 
@@ -63,4 +176,8 @@ func (conn *PeerConnection) Close() {
 	if conn.cancelFn != nil {
 		conn.cancelFn()
 	}
+
+	if conn.counted && conn.closed.CompareAndSwap(false, true) {
+		liveConnections.Add(-1)
+	}
 }
@@ -0,0 +1,100 @@
+package nxproxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Dials a network address; matches net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dials a destination from the peer's framed IP. With family fallback enabled, a destination
+// that can't be reached over the framed IP's address family gets dialed over the other one
+// using the node's default source address
+func (peer *Peer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+
+	started := time.Now()
+
+	conn, err := peer.dialContext(ctx, network, address)
+	if err == nil {
+		peer.latency.record(latencyNetwork(conn.RemoteAddr()), time.Since(started))
+	}
+
+	return conn, err
+}
+
+func (peer *Peer) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+
+	//	peers whose framed IP went away either dial from the default source address or don't dial at all
+	if peer.FramedIPDown() {
+
+		if peer.StrictFramedIP {
+			return nil, ErrFramedIPUnavailable
+		}
+
+		fallback := peer.Dialer
+		fallback.LocalAddr = nil
+
+		return peer.dialWith(&fallback, ctx, network, address)
+	}
+
+	conn, err := peer.dialWith(&peer.Dialer, ctx, network, address)
+	if err == nil || !peer.FamilyFallback || !isAddrFamilyError(err) {
+		return conn, err
+	}
+
+	local, ok := peer.Dialer.LocalAddr.(*net.TCPAddr)
+	if !ok || local == nil {
+		return conn, err
+	}
+
+	fallbackNetwork := "tcp4"
+	if local.IP.To4() != nil {
+		fallbackNetwork = "tcp6"
+	}
+
+	fallback := peer.Dialer
+	fallback.LocalAddr = nil
+
+	conn, fallbackErr := peer.dialWith(&fallback, ctx, fallbackNetwork, address)
+	if fallbackErr != nil {
+		return nil, err
+	}
+
+	slog.Debug("Peer dial: Fell back to another address family",
+		slog.String("peer", peer.DisplayName()),
+		slog.Any("slot", peer.Slot),
+		slog.String("network", fallbackNetwork),
+		slog.String("addr", address),
+		slog.String("err", err.Error()))
+
+	return conn, nil
+}
+
+// Dials with the dial function of the peer if it has one, or with the dialer otherwise
+func (peer *Peer) dialWith(dialer *net.Dialer, ctx context.Context, network, address string) (net.Conn, error) {
+
+	if peer.DialFunc != nil {
+		return peer.DialFunc(ctx, network, address)
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Checks if a dial error is caused by a destination not being reachable over the source address family
+func isAddrFamilyError(err error) bool {
+
+	var addrErr *net.AddrError
+	if errors.As(err, &addrErr) && addrErr.Err == "no suitable address found" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.EADDRNOTAVAIL) ||
+		errors.Is(err, syscall.EAFNOSUPPORT)
+}
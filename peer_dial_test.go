@@ -0,0 +1,67 @@
+package nxproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_DialFamilyFallback(t *testing.T) {
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	var newPeer = func(fallback bool) *nxproxy.Peer {
+		return &nxproxy.Peer{
+			PeerOptions: nxproxy.PeerOptions{FamilyFallback: fallback},
+			Dialer:      net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}},
+		}
+	}
+
+	if conn, err := newPeer(false).DialContext(context.Background(), "tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatalf("ipv6 destination dialed from an ipv4 framed ip without fallback")
+	}
+
+	conn, err := newPeer(true).DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial with fallback: %v", err)
+	}
+
+	conn.Close()
+}
+
+func TestDialOptions_Or(t *testing.T) {
+
+	slotOpts := nxproxy.DialOptions{DialTimeoutMs: 5000}
+	peerOpts := nxproxy.DialOptions{DialTimeoutMs: 1500, KeepAliveMs: 60_000}
+
+	if opts := peerOpts.Or(slotOpts); opts.Timeout() != 1500*time.Millisecond || opts.KeepAlive() != time.Minute {
+		t.Errorf("peer timings not preferred: %+v", opts)
+	}
+
+	if opts := (nxproxy.DialOptions{}).Or(slotOpts); opts.Timeout() != 5*time.Second || opts.KeepAlive() != nxproxy.DefaultDialKeepAlive {
+		t.Errorf("slot timings not applied: %+v", opts)
+	}
+
+	if opts := (nxproxy.DialOptions{}); opts.Timeout() != nxproxy.DefaultDialTimeout {
+		t.Errorf("unexpected default timeout: %v", opts.Timeout())
+	}
+}
@@ -0,0 +1,129 @@
+package nxproxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DisableReason string
+
+const (
+	DisableLeak = DisableReason("leak")
+)
+
+// A peer disabled by the node on its own, such as by the slot leak check. It's reported with every status
+// until the backend acknowledges it by sending the time back in PeerOptions.DisableAck; the peer stays disabled
+// until then, regardless of what the backend config says
+type PeerDisable struct {
+	PeerID uuid.UUID     `json:"peer_id"`
+	Slot   SlotIdentity  `json:"slot"`
+	Reason DisableReason `json:"reason"`
+	Time   time.Time     `json:"time"`
+}
+
+type peerLocalDisable struct {
+	reason DisableReason
+	at     time.Time
+	mtx    sync.Mutex
+}
+
+// Disables the peer locally; returns false if it already was
+func (state *peerLocalDisable) set(reason DisableReason, now time.Time) bool {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if !state.at.IsZero() {
+		return false
+	}
+
+	state.reason = reason
+	state.at = now
+
+	return true
+}
+
+func (state *peerLocalDisable) active() bool {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return !state.at.IsZero()
+}
+
+// Checks whether the backend has acknowledged the disable. Backends may store times with second precision,
+// so sub-second parts of the disable time are ignored
+func (state *peerLocalDisable) acked(ack *time.Time) bool {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	return !state.at.IsZero() && ack != nil && !ack.Before(state.at.Truncate(time.Second))
+}
+
+func (state *peerLocalDisable) reset() {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.reason = ""
+	state.at = time.Time{}
+}
+
+func (state *peerLocalDisable) entry() (PeerDisable, bool) {
+
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if state.at.IsZero() {
+		return PeerDisable{}, false
+	}
+
+	return PeerDisable{Reason: state.reason, Time: state.at}, true
+}
+
+// Disables a peer on behalf of a local policy and drops its connections. Must be called with slot mutex held
+func (peer *Peer) disableLocally(reason DisableReason) {
+
+	if !peer.local.set(reason, time.Now()) {
+		return
+	}
+
+	peer.Disabled = true
+	peer.CloseConnections()
+}
+
+// Returns peers disabled by the node that the backend hasn't acknowledged yet
+func (slot *Slot) PendingDisables() []PeerDisable {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	var entries []PeerDisable
+
+	for _, peer := range slot.peerMap {
+		if entry, has := peer.local.entry(); has {
+			entry.PeerID = peer.ID
+			entry.Slot = peer.Slot
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Hands disables over from a slot that this one replaces, so that recreating a slot doesn't enable the peers
+// it has disabled. Must be called before the slot peers are set
+func (slot *Slot) RestoreDisables(entries []PeerDisable) {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	slot.restoredDisables = map[uuid.UUID]PeerDisable{}
+
+	for _, entry := range entries {
+		slot.restoredDisables[entry.PeerID] = entry
+	}
+}
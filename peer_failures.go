@@ -0,0 +1,143 @@
+package nxproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+)
+
+// Summary of upstream failures of a peer since the previous status report.
+// It helps telling apart proxy issues from the ones of a destination
+type PeerFailures struct {
+	ID uuid.UUID `json:"id"`
+
+	//	total number of destination dial attempts
+	Dials      uint64 `json:"dials"`
+	DialFailed uint64 `json:"dial_failed"`
+
+	//	established connections broken by the destination side
+	UpstreamResets uint64 `json:"upstream_resets"`
+
+	//	failure counts by cause, such as 'dns', 'timeout', 'refused', 'unreachable' or 'reset'
+	Causes    map[string]uint64 `json:"causes,omitempty"`
+	LastError string            `json:"last_error,omitempty"`
+}
+
+// Returns a short failure cause name for network errors
+func ClassifyNetError(err error) string {
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, ErrFramedIPUnavailable):
+		return "framed_ip"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED):
+		return "reset"
+	case errors.Is(err, syscall.EPIPE):
+		return "broken_pipe"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+type peerFailures struct {
+	val PeerFailures
+	mtx sync.Mutex
+}
+
+func (pf *peerFailures) record(cause string, err error, update func(val *PeerFailures)) {
+
+	pf.mtx.Lock()
+	defer pf.mtx.Unlock()
+
+	update(&pf.val)
+
+	if err == nil {
+		return
+	}
+
+	if pf.val.Causes == nil {
+		pf.val.Causes = map[string]uint64{}
+	}
+
+	pf.val.Causes[cause]++
+	pf.val.LastError = err.Error()
+}
+
+func (pf *peerFailures) take() PeerFailures {
+
+	pf.mtx.Lock()
+	defer pf.mtx.Unlock()
+
+	val := pf.val
+	pf.val = PeerFailures{}
+
+	return val
+}
+
+// Records the outcome of a destination dial; dials cancelled by the client or refused by the blocklist aren't counted
+func (peer *Peer) ReportDial(err error) {
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrDestBlocklisted) {
+		return
+	}
+
+	peer.failures.record(ClassifyNetError(err), err, func(val *PeerFailures) {
+		val.Dials++
+		if err != nil {
+			val.DialFailed++
+		}
+	})
+}
+
+// Records an error that broke an established connection on the destination side
+func (peer *Peer) ReportUpstreamError(err error) {
+
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+
+	peer.failures.record(ClassifyNetError(err), err, func(val *PeerFailures) {
+		val.UpstreamResets++
+	})
+}
+
+// Returns failures recorded since the previous call, if there were any
+func (peer *Peer) Failures() (PeerFailures, bool) {
+
+	val := peer.failures.take()
+	val.ID = peer.ID
+
+	return val, val.DialFailed > 0 || val.UpstreamResets > 0
+}
+
+// Checks whether a bridge error has occurred on the remote side
+func isRemoteConnError(err error, remoteConn net.Conn) bool {
+
+	//	spliced copies report errors of both connections as the ones of the destination connection,
+	//	so it's more reliable to check which one is actually dead
+	if tornDown, known := tcpConnTornDown(remoteConn); known {
+		return tornDown
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Addr == nil || remoteConn.RemoteAddr() == nil {
+		return false
+	}
+
+	return opErr.Op == "read" && opErr.Addr.String() == remoteConn.RemoteAddr().String()
+}
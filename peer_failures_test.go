@@ -0,0 +1,125 @@
+package nxproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_Failures(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New()},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	nothing listens on this address anymore, so the dial gets refused
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = peer.Dialer.Dial("tcp", addr)
+	peer.ReportDial(err)
+
+	peer.ReportDial(nil)
+	peer.ReportDial(&net.DNSError{Err: "no such host", Name: "nonexistent.invalid", IsNotFound: true})
+
+	//	clients giving up aren't the destination's fault
+	peer.ReportDial(context.Canceled)
+
+	val, has := peer.Failures()
+	if !has {
+		t.Fatalf("no failures reported")
+	}
+
+	if val.ID != peer.ID || val.Dials != 3 || val.DialFailed != 2 {
+		t.Errorf("unexpected failures: %+v", val)
+	}
+
+	if val.Causes["refused"] != 1 || val.Causes["dns"] != 1 {
+		t.Errorf("unexpected causes: %v", val.Causes)
+	}
+
+	if _, has := peer.Failures(); has {
+		t.Errorf("failures not reset")
+	}
+}
+
+func TestPeer_UpstreamReset(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New()},
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ctl.Close()
+
+	_, clientConn := loopbackPair(t)
+	remoteConn, serverConn := loopbackPair(t)
+
+	//	closing with zero linger makes the destination send a RST
+	serverConn.(*net.TCPConn).SetLinger(0)
+	time.AfterFunc(50*time.Millisecond, func() {
+		serverConn.Close()
+	})
+
+	result, err := nxproxy.ProxyBridge(ctl, clientConn, remoteConn)
+	if err == nil {
+		t.Fatalf("bridge didn't fail")
+	}
+
+	if result.Direction != nxproxy.SpliceRx || result.Cause != "reset" || !result.Upstream {
+		t.Errorf("unexpected bridge result: %+v", result)
+	}
+
+	val, has := peer.Failures()
+	if !has || val.UpstreamResets != 1 || val.Causes["reset"] != 1 {
+		t.Errorf("unexpected failures: %+v", val)
+	}
+
+	if val.LastError == "" {
+		t.Errorf("last error not set")
+	}
+}
+
+func TestPeer_ClientReset(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New()},
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ctl.Close()
+
+	appConn, clientConn := loopbackPair(t)
+	remoteConn, _ := loopbackPair(t)
+
+	//	client going away isn't the destination's fault
+	appConn.(*net.TCPConn).SetLinger(0)
+	time.AfterFunc(50*time.Millisecond, func() {
+		appConn.Close()
+	})
+
+	if result, _ := nxproxy.ProxyBridge(ctl, clientConn, remoteConn); result.Upstream {
+		t.Errorf("client reset attributed to the destination: %+v", result)
+	}
+
+	if val, has := peer.Failures(); has {
+		t.Errorf("unexpected failures: %+v", val)
+	}
+}
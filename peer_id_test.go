@@ -0,0 +1,27 @@
+package nxproxy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPeer_ConnIDOverflow(t *testing.T) {
+
+	peer := Peer{nextConnID: math.MaxUint64 - 1}
+	defer peer.CloseConnections()
+
+	conn, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("last id: %v", err)
+	}
+
+	if conn.ID() != math.MaxUint64 {
+		t.Errorf("unexpected id %d", conn.ID())
+	}
+
+	conn.Close()
+
+	if _, err := peer.Connection(); err != ErrConnIDsExhausted {
+		t.Errorf("unexpected error on a saturated counter: %v", err)
+	}
+}
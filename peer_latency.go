@@ -0,0 +1,196 @@
+package nxproxy
+
+import (
+	"math"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upper bounds of dial latency histogram buckets in milliseconds.
+// Histograms have one more bucket for dials slower than the last bound
+var LatencyBucketsMs = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Dials to networks over the tracking limit are accounted under this key
+const LatencyNetworkOther = "other"
+
+const maxLatencyNetworks = 256
+
+// Distribution of destination connect times, including name resolution
+type LatencyHistogram struct {
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	SumMs  float64  `json:"sum_ms"`
+	MaxMs  float64  `json:"max_ms"`
+}
+
+func (hist *LatencyHistogram) Add(val time.Duration) {
+
+	if hist.Counts == nil {
+		hist.Counts = make([]uint64, len(LatencyBucketsMs)+1)
+	}
+
+	ms := float64(val.Microseconds()) / 1000
+
+	hist.Counts[sort.SearchFloat64s(LatencyBucketsMs, ms)]++
+	hist.Count++
+	hist.SumMs += ms
+	hist.MaxMs = max(hist.MaxMs, ms)
+}
+
+func (hist *LatencyHistogram) AvgMs() float64 {
+
+	if hist.Count == 0 {
+		return 0
+	}
+
+	return hist.SumMs / float64(hist.Count)
+}
+
+// Estimates a quantile as the upper bound of the bucket it falls into
+func (hist *LatencyHistogram) Quantile(q float64) float64 {
+
+	if hist.Count == 0 {
+		return 0
+	}
+
+	rank := max(uint64(math.Ceil(q*float64(hist.Count))), 1)
+
+	var seen uint64
+	for idx, count := range hist.Counts {
+		seen += count
+		if seen >= rank && idx < len(LatencyBucketsMs) {
+			return min(LatencyBucketsMs[idx], hist.MaxMs)
+		}
+	}
+
+	return hist.MaxMs
+}
+
+func (hist LatencyHistogram) clone() LatencyHistogram {
+	hist.Counts = slices.Clone(hist.Counts)
+	return hist
+}
+
+// Dial latency summary of a peer since the previous status report
+type PeerLatency struct {
+	ID    uuid.UUID `json:"id"`
+	Dials uint64    `json:"dials"`
+	AvgMs float64   `json:"avg_ms"`
+	P50Ms float64   `json:"p50_ms"`
+	P90Ms float64   `json:"p90_ms"`
+	P99Ms float64   `json:"p99_ms"`
+	MaxMs float64   `json:"max_ms"`
+}
+
+// Dial latency histograms of a peer accumulated over its lifetime
+type PeerLatencyDetails struct {
+	BucketsMs []float64        `json:"buckets_ms"`
+	Total     LatencyHistogram `json:"total"`
+
+	//	histograms by destination network, which is a /24 for IPv4 and a /48 for IPv6
+	Networks map[string]LatencyHistogram `json:"networks"`
+}
+
+type peerLatency struct {
+	window   LatencyHistogram
+	total    LatencyHistogram
+	networks map[string]*LatencyHistogram
+	mtx      sync.Mutex
+}
+
+func (pl *peerLatency) record(network string, val time.Duration) {
+
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	pl.window.Add(val)
+	pl.total.Add(val)
+
+	if pl.networks == nil {
+		pl.networks = map[string]*LatencyHistogram{}
+	}
+
+	hist := pl.networks[network]
+	if hist == nil {
+
+		if len(pl.networks) >= maxLatencyNetworks {
+			network = LatencyNetworkOther
+		}
+
+		if hist = pl.networks[network]; hist == nil {
+			hist = &LatencyHistogram{}
+			pl.networks[network] = hist
+		}
+	}
+
+	hist.Add(val)
+}
+
+func (pl *peerLatency) take() LatencyHistogram {
+
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	val := pl.window
+	pl.window = LatencyHistogram{}
+
+	return val
+}
+
+func (pl *peerLatency) details() PeerLatencyDetails {
+
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+
+	val := PeerLatencyDetails{
+		BucketsMs: slices.Clone(LatencyBucketsMs),
+		Total:     pl.total.clone(),
+		Networks:  map[string]LatencyHistogram{},
+	}
+
+	for key, hist := range pl.networks {
+		val.Networks[key] = hist.clone()
+	}
+
+	return val
+}
+
+// Returns the destination network that a connection gets accounted under
+func latencyNetwork(addr net.Addr) string {
+
+	ip, _ := GetAddrPort(addr)
+	if ip == nil {
+		return LatencyNetworkOther
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// Returns dial latency recorded since the previous call, if there were any dials
+func (peer *Peer) Latency() (PeerLatency, bool) {
+
+	hist := peer.latency.take()
+
+	return PeerLatency{
+		ID:    peer.ID,
+		Dials: hist.Count,
+		AvgMs: hist.AvgMs(),
+		P50Ms: hist.Quantile(0.5),
+		P90Ms: hist.Quantile(0.9),
+		P99Ms: hist.Quantile(0.99),
+		MaxMs: hist.MaxMs,
+	}, hist.Count > 0
+}
+
+func (peer *Peer) LatencyDetails() PeerLatencyDetails {
+	return peer.latency.details()
+}
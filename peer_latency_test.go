@@ -0,0 +1,93 @@
+package nxproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestLatencyHistogram_Quantile(t *testing.T) {
+
+	var hist nxproxy.LatencyHistogram
+
+	for range 90 {
+		hist.Add(3 * time.Millisecond)
+	}
+
+	for range 9 {
+		hist.Add(40 * time.Millisecond)
+	}
+
+	hist.Add(20 * time.Second)
+
+	if val := hist.Quantile(0.5); val != 5 {
+		t.Errorf("p50: expected 5, got %v", val)
+	}
+
+	if val := hist.Quantile(0.99); val != 50 {
+		t.Errorf("p99: expected 50, got %v", val)
+	}
+
+	if val := hist.Quantile(1); val != 20000 {
+		t.Errorf("p100: expected 20000, got %v", val)
+	}
+
+	if hist.Counts[len(hist.Counts)-1] != 1 {
+		t.Errorf("slow dial not put into the overflow bucket")
+	}
+}
+
+func TestPeer_Latency(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	peer := &nxproxy.Peer{}
+
+	if _, has := peer.Latency(); has {
+		t.Fatalf("latency reported without any dials")
+	}
+
+	for range 3 {
+		conn, err := peer.DialContext(context.Background(), "tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.Close()
+	}
+
+	summary, has := peer.Latency()
+	if !has || summary.Dials != 3 {
+		t.Fatalf("expected 3 dials, got %d", summary.Dials)
+	}
+
+	if _, has := peer.Latency(); has {
+		t.Errorf("latency summary not reset")
+	}
+
+	details := peer.LatencyDetails()
+	if hist := details.Networks["127.0.0.0/24"]; hist.Count != 3 {
+		t.Errorf("expected 3 dials to 127.0.0.0/24, got %v", details.Networks)
+	}
+
+	if details.Total.Count != 3 {
+		t.Errorf("lifetime histogram reset by a status summary")
+	}
+}
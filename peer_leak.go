@@ -0,0 +1,238 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DefaultLeakWindow = time.Hour
+
+// Limits the number of client addresses and countries remembered per peer within a window
+const maxLeakTracked = 256
+
+// Limits the number of leak events kept between status reports
+const maxLeakEvents = 1000
+
+// Flags peers whose credentials are used from too many places within a time window,
+// which usually means they've been shared or leaked
+type LeakCheckOptions struct {
+
+	//	observation window in seconds; an hour when zero
+	WindowSec uint `json:"window_sec,omitempty"`
+
+	//	flag peers authenticating from more distinct client ips than this; not checked when zero
+	MaxClientIPs uint `json:"max_client_ips,omitempty"`
+
+	//	flag peers authenticating from more distinct countries than this; not checked when zero.
+	//	requires the node to have a GeoIP database, clients of unknown location aren't counted
+	MaxCountries uint `json:"max_countries,omitempty"`
+
+	//	disable flagged peers until the backend acknowledges it
+	AutoDisable bool `json:"auto_disable,omitempty"`
+}
+
+func (opts *LeakCheckOptions) Window() time.Duration {
+
+	if opts.WindowSec == 0 {
+		return DefaultLeakWindow
+	}
+
+	return time.Duration(opts.WindowSec) * time.Second
+}
+
+func (opts *LeakCheckOptions) Validate() error {
+
+	if opts.MaxClientIPs == 0 && opts.MaxCountries == 0 {
+		return errors.New("neither client ip nor country limit set")
+	}
+
+	if opts.MaxClientIPs >= maxLeakTracked || opts.MaxCountries >= maxLeakTracked {
+		return fmt.Errorf("limits must be under %d", maxLeakTracked)
+	}
+
+	return nil
+}
+
+type LeakReason string
+
+const (
+	LeakClientIPs = LeakReason("client_ips")
+	LeakCountries = LeakReason("countries")
+)
+
+// Reported when a peer exceeds the leak check limits of its slot.
+// A peer gets flagged at most once per window
+type LeakEvent struct {
+	PeerID    uuid.UUID  `json:"peer_id"`
+	User      string     `json:"user,omitempty"`
+	ProxyAddr string     `json:"proxy_addr"`
+	Reason    LeakReason `json:"reason"`
+
+	//	distinct client addresses and countries the peer authenticated from within the window
+	ClientIPs []string `json:"client_ips"`
+	Countries []string `json:"countries,omitempty"`
+
+	//	set when the peer was disabled by the slot policy
+	Disabled bool      `json:"disabled"`
+	Time     time.Time `json:"time"`
+}
+
+type peerLeakCheck struct {
+	addrs     map[string]time.Time
+	countries map[string]time.Time
+	flaggedAt time.Time
+	mtx       sync.Mutex
+}
+
+// Records a successful authentication and checks it against the limits
+func (lc *peerLeakCheck) observe(addr string, country string, opts *LeakCheckOptions, now time.Time) (LeakEvent, bool) {
+
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+
+	window := opts.Window()
+
+	var track = func(entries map[string]time.Time, key string) map[string]time.Time {
+
+		if entries == nil {
+			entries = map[string]time.Time{}
+		}
+
+		maps.DeleteFunc(entries, func(_ string, seen time.Time) bool {
+			return now.Sub(seen) > window
+		})
+
+		if _, has := entries[key]; has || len(entries) < maxLeakTracked {
+			entries[key] = now
+		}
+
+		return entries
+	}
+
+	lc.addrs = track(lc.addrs, addr)
+
+	if country != "" {
+		lc.countries = track(lc.countries, country)
+	}
+
+	if !lc.flaggedAt.IsZero() && now.Sub(lc.flaggedAt) <= window {
+		return LeakEvent{}, false
+	}
+
+	var reason LeakReason
+
+	switch {
+	case opts.MaxClientIPs > 0 && uint(len(lc.addrs)) > opts.MaxClientIPs:
+		reason = LeakClientIPs
+	case opts.MaxCountries > 0 && uint(len(lc.countries)) > opts.MaxCountries:
+		reason = LeakCountries
+	default:
+		return LeakEvent{}, false
+	}
+
+	lc.flaggedAt = now
+
+	return LeakEvent{
+		Reason:    reason,
+		ClientIPs: slices.Sorted(maps.Keys(lc.addrs)),
+		Countries: slices.Sorted(maps.Keys(lc.countries)),
+		Disabled:  opts.AutoDisable,
+		Time:      now,
+	}, true
+}
+
+func (lc *peerLeakCheck) reset() {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	lc.addrs = nil
+	lc.countries = nil
+	lc.flaggedAt = time.Time{}
+}
+
+type leakEventLog struct {
+	entries []LeakEvent
+	mtx     sync.Mutex
+}
+
+func (ll *leakEventLog) record(entry LeakEvent) {
+
+	ll.mtx.Lock()
+	defer ll.mtx.Unlock()
+
+	if len(ll.entries) < maxLeakEvents {
+		ll.entries = append(ll.entries, entry)
+	}
+}
+
+func (ll *leakEventLog) take() []LeakEvent {
+
+	ll.mtx.Lock()
+	defer ll.mtx.Unlock()
+
+	entries := ll.entries
+	ll.entries = nil
+
+	return entries
+}
+
+// Runs the slot leak check for a peer that has just authenticated. Must be called with slot mutex held
+func (slot *Slot) checkLeak(peer *Peer, ip net.IP) {
+
+	opts := slot.Options()
+	if opts.LeakCheck == nil {
+		return
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return
+	}
+
+	addr = addr.Unmap()
+
+	var country string
+	if opts.LeakCheck.MaxCountries > 0 {
+		country, _ = slot.GeoIP.Country(addr)
+	}
+
+	event, flagged := peer.leak.observe(addr.String(), country, opts.LeakCheck, time.Now())
+	if !flagged {
+		return
+	}
+
+	event.PeerID = peer.ID
+	event.ProxyAddr = opts.BindAddr
+
+	if auth := peer.PasswordAuth; auth != nil {
+		event.User = auth.User
+	}
+
+	slog.Warn("Peer credentials possibly leaked",
+		slog.String("id", peer.ID.String()),
+		slog.String("name", peer.DisplayName()),
+		slog.Any("slot", peer.Slot),
+		slog.String("reason", string(event.Reason)),
+		slog.Int("client_ips", len(event.ClientIPs)),
+		slog.Int("countries", len(event.Countries)),
+		slog.Bool("disabled", event.Disabled))
+
+	if event.Disabled {
+		peer.disableLocally(DisableLeak)
+	}
+
+	slot.leaks.record(event)
+}
+
+// Returns leak events recorded since the previous call
+func (slot *Slot) LeakEvents() []LeakEvent {
+	return slot.leaks.take()
+}
@@ -0,0 +1,164 @@
+package nxproxy_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_LeakCheck(t *testing.T) {
+
+	geo, _, err := nxproxy.ParseGeoIP(strings.NewReader("198.51.100.0/24,US\n203.0.113.0/24,NL\n"))
+	if err != nil {
+		t.Fatalf("parse geoip: %v", err)
+	}
+
+	slot := nxproxy.Slot{DNS: stubDns{}, GeoIP: geo}
+
+	opts := nxproxy.SlotOptions{
+		Proto:     nxproxy.ProxyProtoSocks,
+		BindAddr:  "127.0.0.1:1080",
+		LeakCheck: &nxproxy.LeakCheckOptions{MaxClientIPs: 3, MaxCountries: 1, AutoDisable: true},
+	}
+
+	if err := slot.SetOptions(opts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}},
+	}
+
+	slot.SetPeers(peers)
+
+	var login = func(ip string) *nxproxy.Peer {
+		peer, err := slot.LookupWithPassword(net.ParseIP(ip), "maddsua", "1")
+		if err != nil {
+			t.Fatalf("lookup from %s: %v", ip, err)
+		}
+		return peer
+	}
+
+	login("198.51.100.1")
+	login("198.51.100.2")
+	login("198.51.100.1")
+
+	if events := slot.LeakEvents(); len(events) != 0 {
+		t.Fatalf("unexpected leak events: %v", events)
+	}
+
+	if peer := login("203.0.113.7"); !peer.Disabled {
+		t.Errorf("flagged peer not disabled")
+	}
+
+	events := slot.LeakEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected a leak event, got %v", events)
+	}
+
+	if event := events[0]; event.Reason != nxproxy.LeakCountries || len(event.ClientIPs) != 3 || len(event.Countries) != 2 || !event.Disabled {
+		t.Errorf("unexpected leak event: %+v", event)
+	}
+
+	login("203.0.113.8")
+
+	if events := slot.LeakEvents(); len(events) != 0 {
+		t.Errorf("peer flagged twice within a window: %v", events)
+	}
+
+	//	disabled peers stay disabled until the backend acknowledges it, even if their credentials change
+	if slot.SetPeers(peers); !login("198.51.100.1").Disabled {
+		t.Errorf("peer re-enabled by a config update")
+	}
+
+	peers[0].PasswordAuth = &nxproxy.UserPassword{User: "maddsua", Password: "2"}
+	slot.SetPeers(peers)
+
+	if peer, err := slot.LookupWithPassword(net.ParseIP("198.51.100.1"), "maddsua", "2"); err != nil || !peer.Disabled {
+		t.Errorf("peer re-enabled by a credentials change before an ack")
+	}
+
+	ack := slot.PendingDisables()[0].Time
+	peers[0].DisableAck = &ack
+	slot.SetPeers(peers)
+
+	if peer, err := slot.LookupWithPassword(net.ParseIP("198.51.100.1"), "maddsua", "2"); err != nil || peer.Disabled {
+		t.Errorf("peer not re-enabled after an ack")
+	}
+}
+
+func TestSlot_PendingDisables(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	opts := nxproxy.SlotOptions{
+		ID:        "edge",
+		Proto:     nxproxy.ProxyProtoSocks,
+		BindAddr:  "127.0.0.1:1080",
+		LeakCheck: &nxproxy.LeakCheckOptions{MaxClientIPs: 1, AutoDisable: true},
+	}
+
+	if err := slot.SetOptions(opts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}},
+	}
+
+	slot.SetPeers(peers)
+
+	slot.LookupWithPassword(net.ParseIP("198.51.100.1"), "maddsua", "1")
+	slot.LookupWithPassword(net.ParseIP("198.51.100.2"), "maddsua", "1")
+
+	pending := slot.PendingDisables()
+	if len(pending) != 1 || pending[0].Reason != nxproxy.DisableLeak || pending[0].PeerID != peers[0].ID || pending[0].Slot.ID != "edge" {
+		t.Fatalf("unexpected pending disables: %+v", pending)
+	}
+
+	//	disables stay pending until acknowledged, as status reports may get lost
+	if len(slot.PendingDisables()) != 1 {
+		t.Errorf("pending disable dropped after being reported")
+	}
+
+	//	an ack older than the disable doesn't count
+	stale := pending[0].Time.Add(-time.Minute)
+	peers[0].DisableAck = &stale
+	slot.SetPeers(peers)
+
+	if entries := slot.Peers(); !entries[0].Disabled {
+		t.Errorf("peer re-enabled by a stale ack")
+	}
+
+	//	backends may keep the time with second precision
+	ack := pending[0].Time.Truncate(time.Second)
+	peers[0].DisableAck = &ack
+	slot.SetPeers(peers)
+
+	if entries := slot.Peers(); entries[0].Disabled {
+		t.Errorf("peer not re-enabled after an ack")
+	}
+
+	if pending := slot.PendingDisables(); len(pending) != 0 {
+		t.Errorf("acknowledged disable still pending: %+v", pending)
+	}
+}
+
+func TestLeakCheckOptions_Validate(t *testing.T) {
+
+	if err := (&nxproxy.LeakCheckOptions{}).Validate(); err == nil {
+		t.Errorf("leak check without limits accepted")
+	}
+
+	if err := (&nxproxy.LeakCheckOptions{MaxClientIPs: 1000}).Validate(); err == nil {
+		t.Errorf("leak check with a limit over the tracking cap accepted")
+	}
+
+	if err := (&nxproxy.LeakCheckOptions{MaxCountries: 2}).Validate(); err != nil {
+		t.Errorf("valid leak check rejected: %v", err)
+	}
+}
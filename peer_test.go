@@ -1,7 +1,10 @@
 package nxproxy_test
 
 import (
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	nxproxy "github.com/maddsua/nx-proxy"
@@ -27,6 +30,80 @@ func TestPeer_ConnLimit(t *testing.T) {
 	}
 }
 
+func TestPeer_ConnIDsNotReused(t *testing.T) {
+
+	peer := nxproxy.Peer{PeerOptions: nxproxy.PeerOptions{ID: uuid.New()}}
+
+	var lastID uint64
+
+	for idx := range 100 {
+
+		conn, err := peer.Connection()
+		if err != nil {
+			t.Fatalf("connection %d: %v", idx, err)
+		}
+
+		if conn.ID() <= lastID {
+			t.Fatalf("connection %d: id %d after %d", idx, conn.ID(), lastID)
+		}
+
+		lastID = conn.ID()
+
+		//	freed ids must not be picked again
+		if idx%2 == 0 {
+			conn.Close()
+		}
+	}
+
+	peer.CloseConnections()
+}
+
+func TestPeer_CloseFlushesDeltas(t *testing.T) {
+
+	peer := nxproxy.Peer{PeerOptions: nxproxy.PeerOptions{ID: uuid.New()}}
+
+	var conns []*nxproxy.PeerConnection
+
+	for range 3 {
+
+		conn, err := peer.Connection()
+		if err != nil {
+			t.Fatalf("connection: %v", err)
+		}
+
+		conns = append(conns, conn)
+	}
+
+	//	keep accounting traffic while refresh cycles run and the peer gets closed
+	for idx := range 1000 {
+		conns[idx%len(conns)].AccountRx(10)
+		conns[idx%len(conns)].AccountTx(20)
+	}
+
+	var collected nxproxy.PeerDelta
+
+	if delta, has := peer.Delta(); has {
+		collected = delta
+	}
+
+	delta, has := peer.Close()
+	if !has {
+		t.Fatalf("no residual delta")
+	}
+
+	if rx, tx := collected.Rx+delta.Rx, collected.Tx+delta.Tx; rx != 10_000 || tx != 20_000 {
+		t.Errorf("unexpected totals rx=%d tx=%d", rx, tx)
+	}
+
+	if _, err := peer.Connection(); err != nxproxy.ErrPeerClosed {
+		t.Errorf("unexpected error after close: %v", err)
+	}
+
+	if _, has := peer.Close(); has {
+		t.Errorf("delta reported twice")
+	}
+}
+
 func TestPeer_Bandwidth_1(t *testing.T) {
 
 	peer := nxproxy.Peer{
@@ -158,3 +235,255 @@ func TestPeer_Bandwidth_3(t *testing.T) {
 		t.Errorf("unexpected tx rate: %d", val)
 	}
 }
+
+func TestPeer_Bandwidth_Fairness(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID: uuid.New(),
+			Bandwidth: nxproxy.PeerBandwidth{
+				Rx: 40_000,
+			},
+		},
+	}
+
+	volumes := []int{0, 2_000, 20_000, 30_000}
+
+	var conns []*nxproxy.PeerConnection
+	for _, vol := range volumes {
+
+		conn, err := peer.Connection()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		defer conn.Close()
+
+		conn.AccountRx(vol)
+		conns = append(conns, conn)
+	}
+
+	nxproxy.RedistributePeerBandwidth(conns, peer.Bandwidth)
+
+	const base = 10_000
+
+	var expected int
+	for idx, conn := range conns {
+
+		val, _ := conn.BandwidthRx()
+
+		//	no connection may go below its base share
+		if val < base {
+			t.Errorf("conn %d: rate below base share: %d", idx, val)
+		}
+
+		//	saturated connections split unused bandwidth evenly
+		if volumes[idx] >= base && val != 19_000 {
+			t.Errorf("conn %d: unexpected saturated rate: %d", idx, val)
+		}
+
+		if volumes[idx] >= base {
+			expected += val
+		} else {
+			expected += volumes[idx]
+		}
+	}
+
+	if expected > int(peer.Bandwidth.Rx) {
+		t.Errorf("expected aggregate rate exceeds peer bandwidth: %d", expected)
+	}
+}
+
+func TestPeer_Bandwidth_Window(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID: uuid.New(),
+			Bandwidth: nxproxy.PeerBandwidth{
+				Rx: 10_000,
+			},
+		},
+	}
+
+	busy, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	defer busy.Close()
+
+	idle, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	defer idle.Close()
+
+	started := time.Now()
+	nxproxy.RedistributePeerBandwidthAt(peer.ConnectionList(), peer.Bandwidth, started)
+
+	//	a window shorter than a second must be judged by rate and not by volume:
+	//	1500 bytes in 200ms saturate a 5000 B/s share even though the volume is way below it
+	busy.AccountRx(1_500)
+	idle.AccountRx(100)
+
+	nxproxy.RedistributePeerBandwidthAt([]*nxproxy.PeerConnection{busy, idle}, peer.Bandwidth, started.Add(200*time.Millisecond))
+
+	if val, _ := busy.BandwidthRx(); val < 9_000 || val > 10_000 {
+		t.Errorf("unexpected busy rate: %d", val)
+	}
+
+	if val, _ := idle.BandwidthRx(); val != 5_000 {
+		t.Errorf("unexpected idle rate: %d", val)
+	}
+}
+
+func TestPeer_Bandwidth_Weighted(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID: uuid.New(),
+			Bandwidth: nxproxy.PeerBandwidth{
+				Rx: 40_000,
+			},
+		},
+	}
+
+	//	weights 1:3:4 split 40000 into base shares of 5000, 15000 and 20000;
+	//	the idle connection leaves 12000 unused, which is split 1:3 between the saturated ones
+	tests := []struct {
+		weight uint32
+		volume int
+		want   int
+	}{
+		//	bulk download
+		{weight: 1, volume: 40_000, want: 5_000 + 3_000},
+		//	interactive flow
+		{weight: 3, volume: 40_000, want: 15_000 + 9_000},
+		//	idle connection keeps its base share
+		{weight: 4, volume: 8_000, want: 20_000},
+	}
+
+	var conns []*nxproxy.PeerConnection
+	for _, test := range tests {
+
+		conn, err := peer.Connection()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		defer conn.Close()
+
+		conn.SetWeight(test.weight)
+		conn.AccountRx(test.volume)
+		conns = append(conns, conn)
+	}
+
+	nxproxy.RedistributePeerBandwidth(conns, peer.Bandwidth)
+
+	for idx, conn := range conns {
+		if val, _ := conn.BandwidthRx(); val != tests[idx].want {
+			t.Errorf("conn %d: expected: %d; got: %d", idx, tests[idx].want, val)
+		}
+	}
+}
+
+func TestPeer_SessionCap(t *testing.T) {
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{
+			ID:           uuid.New(),
+			SessionCapRx: 1000,
+		},
+	}
+
+	ctl, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	defer ctl.Close()
+
+	ctl.AccountTx(5000)
+	ctl.AccountRx(600)
+
+	if ctl.CapReached() || ctl.Context().Err() != nil {
+		t.Fatalf("connection terminated before reaching the cap")
+	}
+
+	ctl.AccountRx(600)
+
+	if !ctl.CapReached() || ctl.Context().Err() == nil {
+		t.Errorf("connection not terminated after reaching the cap")
+	}
+
+	//	other connections of the peer keep their own volume
+	other, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	defer other.Close()
+
+	if other.AccountRx(600); other.CapReached() {
+		t.Errorf("cap shared between connections")
+	}
+}
+
+func TestMergePeerDeltas_Slots(t *testing.T) {
+
+	id := uuid.New()
+
+	deltas := nxproxy.MergePeerDeltas([]nxproxy.PeerDelta{
+		{ID: id, Slot: "http@0.0.0.0:8080/tcp", Proto: nxproxy.ProxyProtoHttp, Rx: 10, Tx: 1},
+		{ID: id, Slot: "socks@0.0.0.0:1080/tcp", Proto: nxproxy.ProxyProtoSocks, Rx: 20, Tx: 2},
+		{ID: id, Slot: "http@0.0.0.0:8080/tcp", Proto: nxproxy.ProxyProtoHttp, Rx: 30, Tx: 3},
+	})
+
+	slices.SortFunc(deltas, func(a, b nxproxy.PeerDelta) int {
+		return strings.Compare(a.Slot, b.Slot)
+	})
+
+	if len(deltas) != 2 {
+		t.Fatalf("unexpected deltas: %+v", deltas)
+	}
+
+	if val := deltas[0]; val.Proto != nxproxy.ProxyProtoHttp || val.Rx != 40 || val.Tx != 4 {
+		t.Errorf("unexpected http delta: %+v", val)
+	}
+
+	if val := deltas[1]; val.Proto != nxproxy.ProxyProtoSocks || val.Rx != 20 || val.Tx != 2 {
+		t.Errorf("unexpected socks delta: %+v", val)
+	}
+}
+
+func TestCapPeerDeltas(t *testing.T) {
+
+	peerA, peerB := uuid.New(), uuid.New()
+
+	deltas := []nxproxy.PeerDelta{
+		{ID: peerA, Slot: "http@127.0.0.1:8080", Rx: 10, Tx: 1},
+		{ID: peerA, Slot: "socks@127.0.0.1:1080", Rx: 20, Tx: 2},
+		{ID: peerB, Slot: "socks@127.0.0.1:1080", Rx: 30, Tx: 3},
+	}
+
+	if result, summarized := nxproxy.CapPeerDeltas(deltas, 3); summarized != 0 || len(result) != 3 {
+		t.Errorf("deltas within the cap got summarized: %+v", result)
+	}
+
+	result, summarized := nxproxy.CapPeerDeltas(deltas, 2)
+	if summarized != 3 || len(result) != 2 {
+		t.Fatalf("unexpected summary: %d %+v", summarized, result)
+	}
+
+	for _, val := range result {
+
+		if val.Slot != "" {
+			t.Errorf("summarized delta kept its slot: %+v", val)
+		}
+
+		if (val.ID == peerA && (val.Rx != 30 || val.Tx != 3)) || (val.ID == peerB && (val.Rx != 30 || val.Tx != 3)) {
+			t.Errorf("unexpected summarized delta: %+v", val)
+		}
+	}
+}
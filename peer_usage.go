@@ -0,0 +1,54 @@
+package nxproxy
+
+import (
+	"sync"
+	"time"
+)
+
+type UsageSample struct {
+	Time time.Time `json:"time"`
+	Rx   uint64    `json:"rx"`
+	Tx   uint64    `json:"tx"`
+}
+
+// A fixed size ring buffer of usage samples, oldest samples get overwritten first
+type UsageRing struct {
+	entries []UsageSample
+	next    int
+	full    bool
+	mtx     sync.Mutex
+}
+
+func NewUsageRing(size int) *UsageRing {
+	return &UsageRing{entries: make([]UsageSample, max(size, 1))}
+}
+
+func (ring *UsageRing) Push(sample UsageSample) {
+
+	ring.mtx.Lock()
+	defer ring.mtx.Unlock()
+
+	ring.entries[ring.next] = sample
+
+	if ring.next++; ring.next >= len(ring.entries) {
+		ring.next = 0
+		ring.full = true
+	}
+}
+
+// Returns stored samples ordered from oldest to newest
+func (ring *UsageRing) Samples() []UsageSample {
+
+	ring.mtx.Lock()
+	defer ring.mtx.Unlock()
+
+	if !ring.full {
+		return append([]UsageSample{}, ring.entries[:ring.next]...)
+	}
+
+	entries := make([]UsageSample, 0, len(ring.entries))
+	entries = append(entries, ring.entries[ring.next:]...)
+	entries = append(entries, ring.entries[:ring.next]...)
+
+	return entries
+}
@@ -0,0 +1,37 @@
+package nxproxy_test
+
+import (
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestUsageRing_1(t *testing.T) {
+
+	ring := nxproxy.NewUsageRing(3)
+
+	if samples := ring.Samples(); len(samples) != 0 {
+		t.Errorf("unexpected samples: %v", samples)
+	}
+
+	started := time.Now()
+
+	for idx := range 5 {
+		ring.Push(nxproxy.UsageSample{
+			Time: started.Add(time.Duration(idx) * time.Second),
+			Rx:   uint64(idx),
+		})
+	}
+
+	samples := ring.Samples()
+	if len(samples) != 3 {
+		t.Fatalf("unexpected sample count: %d", len(samples))
+	}
+
+	for idx, val := range samples {
+		if want := uint64(idx + 2); val.Rx != want {
+			t.Errorf("unexpected sample at %d; expected: %d; got: %d", idx, want, val.Rx)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package nxproxy
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,15 +23,58 @@ var DefaultRatelimiter = RateLimiterOptions{
 	Window: 5 * time.Minute,
 }
 
+type RateLimitMode string
+
+const (
+
+	//	counts attempts against the quota until no attempts are made for a whole window, then restores it at once
+	RateLimitFixedWindow = RateLimitMode("fixed")
+
+	//	generic cell rate algorithm: the quota is restored gradually, one attempt every window/quota,
+	//	so that clients can't burst through it again as soon as a window is over
+	RateLimitGcra = RateLimitMode("gcra")
+)
+
+func (val RateLimitMode) Valid() bool {
+	return val == "" || val == RateLimitFixedWindow || val == RateLimitGcra
+}
+
+// Classes of rate limited keys that may be given their own quotas
+type RateLimitClass string
+
+const (
+	RateLimitClassDefault = RateLimitClass("")
+
+	//	keys that have run out of their quota before; they stay in the class until their counter gets dropped
+	RateLimitClassOffender = RateLimitClass("offender")
+)
+
 type RlCounter struct {
 	init    int64
 	quota   atomic.Int64
 	expires time.Time
 	mod     atomic.Bool
+
+	//	set once the key runs out of its quota
+	offender atomic.Bool
+
+	//	gcra state; the quota counter isn't used when the interval is set
+	clock     Clock
+	interval  time.Duration
+	tolerance time.Duration
+	tat       time.Time
+	gcraMtx   sync.Mutex
+
+	rl *RateLimiter
 }
 
 func (rlc *RlCounter) Reset() {
+
 	rlc.quota.Store(rlc.init)
+
+	rlc.gcraMtx.Lock()
+	rlc.tat = time.Time{}
+	rlc.gcraMtx.Unlock()
 }
 
 func (rlc *RlCounter) resetTo(val int64) {
@@ -36,30 +82,166 @@ func (rlc *RlCounter) resetTo(val int64) {
 	rlc.quota.Store(val)
 }
 
+// Spreads the quota over the window, allowing the whole of it in a burst
+func (rlc *RlCounter) setGcra(clock Clock, quota int64, window time.Duration) {
+
+	rlc.gcraMtx.Lock()
+	defer rlc.gcraMtx.Unlock()
+
+	rlc.clock = clock
+	rlc.interval = 0
+
+	if quota > 0 && window > 0 {
+		rlc.interval = max(window/time.Duration(quota), 1)
+		rlc.tolerance = window - rlc.interval
+	}
+}
+
 func (rlc *RlCounter) Use() error {
 
+	if rlc.rl != nil {
+		rlc.rl.attempts.Add(1)
+	}
+
 	if rlc.init <= 0 {
 		return nil
 	}
 
+	rlc.gcraMtx.Lock()
+	gcra := rlc.interval > 0
+	rlc.gcraMtx.Unlock()
+
+	if gcra {
+		return rlc.useGcra()
+	}
+
 	if rlc.quota.Add(-1) < 0 {
+		rlc.limited()
 		return &RateLimitError{Expires: rlc.expires}
 	}
 
 	return nil
 }
 
+func (rlc *RlCounter) limited() {
+
+	rlc.offender.Store(true)
+
+	if rlc.rl != nil {
+		rlc.rl.hits.Add(1)
+	}
+}
+
+// Returns the time the key gets its next attempt at if it's out of its quota at the moment
+func (rlc *RlCounter) limitedUntil(now time.Time) (time.Time, bool) {
+
+	if rlc.init <= 0 {
+		return time.Time{}, false
+	}
+
+	rlc.gcraMtx.Lock()
+	defer rlc.gcraMtx.Unlock()
+
+	if rlc.interval > 0 {
+		allowedAt := rlc.tat.Add(-rlc.tolerance)
+		return allowedAt, allowedAt.After(now)
+	}
+
+	return rlc.expires, rlc.quota.Load() <= 0 && rlc.expires.After(now)
+}
+
+func (rlc *RlCounter) useGcra() error {
+
+	rlc.gcraMtx.Lock()
+	defer rlc.gcraMtx.Unlock()
+
+	now := rlc.clock.Now()
+
+	tat := rlc.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	if allowedAt := tat.Add(-rlc.tolerance); allowedAt.After(now) {
+		rlc.limited()
+		return &RateLimitError{Expires: allowedAt}
+	}
+
+	rlc.tat = tat.Add(rlc.interval)
+
+	return nil
+}
+
 type RateLimiterOptions struct {
 	Quota  int64
 	Window time.Duration
+
+	//	fixed window when unset
+	Mode RateLimitMode
+
+	//	quotas that replace the default one for classes of keys; a non-positive quota lifts the limit for the class.
+	//	a key gets its class quota once its current window is over, or right away if it's new
+	ClassQuotas map[RateLimitClass]int64
+
+	//	optional classifier of keys; offenders are classified as such regardless of it
+	Classify func(key string) RateLimitClass
+}
+
+func (opts *RateLimiterOptions) Validate() error {
+
+	if !opts.Mode.Valid() {
+		return fmt.Errorf("unsupported mode '%s'", opts.Mode)
+	}
+
+	if opts.Window <= 0 && opts.Quota > 0 {
+		return errors.New("window must be positive")
+	}
+
+	return nil
+}
+
+// Returns the quota of a key counter
+func (opts *RateLimiterOptions) quotaOf(key string, ctr *RlCounter) int64 {
+
+	class := RateLimitClassDefault
+
+	if ctr.offender.Load() {
+		class = RateLimitClassOffender
+	} else if opts.Classify != nil {
+		class = opts.Classify(key)
+	}
+
+	if quota, has := opts.ClassQuotas[class]; has {
+		return quota
+	}
+
+	return opts.Quota
 }
 
 type RateLimiter struct {
 	RateLimiterOptions
 
+	//	optional time source; the system clock is used when unset
+	Clock Clock
+
+	//	set on limiters that are shared by multiple slots
+	Shared bool
+
 	entries          map[string]*RlCounter
 	mtx              sync.Mutex
 	cleanupScheduled atomic.Bool
+
+	attempts atomic.Uint64
+	hits     atomic.Uint64
+}
+
+func (rl *RateLimiter) clock() Clock {
+
+	if rl.Clock != nil {
+		return rl.Clock
+	}
+
+	return SystemClock
 }
 
 func (rl *RateLimiter) Get(key string) *RlCounter {
@@ -72,19 +254,24 @@ func (rl *RateLimiter) Get(key string) *RlCounter {
 	}
 
 	if rl.cleanupScheduled.CompareAndSwap(false, true) {
-		time.AfterFunc(time.Minute, rl.cleanup)
+		rl.clock().AfterFunc(time.Minute, rl.cleanup)
 	}
 
 	ctr := rl.entries[key]
 	if ctr == nil {
-		ctr = &RlCounter{init: rl.Quota}
+		ctr = &RlCounter{rl: rl}
+		ctr.resetTo(rl.quotaOf(key, ctr))
 		rl.entries[key] = ctr
 	}
 
-	now := time.Now()
+	now := rl.clock().Now()
 
 	if ctr.expires.Before(now) {
-		ctr.resetTo(rl.Quota)
+		ctr.resetTo(rl.quotaOf(key, ctr))
+	}
+
+	if rl.Mode == RateLimitGcra {
+		ctr.setGcra(rl.clock(), ctr.init, rl.Window)
 	}
 
 	ctr.expires = now.Add(rl.Window)
@@ -100,14 +287,14 @@ func (rl *RateLimiter) cleanup() {
 
 	defer rl.cleanupScheduled.Store(false)
 
-	now := time.Now()
+	now := rl.clock().Now()
 
 	for key, entry := range rl.entries {
 
 		if entry.expires.Before(now) {
 
 			if entry.mod.Load() {
-				entry.resetTo(rl.Quota)
+				entry.resetTo(rl.quotaOf(key, entry))
 				entry.mod.Store(false)
 				continue
 			}
@@ -116,3 +303,97 @@ func (rl *RateLimiter) cleanup() {
 		}
 	}
 }
+
+// Snapshot of rate limiter state
+type RateLimiterInfo struct {
+	Mode      RateLimitMode `json:"mode"`
+	Quota     int64         `json:"quota"`
+	WindowSec int64         `json:"window_sec"`
+
+	//	set when the limiter is shared with other slots of the node, in which case the rest of the info is node-wide
+	Shared bool `json:"shared"`
+
+	//	keys that have a counter at the moment
+	Tracked int `json:"tracked"`
+
+	//	keys that are out of their quota at the moment
+	Limited int `json:"limited"`
+
+	//	tracked keys that have run out of their quota before
+	Offenders int `json:"offenders"`
+
+	//	attempts counted and attempts refused over the limiter lifetime
+	Attempts uint64 `json:"attempts"`
+	Hits     uint64 `json:"hits"`
+}
+
+func (rl *RateLimiter) Info() RateLimiterInfo {
+
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	info := RateLimiterInfo{
+		Mode:      rl.Mode,
+		Quota:     rl.Quota,
+		WindowSec: int64(rl.Window.Seconds()),
+		Tracked:   len(rl.entries),
+		Attempts:  rl.attempts.Load(),
+		Hits:      rl.hits.Load(),
+	}
+
+	if info.Mode == "" {
+		info.Mode = RateLimitFixedWindow
+	}
+
+	now := rl.clock().Now()
+
+	for _, entry := range rl.entries {
+
+		if _, limited := entry.limitedUntil(now); limited {
+			info.Limited++
+		}
+
+		if entry.offender.Load() {
+			info.Offenders++
+		}
+	}
+
+	return info
+}
+
+// A key that's out of its quota
+type RateLimitedKey struct {
+	Key      string    `json:"key"`
+	Until    time.Time `json:"until"`
+	Offender bool      `json:"offender"`
+}
+
+// Returns keys that are out of their quota at the moment, the ones limited for the longest first
+func (rl *RateLimiter) LimitedKeys() []RateLimitedKey {
+
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	now := rl.clock().Now()
+
+	var entries []RateLimitedKey
+
+	for key, entry := range rl.entries {
+		if until, limited := entry.limitedUntil(now); limited {
+			entries = append(entries, RateLimitedKey{
+				Key:      key,
+				Until:    until,
+				Offender: entry.offender.Load(),
+			})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b RateLimitedKey) int {
+		if val := b.Until.Compare(a.Until); val != 0 {
+			return val
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return entries
+}
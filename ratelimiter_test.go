@@ -0,0 +1,306 @@
+package nxproxy_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestRateLimiter_Window(t *testing.T) {
+
+	clock := nxproxy.NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl := nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{Quota: 2, Window: 5 * time.Minute},
+		Clock:              clock,
+	}
+
+	for range 2 {
+		if err := rl.Get("client").Use(); err != nil {
+			t.Fatalf("limited within the quota: %v", err)
+		}
+	}
+
+	var rlErr *nxproxy.RateLimitError
+	if err := rl.Get("client").Use(); !errors.As(err, &rlErr) {
+		t.Fatalf("not limited past the quota: %v", err)
+	} else if !rlErr.Expires.Equal(clock.Now().Add(5 * time.Minute)) {
+		t.Errorf("unexpected expiry: %v", rlErr.Expires)
+	}
+
+	//	every attempt extends the window
+	clock.Advance(4 * time.Minute)
+
+	if err := rl.Get("client").Use(); err == nil {
+		t.Errorf("limit lifted before the window has passed")
+	}
+
+	clock.Advance(5*time.Minute + time.Second)
+
+	if err := rl.Get("client").Use(); err != nil {
+		t.Errorf("limit not lifted after the window: %v", err)
+	}
+}
+
+func TestRateLimiter_Cleanup(t *testing.T) {
+
+	clock := nxproxy.NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl := nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{Quota: 1, Window: 30 * time.Second},
+		Clock:              clock,
+	}
+
+	ctr := rl.Get("client")
+	ctr.Use()
+
+	//	expired counters that were used are reset by the first cleanup and dropped by the next one
+	clock.Advance(time.Minute)
+
+	if err := ctr.Use(); err != nil {
+		t.Errorf("expired counter not reset by cleanup: %v", err)
+	}
+
+	rl.Get("other")
+	clock.Advance(time.Minute)
+
+	if next := rl.Get("client"); next == ctr {
+		t.Errorf("expired counter not dropped by cleanup")
+	}
+}
+
+func TestRateLimiter_Gcra(t *testing.T) {
+
+	clock := nxproxy.NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl := nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{Quota: 5, Window: 5 * time.Minute, Mode: nxproxy.RateLimitGcra},
+		Clock:              clock,
+	}
+
+	//	the whole quota may be used in a burst
+	for range 5 {
+		if err := rl.Get("client").Use(); err != nil {
+			t.Fatalf("limited within the quota: %v", err)
+		}
+	}
+
+	var rlErr *nxproxy.RateLimitError
+	if err := rl.Get("client").Use(); !errors.As(err, &rlErr) {
+		t.Fatalf("not limited past the quota: %v", err)
+	} else if !rlErr.Expires.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("unexpected expiry: %v", rlErr.Expires)
+	}
+
+	//	after that, attempts are only allowed at the rate of the quota
+	clock.Advance(time.Minute)
+
+	if err := rl.Get("client").Use(); err != nil {
+		t.Errorf("limit not lifted after an interval: %v", err)
+	}
+
+	if err := rl.Get("client").Use(); err == nil {
+		t.Errorf("burst allowed right after a window boundary")
+	}
+
+	//	successful attempts restore the quota
+	rl.Get("client").Reset()
+
+	for range 5 {
+		if err := rl.Get("client").Use(); err != nil {
+			t.Fatalf("quota not restored by a reset: %v", err)
+		}
+	}
+}
+
+func TestRateLimiter_OffenderQuota(t *testing.T) {
+
+	clock := nxproxy.NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl := nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{
+			Quota:       3,
+			Window:      time.Minute,
+			ClassQuotas: map[nxproxy.RateLimitClass]int64{nxproxy.RateLimitClassOffender: 1},
+			Classify: func(key string) nxproxy.RateLimitClass {
+				if key == "trusted" {
+					return "trusted"
+				}
+				return nxproxy.RateLimitClassDefault
+			},
+		},
+		Clock: clock,
+	}
+
+	rl.ClassQuotas["trusted"] = 0
+
+	var attempts = func(key string) int {
+		for idx := range 10 {
+			if err := rl.Get(key).Use(); err != nil {
+				return idx
+			}
+		}
+		return 10
+	}
+
+	if val := attempts("client"); val != 3 {
+		t.Errorf("unexpected default quota: %d", val)
+	}
+
+	if val := attempts("trusted"); val != 10 {
+		t.Errorf("limit not lifted for a class: %d", val)
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	if val := attempts("client"); val != 1 {
+		t.Errorf("unexpected offender quota: %d", val)
+	}
+
+	if val := attempts("other"); val != 3 {
+		t.Errorf("offender quota applied to another key: %d", val)
+	}
+}
+
+func TestRateLimiter_Info(t *testing.T) {
+
+	clock := nxproxy.NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rl := nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{Quota: 2, Window: time.Minute},
+		Clock:              clock,
+	}
+
+	for range 3 {
+		rl.Get("pw:198.51.100.1").Use()
+	}
+
+	rl.Get("pw:198.51.100.2").Use()
+
+	info := rl.Info()
+
+	expect := nxproxy.RateLimiterInfo{
+		Mode:      nxproxy.RateLimitFixedWindow,
+		Quota:     2,
+		WindowSec: 60,
+		Tracked:   2,
+		Limited:   1,
+		Offenders: 1,
+		Attempts:  4,
+		Hits:      1,
+	}
+
+	if info != expect {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	keys := rl.LimitedKeys()
+	if len(keys) != 1 || keys[0].Key != "pw:198.51.100.1" || !keys[0].Offender || !keys[0].Until.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("unexpected limited keys: %+v", keys)
+	}
+
+	//	offenders are still reported as such once their limit is lifted
+	clock.Advance(2 * time.Minute)
+
+	if info := rl.Info(); info.Limited != 0 || info.Offenders != 1 {
+		t.Errorf("unexpected info after the window: %+v", info)
+	}
+
+	if keys := rl.LimitedKeys(); len(keys) != 0 {
+		t.Errorf("unexpected limited keys after the window: %+v", keys)
+	}
+}
+
+func TestSlot_SharedRateLimiter(t *testing.T) {
+
+	shared := &nxproxy.RateLimiter{
+		RateLimiterOptions: nxproxy.RateLimiterOptions{Quota: 2, Window: time.Minute},
+		Shared:             true,
+	}
+
+	slotA := nxproxy.Slot{Rl: shared}
+	slotB := nxproxy.Slot{Rl: shared}
+
+	optsA := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}
+	optsB := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1081"}
+
+	for _, entry := range []struct {
+		slot *nxproxy.Slot
+		opts nxproxy.SlotOptions
+	}{{&slotA, optsA}, {&slotB, optsB}} {
+		if err := entry.slot.SetOptions(entry.opts); err != nil {
+			t.Fatalf("set options: %v", err)
+		}
+	}
+
+	ip := net.ParseIP("198.51.100.1")
+
+	var limited = func(slot *nxproxy.Slot) bool {
+		_, err := slot.LookupWithPassword(ip, "nobody", "guess")
+		var rlErr *nxproxy.RateLimitError
+		return errors.As(err, &rlErr)
+	}
+
+	//	attempts on one slot count against the quota on the other ones
+	limited(&slotA)
+	limited(&slotA)
+
+	if !limited(&slotB) {
+		t.Fatalf("quota not shared between slots")
+	}
+
+	if info := slotA.Info(); info.AuthRateLimit == nil || !info.AuthRateLimit.Shared {
+		t.Errorf("shared limiter not reported as such: %+v", info.AuthRateLimit)
+	}
+
+	//	overrides give the slot its own quota, which survives reloads with the same options
+	optsB.AuthRateLimit = &nxproxy.SlotRateLimit{Quota: 3, WindowSec: 60}
+
+	if err := slotB.SetOptions(optsB); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	for idx := range 3 {
+		if limited(&slotB) {
+			t.Fatalf("limited within the slot quota: attempt %d", idx)
+		}
+	}
+
+	if err := slotB.SetOptions(optsB); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	if !limited(&slotB) {
+		t.Errorf("slot quota restored by a reload")
+	}
+
+	if !limited(&slotA) {
+		t.Errorf("shared quota restored by a slot override")
+	}
+
+	if keys := slotB.RateLimitedKeys(); len(keys) != 1 || keys[0].Key != "pw:198.51.100.1" {
+		t.Errorf("unexpected slot keys: %+v", keys)
+	}
+
+	if keys := slotA.RateLimitedKeys(); len(keys) != 0 {
+		t.Errorf("shared keys reported by a slot: %+v", keys)
+	}
+
+	//	dropping the override brings the slot back to the shared limiter
+	optsB.AuthRateLimit = nil
+
+	if err := slotB.SetOptions(optsB); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	if info := slotB.Info(); info.AuthRateLimit == nil || !info.AuthRateLimit.Shared {
+		t.Errorf("slot not back on the shared limiter: %+v", info.AuthRateLimit)
+	}
+
+	if !limited(&slotB) {
+		t.Errorf("shared quota not applied after dropping the override")
+	}
+}
@@ -1,22 +1,154 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	nxproxy "github.com/maddsua/nx-proxy"
 )
 
 type FullConfig struct {
+	Services  []nxproxy.ServiceOptions `json:"services"`
+	DNS       string                   `json:"dns"`
+	LogStream *LogStream               `json:"log_stream,omitempty"`
+
+	//	optional checksum of the services as returned by nxproxy.ConfigChecksum; echoed back in status reports
+	//	along with the checksum of what the node has actually applied
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// A part of the full config. Nodes request pages until they get the one with a commit marker
+// and only apply the config once all of its pages have been received
+type ConfigPage struct {
+
+	//	identifies the config snapshot that the page belongs to
+	Revision string                   `json:"revision"`
 	Services []nxproxy.ServiceOptions `json:"services"`
-	DNS      string                   `json:"dns"`
+
+	//	cursor of the next page; not set on the last one
+	Next string `json:"next,omitempty"`
+
+	//	commit marker, only set on the last page
+	Commit *ConfigCommit `json:"commit,omitempty"`
+}
+
+// Carries node-wide options of a paged config
+type ConfigCommit struct {
+
+	//	total number of services across all pages
+	Services  int        `json:"services"`
+	DNS       string     `json:"dns"`
+	LogStream *LogStream `json:"log_stream,omitempty"`
+	Checksum  string     `json:"checksum,omitempty"`
+}
+
+// Asks a node to send its logs, including debug ones, for a limited time
+type LogStream struct {
+	ID uuid.UUID `json:"id"`
+
+	//	stream duration in seconds
+	Duration int `json:"duration"`
+
+	//	optional filters; peer matches either the id or the name and slot matches either the bind address or the slot id
+	Peer string `json:"peer,omitempty"`
+	Slot string `json:"slot,omitempty"`
+}
+
+type LogBatch struct {
+	StreamID uuid.UUID  `json:"stream_id"`
+	Entries  []LogEntry `json:"entries"`
+
+	//	number of entries that didn't fit into the buffer
+	Dropped int `json:"dropped,omitempty"`
+
+	//	set on the last batch of a stream
+	Done bool `json:"done,omitempty"`
+}
+
+type LogEntry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
 }
 
 type Status struct {
-	Service ServiceInfo         `json:"service"`
-	Deltas  []nxproxy.PeerDelta `json:"deltas"`
-	Slots   []nxproxy.SlotInfo
+	Service   ServiceInfo         `json:"service"`
+	Deltas    []nxproxy.PeerDelta `json:"deltas"`
+	Slots     []nxproxy.SlotInfo
+	Failures  []nxproxy.PeerFailures  `json:"failures,omitempty"`
+	Latency   []nxproxy.PeerLatency   `json:"latency,omitempty"`
+	Activity  []nxproxy.PeerActivity  `json:"activity,omitempty"`
+	Blocked   []nxproxy.BlockedDest   `json:"blocked,omitempty"`
+	Leaks     []nxproxy.LeakEvent     `json:"leaks,omitempty"`
+	Disables  []nxproxy.PeerDisable   `json:"disables,omitempty"`
+	FramedIPs []nxproxy.FramedIPIssue `json:"framed_ips,omitempty"`
+	Watchdog  *WatchdogReport         `json:"watchdog,omitempty"`
+	Blocklist *nxproxy.BlocklistStats `json:"blocklist,omitempty"`
+
+	//	public addresses assigned to the node interfaces, which slots may bind to and peers may use as framed ips
+	Addrs []NodeAddr `json:"addrs,omitempty"`
+
+	//	outcome of the latest config update; only sent when it changes
+	Config *nxproxy.ConfigReport `json:"config,omitempty"`
+
+	//	time span the deltas cover; only set when the delta queue gets flushed, which it may be with no deltas at all
+	DeltaWindow *DeltaWindow `json:"delta_window,omitempty"`
+
+	//	checksum of the config entries the node is running and the one sent with the config by the backend;
+	//	the expected one is missing when the backend doesn't send checksums
+	ConfigChecksum   string `json:"config_checksum,omitempty"`
+	ExpectedChecksum string `json:"expected_checksum,omitempty"`
+}
+
+// Time span covered by the deltas of a status report. Traffic isn't attributed within it any more precisely,
+// so backends should book deltas by their window rather than by the time they arrive at, as reports may be held back or retried
+type DeltaWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
 }
 
 type ServiceInfo struct {
-	RunID  uuid.UUID `json:"run_id"`
-	Uptime int64     `json:"uptime"`
+	RunID uuid.UUID `json:"run_id"`
+
+	//	seconds since the node has started, taken from the monotonic clock so that wall clock adjustments don't affect it
+	Uptime int64 `json:"uptime"`
+
+	//	node wall clock at the time the status was sent, along with its timezone name and utc offset in seconds
+	Time      time.Time `json:"time"`
+	Timezone  string    `json:"timezone,omitempty"`
+	UtcOffset int       `json:"utc_offset"`
+
+	//	duration of the previous status push in milliseconds, and the number of pushes skipped since the start
+	//	because the previous one was still running; both grow when the backend can't keep up with the node
+	PushLatency   int64  `json:"push_latency"`
+	PushesSkipped uint64 `json:"pushes_skipped"`
+}
+
+// Clock difference beyond which node and backend clocks are considered to be out of sync
+const MaxClockSkew = 30 * time.Second
+
+// Returns how far the node clock is ahead of a reference time, such as the time its status has been received at.
+// The result includes the delivery delay, so it's only meaningful when it's large; nothing is returned for nodes
+// that don't report their time
+func (info *ServiceInfo) ClockSkew(ref time.Time) (time.Duration, bool) {
+
+	if info.Time.IsZero() {
+		return 0, false
+	}
+
+	return info.Time.Sub(ref), true
+}
+
+type NodeAddr struct {
+	IP        string `json:"ip"`
+	Interface string `json:"interface"`
+}
+
+type WatchdogReport struct {
+	Goroutines          int      `json:"goroutines"`
+	OpenFiles           int      `json:"open_files"`
+	Connections         int      `json:"connections"`
+	OrphanedConnections int      `json:"orphaned_connections"`
+	Anomalies           []string `json:"anomalies,omitempty"`
 }
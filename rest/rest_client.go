@@ -3,6 +3,7 @@ package rest
 import (
 	"net/http"
 	"net/url"
+	"sync/atomic"
 
 	nxproxy "github.com/maddsua/nx-proxy"
 	"github.com/maddsua/nx-proxy/rest/model"
@@ -11,16 +12,36 @@ import (
 type Client struct {
 	URL   *url.URL
 	Token *nxproxy.ServerToken
+
+	//	set once the backend turns out not to serve config pages
+	noPaging atomic.Bool
 }
 
 func (client *Client) PostStatus(status *model.Status) error {
 	return beacon(client.URL, client.Token, http.MethodPost, "/nxproxy/v1/status", status)
 }
 
+// Pulls the full config. Paged retrieval is preferred; the whole config is requested at once
+// from backends that don't implement it
 func (client *Client) PullConfig() (*model.FullConfig, error) {
+
+	if !client.noPaging.Load() {
+
+		cfg, err := client.pullConfigPages()
+		if !isNotImplemented(err) {
+			return cfg, err
+		}
+
+		client.noPaging.Store(true)
+	}
+
 	return fetch[model.FullConfig](client.URL, client.Token, http.MethodGet, "/nxproxy/v1/config", nil)
 }
 
+func (client *Client) PostLogs(batch *model.LogBatch) error {
+	return beacon(client.URL, client.Token, http.MethodPost, "/nxproxy/v1/logs", batch)
+}
+
 func (client *Client) Ping() error {
 	return beacon(client.URL, client.Token, http.MethodGet, "/nxproxy/v1/ping", nil)
 }
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// Compresses responses for clients that accept gzip
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		wrt.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(req.Header) {
+			next.ServeHTTP(wrt, req)
+			return
+		}
+
+		gzwrt := gzipResponseWriter{ResponseWriter: wrt}
+		defer gzwrt.Close()
+
+		next.ServeHTTP(&gzwrt, req)
+	})
+}
+
+func acceptsGzip(header http.Header) bool {
+
+	for _, val := range strings.Split(header.Get("Accept-Encoding"), ",") {
+
+		coding, params, _ := strings.Cut(strings.TrimSpace(val), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (wrt *gzipResponseWriter) WriteHeader(status int) {
+
+	if wrt.wroteHeader {
+		return
+	}
+
+	wrt.wroteHeader = true
+
+	//	bodiless responses are left as they are, as the gzip trailer would make them have one
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		wrt.Header().Set("Content-Encoding", "gzip")
+		wrt.Header().Del("Content-Length")
+		wrt.gz = gzipWriterPool.Get().(*gzip.Writer)
+		wrt.gz.Reset(wrt.ResponseWriter)
+	}
+
+	wrt.ResponseWriter.WriteHeader(status)
+}
+
+func (wrt *gzipResponseWriter) Write(data []byte) (int, error) {
+
+	if !wrt.wroteHeader {
+		wrt.WriteHeader(http.StatusOK)
+	}
+
+	if wrt.gz == nil {
+		return wrt.ResponseWriter.Write(data)
+	}
+
+	return wrt.gz.Write(data)
+}
+
+func (wrt *gzipResponseWriter) Close() error {
+
+	if wrt.gz == nil {
+		return nil
+	}
+
+	err := wrt.gz.Close()
+	gzipWriterPool.Put(wrt.gz)
+	wrt.gz = nil
+
+	return err
+}
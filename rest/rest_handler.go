@@ -11,12 +11,35 @@ import (
 	"github.com/maddsua/nx-proxy/rest/model"
 )
 
+// Procedure a node request is made to
+type Resource string
+
+const (
+	ResourceConfig = Resource("config")
+	ResourceStatus = Resource("status")
+	ResourceLogs   = Resource("logs")
+)
+
 type ProcedureHandler struct {
 	HandleFullConfig func(ctx context.Context, token *nxproxy.ServerToken) (*model.FullConfig, error)
 	HandleStatus     func(ctx context.Context, token *nxproxy.ServerToken, status *model.Status) error
+	HandleLogs       func(ctx context.Context, token *nxproxy.ServerToken, batch *model.LogBatch) error
+
+	//	optional; when not set, config pages are cut from the HandleFullConfig result
+	HandleConfigPage func(ctx context.Context, token *nxproxy.ServerToken, cursor string) (*model.ConfigPage, error)
+
+	//	optional; called with the parsed token before any procedure handler runs. An error rejects the request,
+	//	with 403 unless it carries its own status. The returned context is passed on to the procedure handler,
+	//	so that a controller serving multiple tenants can resolve the tenant once and scope what the handlers see.
+	//	a nil context keeps the request one
+	Authorize func(ctx context.Context, token *nxproxy.ServerToken, resource Resource) (context.Context, error)
 }
 
 func NewHandler(proc ProcedureHandler) http.Handler {
+	return compressResponses(newServeMux(proc))
+}
+
+func newServeMux(proc ProcedureHandler) *http.ServeMux {
 
 	mux := http.NewServeMux()
 
@@ -26,10 +49,39 @@ func NewHandler(proc ProcedureHandler) http.Handler {
 			panic(fmt.Errorf("nx-proxy.ProcedureHandler.HandleFullConfig not implemented"))
 		}
 
-		if token := handleRequestAuth(wrt, req); token != nil {
-			result, err := proc.HandleFullConfig(req.Context(), token)
+		if ctx, token := proc.handleRequestAuth(wrt, req, ResourceConfig); token != nil {
+			result, err := proc.HandleFullConfig(ctx, token)
+			writeResponse(wrt, result, err)
+		}
+	}))
+
+	mux.Handle("GET /nxproxy/v1/config/pages", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		if proc.HandleConfigPage == nil && proc.HandleFullConfig == nil {
+			panic(fmt.Errorf("nx-proxy.ProcedureHandler.HandleConfigPage not implemented"))
+		}
+
+		ctx, token := proc.handleRequestAuth(wrt, req, ResourceConfig)
+		if token == nil {
+			return
+		}
+
+		cursor := req.URL.Query().Get("cursor")
+
+		if proc.HandleConfigPage != nil {
+			result, err := proc.HandleConfigPage(ctx, token, cursor)
 			writeResponse(wrt, result, err)
+			return
+		}
+
+		cfg, err := proc.HandleFullConfig(ctx, token)
+		if err != nil {
+			writeResponse[any](wrt, nil, err)
+			return
 		}
+
+		result, err := PageConfig(cfg, cursor)
+		writeResponse(wrt, result, err)
 	}))
 
 	mux.Handle("POST /nxproxy/v1/status", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
@@ -39,8 +91,25 @@ func NewHandler(proc ProcedureHandler) http.Handler {
 		}
 
 		if status := handleRequestBody[model.Status](wrt, req); status != nil {
-			if token := handleRequestAuth(wrt, req); token != nil {
-				if err := proc.HandleStatus(req.Context(), token, status); err != nil {
+			if ctx, token := proc.handleRequestAuth(wrt, req, ResourceStatus); token != nil {
+				if err := proc.HandleStatus(ctx, token, status); err != nil {
+					writeResponse[any](wrt, nil, err)
+					return
+				}
+				wrt.WriteHeader(http.StatusNoContent)
+			}
+		}
+	}))
+
+	mux.Handle("POST /nxproxy/v1/logs", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		if proc.HandleLogs == nil {
+			panic(fmt.Errorf("nx-proxy.ProcedureHandler.HandleLogs not implemented"))
+		}
+
+		if batch := handleRequestBody[model.LogBatch](wrt, req); batch != nil {
+			if ctx, token := proc.handleRequestAuth(wrt, req, ResourceLogs); token != nil {
+				if err := proc.HandleLogs(ctx, token, batch); err != nil {
 					writeResponse[any](wrt, nil, err)
 					return
 				}
@@ -53,6 +122,8 @@ func NewHandler(proc ProcedureHandler) http.Handler {
 		wrt.WriteHeader(http.StatusNoContent)
 	}))
 
+	mux.Handle("GET /nxproxy/v1/openapi.json", http.HandlerFunc(serveOpenAPI))
+
 	return mux
 }
 
@@ -83,7 +154,8 @@ func handleRequestBody[T any](wrt http.ResponseWriter, req *http.Request) *T {
 	return &body
 }
 
-func handleRequestAuth(wrt http.ResponseWriter, req *http.Request) *nxproxy.ServerToken {
+// Parses the request token and runs the authorizer on it. Returns the context to run the procedure with; the token is nil when the request got rejected
+func (proc *ProcedureHandler) handleRequestAuth(wrt http.ResponseWriter, req *http.Request, resource Resource) (context.Context, *nxproxy.ServerToken) {
 
 	var unwrapToken = func() (*nxproxy.ServerToken, error) {
 		if schema, bearer, _ := strings.Cut(req.Header.Get("Authorization"), " "); strings.ToLower(schema) == "bearer" {
@@ -99,7 +171,7 @@ func handleRequestAuth(wrt http.ResponseWriter, req *http.Request) *nxproxy.Serv
 			Message: fmt.Sprintf("invalid token: %v", err),
 			Status:  http.StatusBadRequest,
 		})
-		return nil
+		return nil, nil
 
 	} else if token == nil {
 
@@ -107,8 +179,29 @@ func handleRequestAuth(wrt http.ResponseWriter, req *http.Request) *nxproxy.Serv
 			Message: "unauthorized",
 			Status:  http.StatusUnauthorized,
 		})
-		return nil
+		return nil, nil
+	}
+
+	ctx := req.Context()
+
+	if proc.Authorize == nil {
+		return ctx, token
+	}
+
+	authCtx, err := proc.Authorize(ctx, token, resource)
+	if err != nil {
+
+		if _, ok := err.(StatusCoder); !ok {
+			err = &APIError{Message: err.Error(), Status: http.StatusForbidden}
+		}
+
+		writeResponse[any](wrt, nil, err)
+		return nil, nil
+	}
+
+	if authCtx != nil {
+		ctx = authCtx
 	}
 
-	return token
+	return ctx, token
 }
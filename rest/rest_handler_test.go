@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+type tenantKey struct{}
+
+func TestHandler_Authorize(t *testing.T) {
+
+	tenantOf := map[string]string{}
+
+	handler := NewHandler(ProcedureHandler{
+		Authorize: func(ctx context.Context, token *nxproxy.ServerToken, resource Resource) (context.Context, error) {
+
+			tenant, has := tenantOf[token.ID.String()]
+			if !has {
+				return nil, errors.New("unknown node")
+			}
+
+			if tenant == "suspended" {
+				return nil, &APIError{Message: "tenant suspended", Status: http.StatusPaymentRequired}
+			}
+
+			return context.WithValue(ctx, tenantKey{}, tenant), nil
+		},
+		HandleFullConfig: func(ctx context.Context, token *nxproxy.ServerToken) (*model.FullConfig, error) {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return &model.FullConfig{DNS: tenant}, nil
+		},
+	})
+
+	clientA := newTestClient(t, handler)
+	clientB := newTestClient(t, handler)
+	clientC := newTestClient(t, handler)
+	clientX := newTestClient(t, handler)
+
+	tenantOf[clientA.Token.ID.String()] = "tenant-a"
+	tenantOf[clientB.Token.ID.String()] = "tenant-b"
+	tenantOf[clientC.Token.ID.String()] = "suspended"
+
+	for client, tenant := range map[*Client]string{clientA: "tenant-a", clientB: "tenant-b"} {
+
+		cfg, err := client.PullConfig()
+		if err != nil {
+			t.Fatalf("%s: pull config: %v", tenant, err)
+		}
+
+		if cfg.DNS != tenant {
+			t.Errorf("%s: got config of '%s'", tenant, cfg.DNS)
+		}
+	}
+
+	var statusOf = func(err error) int {
+		if err, ok := err.(StatusCoder); ok {
+			return err.StatusCode()
+		}
+		return 0
+	}
+
+	if _, err := clientX.PullConfig(); statusOf(err) != http.StatusForbidden {
+		t.Errorf("unknown node: unexpected error: %v", err)
+	}
+
+	if _, err := clientC.PullConfig(); statusOf(err) != http.StatusPaymentRequired {
+		t.Errorf("suspended tenant: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package rest
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+const apiPrefix = "/nxproxy/v1"
+
+// Describes an API endpoint for the generated OpenAPI document
+type apiRoute struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Auth    bool
+
+	//	names of optional string query parameters
+	Query []string
+
+	//	json body types; no body when nil
+	Request  reflect.Type
+	Response reflect.Type
+}
+
+// Endpoints served by NewHandler
+var apiRoutes = []apiRoute{
+	{
+		Method:   http.MethodGet,
+		Path:     "/config",
+		Tag:      "config",
+		Summary:  "Get full service configuration",
+		Auth:     true,
+		Response: reflect.TypeFor[model.FullConfig](),
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/config/pages",
+		Tag:      "config",
+		Summary:  "Get service configuration page by page; pages are requested until one has a commit marker",
+		Auth:     true,
+		Query:    []string{"cursor"},
+		Response: reflect.TypeFor[model.ConfigPage](),
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/status",
+		Tag:     "status",
+		Summary: "Report node status and traffic deltas",
+		Auth:    true,
+		Request: reflect.TypeFor[model.Status](),
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/logs",
+		Tag:     "status",
+		Summary: "Deliver a batch of streamed node logs",
+		Auth:    true,
+		Request: reflect.TypeFor[model.LogBatch](),
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/ping",
+		Tag:     "status",
+		Summary: "Returns an empty No-Content response",
+	},
+}
+
+var openAPIDocument = sync.OnceValues(func() ([]byte, error) {
+	return json.MarshalIndent(buildOpenAPI(apiRoutes), "", "  ")
+})
+
+// Returns an OpenAPI 3 document describing the endpoints that NewHandler serves
+func OpenAPIDocument() ([]byte, error) {
+	return openAPIDocument()
+}
+
+func serveOpenAPI(wrt http.ResponseWriter, _ *http.Request) {
+
+	doc, err := OpenAPIDocument()
+	if err != nil {
+		writeResponse[any](wrt, nil, &APIError{Message: err.Error(), Status: http.StatusInternalServerError})
+		return
+	}
+
+	wrt.Header().Set("Content-Type", "application/json")
+	wrt.Write(doc)
+}
+
+type jsonSchema = map[string]any
+
+func buildOpenAPI(routes []apiRoute) map[string]any {
+
+	gen := schemaGen{
+		schemas: map[string]jsonSchema{},
+		names:   map[reflect.Type]string{},
+	}
+
+	errorSchema := gen.schemaOf(reflect.TypeFor[APIError]())
+
+	var errorResponse = func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": jsonSchema{
+						"type":       "object",
+						"properties": map[string]any{"error": errorSchema},
+					},
+				},
+			},
+		}
+	}
+
+	paths := map[string]any{}
+
+	for _, route := range routes {
+
+		op := map[string]any{
+			"tags":        []string{route.Tag},
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + capitalize(strings.ReplaceAll(strings.Trim(route.Path, "/"), "/", "_")),
+			"parameters":  []map[string]any{},
+		}
+
+		for _, name := range route.Query {
+			op["parameters"] = append(op["parameters"].([]map[string]any), map[string]any{
+				"name":   name,
+				"in":     "query",
+				"schema": jsonSchema{"type": "string"},
+			})
+		}
+
+		if route.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": gen.schemaOf(route.Request)},
+				},
+			}
+		}
+
+		responses := map[string]any{}
+
+		if route.Response != nil {
+			responses["200"] = map[string]any{
+				"description": "Successful operation",
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": jsonSchema{
+							"type":       "object",
+							"properties": map[string]any{"data": gen.schemaOf(route.Response)},
+						},
+					},
+				},
+			}
+		} else {
+			responses["204"] = map[string]any{"description": "Successful operation"}
+		}
+
+		if route.Request != nil {
+			responses["400"] = errorResponse("Invalid request body")
+		}
+
+		if route.Auth {
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+			responses["401"] = errorResponse("No auth token provided")
+			responses["403"] = errorResponse("Auth token invalid")
+			responses["500"] = errorResponse("Something is broken on the backend")
+		}
+
+		op["responses"] = responses
+
+		item, _ := paths[route.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[route.Path] = item
+		}
+
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.4",
+		"info": map[string]any{
+			"title":       "NX-Proxy REST API",
+			"description": "These are the endpoints that your backend must implement",
+			"version":     "1.0.0",
+		},
+		"servers": []map[string]any{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Node server token",
+				},
+			},
+		},
+	}
+}
+
+// Derives json schemas from Go types the same way encoding/json serializes them
+type schemaGen struct {
+	schemas map[string]jsonSchema
+	names   map[reflect.Type]string
+}
+
+var (
+	typeTime          = reflect.TypeFor[time.Time]()
+	typeDuration      = reflect.TypeFor[time.Duration]()
+	typeUUID          = reflect.TypeFor[uuid.UUID]()
+	typeTextMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+	typeJsonMarshaler = reflect.TypeFor[json.Marshaler]()
+)
+
+func (gen *schemaGen) schemaOf(typ reflect.Type) jsonSchema {
+
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ {
+	case typeTime:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case typeDuration:
+		return jsonSchema{"type": "integer", "format": "int64"}
+	case typeUUID:
+		return jsonSchema{"type": "string", "format": "uuid"}
+	}
+
+	if implements(typ, typeJsonMarshaler) {
+		return jsonSchema{}
+	} else if implements(typ, typeTextMarshaler) {
+		return jsonSchema{"type": "string"}
+	}
+
+	switch typ.Kind() {
+
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return jsonSchema{"type": "integer", "format": "int32"}
+
+	case reflect.Int, reflect.Int64:
+		return jsonSchema{"type": "integer", "format": "int64"}
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonSchema{"type": "integer", "format": "int32", "minimum": 0}
+
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return jsonSchema{"type": "integer", "format": "int64", "minimum": 0}
+
+	case reflect.Float32:
+		return jsonSchema{"type": "number", "format": "float"}
+
+	case reflect.Float64:
+		return jsonSchema{"type": "number", "format": "double"}
+
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+
+		if typ.Elem().Kind() == reflect.Uint8 && typ.Kind() == reflect.Slice {
+			return jsonSchema{"type": "string", "format": "byte"}
+		}
+
+		return jsonSchema{"type": "array", "items": gen.schemaOf(typ.Elem())}
+
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": gen.schemaOf(typ.Elem())}
+
+	case reflect.Struct:
+
+		if typ.Name() == "" {
+			return gen.structSchema(typ)
+		}
+
+		return jsonSchema{"$ref": "#/components/schemas/" + gen.define(typ)}
+
+	default:
+		return jsonSchema{}
+	}
+}
+
+// Adds a named struct to document components and returns its schema name
+func (gen *schemaGen) define(typ reflect.Type) string {
+
+	if name, has := gen.names[typ]; has {
+		return name
+	}
+
+	name := typ.Name()
+
+	//	same named types from different packages get prefixed with their package names
+	if _, taken := gen.schemas[name]; taken {
+		name = capitalize(path.Base(typ.PkgPath())) + name
+	}
+
+	gen.names[typ] = name
+
+	//	reserves the name for recursive types
+	gen.schemas[name] = nil
+	gen.schemas[name] = gen.structSchema(typ)
+
+	return name
+}
+
+func (gen *schemaGen) structSchema(typ reflect.Type) jsonSchema {
+
+	properties := map[string]any{}
+	var required []string
+
+	var addFields func(typ reflect.Type)
+	addFields = func(typ reflect.Type) {
+
+		for idx := range typ.NumField() {
+
+			field := typ.Field(idx)
+
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(tag, ",")
+
+			//	embedded structs without a json name have their fields promoted
+			if field.Anonymous && name == "" {
+
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+
+				if embedded.Kind() == reflect.Struct {
+					addFields(embedded)
+					continue
+				}
+			}
+
+			if !field.IsExported() {
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = gen.schemaOf(field.Type)
+
+			omitEmpty := strings.Contains(","+opts+",", ",omitempty,") || strings.Contains(","+opts+",", ",omitzero,")
+			if !omitEmpty && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+
+	addFields(typ)
+
+	schema := jsonSchema{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func capitalize(val string) string {
+	if val == "" {
+		return val
+	}
+	return strings.ToUpper(val[:1]) + val[1:]
+}
+
+func implements(typ reflect.Type, iface reflect.Type) bool {
+	return typ.Implements(iface) || reflect.PointerTo(typ).Implements(iface)
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+
+	handler := newServeMux(ProcedureHandler{})
+
+	req := httptest.NewRequest(http.MethodGet, "/nxproxy/v1/openapi.json", nil)
+	wrt := httptest.NewRecorder()
+	handler.ServeHTTP(wrt, req)
+
+	if wrt.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", wrt.Code)
+	}
+
+	var doc struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	if err := json.Unmarshal(wrt.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	//	every documented route has to be served by the handler
+	for _, route := range apiRoutes {
+
+		if _, has := doc.Paths[route.Path][strings.ToLower(route.Method)]; !has {
+			t.Errorf("route %s %s not documented", route.Method, route.Path)
+		}
+
+		_, pattern := handler.Handler(httptest.NewRequest(route.Method, apiPrefix+route.Path, nil))
+		if pattern == "" {
+			t.Errorf("route %s %s not served", route.Method, route.Path)
+		}
+	}
+
+	for _, name := range []string{"FullConfig", "ServiceOptions", "PeerOptions", "Status", "LogBatch", "APIError"} {
+		if _, has := doc.Components.Schemas[name]; !has {
+			t.Errorf("schema %s missing", name)
+		}
+	}
+
+	//	embedded structs are flattened
+	if _, has := doc.Components.Schemas["ServiceOptions"].Properties["bind_addr"]; !has {
+		t.Errorf("ServiceOptions is missing embedded slot options")
+	}
+
+	if _, has := doc.Components.Schemas["PeerOptions"].Properties["dial_timeout_ms"]; !has {
+		t.Errorf("PeerOptions is missing embedded dial options")
+	}
+}
@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Number of times paging restarts when the config changes in the middle of it
+const maxConfigPageRetries = 3
+
+// Guards against backends that never send a commit marker
+const maxConfigPages = 100_000
+
+var errConfigRevisionChanged = errors.New("config revision changed while paging")
+
+// Splits a full config into pages of one service each. Cursors are page indexes;
+// the revision is derived from config contents, so that nodes can tell when it changes between page requests
+func PageConfig(cfg *model.FullConfig, cursor string) (*model.ConfigPage, error) {
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+
+	page := model.ConfigPage{
+		Revision: hex.EncodeToString(hash[:16]),
+	}
+
+	var idx int
+
+	if cursor != "" {
+		if idx, err = strconv.Atoi(cursor); err != nil || idx < 0 || idx >= max(len(cfg.Services), 1) {
+			return nil, &APIError{
+				Message: fmt.Sprintf("invalid page cursor: '%s'", cursor),
+				Status:  http.StatusBadRequest,
+			}
+		}
+	}
+
+	if idx < len(cfg.Services) {
+		page.Services = cfg.Services[idx : idx+1]
+	}
+
+	if next := idx + 1; next < len(cfg.Services) {
+		page.Next = strconv.Itoa(next)
+	} else {
+		page.Commit = &model.ConfigCommit{
+			Services:  len(cfg.Services),
+			DNS:       cfg.DNS,
+			LogStream: cfg.LogStream,
+			Checksum:  cfg.Checksum,
+		}
+	}
+
+	return &page, nil
+}
+
+// Pulls all config pages and assembles them into a full config, restarting if the config changes mid-way
+func (client *Client) pullConfigPages() (*model.FullConfig, error) {
+
+	for attempt := 1; ; attempt++ {
+
+		cfg, err := client.fetchConfigPages()
+		if errors.Is(err, errConfigRevisionChanged) && attempt < maxConfigPageRetries {
+			continue
+		}
+
+		return cfg, err
+	}
+}
+
+func (client *Client) fetchConfigPages() (*model.FullConfig, error) {
+
+	var cfg model.FullConfig
+	var revision string
+	var cursor string
+
+	for pageIdx := 0; pageIdx < maxConfigPages; pageIdx++ {
+
+		path := "/nxproxy/v1/config/pages"
+		if cursor != "" {
+			path += "?cursor=" + url.QueryEscape(cursor)
+		}
+
+		page, err := fetch[model.ConfigPage](client.URL, client.Token, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		} else if page == nil {
+			return nil, fmt.Errorf("api: empty config page")
+		}
+
+		if pageIdx == 0 {
+			revision = page.Revision
+		} else if page.Revision != revision {
+			return nil, errConfigRevisionChanged
+		}
+
+		cfg.Services = append(cfg.Services, page.Services...)
+
+		if commit := page.Commit; commit != nil {
+
+			if commit.Services != len(cfg.Services) {
+				return nil, fmt.Errorf("api: config commit expects %d services; %d received", commit.Services, len(cfg.Services))
+			}
+
+			cfg.DNS = commit.DNS
+			cfg.LogStream = commit.LogStream
+			cfg.Checksum = commit.Checksum
+
+			return &cfg, nil
+		}
+
+		if page.Next == "" || page.Next == cursor {
+			return nil, fmt.Errorf("api: config page has neither a commit marker nor a next page cursor")
+		}
+
+		cursor = page.Next
+	}
+
+	return nil, fmt.Errorf("api: config exceeds %d pages", maxConfigPages)
+}
+
+// Reports whether the backend doesn't implement an endpoint
+func isNotImplemented(err error) bool {
+	var coder StatusCoder
+	if errors.As(err, &coder) {
+		status := coder.StatusCode()
+		return status == http.StatusNotFound || status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+func newTestConfig(services int) *model.FullConfig {
+
+	cfg := model.FullConfig{DNS: "1.1.1.1"}
+
+	for idx := range services {
+		cfg.Services = append(cfg.Services, nxproxy.ServiceOptions{
+			SlotOptions: nxproxy.SlotOptions{
+				Proto:    nxproxy.ProxyProtoSocks,
+				BindAddr: fmt.Sprintf("127.0.0.1:%d", 1080+idx),
+			},
+		})
+	}
+
+	return &cfg
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	baseUrl, _ := url.Parse(srv.URL)
+
+	token, err := nxproxy.NewServerToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Client{URL: baseUrl, Token: token}
+}
+
+func TestClient_PullConfigPaged(t *testing.T) {
+
+	cfg := newTestConfig(5)
+
+	var fullCalls atomic.Int32
+	var gzipped atomic.Int32
+
+	handler := NewHandler(ProcedureHandler{
+		HandleFullConfig: func(ctx context.Context, token *nxproxy.ServerToken) (*model.FullConfig, error) {
+			fullCalls.Add(1)
+			return cfg, nil
+		},
+	})
+
+	client := newTestClient(t, http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		if acceptsGzip(req.Header) {
+			gzipped.Add(1)
+		}
+		handler.ServeHTTP(wrt, req)
+	}))
+
+	result, err := client.PullConfig()
+	if err != nil {
+		t.Fatalf("pull config: %v", err)
+	}
+
+	if len(result.Services) != len(cfg.Services) || result.DNS != cfg.DNS {
+		t.Fatalf("unexpected config: %d services, dns %s", len(result.Services), result.DNS)
+	}
+
+	for idx, entry := range result.Services {
+		if entry.BindAddr != cfg.Services[idx].BindAddr {
+			t.Errorf("service %d out of order: %s", idx, entry.BindAddr)
+		}
+	}
+
+	if calls := fullCalls.Load(); calls != int32(len(cfg.Services)) {
+		t.Errorf("expected a page per service, got %d requests", calls)
+	}
+
+	if gzipped.Load() != fullCalls.Load() {
+		t.Errorf("client doesn't accept gzip")
+	}
+}
+
+func TestClient_PullConfigRevisionChange(t *testing.T) {
+
+	var calls atomic.Int32
+
+	client := newTestClient(t, NewHandler(ProcedureHandler{
+		HandleFullConfig: func(ctx context.Context, token *nxproxy.ServerToken) (*model.FullConfig, error) {
+
+			//	the config gets updated right after the first page is served
+			if calls.Add(1) == 1 {
+				return newTestConfig(3), nil
+			}
+
+			return newTestConfig(4), nil
+		},
+	}))
+
+	result, err := client.PullConfig()
+	if err != nil {
+		t.Fatalf("pull config: %v", err)
+	}
+
+	if len(result.Services) != 4 {
+		t.Fatalf("expected the updated config to be pulled, got %d services", len(result.Services))
+	}
+}
+
+func TestClient_PullConfigFallback(t *testing.T) {
+
+	cfg := newTestConfig(2)
+
+	var fullRequests atomic.Int32
+
+	//	backends predating config paging only serve the full config
+	mux := http.NewServeMux()
+	mux.Handle("GET /nxproxy/v1/config", http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		fullRequests.Add(1)
+		writeResponse(wrt, cfg, nil)
+	}))
+
+	client := newTestClient(t, mux)
+
+	for range 2 {
+
+		result, err := client.PullConfig()
+		if err != nil {
+			t.Fatalf("pull config: %v", err)
+		}
+
+		if len(result.Services) != 2 {
+			t.Fatalf("unexpected services: %d", len(result.Services))
+		}
+	}
+
+	if !client.noPaging.Load() || fullRequests.Load() != 2 {
+		t.Errorf("client didn't fall back to full config requests")
+	}
+}
+
+func TestPageConfig_InvalidCursor(t *testing.T) {
+
+	cfg := newTestConfig(2)
+
+	for _, cursor := range []string{"-1", "2", "abc"} {
+		if _, err := PageConfig(cfg, cursor); err == nil {
+			t.Errorf("cursor '%s' accepted", cursor)
+		}
+	}
+
+	page, err := PageConfig(&model.FullConfig{}, "")
+	if err != nil {
+		t.Fatalf("page empty config: %v", err)
+	}
+
+	if page.Commit == nil || page.Commit.Services != 0 {
+		t.Errorf("empty config page has no commit marker")
+	}
+}
+
+func TestCompressResponses_NoContent(t *testing.T) {
+
+	handler := compressResponses(http.HandlerFunc(func(wrt http.ResponseWriter, _ *http.Request) {
+		wrt.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	wrt := httptest.NewRecorder()
+	handler.ServeHTTP(wrt, req)
+
+	if wrt.Body.Len() != 0 || wrt.Header().Get("Content-Encoding") != "" {
+		t.Errorf("no content response got a body")
+	}
+}
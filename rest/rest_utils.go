@@ -2,6 +2,7 @@ package rest
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -75,6 +76,20 @@ func (err *APIError) StatusCode() int {
 	return http.StatusBadRequest
 }
 
+// Non-API error response
+type HTTPError struct {
+	Status int
+	Text   string
+}
+
+func (err *HTTPError) Error() string {
+	return "http: " + err.Text
+}
+
+func (err *HTTPError) StatusCode() int {
+	return err.Status
+}
+
 func beacon(baseUrl *url.URL, token *nxproxy.ServerToken, method string, path string, payload any) error {
 	if _, err := fetch[any](baseUrl, token, method, path, payload); err != nil {
 		return err
@@ -88,6 +103,8 @@ func fetch[T any](baseUrl *url.URL, token *nxproxy.ServerToken, method string, p
 		return nil, fmt.Errorf("remote url not set")
 	}
 
+	path, query, _ := strings.Cut(path, "?")
+
 	reqUrl := url.URL{
 		Scheme:   baseUrl.Scheme,
 		Host:     baseUrl.Host,
@@ -95,6 +112,14 @@ func fetch[T any](baseUrl *url.URL, token *nxproxy.ServerToken, method string, p
 		RawQuery: baseUrl.RawQuery,
 	}
 
+	if query != "" {
+		if reqUrl.RawQuery != "" {
+			reqUrl.RawQuery += "&" + query
+		} else {
+			reqUrl.RawQuery = query
+		}
+	}
+
 	var bodyReader io.Reader
 	if payload != nil {
 		var buff bytes.Buffer
@@ -113,6 +138,9 @@ func fetch[T any](baseUrl *url.URL, token *nxproxy.ServerToken, method string, p
 		req.Header.Set("Content-Type", "application/json")
 	}
 
+	//	setting it explicitly disables transparent decompression, which is handled below
+	req.Header.Set("Accept-Encoding", "gzip")
+
 	if token != nil {
 		bearer := strings.Join([]string{"Bearer", token.String()}, " ")
 		req.Header.Set("Authorization", bearer)
@@ -136,14 +164,28 @@ func fetch[T any](baseUrl *url.URL, token *nxproxy.ServerToken, method string, p
 		return nil, nil
 	}
 
+	var respReader io.Reader = resp.Body
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+
+		gzreader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decode: gzip: %v", err)
+		}
+
+		defer gzreader.Close()
+		respReader = gzreader
+	}
+
 	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
 
-		apiResp, err := decodeResponse[T](resp.Body)
+		apiResp, err := decodeResponse[T](respReader)
 		if err != nil {
 			return nil, fmt.Errorf("decode: %v", err)
 		}
 
 		if apiResp.Error != nil {
+			apiResp.Error.Status = resp.StatusCode
 			return nil, apiResp.Error
 		} else if apiResp.Data == nil {
 			return nil, fmt.Errorf("api: empty data payload")
@@ -153,7 +195,7 @@ func fetch[T any](baseUrl *url.URL, token *nxproxy.ServerToken, method string, p
 	}
 
 	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("http: %s", resp.Status)
+		return nil, &HTTPError{Status: resp.StatusCode, Text: resp.Status}
 	}
 
 	return nil, fmt.Errorf("no supported data returned (http: %s)", resp.Status)
@@ -0,0 +1,88 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrPeerNotFound = errors.New("peer not found")
+
+// Forwards requests for a host name to a fixed upstream. Upstream connections are opened by a slot peer,
+// so they're accounted and shaped the same way proxied ones are
+type ReverseRoute struct {
+
+	//	host name served by the route; patterns starting with a dot also match all subdomains
+	Host string `json:"host"`
+
+	//	base url that requests are forwarded to, such as http://10.0.0.5:8080
+	Upstream string `json:"upstream"`
+
+	//	peer that opens and accounts upstream connections
+	PeerID uuid.UUID `json:"peer_id"`
+
+	//	send the Host header of the incoming request upstream instead of the upstream host
+	PreserveHost bool `json:"preserve_host,omitempty"`
+}
+
+func (route *ReverseRoute) Validate() error {
+
+	if strings.Trim(route.Host, ".") == "" {
+		return fmt.Errorf("invalid host pattern '%s'", route.Host)
+	}
+
+	if _, err := route.UpstreamURL(); err != nil {
+		return fmt.Errorf("upstream: %v", err)
+	}
+
+	return nil
+}
+
+func (route *ReverseRoute) UpstreamURL() (*url.URL, error) {
+
+	val, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Scheme != "http" && val.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme '%s'", val.Scheme)
+	}
+
+	if val.Host == "" {
+		return nil, errors.New("host missing")
+	}
+
+	return val, nil
+}
+
+// Returns the first reverse route matching a host, which may include a port
+func (opts *SlotOptions) MatchReverseRoute(addr string) (ReverseRoute, bool) {
+
+	host := hostName(addr)
+
+	for _, route := range opts.ReverseRoutes {
+		if matchDomain(host, strings.ToLower(route.Host)) {
+			return route, true
+		}
+	}
+
+	return ReverseRoute{}, false
+}
+
+// Looks up a peer by its id; used by slots that pick peers by their config rather than by client credentials
+func (slot *Slot) LookupPeer(id uuid.UUID) (*Peer, error) {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	peer := slot.peerMap[id]
+	if peer == nil {
+		return nil, ErrPeerNotFound
+	}
+
+	return peer, nil
+}
@@ -0,0 +1,200 @@
+package reverse
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+	http_proxy "github.com/maddsua/nx-proxy/http"
+)
+
+// Time a client has to send request headers before its connection gets closed
+const handshakeTimeout = 5 * time.Second
+
+// Largest request head accepted from clients
+const maxHeaderBytes = 64 << 10
+
+func NewService(opts nxproxy.SlotOptions, env nxproxy.SlotEnv) (nxproxy.SlotService, error) {
+
+	svc := service{
+		Slot: nxproxy.Slot{
+			Rl:           env.NewAuthRateLimiter(),
+			DNS:          env.DNS,
+			UsageSamples: env.UsageSamples,
+			Egress:       env.Egress,
+			KernelPacing: env.KernelPacing,
+			BridgeLinger: env.BridgeLinger,
+			Blocklist:    env.Blocklist,
+			GeoIP:        env.GeoIP,
+			AuthLog:      env.AuthLog,
+			ListenFunc:   env.Listen,
+			DialFunc:     env.Dial,
+		},
+	}
+
+	if err := svc.SetOptions(opts); err != nil {
+		return nil, err
+	}
+
+	addr, _, _ := nxproxy.SplitAddrNet(opts.BindAddr)
+
+	listener, err := svc.Listen()
+	if err != nil {
+		return nil, err
+	}
+
+	svc.srv.Addr = addr
+	svc.srv.Handler = http.HandlerFunc(svc.ServeHTTP)
+	svc.srv.ReadHeaderTimeout = handshakeTimeout
+	svc.srv.MaxHeaderBytes = maxHeaderBytes
+	svc.listener = listener
+
+	svc.conns = http_proxy.ClientConns{Slot: &svc.Slot}
+	svc.conns.Attach(&svc.srv)
+
+	go svc.srv.Serve(listener)
+
+	return &svc, nil
+}
+
+type service struct {
+	nxproxy.Slot
+
+	srv      http.Server
+	listener net.Listener
+	conns    http_proxy.ClientConns
+}
+
+func (svc *service) Close() error {
+
+	err := svc.srv.Close()
+
+	//	the server only tracks the listener once Serve gets to run, so it's closed here
+	//	as well to make sure that the address is released by the time Close returns
+	if lnErr := svc.listener.Close(); err == nil && lnErr != nil && !errors.Is(lnErr, net.ErrClosed) {
+		err = lnErr
+	}
+
+	svc.Slot.ClosePeerConnections()
+
+	return err
+}
+
+func (svc *service) ServeHTTP(wrt http.ResponseWriter, req *http.Request) {
+
+	opts := svc.Options()
+	proxyAddr := opts.BindAddr
+
+	clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+
+	svc.Counters.Accepted.Add(1)
+
+	//	clients are counted per connection, as they may send any number of requests over one
+	if conn := http_proxy.RequestClientConn(req); conn != nil && conn.Err != nil {
+		slog.Debug("Reverse: Client connection limit reached",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr))
+		svc.Tarpit(req.Context())
+		wrt.Header().Set("Connection", "close")
+		wrt.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	//	upgraded connections get hijacked by the proxy
+	defer http_proxy.RequestClientConn(req).ReleaseHijacked()
+
+	route, ok := opts.MatchReverseRoute(req.Host)
+	if !ok {
+		slog.Debug("Reverse: No route",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("host", req.Host))
+		wrt.WriteHeader(http.StatusMisdirectedRequest)
+		return
+	}
+
+	peer, err := svc.LookupPeer(route.PeerID)
+	if err != nil {
+		slog.Warn("Reverse: Route peer not found",
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("host", req.Host),
+			slog.String("peer_id", route.PeerID.String()))
+		wrt.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	peer.RecordRequest(nxproxy.RequestReverse, req.Host)
+
+	if !peer.AcceptsSessions() {
+		slog.Debug("Reverse: Request cancelled; Peer disabled or paused",
+			slog.String("client_ip", clientIP),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", req.Host),
+			slog.Bool("paused", peer.Paused))
+		wrt.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	//	validated with the options
+	upstream, _ := route.UpstreamURL()
+
+	if peer.HttpClient == nil {
+		peer.HttpClient = http_proxy.NewPeerClient(peer, func(addr string) uint32 {
+			opts := svc.Options()
+			return opts.ConnectionWeights.Weight(nxproxy.ConnectionForward, addr)
+		}, nil)
+	}
+
+	proxy := httputil.ReverseProxy{
+		Transport: peer.HttpClient.Transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+			if route.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+		},
+		ErrorHandler: func(wrt http.ResponseWriter, req *http.Request, err error) {
+
+			status := http.StatusBadGateway
+
+			switch {
+			case errors.Is(err, context.Canceled):
+				//	the client is gone, there's no one to respond to
+				return
+			case errors.Is(err, nxproxy.ErrDestBlocklisted):
+				svc.DenyDest(peer, clientIP, upstream.Host, nxproxy.DenyBlocklist)
+				status = http.StatusForbidden
+			case errors.Is(err, nxproxy.ErrTooManyConnections):
+				status = http.StatusServiceUnavailable
+			default:
+				svc.Counters.DialFailed.Add(1)
+			}
+
+			slog.Debug("Reverse: Upstream request",
+				slog.String("client_ip", clientIP),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("host", req.Host),
+				slog.String("upstream", upstream.Host),
+				slog.String("err", err.Error()))
+
+			wrt.WriteHeader(status)
+		},
+	}
+
+	proxy.ServeHTTP(wrt, req)
+
+	slog.Debug("Reverse: Forward",
+		slog.String("client_ip", clientIP),
+		slog.String("proxy_addr", proxyAddr),
+		slog.String("peer", peer.DisplayName()),
+		slog.String("host", req.Host),
+		slog.String("upstream", upstream.Host))
+}
@@ -0,0 +1,103 @@
+package reverse
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+type stubDns struct{}
+
+func (stubDns) Resolver() *net.Resolver {
+	return net.DefaultResolver
+}
+
+func TestService_Forward(t *testing.T) {
+
+	origin := httptest.NewServer(http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+		wrt.Header().Set("X-Host", req.Host)
+		wrt.Header().Set("X-Path", req.URL.Path)
+		io.WriteString(wrt, "hello")
+	}))
+	defer origin.Close()
+
+	peerID := uuid.New()
+
+	slot, err := NewService(nxproxy.SlotOptions{
+		Proto:    nxproxy.ProxyProtoReverse,
+		BindAddr: "127.0.0.1:0",
+		ReverseRoutes: []nxproxy.ReverseRoute{
+			{Host: "app.example.com", Upstream: origin.URL + "/base", PeerID: peerID},
+			{Host: ".sites.example.com", Upstream: origin.URL, PeerID: peerID, PreserveHost: true},
+			{Host: "orphan.example.com", Upstream: origin.URL, PeerID: uuid.New()},
+		},
+	}, nxproxy.SlotEnv{DNS: stubDns{}})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	svc := slot.(*service)
+	defer svc.Close()
+
+	svc.SetPeers([]nxproxy.PeerOptions{{ID: peerID}})
+
+	addr := svc.listener.Addr().String()
+
+	var get = func(host string, path string) (*http.Response, string) {
+
+		req, err := http.NewRequest("GET", "http://"+addr+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+
+		req.Host = host
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := get("app.example.com", "/page")
+	if resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Fatalf("unexpected response: %d '%s'", resp.StatusCode, body)
+	}
+
+	if val := resp.Header.Get("X-Path"); val != "/base/page" {
+		t.Errorf("unexpected upstream path: '%s'", val)
+	}
+
+	if val := resp.Header.Get("X-Host"); val == "app.example.com" {
+		t.Errorf("host not rewritten: '%s'", val)
+	}
+
+	resp, _ = get("blog.sites.example.com:8080", "/")
+	if val := resp.Header.Get("X-Host"); val != "blog.sites.example.com:8080" {
+		t.Errorf("host not preserved: '%s'", val)
+	}
+
+	if resp, _ := get("other.example.com", "/"); resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Errorf("unexpected status for unknown host: %d", resp.StatusCode)
+	}
+
+	if resp, _ := get("orphan.example.com", "/"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unexpected status for missing peer: %d", resp.StatusCode)
+	}
+
+	//	connection traffic is moved to the peer once the connection is closed
+	svc.ClosePeerConnections()
+
+	if deltas := svc.Deltas(); len(deltas) != 1 || deltas[0].ID != peerID || deltas[0].Rx == 0 || deltas[0].Tx == 0 {
+		t.Errorf("upstream traffic not accounted: %+v", deltas)
+	}
+}
@@ -2,14 +2,18 @@ package nxproxy
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,11 +21,31 @@ import (
 
 var ErrSlotOptionsIncompatible = errors.New("slot options incompatible")
 var ErrUnsupportedProto = errors.New("unsupported protocol")
+var ErrTooManyClientConnections = errors.New("too many client connections")
+var ErrDomainBlocked = errors.New("destination domain blocked")
+var ErrBindAddrNotUnique = errors.New("bind address not unique")
+var ErrBindAddrOverlap = errors.New("bind address overlaps with another slot")
 
 type SlotService interface {
 	Info() SlotInfo
+	TakeStats() SlotStats
 	Deltas() []PeerDelta
-	SetPeers(entries []PeerOptions)
+	Failures() []PeerFailures
+	Latency() []PeerLatency
+	Activity() []PeerActivity
+	BlockedDests() []BlockedDest
+	LeakEvents() []LeakEvent
+	PendingDisables() []PeerDisable
+	RestoreDisables(entries []PeerDisable)
+	RateLimitedKeys() []RateLimitedKey
+	FramedIPIssues() []FramedIPIssue
+	CheckFramedIPs(ctx context.Context, probe *FramedIPProbe)
+	PeerLatency(id uuid.UUID) (PeerLatencyDetails, bool)
+	PeerUsage(id uuid.UUID) ([]UsageSample, bool)
+	CapturePeer(id uuid.UUID, capture *PeerCapture) (bool, error)
+	ActiveConnections() int
+	Peers() []PeerOptions
+	SetPeers(entries []PeerOptions) ConfigReport
 	SetOptions(opts SlotOptions) error
 	Close() error
 }
@@ -29,65 +53,666 @@ type SlotService interface {
 type ProxyProto string
 
 func (val ProxyProto) Valid() bool {
-	return val == ProxyProtoHttp || val == ProxyProtoSocks
+	return val == ProxyProtoHttp || val == ProxyProtoHttps || val == ProxyProtoSocks || val == ProxyProtoReverse
+}
+
+// Checks whether slots of the protocol serve http proxy requests, either in cleartext or over TLS
+func (val ProxyProto) IsHttp() bool {
+	return val == ProxyProtoHttp || val == ProxyProtoHttps
 }
 
 const (
 	ProxyProtoSocks = ProxyProto("socks")
 	ProxyProtoHttp  = ProxyProto("http")
+
+	//	http proxy served over TLS, so that client credentials don't travel in cleartext
+	ProxyProtoHttps = ProxyProto("https")
+
+	//	forwards requests for configured hosts to fixed upstreams instead of proxying client chosen destinations
+	ProxyProtoReverse = ProxyProto("reverse")
 )
 
+type SlotAuth string
+
+func (val SlotAuth) Valid() bool {
+	return val == SlotAuthNone || val == SlotAuthPassword
+}
+
+const (
+	//	lets clients in without credentials; connections are accounted to the slot's anonymous peer
+	SlotAuthNone     = SlotAuth("none")
+	SlotAuthPassword = SlotAuth("password")
+)
+
+type HttpAuthScheme string
+
+func (val HttpAuthScheme) Valid() bool {
+	return val == HttpAuthBasic || val == HttpAuthDigest
+}
+
+const (
+	HttpAuthBasic = HttpAuthScheme("basic")
+	//	RFC 7616 digest auth; mapped to the same peer credentials as basic
+	HttpAuthDigest = HttpAuthScheme("digest")
+)
+
+const DefaultHttpRealm = "nx-proxy"
+
+// Realm used by stealth slots that don't have one set, as the default one gives the proxy away
+const StealthHttpRealm = "proxy"
+
+type ConnectionKind int
+
+const (
+	//	CONNECT tunnels and socks connections
+	ConnectionTunnel ConnectionKind = iota
+	//	connections used by forwarded plain http requests
+	ConnectionForward
+)
+
+// Relative weights used when peer bandwidth is split between its connections, zero values count as 1
+type ConnectionWeights struct {
+	Tunnel  uint32 `json:"tunnel,omitempty"`
+	Forward uint32 `json:"forward,omitempty"`
+
+	//	destination host overrides; entries starting with a dot match all subdomains
+	Hosts map[string]uint32 `json:"hosts,omitempty"`
+}
+
+// Returns connection weight for a destination address, which may or may not include a port
+func (cw *ConnectionWeights) Weight(kind ConnectionKind, addr string) uint32 {
+
+	if cw == nil {
+		return 1
+	}
+
+	if len(cw.Hosts) > 0 {
+
+		host := hostName(addr)
+
+		if weight, has := cw.Hosts[host]; has {
+			return max(weight, 1)
+		}
+
+		//	the most specific suffix wins
+		var matched string
+		for pattern := range cw.Hosts {
+			if strings.HasPrefix(pattern, ".") && matchDomain(host, pattern) && len(pattern) > len(matched) {
+				matched = pattern
+			}
+		}
+
+		if matched != "" {
+			return max(cw.Hosts[matched], 1)
+		}
+	}
+
+	switch kind {
+	case ConnectionForward:
+		return max(cw.Forward, 1)
+	default:
+		return max(cw.Tunnel, 1)
+	}
+}
+
+// Node-wide settings and dependencies shared by all slots
+type SlotEnv struct {
+	DNS DnsProvider
+
+	//	number of per-second usage samples to keep for each peer; sampling is disabled when zero
+	UsageSamples int
+
+	//	optional node-wide egress limit shared by all slots
+	Egress *TokenBucket
+
+	//	shape tunnelled connections with SO_MAX_PACING_RATE where supported
+	KernelPacing bool
+
+	//	time the remaining direction of a broken tunnel is given to flush its data; torn down right away when zero
+	BridgeLinger time.Duration
+
+	//	optional node-wide destination blocklist
+	Blocklist *Blocklist
+
+	//	optional country lookup table for the leak check
+	GeoIP *GeoIP
+
+	//	optional log of failed client authentications for fail2ban and the like
+	AuthLog *AuthFailureLog
+
+	//	limiter of password attempts shared by all slots, so that clients get a single quota on the node
+	//	no matter how many slots they try. Slots create their own limiters when it's unset
+	AuthLimiter *RateLimiter
+
+	//	limits on password attempts from a single client ip of limiters created by slots; DefaultRatelimiter is used when unset
+	AuthRateLimit *RateLimiterOptions
+
+	//	optional replacements for the network, so that slots can be tested without listening on or dialing real addresses.
+	//	peers dialing through Dial don't apply their own dialer settings, such as framed ips, timeouts or the blocklist
+	Listen ListenFunc
+	Dial   DialFunc
+}
+
+// Returns a limiter of password attempts for a new slot; that's the shared one if the env has it
+func (env *SlotEnv) NewAuthRateLimiter() *RateLimiter {
+
+	if env.AuthLimiter != nil {
+		return env.AuthLimiter
+	}
+
+	if env.AuthRateLimit != nil {
+		return &RateLimiter{RateLimiterOptions: *env.AuthRateLimit}
+	}
+
+	return &RateLimiter{RateLimiterOptions: DefaultRatelimiter}
+}
+
+// Identifies a slot in logs and reports
+func (opts *SlotOptions) Handle() string {
+	return opts.Identity().Handle()
+}
+
+func (opts *SlotOptions) Identity() SlotIdentity {
+	return SlotIdentity{ID: opts.ID, Proto: opts.Proto, BindAddr: opts.BindAddr}
+}
+
+// Identifies a slot in logs, metrics and the admin API. Peers carry the identity of the slot that has imported them
+type SlotIdentity struct {
+	ID       string     `json:"id,omitempty"`
+	Proto    ProxyProto `json:"proto"`
+	BindAddr string     `json:"bind_addr"`
+}
+
+// Returns the slot handle as 'proto@bind_addr'
+func (id SlotIdentity) Handle() string {
+	return string(id.Proto) + "@" + id.BindAddr
+}
+
+// Logs the identity as a group, leaving out the id when it's not set
+func (id SlotIdentity) LogValue() slog.Value {
+
+	attrs := []slog.Attr{
+		slog.String("proto", string(id.Proto)),
+		slog.String("bind_addr", id.BindAddr),
+	}
+
+	if id.ID != "" {
+		attrs = append([]slog.Attr{slog.String("id", id.ID)}, attrs...)
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
 type ServiceOptions struct {
 	SlotOptions
 	Peers []PeerOptions `json:"peers"`
 }
 
+// Slot options are split into identity fields and hot-reloadable ones.
+// Identity fields make up the fingerprint and changing any of them requires the slot to be recreated;
+// everything else can be applied to a running slot
 type SlotOptions struct {
+
+	//	identity fields
 	Proto    ProxyProto `json:"proto"`
 	BindAddr string     `json:"bind_addr"`
+
+	//	optional id assigned to the slot by the backend; reported along with the slot info, peer deltas and log lines
+	//	so that they can be tied back to the config entry. Changing it doesn't restart the slot
+	ID string `json:"id,omitempty"`
+
+	//	names of network interfaces a wildcard bind address is expanded into, glob patterns like 'eth*' allowed.
+	//	the slot then listens on every address of the matching interfaces, reporting activity of each one
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	//	hot-reloadable fields
+
+	//	CIDRs of reverse proxies that are trusted to set X-Forwarded-For/X-Real-IP headers; http only
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	//	maximal number of concurrent connections from a single client ip, unlimited when zero
+	MaxClientConnections uint `json:"max_client_connections,omitempty"`
+
+	//	auth methods accepted by the slot, most preferred first; password only by default
+	AuthMethods []SlotAuth `json:"auth_methods,omitempty"`
+
+	//	auth schemes offered in proxy challenges, most preferred first; basic only by default; http only
+	HttpAuthSchemes []HttpAuthScheme `json:"http_auth_schemes,omitempty"`
+
+	//	realm reported in proxy challenges; http only
+	HttpRealm string `json:"http_realm,omitempty"`
+
+	//	page shown to browsers that come without credentials; a bare auth challenge is returned when unset; http only
+	HttpLanding *HttpLanding `json:"http_landing,omitempty"`
+
+	//	proxy auto-config file served to clients that request it from the slot directly; not served unless set; http only
+	HttpPac *HttpPac `json:"http_pac,omitempty"`
+
+	//	host name that authenticated clients can request their account details from at /account,
+	//	such as http://proxy.local/account; disabled when unset; http only
+	HttpAccountHost string `json:"http_account_host,omitempty"`
+
+	//	hosts served by the slot and their upstreams, matched in order; reverse only
+	ReverseRoutes []ReverseRoute `json:"reverse_routes,omitempty"`
+
+	//	leave out headers and response traits that identify the proxy, such as Via and the default realm,
+	//	and strip proxy headers off forwarded requests; http only
+	Stealth bool `json:"stealth,omitempty"`
+
+	//	relative shares of peer bandwidth given to different kinds of connections; all connections are equal when unset
+	ConnectionWeights *ConnectionWeights `json:"connection_weights,omitempty"`
+
+	//	tolerate known protocol deviations of popular clients; socks only
+	LenientParsing bool `json:"lenient_parsing,omitempty"`
+
+	//	default destination dial timings for slot peers
+	DialOptions
+
+	//	look into TLS handshakes of tunneled connections to log the requested server names
+	SniInspection bool `json:"sni_inspection,omitempty"`
+
+	//	destination domains that may not be connected to; patterns starting with a dot also match all subdomains.
+	//	server names of TLS tunnels are checked as well
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+
+	//	terminate and inspect TLS inside tunnels; disabled unless set
+	Mitm *MitmOptions `json:"mitm,omitempty"`
+
+	//	own limit of password attempts, in place of the one shared by all slots of the node
+	AuthRateLimit *SlotRateLimit `json:"auth_rate_limit,omitempty"`
+
+	//	server certificate; required by https slots and only supported by them
+	Tls *TlsOptions `json:"tls,omitempty"`
+
+	//	hold rate limited clients and the ones over the connection limit for this long before rejecting them;
+	//	disabled when zero
+	TarpitMs uint `json:"tarpit_ms,omitempty"`
+
+	//	flag peers that authenticate from too many client ips or countries; disabled unless set
+	LeakCheck *LeakCheckOptions `json:"leak_check,omitempty"`
+
+	//	announce scheduled peer disables this many minutes ahead in response headers; disabled when zero; http only
+	ExpiryWarningMin uint `json:"expiry_warning_min,omitempty"`
+
+	//	refuse peer dials while their framed IP is unavailable; such peers dial from the default source address otherwise
+	StrictFramedIP bool `json:"strict_framed_ip,omitempty"`
+}
+
+// Returns accepted auth methods ordered by preference
+func (opts *SlotOptions) AuthPriority() []SlotAuth {
+
+	if len(opts.AuthMethods) == 0 {
+		return []SlotAuth{SlotAuthPassword}
+	}
+
+	return opts.AuthMethods
+}
+
+func (opts *SlotOptions) AuthAllowed(method SlotAuth) bool {
+	return slices.Contains(opts.AuthPriority(), method)
+}
+
+// Returns http auth schemes ordered by preference
+func (opts *SlotOptions) HttpAuthPriority() []HttpAuthScheme {
+
+	if len(opts.HttpAuthSchemes) == 0 {
+		return []HttpAuthScheme{HttpAuthBasic}
+	}
+
+	return opts.HttpAuthSchemes
+}
+
+func (opts *SlotOptions) HttpAuthRealm() string {
+
+	if opts.HttpRealm == "" && opts.Stealth {
+		return StealthHttpRealm
+	} else if opts.HttpRealm == "" {
+		return DefaultHttpRealm
+	}
+
+	return opts.HttpRealm
+}
+
+// Checks whether a destination host, which may include a port, matches any of the blocked domains
+func (opts *SlotOptions) DomainBlocked(addr string) bool {
+
+	if len(opts.BlockedDomains) == 0 {
+		return false
+	}
+
+	host := hostName(addr)
+
+	for _, pattern := range opts.BlockedDomains {
+		if matchDomain(host, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks whether tunneled TLS handshakes have to be looked into
+func (opts *SlotOptions) InspectTls() bool {
+	return opts.SniInspection || len(opts.BlockedDomains) > 0
+}
+
+// Returns a normalized host name of an address that may or may not include a port
+func hostName(addr string) string {
+
+	host := addr
+	if val, _, err := net.SplitHostPort(addr); err == nil {
+		host = val
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
 }
 
+// Matches a host either exactly or, for patterns starting with a dot, as the domain itself or any of its subdomains
+func matchDomain(host string, pattern string) bool {
+
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(host, pattern) || host == pattern[1:]
+	}
+
+	return host == pattern
+}
+
+// Returns a hash of the identity fields
+func (opts *SlotOptions) Fingerprint() string {
+
+	hash := sha256.New()
+
+	for _, val := range []string{string(opts.Proto), opts.BindAddr, strings.Join(opts.Interfaces, ",")} {
+		hash.Write([]byte(val))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// Checks whether other options can be applied to a slot running with these options
 func (opts *SlotOptions) Compatible(other *SlotOptions) bool {
 
 	if other == nil || opts == nil {
 		return false
 	}
 
-	return opts.Proto == other.Proto &&
-		opts.BindAddr == other.BindAddr
+	return opts.Fingerprint() == other.Fingerprint()
 }
 
 type SlotInfo struct {
+	ID              string     `json:"id,omitempty"`
 	Up              bool       `json:"up"`
 	Proto           ProxyProto `json:"proto"`
 	BindAddr        string     `json:"bind_addr"`
 	RegisteredPeers int        `json:"registered_peers"`
 	Error           string     `json:"error,omitempty"`
+
+	//	number of tolerated protocol deviations by type
+	Deviations map[string]uint64 `json:"deviations,omitempty"`
+
+	//	slot activity since the previous status report
+	Stats *SlotStats `json:"stats,omitempty"`
+
+	//	set when the slot intercepts TLS inside tunnels
+	Mitm bool `json:"mitm"`
+
+	//	per-address activity of slots bound to interface addresses
+	Listeners []ListenerInfo `json:"listeners,omitempty"`
+
+	//	expiry of the server certificate of https slots
+	CertExpires *time.Time `json:"cert_expires,omitempty"`
+
+	//	state of the limiter of password attempts
+	AuthRateLimit *RateLimiterInfo `json:"auth_rate_limit,omitempty"`
 }
 
-type Slot struct {
-	SlotOptions
+type SlotStats struct {
+	Accepted      uint64 `json:"accepted"`
+	Authenticated uint64 `json:"authenticated"`
+	AuthFailed    uint64 `json:"auth_failed"`
+	DeniedDest    uint64 `json:"denied_dest"`
+	DialFailed    uint64 `json:"dial_failed"`
+	Tarpitted     uint64 `json:"tarpitted"`
+	ActiveConns   int    `json:"active_conns"`
+}
 
-	BaseContext context.Context
-	Rl          *RateLimiter
-	DNS         DnsProvider
+// Rolling slot event counters; they're reset every time stats are taken
+type SlotCounters struct {
+	Accepted      atomic.Uint64
+	Authenticated atomic.Uint64
+	AuthFailed    atomic.Uint64
+	DeniedDest    atomic.Uint64
+	DialFailed    atomic.Uint64
+	Tarpitted     atomic.Uint64
+}
 
+type Slot struct {
+	BaseContext  context.Context
+	Rl           *RateLimiter
+	DNS          DnsProvider
+	UsageSamples int
+	Egress       *TokenBucket
+	KernelPacing bool
+	BridgeLinger time.Duration
+	Blocklist    *Blocklist
+	GeoIP        *GeoIP
+	AuthLog      *AuthFailureLog
+	ListenFunc   ListenFunc
+	DialFunc     DialFunc
+
+	Counters SlotCounters
+
+	opts      atomic.Pointer[SlotOptions]
+	listener  *SlotListener
+	mitm      atomic.Pointer[MitmAuthority]
+	tlsCert   atomic.Pointer[ServerCertificate]
 	oldDeltas []PeerDelta
 
-	peerMap     map[uuid.UUID]*Peer
-	userNameMap map[string]*Peer
-	mtx         sync.Mutex
+	//	limiter the slot was created with and the override it has replaced it with, if any
+	baseRl     *RateLimiter
+	rlOverride *SlotRateLimit
+
+	//	disables handed over from the slot that this one replaces; only kept until peers are set
+	restoredDisables map[uuid.UUID]PeerDisable
+
+	peerMap       map[uuid.UUID]*Peer
+	userNameMap   map[string]*Peer
+	anonymousPeer *Peer
+	mtx           sync.Mutex
+
+	clientConns map[string]uint
+	clientMtx   sync.Mutex
+
+	deviations   map[string]uint64
+	deviationMtx sync.Mutex
+
+	blocked blockedDestLog
+	leaks   leakEventLog
+
+	//	number of clients currently held in the tarpit
+	tarpitted atomic.Int64
+}
+
+// Returns a snapshot of current slot options
+func (slot *Slot) Options() SlotOptions {
+	if opts := slot.opts.Load(); opts != nil {
+		return *opts
+	}
+	return SlotOptions{}
+}
+
+// Replaces slot options if they're compatible with the current ones
+func (slot *Slot) SetOptions(opts SlotOptions) error {
+
+	if current := slot.opts.Load(); current != nil && !current.Compatible(&opts) {
+		return ErrSlotOptionsIncompatible
+	}
+
+	if err := opts.validateReloadable(); err != nil {
+		return err
+	}
+
+	var mitmAuth *MitmAuthority
+	if mitm := opts.Mitm; mitm != nil {
+
+		if current := slot.mitm.Load(); current != nil && current.Matches(mitm.CaCert, mitm.CaKey) {
+			mitmAuth = current
+		} else if auth, err := NewMitmAuthority(mitm.CaCert, mitm.CaKey); err != nil {
+			return fmt.Errorf("mitm: %v", err)
+		} else {
+			mitmAuth = auth
+		}
+
+		if slot.mitm.Load() == nil {
+			slog.Warn("TLS interception enabled",
+				slog.Any("slot", opts.Identity()))
+		}
+	}
+
+	var serverCert *ServerCertificate
+	if tlsOpts := opts.Tls; tlsOpts != nil {
+
+		if current := slot.tlsCert.Load(); current != nil && current.Matches(tlsOpts.Cert, tlsOpts.Key) {
+			serverCert = current
+		} else if cert, err := NewServerCertificate(tlsOpts.Cert, tlsOpts.Key); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		} else {
+			serverCert = cert
+		}
+	}
+
+	slot.mtx.Lock()
+	slot.applyAuthRateLimit(opts.AuthRateLimit)
+	slot.mtx.Unlock()
+
+	slot.mitm.Store(mitmAuth)
+	slot.tlsCert.Store(serverCert)
+	slot.opts.Store(&opts)
+
+	return nil
+}
+
+// Returns TLS interception settings if the slot has them enabled
+func (slot *Slot) MitmConfig() (MitmConfig, bool) {
+
+	opts := slot.opts.Load()
+	auth := slot.mitm.Load()
+
+	if opts == nil || opts.Mitm == nil || auth == nil {
+		return MitmConfig{}, false
+	}
+
+	return MitmConfig{MitmOptions: *opts.Mitm, Authority: auth}, true
 }
 
 func (slot *Slot) Info() SlotInfo {
-	return SlotInfo{
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	opts := slot.Options()
+
+	info := SlotInfo{
+		ID:              opts.ID,
 		Up:              true,
-		Proto:           slot.Proto,
-		BindAddr:        slot.BindAddr,
+		Proto:           opts.Proto,
+		BindAddr:        opts.BindAddr,
 		RegisteredPeers: len(slot.peerMap),
+		Deviations:      slot.deviationCounts(),
+		Mitm:            slot.mitm.Load() != nil,
+		Listeners:       slot.listener.Info(),
+	}
+
+	if cert := slot.tlsCert.Load(); cert != nil {
+		expires := cert.Expires()
+		info.CertExpires = &expires
+	}
+
+	if slot.Rl != nil {
+		rlInfo := slot.Rl.Info()
+		rlInfo.Shared = slot.Rl.Shared
+		info.AuthRateLimit = &rlInfo
+	}
+
+	return info
+}
+
+// Returns keys of the password attempt limiter that are out of their quota, which are client ips prefixed with 'pw:'.
+// Nothing is returned for limiters shared with other slots
+func (slot *Slot) RateLimitedKeys() []RateLimitedKey {
+
+	slot.mtx.Lock()
+	rl := slot.Rl
+	slot.mtx.Unlock()
+
+	if rl == nil || rl.Shared {
+		return nil
+	}
+
+	return rl.LimitedKeys()
+}
+
+// Starts listening on the slot bind address. Must only be called once, when the slot service gets created
+func (slot *Slot) Listen() (*SlotListener, error) {
+
+	opts := slot.Options()
+
+	listen := slot.ListenFunc
+	if listen == nil {
+		listen = net.Listen
+	}
+
+	listener, err := listenSlot(&opts, listen)
+	if err != nil {
+		return nil, err
+	}
+
+	slot.mtx.Lock()
+	slot.listener = listener
+	slot.mtx.Unlock()
+
+	return listener, nil
+}
+
+// Returns slot counters accumulated since the previous call and resets them
+func (slot *Slot) TakeStats() SlotStats {
+	return SlotStats{
+		Accepted:      slot.Counters.Accepted.Swap(0),
+		Authenticated: slot.Counters.Authenticated.Swap(0),
+		AuthFailed:    slot.Counters.AuthFailed.Swap(0),
+		DeniedDest:    slot.Counters.DeniedDest.Swap(0),
+		DialFailed:    slot.Counters.DialFailed.Swap(0),
+		Tarpitted:     slot.Counters.Tarpitted.Swap(0),
+		ActiveConns:   slot.ActiveConnections(),
 	}
 }
 
+// Records a protocol deviation that the slot has tolerated
+func (slot *Slot) CountDeviation(name string) {
+
+	slot.deviationMtx.Lock()
+	defer slot.deviationMtx.Unlock()
+
+	if slot.deviations == nil {
+		slot.deviations = map[string]uint64{}
+	}
+
+	slot.deviations[name]++
+}
+
+func (slot *Slot) deviationCounts() map[string]uint64 {
+
+	slot.deviationMtx.Lock()
+	defer slot.deviationMtx.Unlock()
+
+	if len(slot.deviations) == 0 {
+		return nil
+	}
+
+	return maps.Clone(slot.deviations)
+}
+
 func (slot *Slot) Deltas() []PeerDelta {
 
 	slot.mtx.Lock()
@@ -102,105 +727,245 @@ func (slot *Slot) Deltas() []PeerDelta {
 		}
 	}
 
-	peerMap := map[uuid.UUID]*PeerDelta{}
+	opts := slot.Options()
+
+	for idx := range deltaList {
+		deltaList[idx].Slot = opts.Handle()
+		deltaList[idx].SlotID = opts.ID
+		deltaList[idx].Proto = opts.Proto
+	}
+
+	return MergePeerDeltas(deltaList)
+}
 
-	for _, delta := range deltaList {
+// Returns peer failure summaries accumulated since the previous call
+func (slot *Slot) Failures() []PeerFailures {
 
-		entry := peerMap[delta.ID]
-		if entry == nil {
-			entry = &delta
-			peerMap[delta.ID] = entry
-		} else {
-			entry.Rx += delta.Rx
-			entry.Tx += delta.Tx
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	var entries []PeerFailures
+
+	for _, peer := range slot.peerMap {
+		if val, has := peer.Failures(); has {
+			entries = append(entries, val)
 		}
 	}
 
-	var entries []PeerDelta
-	for _, val := range peerMap {
-		entries = append(entries, *val)
+	return entries
+}
+
+// Returns peer dial latency summaries accumulated since the previous call
+func (slot *Slot) Latency() []PeerLatency {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	var entries []PeerLatency
+
+	for _, peer := range slot.peerMap {
+		if val, has := peer.Latency(); has {
+			entries = append(entries, val)
+		}
 	}
 
 	return entries
 }
 
-func (slot *Slot) SetPeers(entries []PeerOptions) {
+func (slot *Slot) PeerLatency(id uuid.UUID) (PeerLatencyDetails, bool) {
 
 	slot.mtx.Lock()
 	defer slot.mtx.Unlock()
 
-	importedPeerIdSet := map[uuid.UUID]struct{}{}
-	importedUsernameSet := map[string]struct{}{}
+	peer := slot.peerMap[id]
+	if peer == nil {
+		return PeerLatencyDetails{}, false
+	}
 
-	//	checks whether we can reliably identify and map a peer by it's uuid and/or credentials
-	var peerMappable = func(peer *PeerOptions) error {
+	return peer.LatencyDetails(), true
+}
 
-		if _, has := importedPeerIdSet[peer.ID]; has {
-			return fmt.Errorf("id not unique: %v", peer.ID)
-		} else {
-			importedPeerIdSet[peer.ID] = struct{}{}
-		}
+// Returns the number of open connections across all slot peers
+func (slot *Slot) ActiveConnections() int {
 
-		if peer.PasswordAuth == nil {
-			return fmt.Errorf("no auth properties are set")
-		}
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
 
-		if _, has := importedUsernameSet[peer.PasswordAuth.User]; has {
-			return fmt.Errorf("password auth: user name not unique: %s", peer.PasswordAuth.User)
-		} else {
-			importedUsernameSet[peer.PasswordAuth.User] = struct{}{}
+	var n int
+	for _, peer := range slot.peerMap {
+		n += peer.ActiveConnections()
+	}
+
+	return n
+}
+
+// Returns effective options of slot peers, ordered by id. Peers disabled by the node
+// or by their scheduled disable time are returned as disabled
+func (slot *Slot) Peers() []PeerOptions {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	now := time.Now()
+
+	var entries []PeerOptions
+
+	for _, peer := range slot.peerMap {
+
+		opts := peer.PeerOptions
+		if peer.local.active() || opts.DisableDue(now) {
+			opts.Disabled = true
 		}
 
-		return nil
+		entries = append(entries, opts)
+	}
+
+	slices.SortFunc(entries, func(a, b PeerOptions) int {
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+
+	return entries
+}
+
+// Returns recorded usage samples of a peer, if sampling is enabled
+func (slot *Slot) PeerUsage(id uuid.UUID) ([]UsageSample, bool) {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	peer := slot.peerMap[id]
+	if peer == nil || peer.Usage == nil {
+		return nil, false
+	}
+
+	return peer.Usage.Samples(), true
+}
+
+// Attaches a traffic capture to a peer; the first value is false if the slot doesn't have the peer
+func (slot *Slot) CapturePeer(id uuid.UUID, capture *PeerCapture) (bool, error) {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	peer := slot.peerMap[id]
+	if peer == nil {
+		return false, nil
 	}
 
+	return true, peer.StartCapture(capture)
+}
+
+// Replaces slot peers. Returns peer entries that were accepted and the ones that weren't
+func (slot *Slot) SetPeers(entries []PeerOptions) ConfigReport {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	var imported peerSet
+
 	var storePeerDelta = func(peer *Peer) {
 		if delta, has := peer.Delta(); has {
 			slot.oldDeltas = append(slot.oldDeltas, delta)
 		}
 	}
 
-	slotHandle := strings.Join([]string{string(slot.Proto), slot.BindAddr}, "@")
+	opts := slot.Options()
+	slotID := opts.Identity()
+	slotHandle := slotID.Handle()
 
 	newPeerMap := map[uuid.UUID]*Peer{}
 
+	var report ConfigReport
+
 	//	update peers
 	for _, entry := range entries {
 
-		if err := peerMappable(&entry); err != nil {
+		if err := imported.add(&entry); err != nil {
 			slog.Warn("Update peers: Peer option invalid; Skipped",
 				slog.String("peer_id", entry.ID.String()),
 				slog.String("name", entry.DisplayName()),
-				slog.String("slot", slotHandle),
+				slog.Any("slot", slotID),
 				slog.String("err", err.Error()))
+			report.Rejected = append(report.Rejected, peerIssue(slotHandle, &entry, err))
 			continue
 		}
 
-		framedIP, err := ParseFramedIP(entry.FramedIP)
+		report.Peers++
+
+		if unused := entry.UnusedAuth(); len(unused) > 0 {
+			slog.Warn("Update peers: Auth methods not supported; Ignored",
+				slog.String("id", entry.ID.String()),
+				slog.String("name", entry.DisplayName()),
+				slog.Any("slot", slotID),
+				slog.Int("count", len(unused)))
+			report.Warnings = append(report.Warnings, peerIssue(slotHandle, &entry, fmt.Errorf("%d auth methods not supported; ignored", len(unused))))
+		}
+
+		framedIP, err := ParseFramedIP(entry.LocalFramedIP())
 		if err != nil {
 			slog.Warn("Update peers: Framed IP unavailable",
 				slog.String("id", entry.ID.String()),
 				slog.String("addr", entry.FramedIP),
 				slog.String("name", entry.DisplayName()),
-				slog.String("slot", slotHandle),
+				slog.Any("slot", slotID),
 				slog.String("err", err.Error()))
+			report.Warnings = append(report.Warnings, peerIssue(slotHandle, &entry, fmt.Errorf("framed ip unavailable: %v", err)))
 		}
 
+		//	unavailable framed IPs are kept and marked down, so that peers switch back to them once they're assigned again
+		framedErr := err
+		if framedIP == nil {
+			framedIP = net.ParseIP(entry.LocalFramedIP())
+		}
+
+		dialOpts := entry.DialOptions.Or(opts.DialOptions)
+
 		if peer, ok := slot.peerMap[entry.ID]; ok {
 
 			slog.Debug("Update peer",
 				slog.String("id", peer.ID.String()),
 				slog.String("name", peer.DisplayName()),
-				slog.String("slot", slotHandle))
+				slog.Any("slot", slotID))
 
 			//	diff peer options
 			credentialsChanges := !peer.PeerOptions.CmpCredentials(entry)
-			framedIpChanged := peer.PeerOptions.FramedIP != entry.FramedIP
+			framedIpChanged := peer.PeerOptions.FramedIP != entry.FramedIP || peer.EgressNat != entry.EgressNat
+
+			if credentialsChanges {
+				peer.leak.reset()
+			}
+
+			//	peers disabled by the node stay disabled until the backend acknowledges it, even if their credentials change,
+			//	so that a config pulled before the backend learned about the disable doesn't enable them right back
+			if peer.local.acked(entry.DisableAck) {
+
+				slog.Info("Peer disable acknowledged",
+					slog.String("id", peer.ID.String()),
+					slog.String("name", peer.DisplayName()),
+					slog.Any("slot", slotID),
+					slog.Bool("disabled", entry.Disabled))
+
+				peer.leak.reset()
+				peer.local.reset()
+
+			} else if peer.local.active() {
+				entry.Disabled = true
+			}
+
 			disabledFlagChanged := peer.Disabled != entry.Disabled
+			pausedFlagChanged := peer.Paused != entry.Paused
+			bandwidthChanged := peer.Bandwidth != entry.Bandwidth
+
+			report.Changes.addPeer(&peer.PeerOptions, &entry)
 
 			//	update peer options
 			peer.PeerOptions = entry
 			peer.Dialer.LocalAddr = TcpDialAddr(framedIP)
+			peer.Dialer.Timeout = dialOpts.Timeout()
+			peer.Dialer.KeepAlive = dialOpts.KeepAlive()
+			peer.StrictFramedIP = opts.StrictFramedIP
+			peer.Slot = slotID
+			peer.setFramedIP(framedIP, framedErr)
 
 			//	drop connections when peer state changes to 'disabled'
 			if disabledFlagChanged {
@@ -213,16 +978,43 @@ func (slot *Slot) SetPeers(entries []PeerOptions) {
 					slog.Info("Peer disabled",
 						slog.String("id", peer.ID.String()),
 						slog.String("name", peer.DisplayName()),
-						slog.String("slot", slotHandle))
+						slog.Any("slot", slotID))
 
 				} else {
 					slog.Info("Peer enabled",
 						slog.String("id", peer.ID.String()),
 						slog.String("name", peer.DisplayName()),
-						slog.String("slot", slotHandle))
+						slog.Any("slot", slotID))
 				}
 			}
 
+			//	paused peers keep their open connections, they just can't open new ones
+			if pausedFlagChanged {
+
+				message := "Peer resumed"
+				if peer.Paused {
+					message = "Peer paused"
+				}
+
+				slog.Info(message,
+					slog.String("id", peer.ID.String()),
+					slog.String("name", peer.DisplayName()),
+					slog.Any("slot", slotID),
+					slog.Int("connections", peer.ActiveConnections()))
+			}
+
+			//	connections that stay open switch to the new rates right away
+			if bandwidthChanged && !credentialsChanges && !framedIpChanged {
+
+				slog.Debug("Peer bandwidth changed",
+					slog.String("id", peer.ID.String()),
+					slog.String("name", peer.DisplayName()),
+					slog.Any("slot", slotID),
+					slog.Int("connections", peer.ActiveConnections()))
+
+				peer.RebalanceBandwidth()
+			}
+
 			//	drop connections when peer auth or ip changed
 			if credentialsChanges || framedIpChanged {
 
@@ -231,12 +1023,12 @@ func (slot *Slot) SetPeers(entries []PeerOptions) {
 					slog.Info("Peer credentials changed; Must reauthenticate",
 						slog.String("id", peer.ID.String()),
 						slog.String("name", peer.DisplayName()),
-						slog.String("slot", slotHandle))
+						slog.Any("slot", slotID))
 				case framedIpChanged:
 					slog.Info("Peer framed IP changed; Must reauthenticate",
 						slog.String("id", peer.ID.String()),
 						slog.String("name", peer.DisplayName()),
-						slog.String("slot", slotHandle))
+						slog.Any("slot", slotID))
 				}
 
 				peer.CloseConnections()
@@ -253,20 +1045,55 @@ func (slot *Slot) SetPeers(entries []PeerOptions) {
 		//	create and insert a new peer into a fresh map
 
 		peer := Peer{
-			PeerOptions: entry,
-			BaseContext: slot.BaseContext,
+			PeerOptions:    entry,
+			Slot:           slotID,
+			BaseContext:    slot.BaseContext,
+			Egress:         slot.Egress,
+			KernelPacing:   slot.KernelPacing,
+			BridgeLinger:   slot.BridgeLinger,
+			StrictFramedIP: opts.StrictFramedIP,
 			Dialer: net.Dialer{
-				Resolver:  slot.DNS.Resolver(),
 				LocalAddr: TcpDialAddr(framedIP),
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
+				Timeout:   dialOpts.Timeout(),
+				KeepAlive: dialOpts.KeepAlive(),
 			},
+			DialFunc: slot.DialFunc,
+		}
+
+		//	slots without a dns provider use the system resolver
+		if slot.DNS != nil {
+			peer.Dialer.Resolver = slot.DNS.Resolver()
+		}
+
+		peer.setFramedIP(framedIP, framedErr)
+		peer.totals.since = time.Now()
+
+		//	peers disabled by the slot that this one replaces stay disabled until the backend acknowledges it
+		if held, has := slot.restoredDisables[peer.ID]; has {
+
+			peer.local.set(held.Reason, held.Time)
+
+			if peer.local.acked(entry.DisableAck) {
+				peer.local.reset()
+			} else {
+				peer.Disabled = true
+			}
+		}
+
+		if slot.Blocklist != nil {
+			peer.Dialer.Control = slot.Blocklist.DialControl
+		}
+
+		if slot.UsageSamples > 0 {
+			peer.Usage = NewUsageRing(slot.UsageSamples)
 		}
 
 		slog.Info("Create peer",
 			slog.String("id", peer.ID.String()),
 			slog.String("name", peer.DisplayName()),
-			slog.String("slot", slotHandle))
+			slog.Any("slot", slotID))
+
+		report.Changes.PeersAdded++
 
 		newPeerMap[entry.ID] = &peer
 	}
@@ -278,10 +1105,13 @@ func (slot *Slot) SetPeers(entries []PeerOptions) {
 			slog.Info("Remove peer",
 				slog.String("id", peer.ID.String()),
 				slog.String("name", peer.DisplayName()),
-				slog.String("slot", slotHandle))
+				slog.Any("slot", slotID))
+
+			report.Changes.PeersRemoved++
 
-			peer.CloseConnections()
-			storePeerDelta(peer)
+			if delta, has := peer.Close(); has {
+				slot.oldDeltas = append(slot.oldDeltas, delta)
+			}
 		}
 	}
 
@@ -289,31 +1119,98 @@ func (slot *Slot) SetPeers(entries []PeerOptions) {
 
 	//	remap by username
 	newUserNameMap := map[string]*Peer{}
+	slot.anonymousPeer = nil
+
 	for _, peer := range newPeerMap {
 		if auth := peer.PeerOptions.PasswordAuth; auth != nil {
-			newUserNameMap[auth.User] = peer
+			//	peers with invalid user names don't make it this far
+			username, _ := NormalizeUsername(auth.User)
+			newUserNameMap[username] = peer
+		} else if peer.Anonymous() {
+			slot.anonymousPeer = peer
 		}
 	}
 
 	slot.userNameMap = newUserNameMap
+	slot.restoredDisables = nil
+
+	return report
 }
 
+// Closes all slot peers for good when the slot is shut down; their residual deltas are kept for the final report
 func (slot *Slot) ClosePeerConnections() {
 
 	slot.mtx.Lock()
 	defer slot.mtx.Unlock()
 
 	for _, peer := range slot.peerMap {
-
-		peer.CloseConnections()
-
-		if delta, has := peer.Delta(); has {
+		if delta, has := peer.Close(); has {
 			slot.oldDeltas = append(slot.oldDeltas, delta)
 		}
 	}
 }
 
+// Registers a client connection against the per-ip limit.
+// The returned function must be called once the connection is done
+func (slot *Slot) AcquireClient(ip string) (func(), error) {
+
+	slot.clientMtx.Lock()
+	defer slot.clientMtx.Unlock()
+
+	limit := slot.Options().MaxClientConnections
+	if limit == 0 {
+		return func() {}, nil
+	}
+
+	if slot.clientConns == nil {
+		slot.clientConns = map[string]uint{}
+	}
+
+	if slot.clientConns[ip] >= limit {
+		return nil, ErrTooManyClientConnections
+	}
+
+	slot.clientConns[ip]++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+
+			slot.clientMtx.Lock()
+			defer slot.clientMtx.Unlock()
+
+			if slot.clientConns[ip] <= 1 {
+				delete(slot.clientConns, ip)
+			} else {
+				slot.clientConns[ip]--
+			}
+		})
+	}, nil
+}
+
+// Returns the anonymous peer if the slot accepts clients without credentials
+func (slot *Slot) LookupAnonymous() (*Peer, error) {
+
+	slot.mtx.Lock()
+	defer slot.mtx.Unlock()
+
+	if opts := slot.Options(); !opts.AuthAllowed(SlotAuthNone) || slot.anonymousPeer == nil {
+		return nil, &CredentialsError{}
+	}
+
+	return slot.anonymousPeer, nil
+}
+
 func (slot *Slot) LookupWithPassword(ip net.IP, username, password string) (*Peer, error) {
+	return slot.LookupWithVerifier(ip, username, func(want string) bool {
+		return subtle.ConstantTimeCompare([]byte(want), []byte(password)) == 1
+	})
+}
+
+// Same as LookupWithPassword, but lets the caller check the password;
+// used by challenge-response schemes where the password itself is never sent
+func (slot *Slot) LookupWithVerifier(ip net.IP, username string, verify func(password string) bool) (*Peer, error) {
 
 	slot.mtx.Lock()
 	defer slot.mtx.Unlock()
@@ -332,18 +1229,23 @@ func (slot *Slot) LookupWithPassword(ip net.IP, username, password string) (*Pee
 		slot.peerMap = map[uuid.UUID]*Peer{}
 	}
 
-	peer := slot.userNameMap[username]
-	if peer == nil {
+	if opts := slot.Options(); !opts.AuthAllowed(SlotAuthPassword) {
 		return nil, &CredentialsError{}
 	}
 
-	var comparePasswords = func(want, have string) bool {
-		return subtle.ConstantTimeCompare([]byte(want), []byte(have)) == 1
+	key, err := NormalizeUsername(username)
+	if err != nil {
+		return nil, &CredentialsError{Err: err}
+	}
+
+	peer := slot.userNameMap[key]
+	if peer == nil {
+		return nil, &CredentialsError{}
 	}
 
 	if pa := peer.PasswordAuth; pa == nil {
 		return nil, &CredentialsError{}
-	} else if !comparePasswords(pa.Password, password) {
+	} else if !verify(pa.Password) {
 		return nil, &CredentialsError{Username: &username}
 	}
 
@@ -351,15 +1253,32 @@ func (slot *Slot) LookupWithPassword(ip net.IP, username, password string) (*Pee
 		rlc.Reset()
 	}
 
+	slot.checkLeak(peer, ip)
+
 	return peer, nil
 }
 
 type CredentialsError struct {
 	Username *string
+
+	//	set when the user name doesn't conform to the naming policy
+	Err error
+}
+
+// Returns the user name if the client sent a known one
+func (err *CredentialsError) User() string {
+	if err.Username != nil {
+		return *err.Username
+	}
+	return ""
 }
 
 func (err *CredentialsError) Error() string {
 
+	if err.Err != nil {
+		return "invalid user name: " + err.Err.Error()
+	}
+
 	if err.Username != nil {
 		return fmt.Sprintf("invalid password for %s", *err.Username)
 	}
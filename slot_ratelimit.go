@@ -0,0 +1,79 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limit of password attempts of a single slot, applied in place of the one the slot shares with other slots of the node
+type SlotRateLimit struct {
+
+	//	attempts per window from a single client ip; a non-positive quota lifts the limit
+	Quota     int64 `json:"quota"`
+	WindowSec uint  `json:"window_sec"`
+
+	//	fixed window when unset
+	Mode RateLimitMode `json:"mode,omitempty"`
+
+	//	quota of client ips that have run out of attempts before; same as the regular one when unset
+	OffenderQuota int64 `json:"offender_quota,omitempty"`
+}
+
+func (opts *SlotRateLimit) Validate() error {
+
+	if !opts.Mode.Valid() {
+		return fmt.Errorf("unsupported mode '%s'", opts.Mode)
+	}
+
+	if opts.Quota > 0 && opts.WindowSec == 0 {
+		return errors.New("window must be set")
+	}
+
+	return nil
+}
+
+func (opts *SlotRateLimit) limiterOptions() RateLimiterOptions {
+
+	result := RateLimiterOptions{
+		Quota:  opts.Quota,
+		Window: time.Duration(opts.WindowSec) * time.Second,
+		Mode:   opts.Mode,
+	}
+
+	if opts.OffenderQuota != 0 {
+		result.ClassQuotas = map[RateLimitClass]int64{RateLimitClassOffender: opts.OffenderQuota}
+	}
+
+	return result
+}
+
+// Switches the slot between its own limiter of password attempts and the one it was created with,
+// which may be shared with other slots. Limiters are kept as long as their options don't change,
+// so that reloads don't restore quotas of clients that are out of them. Must be called with the slot lock held
+func (slot *Slot) applyAuthRateLimit(opts *SlotRateLimit) {
+
+	if slot.baseRl == nil {
+		slot.baseRl = slot.Rl
+	}
+
+	if opts == nil {
+		slot.Rl = slot.baseRl
+		slot.rlOverride = nil
+		return
+	}
+
+	if current := slot.rlOverride; current != nil && *current == *opts {
+		return
+	}
+
+	rl := RateLimiter{RateLimiterOptions: opts.limiterOptions()}
+	if slot.baseRl != nil {
+		rl.Clock = slot.baseRl.Clock
+	}
+
+	override := *opts
+
+	slot.Rl = &rl
+	slot.rlOverride = &override
+}
@@ -0,0 +1,438 @@
+package nxproxy_test
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlotOptions_Fingerprint(t *testing.T) {
+
+	opts := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}
+
+	if other := opts; other.Fingerprint() != opts.Fingerprint() {
+		t.Errorf("fingerprint not stable")
+	}
+
+	//	field boundaries must be accounted for
+	shifted := nxproxy.SlotOptions{Proto: nxproxy.ProxyProto("socks127.0.0.1"), BindAddr: ":1080"}
+	if shifted.Fingerprint() == opts.Fingerprint() {
+		t.Errorf("fingerprint collision")
+	}
+
+	for _, other := range []nxproxy.SlotOptions{
+		{Proto: nxproxy.ProxyProtoHttp, BindAddr: opts.BindAddr},
+		{Proto: opts.Proto, BindAddr: "127.0.0.1:1081"},
+	} {
+		if opts.Compatible(&other) {
+			t.Errorf("unexpected compatibility with %v", other)
+		}
+	}
+}
+
+func TestSlot_AcquireClient(t *testing.T) {
+
+	var slot nxproxy.Slot
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{MaxClientConnections: 2}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	var releases []func()
+
+	for idx := range 2 {
+		release, err := slot.AcquireClient("10.0.0.1")
+		if err != nil {
+			t.Fatalf("unexpected err: %v at idx %d", err, idx)
+		}
+		releases = append(releases, release)
+	}
+
+	if _, err := slot.AcquireClient("10.0.0.1"); err != nxproxy.ErrTooManyClientConnections {
+		t.Errorf("unexpected absense of ErrTooManyClientConnections")
+	}
+
+	if _, err := slot.AcquireClient("10.0.0.2"); err != nil {
+		t.Errorf("unexpected err for another client: %v", err)
+	}
+
+	//	releasing twice must not free up extra slots
+	releases[0]()
+	releases[0]()
+
+	if _, err := slot.AcquireClient("10.0.0.1"); err != nil {
+		t.Errorf("unexpected err after release: %v", err)
+	}
+
+	if _, err := slot.AcquireClient("10.0.0.1"); err != nxproxy.ErrTooManyClientConnections {
+		t.Errorf("unexpected absense of ErrTooManyClientConnections after double release")
+	}
+}
+
+func TestConnectionWeights_Weight(t *testing.T) {
+
+	weights := &nxproxy.ConnectionWeights{
+		Tunnel:  2,
+		Forward: 1,
+		Hosts: map[string]uint32{
+			"example.com":        5,
+			".video.example":     0,
+			".cdn.video.example": 7,
+		},
+	}
+
+	tests := []struct {
+		kind nxproxy.ConnectionKind
+		addr string
+		want uint32
+	}{
+		{nxproxy.ConnectionTunnel, "maddsua.com:443", 2},
+		{nxproxy.ConnectionForward, "maddsua.com:80", 1},
+		{nxproxy.ConnectionForward, "Example.com:80", 5},
+		{nxproxy.ConnectionTunnel, "example.com", 5},
+		{nxproxy.ConnectionTunnel, "www.video.example:443", 1},
+		{nxproxy.ConnectionTunnel, "video.example:443", 1},
+		{nxproxy.ConnectionTunnel, "a.cdn.video.example:443", 7},
+	}
+
+	for _, test := range tests {
+		if got := weights.Weight(test.kind, test.addr); got != test.want {
+			t.Errorf("%s: expected: %d; got: %d", test.addr, test.want, got)
+		}
+	}
+
+	var unset *nxproxy.ConnectionWeights
+	if got := unset.Weight(nxproxy.ConnectionTunnel, "example.com:443"); got != 1 {
+		t.Errorf("unexpected default weight: %d", got)
+	}
+}
+
+func TestSlot_TakeStats(t *testing.T) {
+
+	var slot nxproxy.Slot
+
+	slot.Counters.Accepted.Add(3)
+	slot.Counters.Authenticated.Add(2)
+	slot.Counters.AuthFailed.Add(1)
+
+	stats := slot.TakeStats()
+	if stats.Accepted != 3 || stats.Authenticated != 2 || stats.AuthFailed != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if stats := slot.TakeStats(); stats != (nxproxy.SlotStats{}) {
+		t.Errorf("stats not reset: %+v", stats)
+	}
+}
+
+type stubDns struct{}
+
+func (stubDns) Resolver() *net.Resolver {
+	return net.DefaultResolver
+}
+
+func TestSlot_SetPeersReport(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	dupID := uuid.New()
+
+	report := slot.SetPeers([]nxproxy.PeerOptions{
+		{ID: dupID, PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}},
+		{ID: dupID, PasswordAuth: &nxproxy.UserPassword{User: "other", Password: "1"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "2"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "framed", Password: "3"}, FramedIP: "203.0.113.7"},
+	})
+
+	if report.Peers != 2 {
+		t.Errorf("expected 2 accepted peers, got %d", report.Peers)
+	}
+
+	if len(report.Rejected) != 2 {
+		t.Fatalf("expected 2 rejected peers, got %v", report.Rejected)
+	}
+
+	for _, entry := range report.Rejected {
+		if entry.Slot != "socks@127.0.0.1:1080" || entry.PeerID == nil || entry.Reason == "" {
+			t.Errorf("incomplete rejection entry: %+v", entry)
+		}
+	}
+
+	if len(report.Warnings) != 1 || report.Warnings[0].Peer != "framed" {
+		t.Errorf("expected a framed ip warning, got %v", report.Warnings)
+	}
+
+	if report.SameOutcome(&nxproxy.ConfigReport{Peers: report.Peers}) {
+		t.Errorf("reports with different rejections have the same outcome")
+	}
+}
+
+func TestSlot_SetPeersChanges(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "rotated", Password: "1"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "disabled", Password: "2"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "limited", Password: "3"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "same", Password: "4"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "removed", Password: "5"}},
+	}
+
+	if report := slot.SetPeers(peers); report.Changes != (nxproxy.ConfigChanges{PeersAdded: 5}) {
+		t.Fatalf("unexpected initial changes: %+v", report.Changes)
+	}
+
+	if report := slot.SetPeers(peers); !report.Changes.Empty() {
+		t.Fatalf("unexpected changes of the same config: %+v", report.Changes)
+	}
+
+	updated := slices.Clone(peers[:4])
+	updated[0].PasswordAuth = &nxproxy.UserPassword{User: "rotated", Password: "new"}
+	updated[1].Disabled = true
+	updated[2].MaxConnections = 10
+	updated = append(updated, nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "added", Password: "6"}})
+
+	expect := nxproxy.ConfigChanges{
+		PeersAdded:          1,
+		PeersRemoved:        1,
+		PeersChanged:        3,
+		CredentialRotations: 1,
+		PeersDisabled:       1,
+	}
+
+	if report := slot.SetPeers(updated); report.Changes != expect {
+		t.Errorf("unexpected changes: %+v", report.Changes)
+	}
+}
+
+func TestDiffPeers(t *testing.T) {
+
+	current := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "rotated", Password: "1"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "framed", Password: "2"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "removed", Password: "3"}},
+	}
+
+	next := slices.Clone(current[:2])
+	next[0].PasswordAuth = &nxproxy.UserPassword{User: "rotated", Password: "new"}
+	next[1].FramedIP = "203.0.113.7"
+	next = append(next,
+		nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "added", Password: "4"}},
+		nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "added", Password: "5"}})
+
+	expect := nxproxy.ConfigChanges{
+		PeersAdded:          1,
+		PeersRemoved:        1,
+		PeersChanged:        2,
+		CredentialRotations: 1,
+		FramedIPChanges:     1,
+	}
+
+	if changes := nxproxy.DiffPeers(current, next); changes != expect {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+
+	//	the preview has to match what actually gets applied
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	slot.SetPeers(current)
+
+	if report := slot.SetPeers(next); report.Changes != expect {
+		t.Errorf("applied changes differ from the preview: %+v", report.Changes)
+	}
+}
+
+func TestSlot_SetPeersPause(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}},
+	}
+
+	slot.SetPeers(peers)
+
+	peer, err := slot.LookupWithPassword(net.ParseIP("127.0.0.1"), "maddsua", "1")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	if _, err := peer.Connection(); err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	peers[0].Paused = true
+	slot.SetPeers(peers)
+
+	if peer.AcceptsSessions() {
+		t.Errorf("paused peer accepts new sessions")
+	}
+
+	if val := peer.ActiveConnections(); val != 1 {
+		t.Errorf("paused peer connections dropped, %d left", val)
+	}
+
+	peers[0].Disabled = true
+	slot.SetPeers(peers)
+
+	if val := peer.ActiveConnections(); val != 0 {
+		t.Errorf("disabled peer connections kept, %d left", val)
+	}
+}
+
+func TestSlot_SetPeersBandwidth(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	peers := []nxproxy.PeerOptions{
+		{
+			ID:           uuid.New(),
+			PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"},
+			Bandwidth:    nxproxy.PeerBandwidth{Rx: 1000, Tx: 1000},
+		},
+	}
+
+	slot.SetPeers(peers)
+
+	peer, err := slot.LookupWithPassword(net.ParseIP("127.0.0.1"), "maddsua", "1")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	conn, err := peer.Connection()
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+
+	defer conn.Close()
+
+	peers[0].Bandwidth = nxproxy.PeerBandwidth{Rx: 5000, Tx: 5000}
+	slot.SetPeers(peers)
+
+	//	well under the refresh interval
+	deadline := time.Now().Add(250 * time.Millisecond)
+
+	for {
+
+		if val, _ := conn.BandwidthRx(); val == 5000 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			val, _ := conn.BandwidthRx()
+			t.Fatalf("bandwidth change not applied, rx rate %d", val)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if val, _ := conn.BandwidthTx(); val != 5000 {
+		t.Errorf("unexpected tx rate %d", val)
+	}
+}
+
+func TestSlot_Identity(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	opts := nxproxy.SlotOptions{ID: "svc-1", Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}
+	if err := slot.SetOptions(opts); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	id := uuid.New()
+	slot.SetPeers([]nxproxy.PeerOptions{{ID: id}})
+
+	peer, err := slot.LookupPeer(id)
+	if err != nil {
+		t.Fatalf("lookup peer: %v", err)
+	}
+
+	if peer.Slot != opts.Identity() {
+		t.Errorf("unexpected peer slot: %+v", peer.Slot)
+	}
+
+	//	backend ids may change without restarting the slot
+	opts.ID = "svc-2"
+	if err := slot.SetOptions(opts); err != nil {
+		t.Fatalf("update options: %v", err)
+	}
+
+	slot.SetPeers([]nxproxy.PeerOptions{{ID: id}})
+
+	if peer.Slot.ID != "svc-2" {
+		t.Errorf("peer slot not updated: %+v", peer.Slot)
+	}
+
+	peer.DeltaRx.Add(10)
+
+	deltas := slot.Deltas()
+	if len(deltas) != 1 || deltas[0].SlotID != "svc-2" || deltas[0].Slot != "socks@127.0.0.1:1080" || deltas[0].Proto != nxproxy.ProxyProtoSocks {
+		t.Errorf("unexpected deltas: %+v", deltas)
+	}
+
+	if info := slot.Info(); info.ID != "svc-2" {
+		t.Errorf("unexpected slot info id: '%s'", info.ID)
+	}
+}
+
+func TestConfigChecksum(t *testing.T) {
+
+	peerA := nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "a", Password: "1"}}
+	peerB := nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "b", Password: "1"}}
+
+	http := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "127.0.0.1:8080"}
+	socks := nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "127.0.0.1:1080"}
+
+	entries := []nxproxy.ServiceOptions{
+		{SlotOptions: http, Peers: []nxproxy.PeerOptions{peerA, peerB}},
+		{SlotOptions: socks, Peers: []nxproxy.PeerOptions{peerA}},
+	}
+
+	reordered := []nxproxy.ServiceOptions{
+		{SlotOptions: socks, Peers: []nxproxy.PeerOptions{peerA}},
+		{SlotOptions: http, Peers: []nxproxy.PeerOptions{peerB, peerA}},
+	}
+
+	checksum := nxproxy.ConfigChecksum(entries)
+
+	if other := nxproxy.ConfigChecksum(reordered); other != checksum {
+		t.Errorf("checksum depends on entry order: %s != %s", checksum, other)
+	}
+
+	if entries[0].Peers[0].ID != peerA.ID {
+		t.Errorf("checksum reordered the entries")
+	}
+
+	peerB.Disabled = true
+	reordered[1].Peers[0] = peerB
+
+	if other := nxproxy.ConfigChecksum(reordered); other == checksum {
+		t.Errorf("checksum didn't change with peer options")
+	}
+}
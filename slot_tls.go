@@ -0,0 +1,74 @@
+package nxproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// Server certificate of an https slot. Certificates are delivered by the backend and may be rotated
+// without restarting the slot; obtaining them, over ACME or otherwise, is up to the backend
+type TlsOptions struct {
+
+	//	PEM-encoded certificate chain, leaf first, and its private key
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Server certificate loaded from TLS options
+type ServerCertificate struct {
+	certPEM string
+	keyPEM  string
+
+	cert tls.Certificate
+}
+
+func NewServerCertificate(certPEM, keyPEM string) (*ServerCertificate, error) {
+
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	if pair.Leaf == nil {
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ServerCertificate{certPEM: certPEM, keyPEM: keyPEM, cert: pair}, nil
+}
+
+// Checks whether the certificate was loaded from the same files
+func (cert *ServerCertificate) Matches(certPEM, keyPEM string) bool {
+	return cert.certPEM == certPEM && cert.keyPEM == keyPEM
+}
+
+// Returns the time the leaf certificate expires at
+func (cert *ServerCertificate) Expires() time.Time {
+	return cert.cert.Leaf.NotAfter
+}
+
+var ErrNoServerCertificate = errors.New("no server certificate")
+
+// Returns the config that TLS connections of https slots are accepted with. The certificate is looked up
+// on every handshake, so that rotated ones are picked up by new connections right away
+func (slot *Slot) TlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+
+		//	proxy requests rely on connections being hijacked, which http2 doesn't allow for
+		NextProtos: []string{"http/1.1"},
+
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+			cert := slot.tlsCert.Load()
+			if cert == nil {
+				return nil, ErrNoServerCertificate
+			}
+
+			return &cert.cert, nil
+		},
+	}
+}
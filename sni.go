@@ -0,0 +1,156 @@
+package nxproxy
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// TLS records can't be larger than 16K plus the header
+const maxTlsRecordSize = 5 + 16*1024
+
+// Wraps a client connection to passively look at the TLS ClientHello that the client sends first.
+// The callback receives the parsed hello and may stop the connection by returning an error.
+// Data that doesn't look like TLS is passed through as is
+func InspectClientHello(conn net.Conn, prefix []byte, onHello func(hello *ClientHello) error) net.Conn {
+	return &helloInspector{
+		Conn:    conn,
+		pending: append([]byte{}, prefix...),
+		onHello: onHello,
+	}
+}
+
+type helloInspector struct {
+	net.Conn
+	pending []byte
+	done    bool
+	onHello func(hello *ClientHello) error
+}
+
+func (conn *helloInspector) NetConn() net.Conn {
+	return conn.Conn
+}
+
+func (conn *helloInspector) Read(buff []byte) (int, error) {
+
+	if !conn.done {
+
+		var readErr error
+
+		for {
+
+			hello, complete := parseClientHello(conn.pending)
+			if complete || readErr != nil || len(conn.pending) >= maxTlsRecordSize {
+
+				conn.done = true
+
+				if hello != nil && conn.onHello != nil {
+					if err := conn.onHello(hello); err != nil {
+						return 0, err
+					}
+				}
+
+				break
+			}
+
+			chunk := make([]byte, maxTlsRecordSize-len(conn.pending))
+
+			var read int
+			read, readErr = conn.Conn.Read(chunk)
+			conn.pending = append(conn.pending, chunk[:read]...)
+		}
+
+		if len(conn.pending) == 0 && readErr != nil {
+			return 0, readErr
+		}
+	}
+
+	if len(conn.pending) > 0 {
+		read := copy(buff, conn.pending)
+		conn.pending = conn.pending[read:]
+		return read, nil
+	}
+
+	return conn.Conn.Read(buff)
+}
+
+// A minimal reader of length-prefixed TLS structures
+type cryptoBytes []byte
+
+func (val *cryptoBytes) skip(n int) bool {
+
+	if len(*val) < n {
+		return false
+	}
+
+	*val = (*val)[n:]
+	return true
+}
+
+func (val *cryptoBytes) uint8() (uint8, bool) {
+
+	if len(*val) < 1 {
+		return 0, false
+	}
+
+	next := (*val)[0]
+	*val = (*val)[1:]
+	return next, true
+}
+
+func (val *cryptoBytes) uint16(out *uint16) bool {
+
+	if len(*val) < 2 {
+		return false
+	}
+
+	*out = binary.BigEndian.Uint16(*val)
+	*val = (*val)[2:]
+	return true
+}
+
+func (val *cryptoBytes) uint16List(out *[]uint16) bool {
+
+	if len(*val)%2 != 0 {
+		return false
+	}
+
+	for len(*val) > 0 {
+		var next uint16
+		val.uint16(&next)
+		*out = append(*out, next)
+	}
+
+	return true
+}
+
+func (val *cryptoBytes) prefixed(lenSize int, out *cryptoBytes) bool {
+
+	if len(*val) < lenSize {
+		return false
+	}
+
+	var size int
+	for _, b := range (*val)[:lenSize] {
+		size = size<<8 | int(b)
+	}
+
+	if len(*val) < lenSize+size {
+		return false
+	}
+
+	*out = (*val)[lenSize : lenSize+size]
+	*val = (*val)[lenSize+size:]
+	return true
+}
+
+func (val *cryptoBytes) uint8Prefixed(out *cryptoBytes) bool {
+	return val.prefixed(1, out)
+}
+
+func (val *cryptoBytes) uint16Prefixed(out *cryptoBytes) bool {
+	return val.prefixed(2, out)
+}
+
+func (val *cryptoBytes) uint24Prefixed(out *cryptoBytes) bool {
+	return val.prefixed(3, out)
+}
@@ -0,0 +1,138 @@
+package nxproxy_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Returns the ClientHello record a TLS client sends for the server name
+func clientHelloStream(t *testing.T, serverName string) []byte {
+
+	client, server := net.Pipe()
+
+	go func() {
+		tlsConn := tls.Client(client, &tls.Config{ServerName: serverName})
+		_ = tlsConn.Handshake()
+	}()
+
+	defer client.Close()
+	defer server.Close()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("read record header: %v", err)
+	}
+
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("read record: %v", err)
+	}
+
+	return append(header, body...)
+}
+
+func TestInspectClientHello(t *testing.T) {
+
+	hello := clientHelloStream(t, "example.com")
+	stream := append(append([]byte{}, hello...), "application data"...)
+
+	for _, split := range []int{0, 3, 100, len(hello)} {
+
+		client, server := net.Pipe()
+
+		var serverName string
+		conn := nxproxy.InspectClientHello(server, stream[:split], func(hello *nxproxy.ClientHello) error {
+			serverName = hello.ServerName
+			return nil
+		})
+
+		go func() {
+			client.Write(stream[split:])
+			client.Close()
+		}()
+
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("split %d: read: %v", split, err)
+		}
+
+		if serverName != "example.com" {
+			t.Errorf("split %d: unexpected server name: '%s'", split, serverName)
+		}
+
+		if !bytes.Equal(data, stream) {
+			t.Errorf("split %d: data altered", split)
+		}
+	}
+}
+
+func TestInspectClientHello_Blocked(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn := nxproxy.InspectClientHello(server, clientHelloStream(t, "blocked.example.com"), func(*nxproxy.ClientHello) error {
+		return nxproxy.ErrDomainBlocked
+	})
+
+	if _, err := conn.Read(make([]byte, 1024)); !errors.Is(err, nxproxy.ErrDomainBlocked) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInspectClientHello_NotTls(t *testing.T) {
+
+	client, server := net.Pipe()
+
+	var called bool
+	conn := nxproxy.InspectClientHello(server, nil, func(*nxproxy.ClientHello) error {
+		called = true
+		return nil
+	})
+
+	payload := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	go func() {
+		client.Write(payload)
+		client.Close()
+	}()
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if called || !bytes.Equal(data, payload) {
+		t.Errorf("plain data not passed through")
+	}
+}
+
+func TestSlotOptions_DomainBlocked(t *testing.T) {
+
+	opts := nxproxy.SlotOptions{BlockedDomains: []string{"example.com", ".Example.org"}}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "example.com:443", want: true},
+		{addr: "EXAMPLE.COM.", want: true},
+		{addr: "www.example.com", want: false},
+		{addr: "example.org", want: true},
+		{addr: "cdn.example.org:443", want: true},
+		{addr: "notexample.org", want: false},
+		{addr: "1.1.1.1:443", want: false},
+	}
+
+	for _, test := range tests {
+		if got := opts.DomainBlocked(test.addr); got != test.want {
+			t.Errorf("%s: expected: %v; got: %v", test.addr, test.want, got)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"net"
 	"strconv"
+	"strings"
 
 	nxproxy "github.com/maddsua/nx-proxy"
 )
@@ -69,6 +70,11 @@ func readAddr(reader io.Reader) (*Addr, error) {
 		return nil, err
 	}
 
+	return readAddrOfType(reader, addrType)
+}
+
+func readAddrOfType(reader io.Reader, addrType byte) (*Addr, error) {
+
 	addr := Addr{}
 
 	switch addrType {
@@ -107,6 +113,11 @@ func readAddr(reader io.Reader) (*Addr, error) {
 
 		addr.Host = string(domain)
 
+		//	some clients send IP literals as domain names, occasionally in brackets
+		if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr.Host, "["), "]")); ip != nil {
+			addr.Host = ip.String()
+		}
+
 	default:
 		return nil, fmt.Errorf("invalid addr type: %x", addrType)
 	}
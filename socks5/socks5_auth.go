@@ -101,19 +101,43 @@ const (
 	PasswordAuthFail    = PasswordAuthStatus(0x01)
 )
 
-func replyAuth(conn net.Conn, val AuthMethod) error {
-	return reply(conn, Reply(val), nil)
+// Writes a method selection message (RFC 1928, section 3)
+func replyAuthMethod(conn net.Conn, val AuthMethod) error {
+	_, err := conn.Write([]byte{ProtoVersionByte, byte(val)})
+	return err
 }
 
-// In accordance to https://datatracker.ietf.org/doc/html/rfc1929
-func connPasswordAuth(conn net.Conn, slot *nxproxy.Slot) (*nxproxy.Peer, error) {
+// Picks the first method from the slot's preference list that the client has offered
+func selectAuthMethod(offered map[AuthMethod]bool, priority []nxproxy.SlotAuth) AuthMethod {
+
+	for _, val := range priority {
+
+		var method AuthMethod
+		switch val {
+		case nxproxy.SlotAuthNone:
+			method = AuthMethodNone
+		case nxproxy.SlotAuthPassword:
+			method = AuthMethodPassword
+		default:
+			continue
+		}
 
-	if err := replyAuth(conn, AuthMethodPassword); err != nil {
-		return nil, fmt.Errorf("auth method ack: %v", err)
+		if offered[method] {
+			return method
+		}
 	}
 
+	return AuthMethodUnacceptable
+}
+
+// In accordance to https://datatracker.ietf.org/doc/html/rfc1929
+func connPasswordAuth(conn net.Conn, slot *nxproxy.Slot, lenient Leniency) (*nxproxy.Peer, error) {
+
+	//	clients that get the version wrong expect it to be echoed back
+	replyVersion := PasswordAuthVersion
+
 	var reply = func(val PasswordAuthStatus) error {
-		_, err := conn.Write([]byte{PasswordAuthVersion, byte(val)})
+		_, err := conn.Write([]byte{replyVersion, byte(val)})
 		return err
 	}
 
@@ -125,7 +149,13 @@ func connPasswordAuth(conn net.Conn, slot *nxproxy.Slot) (*nxproxy.Peer, error)
 		}
 
 		if ver := buff[0]; ver != PasswordAuthVersion {
-			return nil, fmt.Errorf("unexpected negotiation version: %v", ver)
+
+			if lenient == nil || ver != ProtoVersionByte {
+				return nil, fmt.Errorf("unexpected negotiation version: %v", ver)
+			}
+
+			lenient(DeviationAuthVersion)
+			replyVersion = ver
 		}
 
 		ulen := int(buff[1])
@@ -154,16 +184,20 @@ func connPasswordAuth(conn net.Conn, slot *nxproxy.Slot) (*nxproxy.Peer, error)
 		return nil, fmt.Errorf("failed to read credentials: %v", err)
 	}
 
-	//	ensure that username isn't empty
-	if creds.User == "" {
+	if _, err := nxproxy.NormalizeUsername(creds.User); err != nil {
 		_ = reply(PasswordAuthFail)
-		return nil, fmt.Errorf("invalid credentials: empty user name")
+		return nil, fmt.Errorf("invalid credentials: %v", err)
 	}
 
 	remoteIp, _ := nxproxy.GetAddrPort(conn.RemoteAddr())
 
 	peer, err := slot.LookupWithPassword(remoteIp, creds.User, creds.Password)
 	if err != nil {
+
+		if _, limited := err.(*nxproxy.RateLimitError); limited {
+			slot.Tarpit(slot.BaseContext)
+		}
+
 		_ = reply(PasswordAuthFail)
 		return nil, err
 	}
@@ -0,0 +1,128 @@
+package socks5
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestReadAddr_IPv6Forms(t *testing.T) {
+
+	var domainAddr = func(host string, port uint16) []byte {
+		return append(append([]byte{AddrDomainName, byte(len(host))}, host...), byte(port>>8), byte(port))
+	}
+
+	cases := []struct {
+		name string
+		raw  []byte
+		addr Addr
+		str  string
+	}{
+		{
+			name: "ipv6 type",
+			raw:  append(append([]byte{AddrIPv6}, net.ParseIP("2001:db8::1")...), 0x01, 0xbb),
+			addr: Addr{Host: "2001:db8::1", Port: 443},
+			str:  "[2001:db8::1]:443",
+		},
+		{
+			name: "ipv4-mapped ipv6 type",
+			raw:  append(append([]byte{AddrIPv6}, net.ParseIP("::ffff:192.0.2.1")...), 0x00, 0x50),
+			addr: Addr{Host: "192.0.2.1", Port: 80},
+			str:  "192.0.2.1:80",
+		},
+		{
+			name: "ipv6 literal as domain",
+			raw:  domainAddr("2001:DB8:0::1", 443),
+			addr: Addr{Host: "2001:db8::1", Port: 443},
+			str:  "[2001:db8::1]:443",
+		},
+		{
+			name: "bracketed ipv6 literal as domain",
+			raw:  domainAddr("[2001:db8::1]", 443),
+			addr: Addr{Host: "2001:db8::1", Port: 443},
+			str:  "[2001:db8::1]:443",
+		},
+	}
+
+	for _, test := range cases {
+
+		addr, err := readAddr(bytes.NewReader(test.raw))
+		if err != nil {
+			t.Errorf("%s: unexpected err: %v", test.name, err)
+			continue
+		}
+
+		if *addr != test.addr {
+			t.Errorf("%s: unexpected addr: %v", test.name, addr)
+		}
+
+		if val := addr.String(); val != test.str {
+			t.Errorf("%s: unexpected string form: %s", test.name, val)
+		}
+	}
+}
+
+func TestService_LocalIPv6Denied(t *testing.T) {
+
+	svc := service{Slot: nxproxy.Slot{DNS: stubDns{}}}
+
+	if err := svc.SetOptions(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoSocks,
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+	}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	svc.SetPeers([]nxproxy.PeerOptions{{ID: uuid.New()}})
+
+	var domainAddr = func(host string) []byte {
+		return append(append([]byte{AddrDomainName, byte(len(host))}, host...), 0x01, 0xbb)
+	}
+
+	requests := map[string][]byte{
+		"ipv6 loopback":         append(append([]byte{AddrIPv6}, net.IPv6loopback...), 0x01, 0xbb),
+		"ipv4-mapped loopback":  append(append([]byte{AddrIPv6}, net.ParseIP("::ffff:127.0.0.1")...), 0x01, 0xbb),
+		"link-local":            append(append([]byte{AddrIPv6}, net.ParseIP("fe80::1")...), 0x01, 0xbb),
+		"bracketed domain form": domainAddr("[::1]"),
+	}
+
+	for name, addr := range requests {
+
+		serverConn, clientConn := net.Pipe()
+
+		go svc.serveConn(serverConn)
+
+		clientConn.SetDeadline(time.Now().Add(time.Second))
+
+		if _, err := clientConn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			t.Fatalf("%s: write greeting: %v", name, err)
+		}
+
+		if _, err := nxproxy.ReadN(clientConn, 2); err != nil {
+			t.Fatalf("%s: read method: %v", name, err)
+		}
+
+		if _, err := clientConn.Write(append([]byte{0x05, byte(CmdConnect), 0x00}, addr...)); err != nil {
+			t.Fatalf("%s: write request: %v", name, err)
+		}
+
+		resp, err := nxproxy.ReadN(clientConn, 2)
+		if err != nil {
+			t.Fatalf("%s: read reply: %v", name, err)
+		}
+
+		if resp[1] != byte(ReplyErrConnNotAllowedByRuleset) {
+			t.Errorf("%s: expected the destination to be denied, got reply %d", name, resp[1])
+		}
+
+		clientConn.Close()
+	}
+
+	if stats := svc.TakeStats(); stats.DeniedDest != uint64(len(requests)) {
+		t.Errorf("expected %d denied dests, got %d", len(requests), stats.DeniedDest)
+	}
+}
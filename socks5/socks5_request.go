@@ -33,20 +33,59 @@ func (val Command) String() string {
 	}
 }
 
-func readCommand(reader io.Reader) (Command, error) {
+// Returns the kind that requests with this command are accounted under in peer activity
+func (val Command) RequestKind() nxproxy.RequestKind {
+	switch val {
+	case CmdConnect:
+		return nxproxy.RequestSocksConnect
+	case CmdBind:
+		return nxproxy.RequestSocksBind
+	case CmdAssociate:
+		return nxproxy.RequestSocksUdp
+	default:
+		return nxproxy.RequestSocksOther
+	}
+}
+
+// Deviations of non-compliant clients that are tolerated in lenient mode
+const (
+	DeviationAuthVersion       = "socks5_auth_version"
+	DeviationReservedMissing   = "socks5_reserved_missing"
+	DeviationReservedNotZeroed = "socks5_reserved_not_zeroed"
+)
+
+// Enables lenient parsing when set; gets called for every tolerated deviation
+type Leniency func(deviation string)
+
+// Returns the command and an address type byte if the client has skipped the reserved byte
+func readCommand(reader io.Reader, lenient Leniency) (Command, byte, error) {
 
 	buff, err := nxproxy.ReadN(reader, 3)
 	if err != nil {
-		return cmdEnum, fmt.Errorf("read command: %v", err)
+		return cmdEnum, 0, fmt.Errorf("read command: %v", err)
 	}
 
 	if buff[0] != ProtoVersionByte {
-		return cmdEnum, fmt.Errorf("unexpected negotiation version: %v", buff[0])
-	} else if buff[2] != ProtoReserved {
-		return cmdEnum, fmt.Errorf("trail data after command byte")
+		return cmdEnum, 0, fmt.Errorf("unexpected negotiation version: %v", buff[0])
 	}
 
-	return Command(buff[1]), nil
+	if val := buff[2]; val != ProtoReserved {
+
+		if lenient == nil {
+			return cmdEnum, 0, fmt.Errorf("trail data after command byte")
+		}
+
+		//	the only way to tell a missing reserved byte from a garbage one is to check if it looks like an address type
+		switch val {
+		case AddrIPv4, AddrDomainName, AddrIPv6:
+			lenient(DeviationReservedMissing)
+			return Command(buff[1]), val, nil
+		default:
+			lenient(DeviationReservedNotZeroed)
+		}
+	}
+
+	return Command(buff[1]), 0, nil
 }
 
 type Request struct {
@@ -54,14 +93,20 @@ type Request struct {
 	Addr *Addr
 }
 
-func readRequest(reader io.Reader) (*Request, error) {
+func readRequest(reader io.Reader, lenient Leniency) (*Request, error) {
 
-	cmd, err := readCommand(reader)
+	cmd, addrType, err := readCommand(reader, lenient)
 	if err != nil {
 		return nil, fmt.Errorf("read cmd: %v", err)
 	}
 
-	addr, err := readAddr(reader)
+	if addrType == 0 {
+		if addrType, err = nxproxy.ReadByte(reader); err != nil {
+			return nil, fmt.Errorf("read addr: %v", err)
+		}
+	}
+
+	addr, err := readAddrOfType(reader, addrType)
 	if err != nil {
 		return nil, fmt.Errorf("read addr: %v", err)
 	}
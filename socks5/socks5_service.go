@@ -2,6 +2,7 @@ package socks5
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -11,23 +12,31 @@ import (
 	nxproxy "github.com/maddsua/nx-proxy"
 )
 
-func NewService(opts nxproxy.SlotOptions, dns nxproxy.DnsProvider) (nxproxy.SlotService, error) {
+func NewService(opts nxproxy.SlotOptions, env nxproxy.SlotEnv) (nxproxy.SlotService, error) {
 
 	svc := service{
 		Slot: nxproxy.Slot{
-			SlotOptions: opts,
-			Rl: &nxproxy.RateLimiter{
-				RateLimiterOptions: nxproxy.DefaultRatelimiter,
-			},
-			DNS: dns,
+			Rl:           env.NewAuthRateLimiter(),
+			DNS:          env.DNS,
+			UsageSamples: env.UsageSamples,
+			Egress:       env.Egress,
+			KernelPacing: env.KernelPacing,
+			BridgeLinger: env.BridgeLinger,
+			Blocklist:    env.Blocklist,
+			GeoIP:        env.GeoIP,
+			AuthLog:      env.AuthLog,
+			ListenFunc:   env.Listen,
+			DialFunc:     env.Dial,
 		},
 	}
 
-	var err error
+	if err := svc.SetOptions(opts); err != nil {
+		return nil, err
+	}
 
-	addr, proto, _ := nxproxy.SplitAddrNet(opts.BindAddr)
+	var err error
 
-	if svc.listener, err = net.Listen(proto, addr); err != nil {
+	if svc.listener, err = svc.Listen(); err != nil {
 		return nil, err
 	}
 
@@ -48,17 +57,6 @@ type service struct {
 	listener net.Listener
 }
 
-func (svc *service) SetOptions(opts nxproxy.SlotOptions) error {
-
-	if !svc.SlotOptions.Compatible(&opts) {
-		return nxproxy.ErrSlotOptionsIncompatible
-	}
-
-	svc.SlotOptions = opts
-
-	return nil
-}
-
 func (svc *service) Close() error {
 
 	if svc.ctx.Err() != nil {
@@ -109,68 +107,129 @@ func (svc *service) serveConn(conn net.Conn) {
 
 	conn.SetDeadline(time.Now().Add(5 * time.Second))
 
+	proxyAddr := svc.Options().BindAddr
 	clientIP, _ := nxproxy.GetAddrPort(conn.RemoteAddr())
 
+	svc.Counters.Accepted.Add(1)
+
+	releaseClient, err := svc.AcquireClient(clientIP.String())
+	if err != nil {
+		slog.Debug("SOCKS5: Client connection limit reached",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr))
+		svc.Tarpit(svc.ctx)
+		return
+	}
+
+	defer releaseClient()
+
 	methods, err := readAuthMethods(conn)
 	if err != nil {
 		slog.Debug("SOCKS5: Handshake error",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("err", err.Error()))
+		_ = replyAuthMethod(conn, AuthMethodUnacceptable)
+		return
+	}
+
+	opts := svc.Options()
+
+	var lenient Leniency
+	if opts.LenientParsing {
+		lenient = svc.CountDeviation
+	}
+
+	method := selectAuthMethod(methods, opts.AuthPriority())
+	if err := replyAuthMethod(conn, method); err != nil {
+		slog.Debug("SOCKS5: Auth method ack",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("err", err.Error()))
-		_ = reply(conn, ReplyErrGeneric, nil)
 		return
 	}
 
 	var peer *nxproxy.Peer
 
-	if _, has := methods[AuthMethodPassword]; has {
+	switch method {
+
+	case AuthMethodPassword:
 
-		peer, err = connPasswordAuth(conn, &svc.Slot)
+		peer, err = connPasswordAuth(conn, &svc.Slot, lenient)
 		if err != nil {
 
-			switch err.(type) {
+			switch err := err.(type) {
 
 			case *nxproxy.RateLimitError:
-				break
+				svc.AuthFailed(clientIP.String(), "", nxproxy.AuthFailRateLimit)
 
 			case *nxproxy.CredentialsError:
+				svc.AuthFailed(clientIP.String(), err.User(), nxproxy.AuthFailCredentials)
 				slog.Debug("SOCKS5: Invalid credentials",
 					slog.String("client_ip", clientIP.String()),
-					slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+					slog.String("proxy_addr", proxyAddr),
 					slog.String("err", err.Error()))
 
 			default:
+				svc.AuthFailed(clientIP.String(), "", nxproxy.AuthFailMalformed)
 				slog.Debug("SOCKS5: Password auth rejected",
 					slog.String("client_ip", clientIP.String()),
-					slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+					slog.String("proxy_addr", proxyAddr),
 					slog.String("err", err.Error()))
 			}
 
 			return
 		}
 
-	} else {
-		_ = replyAuth(conn, AuthMethodUnacceptable)
+	case AuthMethodNone:
+
+		//	there's no way to reject a client at this stage, so the request gets refused instead
+		peer, err = svc.LookupAnonymous()
+		if err != nil {
+
+			svc.AuthFailed(clientIP.String(), "", nxproxy.AuthFailMethod)
+
+			slog.Debug("SOCKS5: Anonymous peer unavailable",
+				slog.String("client_ip", clientIP.String()),
+				slog.String("proxy_addr", proxyAddr))
+
+			if _, err := readRequest(conn, lenient); err == nil {
+				_ = reply(conn, ReplyErrConnNotAllowedByRuleset, nil)
+			}
+
+			return
+		}
+
+	default:
+		svc.AuthFailed(clientIP.String(), "", nxproxy.AuthFailMethod)
+		slog.Debug("SOCKS5: No acceptable auth methods",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr))
 		return
 	}
 
-	req, err := readRequest(conn)
+	svc.Counters.Authenticated.Add(1)
+
+	req, err := readRequest(conn, lenient)
 	if err != nil {
 		slog.Debug("SOCKS5: Invalid request",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("err", err.Error()))
 		_ = reply(conn, ReplyErrGeneric, nil)
 		return
 	}
 
-	//	cancel request if the peer is disabled
-	if peer.Disabled {
-		slog.Debug("SOCKS5: Request cancelled; Peer disabled",
+	peer.RecordRequest(req.Cmd.RequestKind(), req.Addr.String())
+
+	//	cancel request if the peer is disabled or paused
+	if !peer.AcceptsSessions() {
+		slog.Debug("SOCKS5: Request cancelled; Peer disabled or paused",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
-			slog.String("host", req.Addr.String()))
+			slog.String("host", req.Addr.String()),
+			slog.Bool("paused", peer.Paused))
 		_ = reply(conn, ReplyErrConnNotAllowedByRuleset, nil)
 		return
 	}
@@ -178,28 +237,52 @@ func (svc *service) serveConn(conn net.Conn) {
 	if err := conn.SetDeadline(time.Time{}); err != nil {
 		slog.Debug("SOCKS5: Reset io timeouts",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("err", err.Error()))
 		_ = reply(conn, ReplyErrGeneric, nil)
 		return
 	}
 
 	if nxproxy.IsLocalAddress(req.Addr.Host) {
+		svc.DenyDest(peer, clientIP.String(), req.Addr.String(), nxproxy.DenyLocalAddr)
 		slog.Warn("SOCKS5: Dest addr not allowed",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("host", req.Addr.String()))
+		_ = reply(conn, ReplyErrConnNotAllowedByRuleset, nil)
+		return
+	}
+
+	if opts.DomainBlocked(req.Addr.Host) {
+		svc.DenyDest(peer, clientIP.String(), req.Addr.String(), nxproxy.DenyBlockedDomain)
+		slog.Warn("SOCKS5: Dest domain blocked",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
 			slog.String("host", req.Addr.String()))
 		_ = reply(conn, ReplyErrConnNotAllowedByRuleset, nil)
 		return
 	}
 
+	if rule, blocked := svc.Blocklist.Match(req.Addr.Host); blocked {
+		svc.DenyDest(peer, clientIP.String(), req.Addr.String(), nxproxy.DenyBlocklist)
+		slog.Warn("SOCKS5: Dest blocklisted",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", req.Addr.String()),
+			slog.String("rule", rule))
+		_ = reply(conn, ReplyErrConnNotAllowedByRuleset, nil)
+		return
+	}
+
 	switch req.Cmd {
 	case CmdConnect:
 		svc.cmdConnect(conn, peer, req.Addr)
 	default:
 		slog.Debug("SOCKS5: Command not supported",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("cmd", req.Cmd.String()))
 		_ = reply(conn, ReplyErrCmdNotSupported, nil)
 	}
@@ -207,6 +290,8 @@ func (svc *service) serveConn(conn net.Conn) {
 
 func (svc *service) cmdConnect(conn net.Conn, peer *nxproxy.Peer, host *Addr) {
 
+	opts := svc.Options()
+	proxyAddr := opts.BindAddr
 	clientIP, _ := nxproxy.GetAddrPort(conn.RemoteAddr())
 
 	connCtl, err := peer.Connection()
@@ -214,7 +299,7 @@ func (svc *service) cmdConnect(conn net.Conn, peer *nxproxy.Peer, host *Addr) {
 
 		slog.Debug("SOCKS5: Connect: Peer connection rejected",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("err", err.Error()))
 
@@ -229,11 +314,29 @@ func (svc *service) cmdConnect(conn net.Conn, peer *nxproxy.Peer, host *Addr) {
 
 	defer connCtl.Close()
 
-	dstConn, err := peer.Dialer.DialContext(connCtl.Context(), "tcp", host.String())
-	if err != nil {
+	connCtl.SetLogAttrs(
+		slog.String("client_ip", clientIP.String()),
+		slog.String("proxy_addr", proxyAddr),
+		slog.String("host", host.String()))
+
+	connCtl.SetWeight(opts.ConnectionWeights.Weight(nxproxy.ConnectionTunnel, host.Host))
+
+	dstConn, err := peer.DialPooled(connCtl.Context(), "tcp", host.String())
+	peer.ReportDial(err)
+	if errors.Is(err, nxproxy.ErrDestBlocklisted) {
+		svc.DenyDest(peer, clientIP.String(), host.String(), nxproxy.DenyBlocklist)
+		slog.Warn("SOCKSv5: Connect: Resolved dest addr blocklisted",
+			slog.String("client_ip", clientIP.String()),
+			slog.String("proxy_addr", proxyAddr),
+			slog.String("peer", peer.DisplayName()),
+			slog.String("host", host.String()))
+		_ = reply(conn, ReplyErrConnNotAllowedByRuleset, host)
+		return
+	} else if err != nil {
+		svc.Counters.DialFailed.Add(1)
 		slog.Debug("SOCKSv5: Connect: Unable to dial destination",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host.String()),
 			slog.String("err", err.Error()))
@@ -246,7 +349,7 @@ func (svc *service) cmdConnect(conn net.Conn, peer *nxproxy.Peer, host *Addr) {
 	if err := reply(conn, ReplyOk, host); err != nil {
 		slog.Debug("SOCKSv5: Connect: Ack failed",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host.String()),
 			slog.String("err", err.Error()))
@@ -255,16 +358,68 @@ func (svc *service) cmdConnect(conn net.Conn, peer *nxproxy.Peer, host *Addr) {
 
 	slog.Debug("SOCKSv5: Connect",
 		slog.String("client_ip", clientIP.String()),
-		slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+		slog.String("proxy_addr", proxyAddr),
 		slog.String("peer", peer.DisplayName()),
 		slog.String("host", host.String()))
 
-	if err := nxproxy.ProxyBridge(connCtl, conn, dstConn); err != nil {
-		slog.Debug("SOCKSv5: Connect: Broken pipe",
+	var onClientHello = func(hello *nxproxy.ClientHello) error {
+
+		if opts := svc.Options(); hello.ServerName != "" && opts.DomainBlocked(hello.ServerName) {
+			svc.DenyDest(peer, clientIP.String(), hello.ServerName, nxproxy.DenyBlockedSni)
+			slog.Warn("SOCKSv5: Connect: TLS server name blocked",
+				slog.String("client_ip", clientIP.String()),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("host", host.String()),
+				slog.String("sni", hello.ServerName))
+			return nxproxy.ErrDomainBlocked
+		}
+
+		if !peer.TlsClientAllowed(hello) {
+			svc.DenyDest(peer, clientIP.String(), host.String(), nxproxy.DenyTlsFingerprint)
+			slog.Warn("SOCKSv5: Connect: TLS client fingerprint not allowed",
+				slog.String("client_ip", clientIP.String()),
+				slog.String("proxy_addr", proxyAddr),
+				slog.String("peer", peer.DisplayName()),
+				slog.String("host", host.String()),
+				slog.String("ja3", hello.JA3()),
+				slog.String("ja4", hello.JA4()))
+			return nxproxy.ErrTlsFingerprintDenied
+		}
+
+		slog.Debug("SOCKSv5: Connect: TLS",
 			slog.String("client_ip", clientIP.String()),
-			slog.String("proxy_addr", svc.SlotOptions.BindAddr),
+			slog.String("proxy_addr", proxyAddr),
 			slog.String("peer", peer.DisplayName()),
 			slog.String("host", host.String()),
+			slog.String("sni", hello.ServerName),
+			slog.String("ja3", hello.JA3()),
+			slog.String("ja4", hello.JA4()))
+
+		return nil
+	}
+
+	//	intercepted tunnels aren't bridged as is, so there's no splice result for them
+	var result nxproxy.BridgeResult
+
+	if mitm, ok := svc.MitmConfig(); ok {
+		mitm.OnHello = onClientHello
+		mitm.OnUrlBlocked = func(url string) {
+			svc.DenyDest(peer, clientIP.String(), url, nxproxy.DenyBlockedUrl)
+		}
+		err = nxproxy.InterceptTls(connCtl, conn, nil, dstConn, host.String(), mitm)
+	} else if opts.InspectTls() || len(peer.TlsFingerprints) > 0 {
+		result, err = nxproxy.ProxyBridge(connCtl, nxproxy.InspectClientHello(conn, nil, onClientHello), dstConn)
+	} else {
+		result, err = nxproxy.ProxyBridge(connCtl, conn, dstConn)
+	}
+
+	logger := connCtl.Logger().With(result.LogAttrs()...)
+
+	if err != nil {
+		logger.Debug("SOCKSv5: Connect: Broken pipe",
 			slog.String("err", err.Error()))
+	} else {
+		logger.Debug("SOCKSv5: Connect: Closed")
 	}
 }
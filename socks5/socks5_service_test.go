@@ -0,0 +1,157 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+// Listener that hands out in-memory connections made by dial
+type pipeListener struct {
+	connCh chan net.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener(network, addr string) (net.Listener, error) {
+	return &pipeListener{connCh: make(chan net.Conn), done: make(chan struct{})}, nil
+}
+
+func (ln *pipeListener) dial() (net.Conn, error) {
+
+	serverConn, clientConn := net.Pipe()
+
+	select {
+	case ln.connCh <- pipeConn{Conn: serverConn}:
+		return clientConn, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.connCh:
+		return conn, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *pipeListener) Close() error {
+	ln.once.Do(func() { close(ln.done) })
+	return nil
+}
+
+func (ln *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}
+}
+
+// Pipe connection with a tcp client address, which the service expects
+type pipeConn struct {
+	net.Conn
+}
+
+func (conn pipeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func TestService_InjectedNetwork(t *testing.T) {
+
+	var listener *pipeListener
+	var dialed []string
+	var dialMtx sync.Mutex
+
+	env := nxproxy.SlotEnv{
+		Listen: func(network, addr string) (net.Listener, error) {
+			ln, err := newPipeListener(network, addr)
+			listener = ln.(*pipeListener)
+			return ln, err
+		},
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+
+			dialMtx.Lock()
+			dialed = append(dialed, address)
+			dialMtx.Unlock()
+
+			//	echoes everything back
+			serverConn, upstreamConn := net.Pipe()
+			go func() {
+				defer serverConn.Close()
+				io.Copy(serverConn, serverConn)
+			}()
+
+			return upstreamConn, nil
+		},
+	}
+
+	slot, err := NewService(nxproxy.SlotOptions{
+		Proto:       nxproxy.ProxyProtoSocks,
+		BindAddr:    "127.0.0.1:1080",
+		AuthMethods: []nxproxy.SlotAuth{nxproxy.SlotAuthNone},
+	}, env)
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	defer slot.Close()
+
+	slot.SetPeers([]nxproxy.PeerOptions{{ID: uuid.New()}})
+
+	conn, err := listener.dial()
+	if err != nil {
+		t.Fatalf("dial service: %v", err)
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatalf("write greeting: %v", err)
+	}
+
+	if _, err := nxproxy.ReadN(conn, 2); err != nil {
+		t.Fatalf("read method: %v", err)
+	}
+
+	//	the name is never resolved, so it doesn't have to exist
+	host := "upstream.invalid"
+	request := append(append([]byte{0x05, byte(CmdConnect), 0x00, AddrDomainName, byte(len(host))}, host...), 0x01, 0xbb)
+
+	if _, err := conn.Write(request); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	resp, err := nxproxy.ReadN(conn, 3)
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	} else if resp[1] != byte(ReplyOk) {
+		t.Fatalf("unexpected reply: %d", resp[1])
+	}
+
+	if _, err := readAddr(conn); err != nil {
+		t.Fatalf("read bound addr: %v", err)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write data: %v", err)
+	}
+
+	if echo, err := nxproxy.ReadN(conn, 4); err != nil || string(echo) != "ping" {
+		t.Errorf("unexpected tunnel data: %q %v", echo, err)
+	}
+
+	dialMtx.Lock()
+	defer dialMtx.Unlock()
+
+	if len(dialed) != 1 || dialed[0] != "upstream.invalid:443" {
+		t.Errorf("unexpected dials: %v", dialed)
+	}
+}
@@ -0,0 +1,52 @@
+package nxproxy
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const tcpEstablished = 1
+const tcpClose = 7
+
+// Reports whether the kernel has torn a TCP connection down, which happens after a reset or a timeout.
+// The second value is false when the state can't be determined
+func tcpConnTornDown(conn net.Conn) (bool, bool) {
+	state, known := tcpConnState(conn)
+	return state == tcpClose, known
+}
+
+// Reports whether a TCP connection is still established, meaning that neither side has closed or reset it.
+// The second value is false when the state can't be determined
+func tcpConnEstablished(conn net.Conn) (bool, bool) {
+	state, known := tcpConnState(conn)
+	return state == tcpEstablished, known
+}
+
+// Returns tcpi_state of a connection
+func tcpConnState(conn net.Conn) (uint8, bool) {
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, false
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	//	tcpi_state is the first field of struct tcp_info, so there's no need to read the whole thing
+	var state uint8
+	var sockErr syscall.Errno
+
+	if err := rawConn.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(state))
+		_, _, sockErr = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&state)), uintptr(unsafe.Pointer(&size)), 0)
+	}); err != nil || sockErr != 0 {
+		return 0, false
+	}
+
+	return state, true
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package nxproxy
+
+import "net"
+
+func tcpConnTornDown(conn net.Conn) (bool, bool) {
+	return false, false
+}
+
+func tcpConnEstablished(conn net.Conn) (bool, bool) {
+	return false, false
+}
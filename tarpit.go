@@ -0,0 +1,51 @@
+package nxproxy
+
+import (
+	"context"
+	"time"
+)
+
+// Tarpit delays longer than this are rejected when setting slot options
+const maxTarpitDelay = 5 * time.Minute
+
+// Limits the number of clients a slot may hold in the tarpit at once;
+// clients over the limit get rejected right away
+const maxTarpitConnections = 1024
+
+// Returns the delay that rejected clients are held for
+func (opts *SlotOptions) TarpitDelay() time.Duration {
+	return time.Duration(opts.TarpitMs) * time.Millisecond
+}
+
+// Holds a rejected client before its connection gets closed, which slows down the retries.
+// Returns right away if the tarpit is disabled or full, otherwise once the delay passes or ctx is done
+func (slot *Slot) Tarpit(ctx context.Context) {
+
+	opts := slot.Options()
+
+	delay := opts.TarpitDelay()
+	if delay <= 0 {
+		return
+	}
+
+	if slot.tarpitted.Add(1) > maxTarpitConnections {
+		slot.tarpitted.Add(-1)
+		return
+	}
+
+	defer slot.tarpitted.Add(-1)
+
+	slot.Counters.Tarpitted.Add(1)
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
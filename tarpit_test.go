@@ -0,0 +1,50 @@
+package nxproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestSlot_Tarpit(t *testing.T) {
+
+	var slot nxproxy.Slot
+
+	started := time.Now()
+	slot.Tarpit(context.Background())
+
+	if elapsed := time.Since(started); elapsed > 20*time.Millisecond {
+		t.Fatalf("disabled tarpit held the client for %v", elapsed)
+	}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{TarpitMs: 100}); err != nil {
+		t.Fatalf("set options: %v", err)
+	}
+
+	started = time.Now()
+	slot.Tarpit(context.Background())
+
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Fatalf("tarpit released the client after %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	started = time.Now()
+	slot.Tarpit(ctx)
+
+	if elapsed := time.Since(started); elapsed > 80*time.Millisecond {
+		t.Fatalf("tarpit ignored context cancellation, held for %v", elapsed)
+	}
+
+	if stats := slot.TakeStats(); stats.Tarpitted != 2 {
+		t.Errorf("expected 2 tarpitted clients, got %d", stats.Tarpitted)
+	}
+
+	if err := slot.SetOptions(nxproxy.SlotOptions{TarpitMs: uint(time.Hour.Milliseconds())}); err == nil {
+		t.Errorf("excessive tarpit delay accepted")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Serves a minimal CRUD API to manage services and peers. Keys with the status scope may only read
+func NewAdminHandler(store *Store, streams *LogStreams, keys []nxproxy.APIKey) http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/v1/nodes", func(wrt http.ResponseWriter, req *http.Request) {
+		entries, err := store.Nodes(req.Context())
+		writeAdminResponse(wrt, entries, err)
+	})
+
+	//	the token is only ever returned by this call and can't be retrieved later
+	mux.HandleFunc("POST /admin/v1/nodes", func(wrt http.ResponseWriter, req *http.Request) {
+
+		entry, ok := readAdminBody[NodeRecord](wrt, req)
+		if !ok {
+			return
+		}
+
+		token, err := nxproxy.NewServerToken()
+		if err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		if err := store.PutNode(req.Context(), entry.Name, token); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		writeAdminResponse(wrt, NewNodeResponse{
+			NodeRecord: NodeRecord{ID: token.ID, Name: entry.Name},
+			Token:      token.String(),
+		}, nil)
+	})
+
+	mux.HandleFunc("DELETE /admin/v1/nodes/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			writeAdminResponse[any](wrt, nil, store.DeleteNode(req.Context(), id))
+		}
+	})
+
+	//	asks a node to stream its logs; filters and duration are optional
+	mux.HandleFunc("POST /admin/v1/nodes/{id}/logs", func(wrt http.ResponseWriter, req *http.Request) {
+
+		nodeID, ok := readPathID(wrt, req)
+		if !ok {
+			return
+		}
+
+		entry, ok := readAdminBody[model.LogStream](wrt, req)
+		if !ok {
+			return
+		}
+
+		entry.ID = uuid.New()
+
+		if entry.Duration <= 0 {
+			entry.Duration = 60
+		}
+
+		streams.Request(nodeID, *entry)
+
+		writeAdminResponse(wrt, entry, nil)
+	})
+
+	mux.HandleFunc("GET /admin/v1/services", func(wrt http.ResponseWriter, req *http.Request) {
+		entries, err := store.Services(req.Context())
+		writeAdminResponse(wrt, entries, err)
+	})
+
+	mux.HandleFunc("POST /admin/v1/services", func(wrt http.ResponseWriter, req *http.Request) {
+
+		entry, ok := readAdminBody[ServiceRecord](wrt, req)
+		if !ok {
+			return
+		}
+
+		if entry.ID == uuid.Nil {
+			entry.ID = uuid.New()
+		}
+
+		if err := validateService(entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		if err := checkServiceNode(req, store, entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		writeAdminResponse(wrt, entry, store.PutService(req.Context(), *entry))
+	})
+
+	mux.HandleFunc("PUT /admin/v1/services/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+
+		id, ok := readPathID(wrt, req)
+		if !ok {
+			return
+		}
+
+		entry, ok := readAdminBody[ServiceRecord](wrt, req)
+		if !ok {
+			return
+		}
+
+		entry.ID = id
+
+		if err := validateService(entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		if _, err := store.Service(req.Context(), id); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		if err := checkServiceNode(req, store, entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		writeAdminResponse(wrt, entry, store.PutService(req.Context(), *entry))
+	})
+
+	mux.HandleFunc("DELETE /admin/v1/services/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			writeAdminResponse[any](wrt, nil, store.DeleteService(req.Context(), id))
+		}
+	})
+
+	mux.HandleFunc("GET /admin/v1/services/{id}/peers", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			entries, err := store.Peers(req.Context(), id)
+			writeAdminResponse(wrt, entries, err)
+		}
+	})
+
+	mux.HandleFunc("POST /admin/v1/services/{id}/peers", func(wrt http.ResponseWriter, req *http.Request) {
+
+		serviceID, ok := readPathID(wrt, req)
+		if !ok {
+			return
+		}
+
+		entry, ok := readAdminBody[PeerRecord](wrt, req)
+		if !ok {
+			return
+		}
+
+		entry.ServiceID = serviceID
+
+		if entry.ID == uuid.Nil {
+			entry.ID = uuid.New()
+		}
+
+		if err := validatePeer(req.Context(), store, entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		if _, err := store.Service(req.Context(), serviceID); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		writeAdminResponse(wrt, entry, store.PutPeer(req.Context(), *entry))
+	})
+
+	mux.HandleFunc("GET /admin/v1/peers/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			entry, err := store.Peer(req.Context(), id)
+			writeAdminResponse(wrt, entry, err)
+		}
+	})
+
+	mux.HandleFunc("PUT /admin/v1/peers/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+
+		id, ok := readPathID(wrt, req)
+		if !ok {
+			return
+		}
+
+		entry, ok := readAdminBody[PeerRecord](wrt, req)
+		if !ok {
+			return
+		}
+
+		current, err := store.Peer(req.Context(), id)
+		if err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		entry.ID = id
+
+		//	peers can't be moved between services implicitly
+		if entry.ServiceID == uuid.Nil {
+			entry.ServiceID = current.ServiceID
+		}
+
+		if err := validatePeer(req.Context(), store, entry); err != nil {
+			writeAdminResponse[any](wrt, nil, err)
+			return
+		}
+
+		writeAdminResponse(wrt, entry, store.PutPeer(req.Context(), *entry))
+	})
+
+	mux.HandleFunc("DELETE /admin/v1/peers/{id}", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			writeAdminResponse[any](wrt, nil, store.DeletePeer(req.Context(), id))
+		}
+	})
+
+	mux.HandleFunc("GET /admin/v1/peers/{id}/usage", func(wrt http.ResponseWriter, req *http.Request) {
+		if id, ok := readPathID(wrt, req); ok {
+			usage, err := store.PeerUsage(req.Context(), id)
+			writeAdminResponse(wrt, usage, err)
+		}
+	})
+
+	return http.HandlerFunc(func(wrt http.ResponseWriter, req *http.Request) {
+
+		scope, ok := nxproxy.AuthorizeAPIRequest(req, keys)
+		if !ok {
+			writeAdminResponse[any](wrt, nil, &rest.APIError{
+				Message: "unauthorized",
+				Status:  http.StatusUnauthorized,
+			})
+			return
+		}
+
+		if !scope.Allows(req.Method) {
+			writeAdminResponse[any](wrt, nil, &rest.APIError{
+				Message: "token scope doesn't permit this request",
+				Status:  http.StatusForbidden,
+			})
+			return
+		}
+
+		mux.ServeHTTP(wrt, req)
+	})
+}
+
+type NewNodeResponse struct {
+	NodeRecord
+	Token string `json:"token"`
+}
+
+func validateService(entry *ServiceRecord) error {
+
+	opts := nxproxy.SlotOptions{Proto: entry.Proto, BindAddr: entry.BindAddr}
+	if err := opts.Validate(); err != nil {
+		return &rest.APIError{Message: fmt.Sprintf("invalid service: %v", err)}
+	}
+
+	return nil
+}
+
+func checkServiceNode(req *http.Request, store *Store, entry *ServiceRecord) error {
+
+	if entry.NodeID == nil {
+		return nil
+	}
+
+	nodes, err := store.Nodes(req.Context())
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if node.ID == *entry.NodeID {
+			return nil
+		}
+	}
+
+	return &rest.APIError{Message: fmt.Sprintf("node not found: %v", *entry.NodeID)}
+}
+
+// Checks a peer along with the other peers of its service, the same way nodes would
+func validatePeer(ctx context.Context, store *Store, entry *PeerRecord) error {
+
+	if entry.PasswordAuth == nil || entry.PasswordAuth.User == "" {
+		return &rest.APIError{Message: "password auth must be set"}
+	}
+
+	peers, err := store.Peers(ctx, entry.ServiceID)
+	if err != nil {
+		return err
+	}
+
+	//	the entry goes last, so that conflicts with existing peers are attributed to it
+	var entries []nxproxy.PeerOptions
+	for _, peer := range peers {
+		if peer.ID != entry.ID {
+			entries = append(entries, peer.PeerOptions)
+		}
+	}
+
+	entries = append(entries, entry.PeerOptions)
+
+	for _, issue := range nxproxy.ValidatePeers(entries) {
+		if *issue.PeerID == entry.ID {
+			return &rest.APIError{Message: fmt.Sprintf("invalid peer: %s", issue.Reason)}
+		}
+	}
+
+	return nil
+}
+
+func readPathID(wrt http.ResponseWriter, req *http.Request) (uuid.UUID, bool) {
+
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		writeAdminResponse[any](wrt, nil, &rest.APIError{
+			Message: fmt.Sprintf("invalid id: %v", err),
+			Status:  http.StatusBadRequest,
+		})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func readAdminBody[T any](wrt http.ResponseWriter, req *http.Request) (*T, bool) {
+
+	var body T
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeAdminResponse[any](wrt, nil, &rest.APIError{
+			Message: fmt.Sprintf("decoder: %v", err),
+			Status:  http.StatusBadRequest,
+		})
+		return nil, false
+	}
+
+	return &body, true
+}
+
+func writeAdminResponse[T any](wrt http.ResponseWriter, val T, err error) {
+
+	wrt.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+
+		apierr, ok := err.(*rest.APIError)
+
+		switch {
+		case ok:
+			break
+		case err == ErrNotFound:
+			apierr = &rest.APIError{Message: err.Error(), Status: http.StatusNotFound}
+		default:
+			slog.Error("Admin API",
+				slog.String("err", err.Error()))
+			apierr = &rest.APIError{Message: err.Error(), Status: http.StatusInternalServerError}
+		}
+
+		wrt.WriteHeader(apierr.StatusCode())
+		(&rest.Response[T]{Error: apierr}).Write(wrt)
+		return
+	}
+
+	(&rest.Response[T]{Data: &val}).Write(wrt)
+}
@@ -9,9 +9,18 @@ import (
 )
 
 type Config struct {
-	location   string
-	ListenAddr string      `yaml:"listen_addr"`
-	Proxy      ProxyConfig `yaml:"proxy"`
+	location    string
+	ListenAddr  string       `yaml:"listen_addr"`
+	DbPath      string       `yaml:"db_path"`
+	AdminToken  string       `yaml:"admin_token"`
+	StatusToken string       `yaml:"status_token"`
+	Nodes       []NodeConfig `yaml:"nodes"`
+	Proxy       ProxyConfig  `yaml:"proxy"`
+}
+
+type NodeConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
 }
 
 type ProxyConfig struct {
@@ -19,7 +28,10 @@ type ProxyConfig struct {
 	Dns      string          `yaml:"dns"`
 }
 
+// Services listed in the config are only used to seed an empty store
 type ServiceConfig struct {
+	ID       uuid.UUID    `yaml:"id"`
+	NodeID   *uuid.UUID   `yaml:"node_id"`
 	BindAddr string       `yaml:"bind_addr"`
 	Proto    string       `yaml:"proto"`
 	Peers    []PeerConfig `yaml:"peers"`
@@ -33,7 +45,10 @@ type PeerConfig struct {
 	FramedIP       string    `yaml:"framed_ip"`
 	RxRate         uint32    `yaml:"rx_rate"`
 	TxRate         uint32    `yaml:"tx_rate"`
+	MinRxRate      uint32    `yaml:"min_rx_rate"`
+	MinTxRate      uint32    `yaml:"min_tx_rate"`
 	Disabled       bool      `yaml:"disabled"`
+	Paused         bool      `yaml:"paused"`
 }
 
 func FindConfigLocation() string {
@@ -43,6 +58,8 @@ func FindConfigLocation() string {
 		"./nx-auth.yml",
 		"./testing/cmd/nx-auth/nx-auth.yaml",
 		"./testing/cmd/nx-auth/nx-auth.yml",
+		"./cmd/nx-auth/nx-auth.yaml",
+		"./cmd/nx-auth/nx-auth.yml",
 	}
 
 	var findFile = func(name string) bool {
@@ -85,5 +102,9 @@ func LoadConfig(loc string) (*Config, error) {
 
 	cfg.location = loc
 
+	if cfg.DbPath == "" {
+		cfg.DbPath = "./nx-auth.db"
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/maddsua/nx-proxy/rest/model"
+)
+
+// Log streams requested by admins; nodes pick them up with the next config pull
+type LogStreams struct {
+	entries map[uuid.UUID]model.LogStream
+	mtx     sync.Mutex
+}
+
+func (streams *LogStreams) Request(nodeID uuid.UUID, stream model.LogStream) {
+
+	streams.mtx.Lock()
+	defer streams.mtx.Unlock()
+
+	if streams.entries == nil {
+		streams.entries = map[uuid.UUID]model.LogStream{}
+	}
+
+	streams.entries[nodeID] = stream
+}
+
+func (streams *LogStreams) Get(nodeID uuid.UUID) *model.LogStream {
+
+	streams.mtx.Lock()
+	defer streams.mtx.Unlock()
+
+	if stream, has := streams.entries[nodeID]; has {
+		return &stream
+	}
+
+	return nil
+}
+
+func (streams *LogStreams) Finish(nodeID uuid.UUID, streamID uuid.UUID) {
+
+	streams.mtx.Lock()
+	defer streams.mtx.Unlock()
+
+	if stream, has := streams.entries[nodeID]; has && stream.ID == streamID {
+		delete(streams.entries, nodeID)
+	}
+}
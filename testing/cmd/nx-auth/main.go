@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/google/uuid"
 	nxproxy "github.com/maddsua/nx-proxy"
 	"github.com/maddsua/nx-proxy/rest"
 	"github.com/maddsua/nx-proxy/rest/model"
@@ -26,6 +28,50 @@ func main() {
 		os.Exit(1)
 	}
 
+	store, err := OpenStore(cfg.DbPath)
+	if err != nil {
+		slog.Error("Open store",
+			slog.String("path", cfg.DbPath),
+			slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	defer store.Close()
+
+	for _, entry := range cfg.Nodes {
+
+		token, err := nxproxy.ParseServerToken(entry.Token)
+		if err != nil {
+			slog.Error("Parse node token",
+				slog.String("node", entry.Name),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+
+		if err := store.PutNode(context.Background(), entry.Name, token); err != nil {
+			slog.Error("Register node",
+				slog.String("node", entry.Name),
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if empty, err := store.Empty(context.Background()); err != nil {
+		slog.Error("Check store",
+			slog.String("err", err.Error()))
+		os.Exit(1)
+	} else if empty {
+		if err := SeedStore(context.Background(), store, cfg.Proxy.Services); err != nil {
+			slog.Error("Seed store",
+				slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+		slog.Info("Store seeded from config",
+			slog.Int("services", len(cfg.Proxy.Services)))
+	}
+
+	var streams LogStreams
+
 	handler := rest.ProcedureHandler{
 
 		HandleFullConfig: func(ctx context.Context, token *nxproxy.ServerToken) (*model.FullConfig, error) {
@@ -34,51 +80,56 @@ func main() {
 				return nil, fmt.Errorf("unauthorized")
 			}
 
+			node, err := store.VerifyNode(ctx, token)
+			if err != nil {
+				return nil, nodeAuthError(token, err)
+			}
+
 			slog.Info("Sending config",
-				slog.String("token_id", token.ID.String()))
+				slog.String("node_id", node.ID.String()),
+				slog.String("node", node.Name))
 
-			if val, err := LoadConfig(cfg.location); err != nil {
-				slog.Error("Reload config",
-					slog.String("loc", cfg.location),
-					slog.String("err", err.Error()))
-			} else {
-				cfg.Proxy = val.Proxy
-			}
-
-			var services []nxproxy.ServiceOptions
-			for _, entry := range cfg.Proxy.Services {
-
-				var peers []nxproxy.PeerOptions
-
-				for _, entry := range entry.Peers {
-					peers = append(peers, nxproxy.PeerOptions{
-						ID: entry.ID,
-						PasswordAuth: &nxproxy.UserPassword{
-							User:     entry.UserName,
-							Password: entry.Password,
-						},
-						MaxConnections: entry.MaxConnections,
-						FramedIP:       entry.FramedIP,
-						Bandwidth: nxproxy.PeerBandwidth{
-							Rx: entry.RxRate,
-							Tx: entry.TxRate,
-						},
-						Disabled: entry.Disabled,
-					})
+			services, err := store.NodeServices(ctx, node.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			var entries []nxproxy.ServiceOptions
+			for _, svc := range services {
+
+				peers, err := store.Peers(ctx, svc.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				var peerOpts []nxproxy.PeerOptions
+				for _, peer := range peers {
+					peerOpts = append(peerOpts, peer.PeerOptions)
 				}
 
-				services = append(services, nxproxy.ServiceOptions{
-					Peers: peers,
+				entries = append(entries, nxproxy.ServiceOptions{
+					Peers: peerOpts,
 					SlotOptions: nxproxy.SlotOptions{
-						Proto:    nxproxy.ProxyProto(entry.Proto),
-						BindAddr: entry.BindAddr,
+						ID:       svc.ID.String(),
+						Proto:    svc.Proto,
+						BindAddr: svc.BindAddr,
 					},
 				})
 			}
 
+			for _, issue := range nxproxy.ValidateServices(entries) {
+				slog.Warn("Config entry will be rejected by the node",
+					slog.String("node", node.Name),
+					slog.String("slot", issue.Slot),
+					slog.String("peer", issue.Peer),
+					slog.String("reason", issue.Reason))
+			}
+
 			return &model.FullConfig{
-				Services: services,
-				DNS:      cfg.Proxy.Dns,
+				Services:  entries,
+				DNS:       cfg.Proxy.Dns,
+				LogStream: streams.Get(node.ID),
+				Checksum:  nxproxy.ConfigChecksum(entries),
 			}, nil
 		},
 
@@ -88,18 +139,184 @@ func main() {
 				return fmt.Errorf("unauthorized")
 			}
 
-			data, _ := json.MarshalIndent(status, "", "  ")
-			slog.Info("Dumping status",
-				slog.String("token_id", token.ID.String()))
-			fmt.Print(string(data))
+			node, err := store.VerifyNode(ctx, token)
+			if err != nil {
+				return nodeAuthError(token, err)
+			}
+
+			if err := store.AddDeltas(ctx, node.ID, status.Deltas, status.DeltaWindow); err != nil {
+				slog.Error("Store deltas",
+					slog.String("node_id", node.ID.String()),
+					slog.String("err", err.Error()))
+				return err
+			}
+
+			slog.Info("Status received",
+				slog.String("node_id", node.ID.String()),
+				slog.String("node", node.Name),
+				slog.Int("deltas", len(status.Deltas)),
+				slog.Int("slots", len(status.Slots)),
+				slog.Int("addrs", len(status.Addrs)),
+				slog.Int64("push_latency", status.Service.PushLatency))
+
+			if status.Service.PushesSkipped > 0 {
+				slog.Warn("Node skipped status pushes",
+					slog.String("node", node.Name),
+					slog.Uint64("skipped", status.Service.PushesSkipped),
+					slog.Int64("push_latency", status.Service.PushLatency))
+			}
+
+			if status.ExpectedChecksum != "" && status.ConfigChecksum != status.ExpectedChecksum {
+				slog.Warn("Node config drift",
+					slog.String("node", node.Name),
+					slog.String("checksum", status.ConfigChecksum),
+					slog.String("expected", status.ExpectedChecksum))
+			}
+
+			//	nodes running off clocks too far away from the backend one fail time-based token checks
+			if skew, ok := status.Service.ClockSkew(time.Now()); ok && (skew > model.MaxClockSkew || skew < -model.MaxClockSkew) {
+				slog.Warn("Node clock skew",
+					slog.String("node", node.Name),
+					slog.String("skew", skew.Round(time.Second).String()),
+					slog.String("timezone", status.Service.Timezone))
+			}
+
+			for _, entry := range status.Blocked {
+				slog.Warn("Blocked destination",
+					slog.String("node", node.Name),
+					slog.String("peer_id", entry.PeerID.String()),
+					slog.String("user", entry.User),
+					slog.String("client_ip", entry.ClientIP),
+					slog.String("dest", entry.Dest),
+					slog.String("reason", string(entry.Reason)),
+					slog.Uint64("attempts", entry.Attempts))
+			}
+
+			for _, entry := range status.Leaks {
+				slog.Warn("Peer credentials possibly leaked",
+					slog.String("node", node.Name),
+					slog.String("peer_id", entry.PeerID.String()),
+					slog.String("user", entry.User),
+					slog.String("reason", string(entry.Reason)),
+					slog.String("client_ips", strings.Join(entry.ClientIPs, ",")),
+					slog.String("countries", strings.Join(entry.Countries, ",")),
+					slog.Bool("disabled", entry.Disabled))
+			}
+
+			for _, entry := range status.Disables {
+
+				slog.Warn("Peer disabled by node",
+					slog.String("node", node.Name),
+					slog.String("peer_id", entry.PeerID.String()),
+					slog.String("slot", entry.Slot.Handle()),
+					slog.String("reason", string(entry.Reason)))
+
+				if err := store.AckPeerDisable(ctx, entry.PeerID, entry.Time); err != nil && err != ErrNotFound {
+					slog.Error("Acknowledge peer disable",
+						slog.String("peer_id", entry.PeerID.String()),
+						slog.String("err", err.Error()))
+				}
+			}
+
+			for _, entry := range status.FramedIPs {
+				slog.Warn("Peer framed IP unavailable",
+					slog.String("node", node.Name),
+					slog.String("peer_id", entry.PeerID.String()),
+					slog.String("proxy_addr", entry.ProxyAddr),
+					slog.String("ip", entry.IP),
+					slog.String("reason", string(entry.Reason)),
+					slog.String("err", entry.Error),
+					slog.Time("since", entry.Since),
+					slog.Bool("fallback", entry.Fallback))
+			}
+
+			if report := status.Config; report != nil {
+
+				slog.Info("Config applied",
+					slog.String("node", node.Name),
+					slog.Int("slots", report.Slots),
+					slog.Int("peers", report.Peers),
+					slog.Int("rejected", len(report.Rejected)),
+					slog.Int("warnings", len(report.Warnings)))
+
+				for _, entry := range report.Rejected {
+					slog.Warn("Config entry rejected",
+						slog.String("node", node.Name),
+						slog.String("slot", entry.Slot),
+						slog.String("peer", entry.Peer),
+						slog.String("reason", entry.Reason))
+				}
+
+				for _, entry := range report.Warnings {
+					slog.Warn("Config entry applied partially",
+						slog.String("node", node.Name),
+						slog.String("slot", entry.Slot),
+						slog.String("peer", entry.Peer),
+						slog.String("reason", entry.Reason))
+				}
+
+				if !report.Changes.Empty() {
+					slog.Info("Config changes",
+						append([]any{slog.String("node", node.Name)}, report.Changes.LogAttrs()...)...)
+				}
+			}
+
+			return nil
+		},
+
+		HandleLogs: func(ctx context.Context, token *nxproxy.ServerToken, batch *model.LogBatch) error {
+
+			if token == nil {
+				return fmt.Errorf("unauthorized")
+			}
+
+			node, err := store.VerifyNode(ctx, token)
+			if err != nil {
+				return nodeAuthError(token, err)
+			}
+
+			for _, entry := range batch.Entries {
+
+				attrs := []any{
+					slog.String("node", node.Name),
+					slog.String("level", entry.Level),
+					slog.Time("time", entry.Time),
+				}
+
+				for key, val := range entry.Attrs {
+					attrs = append(attrs, slog.String(key, val))
+				}
+
+				slog.Info("Node log: "+entry.Message, attrs...)
+			}
+
+			if batch.Dropped > 0 {
+				slog.Warn("Node log entries dropped",
+					slog.String("node", node.Name),
+					slog.Int("count", batch.Dropped))
+			}
+
+			if batch.Done {
+				streams.Finish(node.ID, batch.StreamID)
+				slog.Info("Node log stream finished",
+					slog.String("node", node.Name),
+					slog.String("stream_id", batch.StreamID.String()))
+			}
 
 			return nil
 		},
 	}
 
+	mux := http.NewServeMux()
+	mux.Handle("/nxproxy/", rest.NewHandler(handler))
+	mux.Handle("/admin/", NewAdminHandler(store, &streams, []nxproxy.APIKey{
+		{Token: cfg.AdminToken, Scope: nxproxy.APIScopeFull},
+		{Token: cfg.StatusToken, Scope: nxproxy.APIScopeStatus},
+	}))
+
 	srv := http.Server{
 		Addr:    cfg.ListenAddr,
-		Handler: rest.NewHandler(handler),
+		Handler: mux,
 	}
 
 	errCh := make(chan error, 1)
@@ -124,3 +341,63 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func SeedStore(ctx context.Context, store *Store, services []ServiceConfig) error {
+
+	for _, svc := range services {
+
+		if svc.ID == uuid.Nil {
+			svc.ID = uuid.New()
+		}
+
+		err := store.PutService(ctx, ServiceRecord{
+			ID:       svc.ID,
+			NodeID:   svc.NodeID,
+			Proto:    nxproxy.ProxyProto(svc.Proto),
+			BindAddr: svc.BindAddr,
+		})
+		if err != nil {
+			return fmt.Errorf("put service %s: %v", svc.BindAddr, err)
+		}
+
+		for _, entry := range svc.Peers {
+
+			err := store.PutPeer(ctx, PeerRecord{
+				ServiceID: svc.ID,
+				PeerOptions: nxproxy.PeerOptions{
+					ID: entry.ID,
+					PasswordAuth: &nxproxy.UserPassword{
+						User:     entry.UserName,
+						Password: entry.Password,
+					},
+					MaxConnections: entry.MaxConnections,
+					FramedIP:       entry.FramedIP,
+					Bandwidth: nxproxy.PeerBandwidth{
+						Rx:    entry.RxRate,
+						Tx:    entry.TxRate,
+						MinRx: entry.MinRxRate,
+						MinTx: entry.MinTxRate,
+					},
+					Disabled: entry.Disabled,
+					Paused:   entry.Paused,
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("put peer %s: %v", entry.UserName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func nodeAuthError(token *nxproxy.ServerToken, err error) error {
+
+	if err == ErrNotFound {
+		slog.Warn("Unknown node token",
+			slog.String("token_id", token.ID.String()))
+		return &rest.APIError{Message: "node token invalid", Status: http.StatusForbidden}
+	}
+
+	return err
+}
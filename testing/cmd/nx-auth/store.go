@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+	"github.com/maddsua/nx-proxy/rest/model"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+type ServiceRecord struct {
+	ID       uuid.UUID          `json:"id"`
+	Proto    nxproxy.ProxyProto `json:"proto"`
+	BindAddr string             `json:"bind_addr"`
+
+	//	node that runs the service; services without one are served by every node
+	NodeID *uuid.UUID `json:"node_id"`
+}
+
+type NodeRecord struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+type NodeUsage struct {
+	NodeID *uuid.UUID `json:"node_id"`
+	Rx     uint64     `json:"rx"`
+	Tx     uint64     `json:"tx"`
+}
+
+type PeerRecord struct {
+	nxproxy.PeerOptions
+	ServiceID uuid.UUID `json:"service_id"`
+}
+
+type PeerUsage struct {
+	ID    uuid.UUID   `json:"id"`
+	Rx    uint64      `json:"rx"`
+	Tx    uint64      `json:"tx"`
+	Nodes []NodeUsage `json:"nodes"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+
+	//	sqlite doesn't do concurrent writes anyway
+	db.SetMaxOpenConns(1)
+
+	store := Store{db: db}
+
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %v", err)
+	}
+
+	return &store, nil
+}
+
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+func (store *Store) migrate() error {
+
+	//	each step is applied once, in order; user_version tracks the last applied one.
+	//	databases created before versioning was introduced report version 0 while already having the first step's schema,
+	//	so that step has to be safe to run on them
+	steps := []string{
+		`
+		create table if not exists services (
+			id text primary key,
+			proto text not null,
+			bind_addr text not null unique
+		);
+
+		create table if not exists peers (
+			id text primary key,
+			service_id text not null references services(id) on delete cascade,
+			username text not null,
+			password text not null,
+			max_connections integer not null default 0,
+			framed_ip text not null default '',
+			rx_rate integer not null default 0,
+			tx_rate integer not null default 0,
+			min_rx_rate integer not null default 0,
+			min_tx_rate integer not null default 0,
+			disabled integer not null default 0,
+			unique (service_id, username)
+		);
+
+		create table if not exists peer_deltas (
+			peer_id text not null,
+			rx integer not null,
+			tx integer not null,
+			recorded_at integer not null
+		);
+
+		create index if not exists peer_deltas_peer_id on peer_deltas(peer_id);
+		`,
+		`
+		create table nodes (
+			id text primary key,
+			name text not null default '',
+			secret_key blob not null
+		);
+
+		alter table services add column node_id text references nodes(id) on delete set null;
+		alter table peer_deltas add column node_id text;
+		`,
+		`
+		alter table peers add column paused integer not null default 0;
+		`,
+		`
+		alter table peer_deltas add column window_start integer;
+		`,
+		`
+		alter table peer_deltas add column slot text;
+		`,
+		`
+		alter table peers add column disable_ack integer;
+		`,
+		`
+		create table services_next (
+			id text primary key,
+			proto text not null,
+			bind_addr text not null,
+			node_id text references nodes(id) on delete set null,
+			unique (node_id, bind_addr)
+		);
+
+		insert into services_next (id, proto, bind_addr, node_id)
+			select id, proto, bind_addr, node_id from services;
+
+		drop table services;
+		alter table services_next rename to services;
+
+		--	null node ids don't collide in unique constraints, so addresses of services shared by all nodes are kept unique separately
+		create unique index services_shared_bind_addr on services(bind_addr) where node_id is null;
+		`,
+	}
+
+	ctx := context.Background()
+
+	//	tables are rebuilt by some steps, which would cascade deletes to the rows referencing them;
+	//	foreign keys can't be switched off within a transaction, so the whole migration runs on a connection of its own
+	conn, err := store.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `pragma foreign_keys = off`); err != nil {
+		return err
+	}
+
+	defer conn.ExecContext(ctx, `pragma foreign_keys = on`)
+
+	var version int
+	if err := conn.QueryRowContext(ctx, `pragma user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for idx := version; idx < len(steps); idx++ {
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(steps[idx]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("step %d: %v", idx+1, err)
+		}
+
+		//	rebuilt tables must not leave dangling references behind
+		rows, err := tx.Query(`pragma foreign_key_check`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		broken := rows.Next()
+		rows.Close()
+
+		if broken {
+			tx.Rollback()
+			return fmt.Errorf("step %d: foreign key check failed", idx+1)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(`pragma user_version = %d`, idx+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *Store) Empty(ctx context.Context) (bool, error) {
+
+	var count int
+	if err := store.db.QueryRowContext(ctx, `select count(*) from services`).Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count == 0, nil
+}
+
+const serviceColumns = `id, proto, bind_addr, node_id`
+
+func scanService(row rowScanner) (*ServiceRecord, error) {
+
+	var entry ServiceRecord
+	var nodeID uuid.NullUUID
+
+	if err := row.Scan(&entry.ID, &entry.Proto, &entry.BindAddr, &nodeID); err != nil {
+		return nil, err
+	}
+
+	if nodeID.Valid {
+		entry.NodeID = &nodeID.UUID
+	}
+
+	return &entry, nil
+}
+
+func (store *Store) Services(ctx context.Context) ([]ServiceRecord, error) {
+	return store.queryServices(ctx, `select `+serviceColumns+` from services order by bind_addr`)
+}
+
+// Returns services that should be served by a given node
+func (store *Store) NodeServices(ctx context.Context, nodeID uuid.UUID) ([]ServiceRecord, error) {
+	return store.queryServices(ctx, `select `+serviceColumns+` from services where node_id is null or node_id = ? order by bind_addr`, nodeID)
+}
+
+func (store *Store) queryServices(ctx context.Context, query string, args ...any) ([]ServiceRecord, error) {
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []ServiceRecord
+
+	for rows.Next() {
+
+		entry, err := scanService(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (store *Store) Service(ctx context.Context, id uuid.UUID) (*ServiceRecord, error) {
+
+	entry, err := scanService(store.db.QueryRowContext(ctx, `select `+serviceColumns+` from services where id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	return entry, err
+}
+
+func (store *Store) PutService(ctx context.Context, entry ServiceRecord) error {
+	_, err := store.db.ExecContext(ctx, `
+		insert into services (`+serviceColumns+`) values (?, ?, ?, ?)
+		on conflict (id) do update set proto = excluded.proto, bind_addr = excluded.bind_addr, node_id = excluded.node_id`,
+		entry.ID, entry.Proto, entry.BindAddr, nullUUID(entry.NodeID))
+	return err
+}
+
+func (store *Store) DeleteService(ctx context.Context, id uuid.UUID) error {
+	return expectAffected(store.db.ExecContext(ctx, `delete from services where id = ?`, id))
+}
+
+const peerColumns = `id, service_id, username, password, max_connections, framed_ip, rx_rate, tx_rate, min_rx_rate, min_tx_rate, disabled, paused`
+
+// Node-managed columns aren't written by PutPeer
+const peerSelectColumns = peerColumns + `, disable_ack`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPeer(row rowScanner) (*PeerRecord, error) {
+
+	var entry PeerRecord
+	var auth nxproxy.UserPassword
+	var disableAck sql.NullInt64
+
+	err := row.Scan(&entry.ID, &entry.ServiceID, &auth.User, &auth.Password,
+		&entry.MaxConnections, &entry.FramedIP,
+		&entry.Bandwidth.Rx, &entry.Bandwidth.Tx, &entry.Bandwidth.MinRx, &entry.Bandwidth.MinTx,
+		&entry.Disabled, &entry.Paused, &disableAck)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.PasswordAuth = &auth
+
+	if disableAck.Valid {
+		ack := time.Unix(disableAck.Int64, 0)
+		entry.DisableAck = &ack
+	}
+
+	return &entry, nil
+}
+
+func (store *Store) Peers(ctx context.Context, serviceID uuid.UUID) ([]PeerRecord, error) {
+
+	rows, err := store.db.QueryContext(ctx, `select `+peerSelectColumns+` from peers where service_id = ? order by username`, serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []PeerRecord
+
+	for rows.Next() {
+
+		entry, err := scanPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (store *Store) Peer(ctx context.Context, id uuid.UUID) (*PeerRecord, error) {
+
+	entry, err := scanPeer(store.db.QueryRowContext(ctx, `select `+peerSelectColumns+` from peers where id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	return entry, err
+}
+
+func (store *Store) PutPeer(ctx context.Context, entry PeerRecord) error {
+
+	if entry.PasswordAuth == nil {
+		return fmt.Errorf("password auth not set")
+	}
+
+	_, err := store.db.ExecContext(ctx, `
+		insert into peers (`+peerColumns+`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (id) do update set
+			service_id = excluded.service_id,
+			username = excluded.username,
+			password = excluded.password,
+			max_connections = excluded.max_connections,
+			framed_ip = excluded.framed_ip,
+			rx_rate = excluded.rx_rate,
+			tx_rate = excluded.tx_rate,
+			min_rx_rate = excluded.min_rx_rate,
+			min_tx_rate = excluded.min_tx_rate,
+			disabled = excluded.disabled,
+			paused = excluded.paused`,
+		entry.ID, entry.ServiceID, entry.PasswordAuth.User, entry.PasswordAuth.Password,
+		entry.MaxConnections, entry.FramedIP,
+		entry.Bandwidth.Rx, entry.Bandwidth.Tx, entry.Bandwidth.MinRx, entry.Bandwidth.MinTx,
+		entry.Disabled, entry.Paused)
+	return err
+}
+
+// Marks a peer disabled by a node as disabled in the store too and acknowledges the disable,
+// so that the node lets the stored state take over again
+func (store *Store) AckPeerDisable(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return expectAffected(store.db.ExecContext(ctx, `update peers set disabled = 1, disable_ack = ? where id = ?`, at.Unix(), id))
+}
+
+func (store *Store) DeletePeer(ctx context.Context, id uuid.UUID) error {
+	return expectAffected(store.db.ExecContext(ctx, `delete from peers where id = ?`, id))
+}
+
+// Stores peer deltas under the end of their window, so that delayed reports still count towards the right period.
+// Nodes that don't report windows get their deltas stored under the current time
+func (store *Store) AddDeltas(ctx context.Context, nodeID uuid.UUID, deltas []nxproxy.PeerDelta, window *model.DeltaWindow) error {
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	recordedAt := time.Now().Unix()
+	var windowStart *int64
+
+	if window != nil {
+		start := window.Start.Unix()
+		recordedAt, windowStart = window.End.Unix(), &start
+	}
+
+	for _, delta := range deltas {
+		if _, err := tx.ExecContext(ctx, `insert into peer_deltas (peer_id, node_id, slot, rx, tx, recorded_at, window_start) values (?, ?, ?, ?, ?, ?, ?)`,
+			delta.ID, nodeID, delta.Slot, delta.Rx, delta.Tx, recordedAt, windowStart); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns peer data volume aggregated across all nodes as well as a per-node breakdown
+func (store *Store) PeerUsage(ctx context.Context, id uuid.UUID) (*PeerUsage, error) {
+
+	rows, err := store.db.QueryContext(ctx, `select node_id, sum(rx), sum(tx) from peer_deltas where peer_id = ? group by node_id order by node_id`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	usage := PeerUsage{ID: id, Nodes: []NodeUsage{}}
+
+	for rows.Next() {
+
+		var entry NodeUsage
+		var nodeID uuid.NullUUID
+
+		if err := rows.Scan(&nodeID, &entry.Rx, &entry.Tx); err != nil {
+			return nil, err
+		}
+
+		if nodeID.Valid {
+			entry.NodeID = &nodeID.UUID
+		}
+
+		usage.Rx += entry.Rx
+		usage.Tx += entry.Tx
+		usage.Nodes = append(usage.Nodes, entry)
+	}
+
+	return &usage, rows.Err()
+}
+
+func (store *Store) Nodes(ctx context.Context) ([]NodeRecord, error) {
+
+	rows, err := store.db.QueryContext(ctx, `select id, name from nodes order by name`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []NodeRecord
+
+	for rows.Next() {
+
+		var entry NodeRecord
+		if err := rows.Scan(&entry.ID, &entry.Name); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (store *Store) PutNode(ctx context.Context, name string, token *nxproxy.ServerToken) error {
+	_, err := store.db.ExecContext(ctx, `
+		insert into nodes (id, name, secret_key) values (?, ?, ?)
+		on conflict (id) do update set name = excluded.name, secret_key = excluded.secret_key`,
+		token.ID, name, token.SecretKey)
+	return err
+}
+
+func (store *Store) DeleteNode(ctx context.Context, id uuid.UUID) error {
+	return expectAffected(store.db.ExecContext(ctx, `delete from nodes where id = ?`, id))
+}
+
+// Checks that the token belongs to a known node
+func (store *Store) VerifyNode(ctx context.Context, token *nxproxy.ServerToken) (*NodeRecord, error) {
+
+	var entry NodeRecord
+	var secretKey []byte
+
+	err := store.db.QueryRowContext(ctx, `select id, name, secret_key from nodes where id = ?`, token.ID).
+		Scan(&entry.ID, &entry.Name, &secretKey)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(secretKey, token.SecretKey) != 1 {
+		return nil, ErrNotFound
+	}
+
+	return &entry, nil
+}
+
+func nullUUID(val *uuid.UUID) uuid.NullUUID {
+	if val == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: *val, Valid: true}
+}
+
+func expectAffected(result sql.Result, err error) error {
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestOpenStore_Unversioned(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nx-auth.db")
+
+	//	schema made by controllers that predate migrations
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+
+	if _, err := db.Exec(`
+		create table services (
+			id text primary key,
+			proto text not null,
+			bind_addr text not null unique
+		);
+
+		create table peers (
+			id text primary key,
+			service_id text not null references services(id) on delete cascade,
+			username text not null,
+			password text not null,
+			max_connections integer not null default 0,
+			framed_ip text not null default '',
+			rx_rate integer not null default 0,
+			tx_rate integer not null default 0,
+			min_rx_rate integer not null default 0,
+			min_tx_rate integer not null default 0,
+			disabled integer not null default 0,
+			unique (service_id, username)
+		);
+
+		create table peer_deltas (
+			peer_id text not null,
+			rx integer not null,
+			tx integer not null,
+			recorded_at integer not null
+		);
+
+		create index peer_deltas_peer_id on peer_deltas(peer_id);
+
+		insert into services (id, proto, bind_addr) values ('0f0c8d3e-5b7a-4e61-8a0d-2f6f4f3c9b21', 'socks', '0.0.0.0:1080');
+	`); err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+
+	db.Close()
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	defer store.Close()
+
+	if entries, err := store.Services(context.Background()); err != nil || len(entries) != 1 {
+		t.Errorf("legacy services lost: %v %+v", err, entries)
+	}
+}
+
+func TestStore_BindAddrPerNode(t *testing.T) {
+
+	ctx := context.Background()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "nx-auth.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	defer store.Close()
+
+	var newNode = func(name string) uuid.UUID {
+
+		token, err := nxproxy.NewServerToken()
+		if err != nil {
+			t.Fatalf("new token: %v", err)
+		}
+
+		if err := store.PutNode(ctx, name, token); err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+
+		return token.ID
+	}
+
+	first := newNode("first")
+	second := newNode("second")
+
+	firstService := ServiceRecord{ID: uuid.New(), Proto: nxproxy.ProxyProtoHttp, BindAddr: "0.0.0.0:8080", NodeID: &first}
+	if err := store.PutService(ctx, firstService); err != nil {
+		t.Fatalf("put service: %v", err)
+	}
+
+	peer := PeerRecord{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua", Password: "1"}},
+		ServiceID:   firstService.ID,
+	}
+
+	if err := store.PutPeer(ctx, peer); err != nil {
+		t.Fatalf("put peer: %v", err)
+	}
+
+	//	nodes have addresses of their own
+	if err := store.PutService(ctx, ServiceRecord{ID: uuid.New(), Proto: nxproxy.ProxyProtoHttp, BindAddr: "0.0.0.0:8080", NodeID: &second}); err != nil {
+		t.Errorf("same address rejected on another node: %v", err)
+	}
+
+	if err := store.PutService(ctx, ServiceRecord{ID: uuid.New(), Proto: nxproxy.ProxyProtoSocks, BindAddr: "0.0.0.0:8080", NodeID: &first}); err == nil {
+		t.Errorf("same address accepted twice on a node")
+	}
+
+	//	services shared by all nodes take the address on every one of them
+	if err := store.PutService(ctx, ServiceRecord{ID: uuid.New(), Proto: nxproxy.ProxyProtoSocks, BindAddr: "0.0.0.0:1080"}); err != nil {
+		t.Fatalf("put shared service: %v", err)
+	}
+
+	if err := store.PutService(ctx, ServiceRecord{ID: uuid.New(), Proto: nxproxy.ProxyProtoSocks, BindAddr: "0.0.0.0:1080"}); err == nil {
+		t.Errorf("same address accepted twice for shared services")
+	}
+
+	if entries, err := store.NodeServices(ctx, second); err != nil || len(entries) != 2 {
+		t.Errorf("unexpected services of the second node: %v %+v", err, entries)
+	}
+
+	//	foreign keys still apply after the table got rebuilt
+	if err := store.DeleteService(ctx, firstService.ID); err != nil {
+		t.Fatalf("delete service: %v", err)
+	}
+
+	if _, err := store.Peer(ctx, peer.ID); err != ErrNotFound {
+		t.Errorf("peer of a deleted service kept: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/maddsua/nx-proxy/testing
+
+go 1.24.4
+
+require (
+	github.com/google/uuid v1.6.0
+	github.com/maddsua/nx-proxy v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+//	the example controller is always built against the proxy sources next to it
+replace github.com/maddsua/nx-proxy => ../
@@ -0,0 +1,325 @@
+package nxproxy
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrTlsFingerprintDenied = errors.New("tls client fingerprint not allowed")
+
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extPointFormats        = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// Fields of a TLS ClientHello that are used to identify a client
+type ClientHello struct {
+
+	//	legacy version field of the hello message
+	Version uint16
+
+	ServerName          string
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+}
+
+// GREASE values are sent by clients at random and are left out of fingerprints
+func isGrease(val uint16) bool {
+	return val&0x0f0f == 0x0a0a && val>>8 == val&0xff
+}
+
+func withoutGrease(vals []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(vals), isGrease)
+}
+
+func joinUint[T uint8 | uint16](vals []T, sep string, format func(val T) string) string {
+
+	parts := make([]string, len(vals))
+	for idx, val := range vals {
+		parts[idx] = format(val)
+	}
+
+	return strings.Join(parts, sep)
+}
+
+func decUint[T uint8 | uint16](val T) string {
+	return strconv.Itoa(int(val))
+}
+
+func hexUint16(val uint16) string {
+	return fmt.Sprintf("%04x", val)
+}
+
+// Returns the JA3 fingerprint of the hello
+func (hello *ClientHello) JA3() string {
+
+	raw := strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		joinUint(withoutGrease(hello.CipherSuites), "-", decUint),
+		joinUint(withoutGrease(hello.Extensions), "-", decUint),
+		joinUint(withoutGrease(hello.SupportedGroups), "-", decUint),
+		joinUint(hello.PointFormats, "-", decUint),
+	}, ",")
+
+	hash := md5.Sum([]byte(raw))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns the JA4 fingerprint of the hello
+func (hello *ClientHello) JA4() string {
+
+	version := hello.Version
+	if versions := withoutGrease(hello.SupportedVersions); len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+
+	var versionTag string
+	switch version {
+	case 0x0304:
+		versionTag = "13"
+	case 0x0303:
+		versionTag = "12"
+	case 0x0302:
+		versionTag = "11"
+	case 0x0301:
+		versionTag = "10"
+	case 0x0300:
+		versionTag = "s3"
+	default:
+		versionTag = "00"
+	}
+
+	sniTag := "i"
+	if hello.ServerName != "" {
+		sniTag = "d"
+	}
+
+	ciphers := withoutGrease(hello.CipherSuites)
+	extensions := withoutGrease(hello.Extensions)
+
+	alpnTag := "00"
+	if len(hello.ALPN) > 0 && hello.ALPN[0] != "" {
+
+		alpn := hello.ALPN[0]
+		first, last := alpn[0], alpn[len(alpn)-1]
+
+		if isAlnum(first) && isAlnum(last) {
+			alpnTag = string([]byte{first, last})
+		} else {
+			encoded := hex.EncodeToString([]byte(alpn))
+			alpnTag = encoded[:1] + encoded[len(encoded)-1:]
+		}
+	}
+
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", versionTag, sniTag, min(len(ciphers), 99), min(len(extensions), 99), alpnTag)
+
+	var truncHash = func(val string) string {
+		if val == "" {
+			return "000000000000"
+		}
+		hash := sha256.Sum256([]byte(val))
+		return hex.EncodeToString(hash[:])[:12]
+	}
+
+	slices.Sort(ciphers)
+
+	//	server name and alpn are accounted in the prefix already
+	extensions = slices.DeleteFunc(extensions, func(val uint16) bool {
+		return val == extServerName || val == extALPN
+	})
+	slices.Sort(extensions)
+
+	extPart := joinUint(extensions, ",", hexUint16)
+	if extPart != "" && len(hello.SignatureAlgorithms) > 0 {
+		extPart += "_" + joinUint(withoutGrease(hello.SignatureAlgorithms), ",", hexUint16)
+	}
+
+	return strings.Join([]string{prefix, truncHash(joinUint(ciphers, ",", hexUint16)), truncHash(extPart)}, "_")
+}
+
+func isAlnum(val byte) bool {
+	return (val >= '0' && val <= '9') || (val >= 'a' && val <= 'z') || (val >= 'A' && val <= 'Z')
+}
+
+// Reports whether the hello matches any of the JA3 or JA4 fingerprints in the list
+func (hello *ClientHello) MatchFingerprint(list []string) bool {
+
+	ja3, ja4 := hello.JA3(), hello.JA4()
+
+	for _, val := range list {
+		if val = strings.ToLower(strings.TrimSpace(val)); val == ja3 || val == ja4 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether a value looks like a JA3 or a JA4 fingerprint
+func ValidTlsFingerprint(val string) bool {
+
+	val = strings.ToLower(strings.TrimSpace(val))
+
+	var isHex = func(val string) bool {
+		_, err := hex.DecodeString(val)
+		return err == nil
+	}
+
+	//	ja3 is an md5 hash
+	if len(val) == 32 {
+		return isHex(val)
+	}
+
+	parts := strings.Split(val, "_")
+	return len(parts) == 3 && len(parts[0]) == 10 && len(parts[1]) == 12 && len(parts[2]) == 12 &&
+		isHex(parts[1]) && isHex(parts[2])
+}
+
+// Reports whether a client with the hello may open TLS tunnels on behalf of the peer
+func (opts *PeerOptions) TlsClientAllowed(hello *ClientHello) bool {
+	return len(opts.TlsFingerprints) == 0 || hello.MatchFingerprint(opts.TlsFingerprints)
+}
+
+// Parses the ClientHello message of a TLS record. The second value is false when more data is needed to decide;
+// it's true for complete records as well as for anything that isn't a ClientHello, in which case the hello is nil
+func parseClientHello(data []byte) (*ClientHello, bool) {
+
+	const recordHandshake = 0x16
+	const handshakeClientHello = 0x01
+	const nameTypeHost = 0x00
+
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	if data[0] != recordHandshake {
+		return nil, true
+	}
+
+	if len(data) < 5 {
+		return nil, false
+	}
+
+	recordLen := int(data[3])<<8 | int(data[4])
+	if len(data) < 5+recordLen {
+		return nil, false
+	}
+
+	msg := cryptoBytes(data[5 : 5+recordLen])
+
+	var body cryptoBytes
+	if msgType, ok := msg.uint8(); !ok || msgType != handshakeClientHello || !msg.uint24Prefixed(&body) {
+		return nil, true
+	}
+
+	var hello ClientHello
+	var sessionID, cipherSuites, compression, extensions cryptoBytes
+
+	//	client random goes after the version
+	if !body.uint16(&hello.Version) ||
+		!body.skip(32) ||
+		!body.uint8Prefixed(&sessionID) ||
+		!body.uint16Prefixed(&cipherSuites) ||
+		!body.uint8Prefixed(&compression) {
+		return nil, true
+	}
+
+	if !cipherSuites.uint16List(&hello.CipherSuites) {
+		return nil, true
+	}
+
+	//	extensions are optional
+	if len(body) > 0 && !body.uint16Prefixed(&extensions) {
+		return nil, true
+	}
+
+	for len(extensions) > 0 {
+
+		var extType uint16
+		var extData cryptoBytes
+
+		if !extensions.uint16(&extType) || !extensions.uint16Prefixed(&extData) {
+			return nil, true
+		}
+
+		hello.Extensions = append(hello.Extensions, extType)
+
+		var list cryptoBytes
+
+		switch extType {
+
+		case extServerName:
+
+			if !extData.uint16Prefixed(&list) {
+				return nil, true
+			}
+
+			for len(list) > 0 {
+
+				var name cryptoBytes
+				nameType, ok := list.uint8()
+				if !ok || !list.uint16Prefixed(&name) {
+					return nil, true
+				}
+
+				if nameType == nameTypeHost && hello.ServerName == "" {
+					hello.ServerName = string(name)
+				}
+			}
+
+		case extSupportedGroups:
+			if !extData.uint16Prefixed(&list) || !list.uint16List(&hello.SupportedGroups) {
+				return nil, true
+			}
+
+		case extPointFormats:
+			if !extData.uint8Prefixed(&list) {
+				return nil, true
+			}
+			hello.PointFormats = append([]uint8{}, list...)
+
+		case extSignatureAlgorithms:
+			if !extData.uint16Prefixed(&list) || !list.uint16List(&hello.SignatureAlgorithms) {
+				return nil, true
+			}
+
+		case extSupportedVersions:
+			if !extData.uint8Prefixed(&list) || !list.uint16List(&hello.SupportedVersions) {
+				return nil, true
+			}
+
+		case extALPN:
+
+			if !extData.uint16Prefixed(&list) {
+				return nil, true
+			}
+
+			for len(list) > 0 {
+
+				var proto cryptoBytes
+				if !list.uint8Prefixed(&proto) {
+					return nil, true
+				}
+
+				hello.ALPN = append(hello.ALPN, string(proto))
+			}
+		}
+	}
+
+	return &hello, true
+}
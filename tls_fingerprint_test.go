@@ -0,0 +1,91 @@
+package nxproxy_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestClientHello_JA3(t *testing.T) {
+
+	hello := nxproxy.ClientHello{
+		Version:         0x0303,
+		CipherSuites:    []uint16{0x1a1a, 0x1301, 0x1302, 0xc02b},
+		Extensions:      []uint16{0x2a2a, 0x0000, 0x000a, 0x000b, 0x002b},
+		SupportedGroups: []uint16{0x3a3a, 29, 23},
+		PointFormats:    []uint8{0},
+	}
+
+	//	md5 of 771,4865-4866-49195,0-10-11-43,29-23,0
+	if val := hello.JA3(); val != "fd17f1f9b9c56d4bfda8900c8900ec06" {
+		t.Errorf("unexpected ja3: %s", val)
+	}
+}
+
+func TestClientHello_JA4(t *testing.T) {
+
+	//	the example from the JA4 spec
+	hello := nxproxy.ClientHello{
+		Version:    0x0303,
+		ServerName: "example.com",
+		CipherSuites: []uint16{
+			0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []uint16{
+			0x4a4a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005,
+			0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015,
+		},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		SupportedVersions:   []uint16{0x5a5a, 0x0304, 0x0303},
+		ALPN:                []string{"h2", "http/1.1"},
+	}
+
+	if val := hello.JA4(); val != "t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("unexpected ja4: %s", val)
+	}
+}
+
+func TestInspectClientHello_Fingerprint(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var hello *nxproxy.ClientHello
+	conn := nxproxy.InspectClientHello(server, clientHelloStream(t, "example.com"), func(val *nxproxy.ClientHello) error {
+		hello = val
+		return io.EOF
+	})
+
+	_, _ = conn.Read(make([]byte, 1024))
+
+	if hello == nil {
+		t.Fatalf("hello not parsed")
+	}
+
+	if hello.ServerName != "example.com" || len(hello.CipherSuites) == 0 || len(hello.SupportedVersions) == 0 {
+		t.Errorf("hello fields missing: %+v", hello)
+	}
+
+	if !strings.HasPrefix(hello.JA4(), "t13d") || !nxproxy.ValidTlsFingerprint(hello.JA4()) {
+		t.Errorf("unexpected ja4: %s", hello.JA4())
+	}
+
+	if !nxproxy.ValidTlsFingerprint(hello.JA3()) {
+		t.Errorf("unexpected ja3: %s", hello.JA3())
+	}
+
+	peer := nxproxy.PeerOptions{ID: uuid.New(), TlsFingerprints: []string{strings.ToUpper(hello.JA3())}}
+	if !peer.TlsClientAllowed(hello) {
+		t.Errorf("client with an allowed ja3 rejected")
+	}
+
+	peer.TlsFingerprints = []string{"t13d1516h2_8daaf6152771_e5627efa2ab1"}
+	if peer.TlsClientAllowed(hello) {
+		t.Errorf("client with an unknown fingerprint allowed")
+	}
+}
@@ -0,0 +1,173 @@
+package nxproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long an unused spare connection is kept around; servers tend to drop idle connections that never sent anything
+const upstreamSpareTTL = 15 * time.Second
+
+// Keeps fresh connections to recently dialed destinations, so that repeated connections to the same hosts
+// don't have to wait for a handshake. Spare connections are handed out once and never reused after carrying any data
+type upstreamPool struct {
+	spares  map[string]*upstreamSpare
+	dialing map[string]struct{}
+	epoch   uint64
+	mtx     sync.Mutex
+}
+
+type upstreamSpare struct {
+	conn    net.Conn
+	created time.Time
+	timer   *time.Timer
+}
+
+// Returns a spare connection to the address if there's a usable one
+func (pool *upstreamPool) take(key string) net.Conn {
+
+	pool.mtx.Lock()
+	spare := pool.spares[key]
+	delete(pool.spares, key)
+	pool.mtx.Unlock()
+
+	if spare == nil {
+		return nil
+	}
+
+	spare.timer.Stop()
+
+	//	the destination may have closed the connection while it was sitting idle
+	if established, known := tcpConnEstablished(spare.conn); known && !established {
+		spare.conn.Close()
+		return nil
+	}
+
+	return spare.conn
+}
+
+// Dials a spare connection to the address in the background, evicting the oldest spare one when the pool is full
+func (pool *upstreamPool) warm(ctx context.Context, key string, size int, dial func(ctx context.Context) (net.Conn, error)) {
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+
+	if pool.spares == nil {
+		pool.spares = map[string]*upstreamSpare{}
+		pool.dialing = map[string]struct{}{}
+	}
+
+	if _, has := pool.spares[key]; has {
+		return
+	} else if _, has := pool.dialing[key]; has {
+		return
+	}
+
+	if len(pool.spares)+len(pool.dialing) >= size {
+
+		var oldestKey string
+		var oldest *upstreamSpare
+
+		for key, spare := range pool.spares {
+			if oldest == nil || spare.created.Before(oldest.created) {
+				oldestKey, oldest = key, spare
+			}
+		}
+
+		//	all slots are taken by pending dials
+		if oldest == nil {
+			return
+		}
+
+		oldest.timer.Stop()
+		oldest.conn.Close()
+		delete(pool.spares, oldestKey)
+	}
+
+	pool.dialing[key] = struct{}{}
+	epoch := pool.epoch
+
+	go func() {
+
+		conn, err := dial(ctx)
+
+		pool.mtx.Lock()
+		defer pool.mtx.Unlock()
+
+		delete(pool.dialing, key)
+
+		if err != nil {
+			return
+		}
+
+		//	the pool was drained while dialing
+		if epoch != pool.epoch {
+			conn.Close()
+			return
+		}
+
+		spare := upstreamSpare{conn: conn, created: time.Now()}
+		spare.timer = time.AfterFunc(upstreamSpareTTL, func() {
+
+			pool.mtx.Lock()
+			defer pool.mtx.Unlock()
+
+			//	spares that were taken already belong to someone else
+			if pool.spares[key] == &spare {
+				delete(pool.spares, key)
+				conn.Close()
+			}
+		})
+
+		pool.spares[key] = &spare
+	}()
+}
+
+// Closes all spare connections
+func (pool *upstreamPool) drain() {
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+
+	pool.epoch++
+
+	for key, spare := range pool.spares {
+		spare.timer.Stop()
+		spare.conn.Close()
+		delete(pool.spares, key)
+	}
+}
+
+// Dials a destination, taking a pre-dialed connection when there is one. With the upstream pool enabled,
+// another connection to the same destination gets dialed in the background to serve the next request
+func (peer *Peer) DialPooled(ctx context.Context, network, address string) (net.Conn, error) {
+
+	if peer.UpstreamPool == 0 {
+		peer.upstream.drain()
+		return peer.DialContext(ctx, network, address)
+	}
+
+	key := network + "/" + address
+
+	conn := peer.upstream.take(key)
+	if conn == nil {
+
+		var err error
+		if conn, err = peer.DialContext(ctx, network, address); err != nil {
+			return nil, err
+		}
+	}
+
+	baseCtx := peer.BaseContext
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
+
+	peer.upstream.warm(baseCtx, key, int(peer.UpstreamPool), func(ctx context.Context) (net.Conn, error) {
+		return peer.DialContext(ctx, network, address)
+	})
+
+	return conn, nil
+}
@@ -0,0 +1,83 @@
+package nxproxy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeer_UpstreamPool(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	var nextAccepted = func() net.Conn {
+		select {
+		case conn := <-accepted:
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		case <-time.After(time.Second):
+			t.Fatalf("no connection accepted")
+			return nil
+		}
+	}
+
+	peer := nxproxy.Peer{
+		PeerOptions: nxproxy.PeerOptions{ID: uuid.New(), UpstreamPool: 1},
+	}
+
+	addr := listener.Addr().String()
+
+	first, err := peer.DialPooled(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	defer first.Close()
+
+	nextAccepted()
+	spare := nextAccepted()
+
+	second, err := peer.DialPooled(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	defer second.Close()
+
+	if second.LocalAddr().String() != spare.RemoteAddr().String() {
+		t.Errorf("spare connection not reused")
+	}
+
+	//	another spare is dialed in place of the taken one and gets closed together with peer connections
+	replacement := nextAccepted()
+
+	//	the replacement spare may not be stored yet right after being accepted
+	time.Sleep(50 * time.Millisecond)
+	peer.CloseConnections()
+
+	replacement.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := replacement.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("spare connection not closed: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package nxproxy
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SOCKS5 password auth can't carry longer user names, so they're limited for all protocols
+const MaxUsernameLength = 255
+
+var ErrUsernameEmpty = errors.New("user name empty")
+var ErrUsernameTooLong = errors.New("user name too long")
+var ErrUsernameEncoding = errors.New("user name is not valid utf-8")
+var ErrUsernameControlChars = errors.New("user name contains control characters")
+var ErrUsernameColon = errors.New("user name contains a colon")
+
+// Returns the form of a user name that peers are indexed and looked up by.
+//
+// User names are case-insensitive and surrounding whitespace is ignored.
+// They have to be valid UTF-8 of at most MaxUsernameLength bytes without control characters.
+// Colons aren't allowed either, as HTTP basic auth can't tell them from the password separator
+func NormalizeUsername(name string) (string, error) {
+
+	name = strings.TrimSpace(name)
+
+	switch {
+	case name == "":
+		return "", ErrUsernameEmpty
+	case len(name) > MaxUsernameLength:
+		return "", ErrUsernameTooLong
+	case !utf8.ValidString(name):
+		return "", ErrUsernameEncoding
+	case strings.ContainsFunc(name, unicode.IsControl):
+		return "", ErrUsernameControlChars
+	case strings.ContainsRune(name, ':'):
+		return "", ErrUsernameColon
+	}
+
+	return strings.ToLower(name), nil
+}
@@ -0,0 +1,70 @@
+package nxproxy_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+
+	valid := map[string]string{
+		"maddsua":      "maddsua",
+		" MaddSua\t":   "maddsua",
+		"Ünïcödé":      "ünïcödé",
+		"user@example": "user@example",
+	}
+
+	for input, expect := range valid {
+		if val, err := nxproxy.NormalizeUsername(input); err != nil {
+			t.Errorf("'%s': unexpected err: %v", input, err)
+		} else if val != expect {
+			t.Errorf("'%s': expected '%s', got '%s'", input, expect, val)
+		}
+	}
+
+	invalid := map[string]error{
+		"  ":                     nxproxy.ErrUsernameEmpty,
+		strings.Repeat("a", 256): nxproxy.ErrUsernameTooLong,
+		"bad\xffutf":             nxproxy.ErrUsernameEncoding,
+		"new\x00line":            nxproxy.ErrUsernameControlChars,
+		"user:name":              nxproxy.ErrUsernameColon,
+	}
+
+	for input, expect := range invalid {
+		if _, err := nxproxy.NormalizeUsername(input); !errors.Is(err, expect) {
+			t.Errorf("'%q': expected %v, got %v", input, expect, err)
+		}
+	}
+}
+
+func TestSlot_LookupCaseInsensitive(t *testing.T) {
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+
+	report := slot.SetPeers([]nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "MaddSua", Password: "pass"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua ", Password: "other"}},
+	})
+
+	if len(report.Rejected) != 1 {
+		t.Fatalf("user names differing in case only must conflict: %+v", report.Rejected)
+	}
+
+	ip := net.ParseIP("10.0.0.1")
+
+	if _, err := slot.LookupWithPassword(ip, "MADDSUA", "pass"); err != nil {
+		t.Errorf("lookup: unexpected err: %v", err)
+	}
+
+	_, err := slot.LookupWithPassword(ip, "bad:name", "pass")
+
+	var credErr *nxproxy.CredentialsError
+	if !errors.As(err, &credErr) || !errors.Is(credErr.Err, nxproxy.ErrUsernameColon) {
+		t.Errorf("expected a user name policy error, got %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package nxproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Longest redirect chain the proxy follows on behalf of a peer; the same limit the go http client applies by default
+const MaxPeerRedirects = 10
+
+// Checks peer options that don't depend on the node or on other peers.
+// Framed IPs are only checked for being valid addresses, as whether they're assigned is up to the node
+func (opts *PeerOptions) Validate() error {
+
+	if auth := opts.PasswordAuth; auth != nil {
+		if _, err := NormalizeUsername(auth.User); err != nil {
+			return fmt.Errorf("password auth: %v", err)
+		}
+	}
+
+	if opts.FramedIP != "" && net.ParseIP(opts.FramedIP) == nil {
+		return fmt.Errorf("framed ip: invalid addr: %s", opts.FramedIP)
+	}
+
+	for _, val := range opts.TlsFingerprints {
+		if !ValidTlsFingerprint(val) {
+			return fmt.Errorf("tls fingerprints: not a JA3 or JA4 fingerprint: '%s'", val)
+		}
+	}
+
+	if !opts.HttpCompression.Valid() {
+		return fmt.Errorf("http compression: invalid mode: '%s'", opts.HttpCompression)
+	}
+
+	if opts.MaxRedirects > MaxPeerRedirects {
+		return fmt.Errorf("max redirects: must not exceed %d", MaxPeerRedirects)
+	}
+
+	if band := opts.Bandwidth; band.Rx > 0 && band.MinRx > band.Rx {
+		return fmt.Errorf("bandwidth: min_rx exceeds rx")
+	}
+
+	if band := opts.Bandwidth; band.Tx > 0 && band.MinTx > band.Tx {
+		return fmt.Errorf("bandwidth: min_tx exceeds tx")
+	}
+
+	return nil
+}
+
+// Checks slot options the same way slots do when they're applied
+func (opts *SlotOptions) Validate() error {
+
+	if !opts.Proto.Valid() {
+		return ErrUnsupportedProto
+	}
+
+	//	templates are resolved by nodes, so only their format can be checked here
+	if IsBindTemplate(opts.BindAddr) {
+		if err := ValidateBindTemplate(opts.BindAddr); err != nil {
+			return fmt.Errorf("bind addr: %v", err)
+		} else if len(opts.Interfaces) > 0 {
+			return errors.New("interfaces: bind address must be a wildcard")
+		}
+	} else if _, err := ServiceBindAddr(opts.BindAddr, opts.Proto); err != nil {
+		return fmt.Errorf("bind addr: %v", err)
+	}
+
+	if len(opts.Interfaces) > 0 {
+
+		addr, _, _ := SplitAddrNet(opts.BindAddr)
+		host, _, _ := net.SplitHostPort(addr)
+
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			return errors.New("interfaces: bind address must be a wildcard")
+		}
+
+		for _, pattern := range opts.Interfaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("interfaces: invalid pattern '%s'", pattern)
+			}
+		}
+	}
+
+	if err := opts.validateReloadable(); err != nil {
+		return err
+	}
+
+	if mitm := opts.Mitm; mitm != nil {
+		if _, err := NewMitmAuthority(mitm.CaCert, mitm.CaKey); err != nil {
+			return fmt.Errorf("mitm: %v", err)
+		}
+	}
+
+	if tlsOpts := opts.Tls; tlsOpts != nil {
+		if _, err := NewServerCertificate(tlsOpts.Cert, tlsOpts.Key); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Checks the options that running slots may be updated with
+func (opts *SlotOptions) validateReloadable() error {
+
+	if _, err := ParsePrefixList(opts.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %v", err)
+	}
+
+	for _, method := range opts.AuthMethods {
+		if !method.Valid() {
+			return fmt.Errorf("auth methods: unsupported method '%s'", method)
+		}
+	}
+
+	for _, scheme := range opts.HttpAuthSchemes {
+		if !scheme.Valid() {
+			return fmt.Errorf("http auth schemes: unsupported scheme '%s'", scheme)
+		}
+	}
+
+	if strings.ContainsAny(opts.HttpRealm, "\"\\\r\n") {
+		return fmt.Errorf("http realm: must not contain quotes, backslashes or line breaks")
+	}
+
+	if landing := opts.HttpLanding; landing != nil {
+		if !opts.Proto.IsHttp() {
+			return errors.New("http landing: only supported by http slots")
+		} else if err := landing.Validate(); err != nil {
+			return fmt.Errorf("http landing: %v", err)
+		}
+	}
+
+	if pac := opts.HttpPac; pac != nil {
+		if !opts.Proto.IsHttp() {
+			return errors.New("http pac: only supported by http slots")
+		} else if err := pac.Validate(); err != nil {
+			return fmt.Errorf("http pac: %v", err)
+		}
+	}
+
+	if opts.HttpAccountHost != "" && !opts.Proto.IsHttp() {
+		return errors.New("http account host: only supported by http slots")
+	} else if strings.ContainsAny(opts.HttpAccountHost, ":/ ") {
+		return errors.New("http account host: must be a bare host name")
+	}
+
+	for _, pattern := range opts.BlockedDomains {
+		if strings.Trim(pattern, ".") == "" {
+			return fmt.Errorf("blocked domains: invalid pattern '%s'", pattern)
+		}
+	}
+
+	if opts.Proto == ProxyProtoHttps && opts.Tls == nil {
+		return errors.New("tls: https slots need a server certificate")
+	} else if opts.Proto != ProxyProtoHttps && opts.Tls != nil {
+		return errors.New("tls: only supported by https slots")
+	}
+
+	if opts.Proto == ProxyProtoReverse && len(opts.ReverseRoutes) == 0 {
+		return errors.New("reverse routes: reverse slots need at least one route")
+	} else if opts.Proto != ProxyProtoReverse && len(opts.ReverseRoutes) > 0 {
+		return errors.New("reverse routes: only supported by reverse slots")
+	}
+
+	for idx, route := range opts.ReverseRoutes {
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("reverse routes: route %d: %v", idx, err)
+		}
+	}
+
+	if limit := opts.AuthRateLimit; limit != nil {
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("auth rate limit: %v", err)
+		}
+	}
+
+	if opts.TarpitDelay() > maxTarpitDelay {
+		return fmt.Errorf("tarpit: delay may not exceed %v", maxTarpitDelay)
+	}
+
+	if leakCheck := opts.LeakCheck; leakCheck != nil {
+		if err := leakCheck.Validate(); err != nil {
+			return fmt.Errorf("leak check: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Checks whether peers can be reliably identified and mapped by their ids and credentials
+type peerSet struct {
+	ids       map[uuid.UUID]struct{}
+	users     map[string]struct{}
+	anonymous bool
+}
+
+func (set *peerSet) add(peer *PeerOptions) error {
+
+	if err := peer.normalizeAuth(); err != nil {
+		return err
+	}
+
+	if err := peer.Validate(); err != nil {
+		return err
+	}
+
+	if set.ids == nil {
+		set.ids = map[uuid.UUID]struct{}{}
+		set.users = map[string]struct{}{}
+	}
+
+	if _, has := set.ids[peer.ID]; has {
+		return fmt.Errorf("id not unique: %v", peer.ID)
+	}
+
+	set.ids[peer.ID] = struct{}{}
+
+	//	peers that only have auth methods the node doesn't support don't take any user names
+	if !peer.Anonymous() && peer.PasswordAuth == nil {
+		return nil
+	}
+
+	//	a peer without any auth properties is the anonymous one
+	if peer.PasswordAuth == nil {
+
+		if set.anonymous {
+			return fmt.Errorf("anonymous peer not unique")
+		}
+
+		set.anonymous = true
+		return nil
+	}
+
+	//	validated above already
+	username, _ := NormalizeUsername(peer.PasswordAuth.User)
+
+	if _, has := set.users[username]; has {
+		return fmt.Errorf("password auth: user name not unique: %s", peer.PasswordAuth.User)
+	}
+
+	set.users[username] = struct{}{}
+
+	return nil
+}
+
+// Checks a peer list the same way slots do when peers are set. Returns the entries that would be rejected
+func ValidatePeers(entries []PeerOptions) []ConfigIssue {
+	return validatePeers("", entries)
+}
+
+func validatePeers(slotHandle string, entries []PeerOptions) []ConfigIssue {
+
+	var set peerSet
+	var issues []ConfigIssue
+
+	for _, entry := range entries {
+		if err := set.add(&entry); err != nil {
+			issues = append(issues, peerIssue(slotHandle, &entry, err))
+		}
+	}
+
+	return issues
+}
+
+// Keeps bind addresses of accepted slots to catch the ones that can't be bound along with them
+type BindSet struct {
+	entries []bindSetEntry
+}
+
+type bindSetEntry struct {
+	bindAddr string
+	handle   string
+}
+
+// Adds a service bind address, as returned by ServiceBindAddr, unless it conflicts with one that's already in the set
+func (set *BindSet) Add(bindAddr string, handle string) error {
+
+	for _, entry := range set.entries {
+
+		if entry.bindAddr == bindAddr {
+			return ErrBindAddrNotUnique
+		}
+
+		if BindAddrsOverlap(entry.bindAddr, bindAddr) {
+			return fmt.Errorf("%w: %s", ErrBindAddrOverlap, entry.handle)
+		}
+	}
+
+	set.entries = append(set.entries, bindSetEntry{bindAddr: bindAddr, handle: handle})
+
+	return nil
+}
+
+// Checks a service list the same way nodes do when they apply a config. Returns the entries that would be rejected;
+// peers of rejected slots aren't checked
+func ValidateServices(entries []ServiceOptions) []ConfigIssue {
+
+	var issues []ConfigIssue
+	var binds BindSet
+
+	for _, entry := range entries {
+
+		handle := entry.SlotOptions.Handle()
+
+		if err := entry.SlotOptions.Validate(); err != nil {
+			issues = append(issues, ConfigIssue{Slot: handle, Reason: err.Error()})
+			continue
+		}
+
+		//	conflicts of templated addresses only show up once they're resolved on a node
+		if bindAddr, err := ServiceBindAddr(entry.BindAddr, entry.Proto); err == nil {
+			if err := binds.Add(bindAddr, handle); err != nil {
+				issues = append(issues, ConfigIssue{Slot: handle, Reason: err.Error()})
+				continue
+			}
+		}
+
+		issues = append(issues, validatePeers(handle, entry.Peers)...)
+	}
+
+	return issues
+}
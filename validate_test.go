@@ -0,0 +1,105 @@
+package nxproxy_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	nxproxy "github.com/maddsua/nx-proxy"
+)
+
+func TestPeerOptions_Validate(t *testing.T) {
+
+	valid := []nxproxy.PeerOptions{
+		{ID: uuid.New()},
+		{ID: uuid.New(), FramedIP: "203.0.113.7", Bandwidth: nxproxy.PeerBandwidth{Rx: 1000, MinRx: 1000}},
+		{ID: uuid.New(), Bandwidth: nxproxy.PeerBandwidth{MinTx: 500}},
+	}
+
+	for _, entry := range valid {
+		if err := entry.Validate(); err != nil {
+			t.Errorf("unexpected err: %v", err)
+		}
+	}
+
+	invalid := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{}},
+		{ID: uuid.New(), FramedIP: "203.0.113"},
+		{ID: uuid.New(), Bandwidth: nxproxy.PeerBandwidth{Rx: 1000, MinRx: 1001}},
+		{ID: uuid.New(), Bandwidth: nxproxy.PeerBandwidth{Tx: 1000, MinTx: 2000}},
+	}
+
+	for idx, entry := range invalid {
+		if err := entry.Validate(); err == nil {
+			t.Errorf("invalid entry %d accepted", idx)
+		}
+	}
+}
+
+func TestValidateServices(t *testing.T) {
+
+	peers := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "maddsua"}},
+	}
+
+	issues := nxproxy.ValidateServices([]nxproxy.ServiceOptions{
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoSocks, BindAddr: "0.0.0.0:1080"}, Peers: peers},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "0.0.0.0:1080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "127.0.0.1:1080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: nxproxy.ProxyProtoHttp, BindAddr: "localhost:8080"}},
+		{SlotOptions: nxproxy.SlotOptions{Proto: "ftp", BindAddr: "0.0.0.0:21"}},
+	})
+
+	expect := []struct {
+		slot string
+		peer *uuid.UUID
+	}{
+		{slot: "socks@0.0.0.0:1080", peer: &peers[1].ID},
+		{slot: "http@0.0.0.0:1080"},
+		{slot: "http@127.0.0.1:1080"},
+		{slot: "http@localhost:8080"},
+		{slot: "ftp@0.0.0.0:21"},
+	}
+
+	if len(issues) != len(expect) {
+		t.Fatalf("unexpected issues: %+v", issues)
+	}
+
+	for idx, issue := range issues {
+
+		if issue.Slot != expect[idx].slot {
+			t.Errorf("issue %d: unexpected slot %s", idx, issue.Slot)
+		}
+
+		if (issue.PeerID == nil) != (expect[idx].peer == nil) || (issue.PeerID != nil && *issue.PeerID != *expect[idx].peer) {
+			t.Errorf("issue %d: unexpected peer %v", idx, issue.PeerID)
+		}
+	}
+}
+
+// Peers rejected by validation must be rejected by slots as well
+func TestValidatePeers_MatchesSlot(t *testing.T) {
+
+	entries := []nxproxy.PeerOptions{
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "a"}},
+		{ID: uuid.New(), PasswordAuth: &nxproxy.UserPassword{User: "a"}},
+		{ID: uuid.New(), Bandwidth: nxproxy.PeerBandwidth{Rx: 10, MinRx: 20}},
+		{ID: uuid.New()},
+		{ID: uuid.New()},
+	}
+
+	slot := nxproxy.Slot{DNS: stubDns{}}
+	report := slot.SetPeers(entries)
+
+	issues := nxproxy.ValidatePeers(entries)
+
+	if len(issues) != len(report.Rejected) {
+		t.Fatalf("validation found %d issues while slot rejected %d peers", len(issues), len(report.Rejected))
+	}
+
+	for idx := range issues {
+		if *issues[idx].PeerID != *report.Rejected[idx].PeerID || issues[idx].Reason != report.Rejected[idx].Reason {
+			t.Errorf("issue %d differs: %+v vs %+v", idx, issues[idx], report.Rejected[idx])
+		}
+	}
+}